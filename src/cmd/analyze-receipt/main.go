@@ -3,7 +3,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -15,6 +14,7 @@ import (
 
 	"expense-tracker/src/pkg/config"
 	"expense-tracker/src/pkg/llm"
+	"expense-tracker/src/pkg/receipt"
 	"expense-tracker/src/pkg/util"
 )
 
@@ -27,8 +27,6 @@ into a structured receipt analysis. Errors are printed and cause a non-zero
 exit code.
 */
 func main() {
-	// Ensure required environment variables are present.
-	config.CheckIfEnvVarsPresent("OPENAI_API_KEY")
 	// Common flags.
 	configPath := flag.String("config", "./cfg/config.json", "Path to your configuration file.")
 	// Program-specific flags.
@@ -41,6 +39,8 @@ func main() {
 	util.EnsureFlags()
 	// Initialize configuration.
 	config.InitializeConfig(*configPath)
+	// Ensure environment variables required by the configured LLM provider are present.
+	config.CheckIfEnvVarsPresent(llm.RequiredEnvVars()...)
 
 	pricesPath := filepath.Join(*ocrDirPath, "prices.json")
 	ocrTextPath := filepath.Join(*ocrDirPath, "ocr.txt")
@@ -64,7 +64,7 @@ func main() {
 	)
 
 	// For now, pass nil to use the default category map inside the LLM layer.
-	receiptAnalysis, analysisErr := llm.GenerateReceiptAnalysisFromImage(imagePath, ocrText, ocrPrices, nil)
+	receiptAnalysis, analysisErr := llm.GenerateReceiptAnalysisFromImage([]string{imagePath}, ocrText, ocrPrices, nil, nil)
 	if analysisErr != nil {
 		analysisErr.QuitIf(xerr.ErrorTypeError)
 	}
@@ -83,13 +83,10 @@ func main() {
 
 	// Totals are OK; proceed to save the analysis JSON next to the OCR text file.
 	runDirPath := filepath.Dir(ocrTextPath)
-	analysisPath := filepath.Join(runDirPath, "receipt-analysis.json")
+	analysisPath := receipt.AnalysisPath(runDirPath)
 
-	jsonBytes, marshalErr := json.MarshalIndent(receiptAnalysis, "", "  ")
-	xerr.QuitIfError(marshalErr, "marshal receipt analysis to JSON")
-
-	writeErr := os.WriteFile(analysisPath, jsonBytes, 0o644)
-	xerr.QuitIfError(writeErr, "write receipt-analysis.json file")
+	e = receipt.SaveAnalysis(analysisPath, receiptAnalysis)
+	e.QuitIf("error")
 
 	// Log the structured analysis as JSON for inspection.
 	tl.LogJSON(tl.Verbose, palette.CyanDim, "ReceiptAnalysis", receiptAnalysis)
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/dian"
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/llm"
+	"expense-tracker/src/pkg/pipeline"
+	"expense-tracker/src/pkg/util"
+)
+
+/*
+main imports Colombian (DIAN) electronic invoices: UBL 2.1 XML files, or the
+.zip they are usually sent in. The XML gives exact items, NIT, CUFE, taxes
+and totals, so there is no OCR; only the item names go to the LLM for
+English names and categories. Each invoice gets a run directory under
+-out/<month>-<year> with invoice.<ext>, receipt-analysis.json and
+manifest.json, and is stored in the ledger like a photographed receipt.
+
+-file is an .xml/.zip file or a directory of them. Files already imported
+(same SHA-256 in out/image-index.json) are skipped unless -force is given.
+
+Example:
+
+	go run ./src/cmd/invoice-import -file ~/Downloads/facturas
+*/
+func main() {
+	// Common flags.
+	configPath := flag.String("config", "./cfg/config.json", "Path to your configuration file.")
+
+	// Program-specific flags.
+	filePath := flag.String("file", "", "Path to an e-invoice (.xml/.zip) OR a directory with e-invoices.")
+	outputDirPath := flag.String("out", "./out", "Directory where run directories are created.")
+	force := flag.Bool("force", false, "Import invoices even if the same file was already imported")
+	resume := flag.Bool("resume", false, "Continue in the earlier run directory of an invoice instead of creating a new one")
+	priceDifference := flag.Bool("price-difference", false, "Fail invoices whose items don't add up to the payable amount (they are still stored and queued for review)")
+
+	flag.Parse()
+	util.RequiredFlag(filePath, "file")
+	util.EnsureFlags()
+	config.InitializeConfig(*configPath)
+	config.CheckIfEnvVarsPresent(llm.RequiredEnvVars()...)
+
+	invoicePaths, e := resolveInvoices(*filePath)
+	e.QuitIf("error")
+	if len(invoicePaths) == 0 {
+		tl.Log(tl.Warning, palette.PurpleBold, "No .xml/.zip files found at: '%s'", *filePath)
+		os.Exit(0)
+	}
+
+	resumeRunDirs := make(map[string]string)
+	if *resume {
+		resumeRunDirs, e = pipeline.FindRunDirsBySource(*outputDirPath)
+		e.QuitIf("error")
+	}
+
+	index, e := imageindex.Load(filepath.Join(*outputDirPath, imageindex.FileName))
+	e.QuitIf("error")
+
+	receiptLedger, e := ledger.Open(ledger.Cfg.DatabasePath)
+	e.QuitIf("error")
+	defer receiptLedger.Close()
+
+	options := pipeline.Options{
+		OutputDirPath:   pipeline.MonthOutputDir(*outputDirPath, time.Now()),
+		PriceDifference: *priceDifference,
+		Ledger:          receiptLedger,
+	}
+
+	tl.Log(tl.Notice, palette.BlueBold, "Importing '%s' e-invoices from '%s'", len(invoicePaths), *filePath)
+
+	var imported, skipped, failed int
+	for _, invoicePath := range invoicePaths {
+		sha256, e := imageindex.FileSHA256(invoicePath)
+		if e != nil {
+			failed++
+			tl.Log(tl.Warning, palette.PurpleBold, "Failed '%s': %s: '%s'", invoicePath, e.Msg, e.ErrStr)
+			continue
+		}
+		hashes := imageindex.ImageHashes{SHA256: sha256}
+
+		if match, found := index.FindDuplicate(hashes, invoicePath, 0); found && !*force {
+			skipped++
+			tl.Log(
+				tl.Notice1, palette.Purple, "Skipping '%s': already imported in '%s' (use -force to import again)",
+				invoicePath, match.Entry.RunDir,
+			)
+			continue
+		}
+
+		invoiceOptions := options
+		invoiceOptions.ResumeRunDir = resumeRunDirs[sha256]
+		result, failedStage, e := pipeline.ImportInvoice(invoicePath, hashes, invoiceOptions)
+		if pipeline.ShouldIndex(failedStage) && result.RunDir != "" {
+			pipeline.AddToIndex(index, hashes, invoicePath, result.RunDir)
+		}
+		if e != nil {
+			failed++
+			tl.Log(
+				tl.Warning, palette.PurpleBold, "Failed '%s' at stage '%s': %s: '%s'",
+				invoicePath, string(failedStage), e.Msg, e.ErrStr,
+			)
+			continue
+		}
+		imported++
+	}
+
+	tl.Log(
+		tl.Notice, palette.GreenBold, "Done. Imported: '%s', skipped: '%s', failed: '%s'",
+		imported, skipped, failed,
+	)
+}
+
+/*
+resolveInvoices returns inputPath if it is an .xml/.zip file, or the
+.xml/.zip files directly inside it if it is a directory, sorted.
+*/
+func resolveInvoices(inputPath string) (invoicePaths []string, e *xerr.Error) {
+	info, statErr := os.Stat(inputPath)
+	if statErr != nil {
+		e = xerr.NewError(statErr, "stat -file input path", inputPath)
+		return nil, e
+	}
+
+	if !info.IsDir() {
+		if !dian.IsInvoiceExt(filepath.Ext(inputPath)) {
+			e = xerr.NewError(fmt.Errorf("unsupported extension '%s'", filepath.Ext(inputPath)), "input file is not .xml/.zip", inputPath)
+			return nil, e
+		}
+		return []string{inputPath}, nil
+	}
+
+	entries, readErr := os.ReadDir(inputPath)
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read directory", inputPath)
+		return nil, e
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !dian.IsInvoiceExt(filepath.Ext(entry.Name())) {
+			continue
+		}
+		invoicePaths = append(invoicePaths, filepath.Join(inputPath, entry.Name()))
+	}
+
+	sort.Strings(invoicePaths)
+	return invoicePaths, nil
+}
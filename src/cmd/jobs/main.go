@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/ledger"
+)
+
+/*
+openLedger parses the common flags plus whatever the subprogram registered
+on subprogramCmd, and opens the ledger.
+*/
+func openLedger(subprogramCmd *flag.FlagSet, flags []string) *ledger.Ledger {
+	configPath := subprogramCmd.String("config", "./cfg/config.json", "Path to your configuration file.")
+	databasePath := subprogramCmd.String("db", "", "Ledger database path (default: ledger.database_path from config)")
+
+	xerr.QuitIfError(subprogramCmd.Parse(flags), "Unable to subprogramCmd.Parse")
+	config.InitializeConfig(*configPath)
+	if *databasePath == "" {
+		*databasePath = ledger.Cfg.DatabasePath
+	}
+
+	receiptLedger, e := ledger.Open(*databasePath)
+	e.QuitIf("error")
+	return receiptLedger
+}
+
+/*
+status prints one job as JSON.
+*/
+func status(subprogram string, flags []string) {
+	subprogramCmd := flag.NewFlagSet(subprogram, flag.ExitOnError)
+	jobID := subprogramCmd.Int64("id", 0, "Job id (returned by POST /receipts)")
+	receiptLedger := openLedger(subprogramCmd, flags)
+	defer receiptLedger.Close()
+
+	job, found, e := receiptLedger.GetJob(*jobID)
+	e.QuitIf("error")
+	if !found {
+		tl.Log(tl.Error, palette.Red, "Job '%s' not found in '%s'", *jobID, receiptLedger.Path)
+		os.Exit(1)
+	}
+
+	jobJSON, marshalErr := json.MarshalIndent(job, "", "  ")
+	xerr.QuitIfError(marshalErr, "marshal job")
+	fmt.Println(string(jobJSON))
+}
+
+/*
+list prints the newest jobs, one per line.
+*/
+func list(subprogram string, flags []string) {
+	subprogramCmd := flag.NewFlagSet(subprogram, flag.ExitOnError)
+	state := subprogramCmd.String("state", "", "Only jobs in this state: queued, ocr, llm, done, failed")
+	limit := subprogramCmd.Int("limit", 20, "Maximum number of jobs (0 = all)")
+	receiptLedger := openLedger(subprogramCmd, flags)
+	defer receiptLedger.Close()
+
+	jobs, e := receiptLedger.ListJobs(ledger.JobState(*state), *limit)
+	e.QuitIf("error")
+
+	fmt.Printf("%-6s %-7s %-8s %-19s %-8s %s\n", "ID", "STATE", "ATTEMPTS", "UPDATED", "RECEIPT", "IMAGE / ERROR")
+	for _, job := range jobs {
+		detail := job.ImagePath
+		if job.Error != "" {
+			detail += " (" + job.FailedStage + ": " + job.Error + ")"
+		}
+		fmt.Printf(
+			"%-6d %-7s %-8s %-19s %-8d %s\n",
+			job.ID, job.State, fmt.Sprintf("%d/%d", job.Attempts, job.MaxAttempts),
+			time.UnixMilli(job.UpdatedAt).Format("2006-01-02 15:04:05"), job.ReceiptID, detail,
+		)
+	}
+}
+
+/*
+main inspects the receipt-server job queue stored in the ledger.
+
+Example:
+
+	go run ./src/cmd/jobs status -id 12
+	go run ./src/cmd/jobs list -state failed
+*/
+func main() {
+	if len(os.Args) < 2 {
+		tl.Log(tl.Error, palette.Red, "Usage: %s", "go run ./src/cmd/jobs status -id N | list [-state S] [-limit N]")
+		os.Exit(1)
+	}
+	subprogram := os.Args[1]
+	flags := os.Args[2:]
+
+	switch subprogram {
+	case "status":
+		status(subprogram, flags)
+	case "list":
+		list(subprogram, flags)
+	default:
+		tl.Log(tl.Error, palette.Red, "Unknown subprogram: %s", subprogram)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+
+	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/ledger"
+)
+
+/*
+main imports existing receipt-analysis.json files into the SQLite ledger.
+
+Every run directory under -out is (re)imported, so it can be used both to
+seed the ledger from results produced before it existed and to rebuild it
+after editing JSON files by hand.
+*/
+func main() {
+	// Common flags.
+	configPath := flag.String("config", "./cfg/config.json", "Path to your configuration file.")
+
+	// Program-specific flags.
+	outDirPath := flag.String("out", "./out", "Directory to scan recursively for receipt-analysis.json files")
+	databasePath := flag.String("db", "", "Ledger database path (default: ledger.database_path from config)")
+
+	flag.Parse()
+	config.InitializeConfig(*configPath)
+
+	if *databasePath == "" {
+		*databasePath = ledger.Cfg.DatabasePath
+	}
+
+	tl.Log(
+		tl.Notice, palette.BlueBold, "Importing receipt analyses from '%s' into ledger '%s'",
+		*outDirPath, *databasePath,
+	)
+
+	receiptLedger, e := ledger.Open(*databasePath)
+	e.QuitIf("error")
+	defer receiptLedger.Close()
+
+	stats, e := receiptLedger.ImportTree(*outDirPath)
+	e.QuitIf("error")
+
+	tl.Log(
+		tl.Notice, palette.GreenBold, "Done. Found: '%s', imported: '%s', failed: '%s'",
+		stats.Found, stats.Imported, stats.Failed,
+	)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/category"
+	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/pipeline"
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+openLedger parses the common flags plus whatever the subprogram registered
+on subprogramCmd, and opens the ledger.
+*/
+func openLedger(subprogramCmd *flag.FlagSet, flags []string) *ledger.Ledger {
+	configPath := subprogramCmd.String("config", "./cfg/config.json", "Path to your configuration file.")
+	databasePath := subprogramCmd.String("db", "", "Ledger database path (default: ledger.database_path from config)")
+
+	xerr.QuitIfError(subprogramCmd.Parse(flags), "Unable to subprogramCmd.Parse")
+	config.InitializeConfig(*configPath)
+	if *databasePath == "" {
+		*databasePath = ledger.Cfg.DatabasePath
+	}
+
+	receiptLedger, e := ledger.Open(*databasePath)
+	e.QuitIf("error")
+	return receiptLedger
+}
+
+/*
+confirm marks the receipt of a run directory as checked, with the
+corrections made in review-server applied, so the ledger learns its
+products. The confirmation is kept in receipt-corrections.json next to
+the untouched receipt-analysis.json.
+*/
+func confirm(subprogram string, flags []string) {
+	subprogramCmd := flag.NewFlagSet(subprogram, flag.ExitOnError)
+	runDir := subprogramCmd.String("run", "", "Run directory whose receipt was checked")
+	receiptLedger := openLedger(subprogramCmd, flags)
+	defer receiptLedger.Close()
+
+	if *runDir == "" {
+		tl.Log(tl.Error, palette.Red, "Flag %s is required", "-run")
+		os.Exit(1)
+	}
+
+	analysis, e := receipt.LoadAnalysis(receipt.AnalysisPath(*runDir))
+	e.QuitIf("error")
+	corrections, _, e := receipt.LoadCorrections(*runDir)
+	e.QuitIf("error")
+
+	confirmed, e := pipeline.ConfirmReceipt(receiptLedger, *runDir, analysis, corrections)
+	e.QuitIf("error")
+	tl.Log(
+		tl.Info, palette.Green, "Confirmed receipt '%s': learned the products of '%s' items",
+		*runDir, len(confirmed.Items),
+	)
+}
+
+/*
+list prints the product memory, one entry per line.
+*/
+func list(subprogram string, flags []string) {
+	subprogramCmd := flag.NewFlagSet(subprogram, flag.ExitOnError)
+	receiptLedger := openLedger(subprogramCmd, flags)
+	defer receiptLedger.Close()
+
+	products, e := receiptLedger.ListProducts()
+	e.QuitIf("error")
+
+	fmt.Printf("%-19s %-16s %-32s %-20s %s\n", "UPDATED", "MERCHANT", "PRODUCT KEY", "CATEGORY", "ENGLISH NAME")
+	for _, product := range products {
+		merchant := product.MerchantKey
+		if merchant == "" {
+			merchant = "-"
+		}
+		fmt.Printf(
+			"%-19s %-16s %-32s %-20s %s\n",
+			time.UnixMilli(product.UpdatedAt).Format("2006-01-02 15:04:05"), merchant,
+			product.ProductKey, product.CategoryKey, product.ProductNameEnglish,
+		)
+	}
+}
+
+/*
+set adds or replaces a product memory entry: by product name for every
+merchant, or by SKU for one merchant.
+*/
+func set(subprogram string, flags []string) {
+	subprogramCmd := flag.NewFlagSet(subprogram, flag.ExitOnError)
+	name := subprogramCmd.String("name", "", "Product name as printed on receipts")
+	categoryKey := subprogramCmd.String("category", "", "Category key from the taxonomy")
+	english := subprogramCmd.String("english", "", "English product name (optional)")
+	merchant := subprogramCmd.String("merchant", "", "Merchant tax ID (NIT) or lower-case name, with -sku")
+	sku := subprogramCmd.String("sku", "", "The merchant's SKU of the product (optional)")
+	receiptLedger := openLedger(subprogramCmd, flags)
+	defer receiptLedger.Close()
+
+	if *name == "" || *categoryKey == "" {
+		tl.Log(tl.Error, palette.Red, "Flags %s and %s are required", "-name", "-category")
+		os.Exit(1)
+	}
+	if _, found := category.Current.Lookup(*categoryKey); !found {
+		tl.Log(tl.Error, palette.Red, "Category '%s' is not in the taxonomy", *categoryKey)
+		os.Exit(1)
+	}
+	if (*merchant == "") != (*sku == "") {
+		tl.Log(tl.Error, palette.Red, "Flags %s and %s go together", "-merchant", "-sku")
+		os.Exit(1)
+	}
+
+	product := ledger.Product{ProductName: *name, ProductNameEnglish: *english, CategoryKey: *categoryKey}
+	if *sku != "" {
+		product.MerchantKey = *merchant
+		product.ProductKey = ledger.SKUKey(*sku)
+	}
+	e := receiptLedger.SaveProduct(product)
+	e.QuitIf("error")
+	tl.Log(tl.Info, palette.Green, "Remembered '%s' as '%s'", *name, *categoryKey)
+}
+
+/*
+forget removes a product memory entry.
+*/
+func forget(subprogram string, flags []string) {
+	subprogramCmd := flag.NewFlagSet(subprogram, flag.ExitOnError)
+	name := subprogramCmd.String("name", "", "Product name to forget")
+	merchant := subprogramCmd.String("merchant", "", "Merchant tax ID (NIT) or lower-case name, with -sku")
+	sku := subprogramCmd.String("sku", "", "The merchant's SKU to forget")
+	receiptLedger := openLedger(subprogramCmd, flags)
+	defer receiptLedger.Close()
+
+	merchantKey, productKey := "", ledger.NormalizeProductName(*name)
+	if *sku != "" {
+		merchantKey, productKey = *merchant, ledger.SKUKey(*sku)
+	}
+	if productKey == "" {
+		tl.Log(tl.Error, palette.Red, "Flag %s or flags %s are required", "-name", "-merchant -sku")
+		os.Exit(1)
+	}
+
+	found, e := receiptLedger.ForgetProduct(merchantKey, productKey)
+	e.QuitIf("error")
+	if !found {
+		tl.Log(tl.Error, palette.Red, "Product '%s' not found in '%s'", productKey, receiptLedger.Path)
+		os.Exit(1)
+	}
+	tl.Log(tl.Info, palette.Green, "Forgot '%s'", productKey)
+}
+
+/*
+main manages the product memory: the categories and English names the
+pipeline gives known products before and instead of the LLM.
+
+Example:
+
+	go run ./src/cmd/products confirm -run ./out/2025-09/run-0001
+	go run ./src/cmd/products list
+	go run ./src/cmd/products set -name "ACEITE GIRASOL PREMIER" -category sunflower_oil -english "Sunflower oil"
+	go run ./src/cmd/products forget -name "ACEITE GIRASOL PREMIER"
+*/
+func main() {
+	if len(os.Args) < 2 {
+		tl.Log(
+			tl.Error, palette.Red, "Usage: %s",
+			"go run ./src/cmd/products confirm -run DIR | list | set -name N -category C [-english E] [-merchant M -sku S] | forget -name N | -merchant M -sku S",
+		)
+		os.Exit(1)
+	}
+	subprogram := os.Args[1]
+	flags := os.Args[2:]
+
+	switch subprogram {
+	case "confirm":
+		confirm(subprogram, flags)
+	case "list":
+		list(subprogram, flags)
+	case "set":
+		set(subprogram, flags)
+	case "forget":
+		forget(subprogram, flags)
+	default:
+		tl.Log(tl.Error, palette.Red, "Unknown subprogram: %s", subprogram)
+		os.Exit(1)
+	}
+}
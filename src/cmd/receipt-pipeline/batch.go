@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/pipeline"
+)
+
+// imageStatus is the outcome of one image in a batch.
+type imageStatus string
+
+const (
+	statusProcessed        imageStatus = "processed"
+	statusAlreadyProcessed imageStatus = "already-processed"
+	statusFailed           imageStatus = "failed"
+)
+
+/*
+batchOptions holds the per-image settings shared by all workers.
+ResumeRunDirs (-resume) maps a source image SHA-256 to the run directory to
+continue in.
+*/
+type batchOptions struct {
+	FinalOutputDirPath string
+	Language           string
+	PriceDifference    bool
+	Force              bool
+	MaxHashDistance    int
+	Workers            int
+	Ledger             *ledger.Ledger
+	ResumeRunDirs      map[string]string
+}
+
+/*
+batchTask is an image that passed the duplicate check and must be processed.
+Position is its index in the batch, so results keep the input order.
+PartPaths is set for a receipt photographed in several parts (ImagePath
+is then the parts joined with "," and Hashes their group hashes).
+*/
+type batchTask struct {
+	Position  int
+	ImagePath string
+	PartPaths []string
+	Hashes    imageindex.ImageHashes
+}
+
+// imagePaths returns the photos of the task's receipt.
+func (task batchTask) imagePaths() []string {
+	if len(task.PartPaths) > 0 {
+		return task.PartPaths
+	}
+	return []string{task.ImagePath}
+}
+
+/*
+imageResult is one line of the batch summary.
+
+Fields:
+  - ImagePath: input image (photos of a stitched receipt joined with ",").
+  - Status: processed, already-processed or failed.
+  - Stage: stage the image failed at (failed only).
+  - RunDir: run directory with OCR/LLM artifacts (may be set for failures too).
+  - DuplicateOf: run directory of the earlier copy (already-processed only).
+  - Error: the error that stopped the image (failed only).
+  - SkippedStages: stages reused from an earlier run (-resume).
+  - DurationMs: wall time spent on this image.
+*/
+type imageResult struct {
+	ImagePath     string           `json:"image_path"`
+	Status        imageStatus      `json:"status"`
+	Stage         pipeline.Stage   `json:"stage,omitempty"`
+	RunDir        string           `json:"run_dir,omitempty"`
+	DuplicateOf   string           `json:"duplicate_of,omitempty"`
+	Error         *xerr.Error      `json:"error,omitempty"`
+	SkippedStages []pipeline.Stage `json:"skipped_stages,omitempty"`
+	DurationMs    int64            `json:"duration_ms"`
+}
+
+/*
+batchSummary is written to <out>/<month-year>/batch-summary-<timestamp>.json
+at the end of every pipeline run.
+*/
+type batchSummary struct {
+	StartedAt        int64         `json:"started_at"`
+	FinishedAt       int64         `json:"finished_at"`
+	Workers          int           `json:"workers"`
+	Processed        int           `json:"processed"`
+	AlreadyProcessed int           `json:"already_processed"`
+	Failed           int           `json:"failed"`
+	Results          []imageResult `json:"results"`
+}
+
+/*
+planBatch hashes every image (sequentially, it's cheap compared to OCR/LLM)
+and splits the batch into images to process and results for images that are
+skipped: already in the index, a near-duplicate of an earlier image in the
+same batch, or not hashable. Each entry of imageGroups is one receipt: a
+single photo, or the photos of a long receipt top to bottom.
+
+results has one slot per receipt; slots for returned tasks are left empty
+and filled in by runBatch.
+*/
+func planBatch(imageGroups [][]string, index *imageindex.Index, options batchOptions) (tasks []batchTask, results []imageResult) {
+	results = make([]imageResult, len(imageGroups))
+	plannedInBatch := &imageindex.Index{}
+
+	for position, imageGroup := range imageGroups {
+		imagePath := strings.Join(imageGroup, ",")
+		hashes, e := computeGroupHashes(imageGroup)
+		if e != nil {
+			results[position] = imageResult{ImagePath: imagePath, Status: statusFailed, Stage: pipeline.StageHash, Error: e}
+			continue
+		}
+
+		if !options.Force {
+			match, found := index.FindDuplicate(hashes, imagePath, options.MaxHashDistance)
+			if found {
+				logDuplicate(imagePath, match)
+				results[position] = imageResult{ImagePath: imagePath, Status: statusAlreadyProcessed, DuplicateOf: match.Entry.RunDir}
+				continue
+			}
+
+			match, found = plannedInBatch.FindDuplicate(hashes, imagePath, options.MaxHashDistance)
+			if found {
+				tl.Log(
+					tl.Notice1, palette.Purple, "Skipping '%s': same receipt as '%s' in this batch (use -force to process both)",
+					imagePath, match.Entry.SourcePath,
+				)
+				results[position] = imageResult{ImagePath: imagePath, Status: statusAlreadyProcessed, DuplicateOf: match.Entry.SourcePath}
+				continue
+			}
+			plannedInBatch.Add(hashes, imagePath, "")
+		}
+
+		task := batchTask{Position: position, ImagePath: imagePath, Hashes: hashes}
+		if len(imageGroup) > 1 {
+			task.PartPaths = imageGroup
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, results
+}
+
+/*
+computeGroupHashes hashes the photos of one receipt (see
+imageindex.GroupHashes).
+*/
+func computeGroupHashes(imagePaths []string) (hashes imageindex.ImageHashes, e *xerr.Error) {
+	parts := make([]imageindex.ImageHashes, 0, len(imagePaths))
+	for _, imagePath := range imagePaths {
+		partHashes, e := imageindex.ComputeHashes(imagePath)
+		if e != nil {
+			return hashes, e
+		}
+		parts = append(parts, partHashes)
+	}
+	return imageindex.GroupHashes(parts), nil
+}
+
+/*
+runBatch processes tasks with options.Workers goroutines. Each worker runs
+OCR and then the LLM stage for one image at a time, so while one image waits
+for the model another one is being OCR'd. LLM calls are additionally spaced
+out by the shared limiter in the llm package.
+
+Successful images are added to the index (saved after each one, so an
+interrupted run keeps its progress). Results are written into their slots.
+*/
+func runBatch(tasks []batchTask, results []imageResult, index *imageindex.Index, options batchOptions) {
+	taskChannel := make(chan batchTask)
+	var waitGroup sync.WaitGroup
+
+	for workerNumber := 1; workerNumber <= options.Workers; workerNumber++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for task := range taskChannel {
+				results[task.Position] = processTask(task, index, options)
+			}
+		}()
+	}
+
+	for _, task := range tasks {
+		taskChannel <- task
+	}
+	close(taskChannel)
+	waitGroup.Wait()
+}
+
+/*
+processTask runs one image through the pipeline and turns the outcome into
+an imageResult. Failures are only noted briefly here; the details go to the
+batch summary.
+*/
+func processTask(task batchTask, index *imageindex.Index, options batchOptions) (result imageResult) {
+	startTime := time.Now()
+	tl.Log(tl.Notice, palette.BlueBold, "%s '%s'", "Processing image", task.ImagePath)
+
+	processed, failedStage, e := pipeline.ProcessImages(task.imagePaths(), task.Hashes, pipeline.Options{
+		OutputDirPath:   options.FinalOutputDirPath,
+		Language:        options.Language,
+		PriceDifference: options.PriceDifference,
+		Ledger:          options.Ledger,
+		ResumeRunDir:    options.ResumeRunDirs[task.Hashes.SHA256],
+	})
+	result = imageResult{
+		ImagePath:     task.ImagePath,
+		RunDir:        processed.RunDir,
+		DurationMs:    time.Since(startTime).Milliseconds(),
+		SkippedStages: processed.SkippedStages,
+	}
+
+	if pipeline.ShouldIndex(failedStage) {
+		pipeline.AddToIndex(index, task.Hashes, task.ImagePath, processed.RunDir)
+	}
+
+	if e != nil {
+		result.Status = statusFailed
+		result.Stage = failedStage
+		result.Error = e
+		tl.Log(tl.Warning, palette.PurpleBold, "Failed '%s' at stage '%s' (details in the summary)", task.ImagePath, failedStage)
+		return result
+	}
+
+	result.Status = statusProcessed
+	tl.Log(
+		tl.Notice1, palette.GreenBold, "%s for '%s'. Results stored in '%s'",
+		"OCR+analysis completed", task.ImagePath, processed.RunDir,
+	)
+	return result
+}
+
+/*
+summarizeBatch counts results per status.
+*/
+func summarizeBatch(results []imageResult, startTime time.Time, workers int) (summary batchSummary) {
+	summary = batchSummary{
+		StartedAt:  startTime.UnixMilli(),
+		FinishedAt: time.Now().UnixMilli(),
+		Workers:    workers,
+		Results:    results,
+	}
+
+	for _, result := range results {
+		switch result.Status {
+		case statusProcessed:
+			summary.Processed++
+		case statusAlreadyProcessed:
+			summary.AlreadyProcessed++
+		case statusFailed:
+			summary.Failed++
+		}
+	}
+
+	return summary
+}
+
+/*
+logBatchSummary prints the totals followed by one block per failed image.
+*/
+func logBatchSummary(summary batchSummary) {
+	elapsed := time.Duration(summary.FinishedAt-summary.StartedAt) * time.Millisecond
+
+	tl.Log(
+		tl.Notice, palette.GreenBold, "Done in %s with '%s' workers. Processed: '%s', failed: '%s', already processed: '%s'",
+		elapsed.Round(time.Second), summary.Workers, summary.Processed, summary.Failed, summary.AlreadyProcessed,
+	)
+
+	if summary.Failed == 0 {
+		return
+	}
+
+	tl.Log(tl.Warning, palette.RedBold, "%s '%s' images:", "Failed", summary.Failed)
+	for _, result := range summary.Results {
+		if result.Status != statusFailed {
+			continue
+		}
+		tl.Log(
+			tl.Warning1, palette.Red, "  - '%s' (stage '%s', run dir '%s'): %s: '%s'",
+			result.ImagePath, result.Stage, result.RunDir, result.Error.Msg, result.Error.ErrStr,
+		)
+	}
+}
+
+/*
+saveBatchSummary writes the summary as JSON next to the run directories and
+returns the file path.
+*/
+func saveBatchSummary(finalOutputDirPath string, summary batchSummary) (summaryPath string, e *xerr.Error) {
+	mkdirErr := os.MkdirAll(finalOutputDirPath, 0o755)
+	if mkdirErr != nil {
+		e = xerr.NewError(mkdirErr, "create output directory", finalOutputDirPath)
+		return "", e
+	}
+
+	fileName := fmt.Sprintf("batch-summary-%s.json", time.UnixMilli(summary.StartedAt).Format("2006-01-02_15-04-05"))
+	summaryPath = filepath.Join(finalOutputDirPath, fileName)
+
+	jsonBytes, marshalErr := json.MarshalIndent(summary, "", "  ")
+	if marshalErr != nil {
+		e = xerr.NewError(marshalErr, "marshal batch summary", summaryPath)
+		return "", e
+	}
+
+	writeErr := os.WriteFile(summaryPath, jsonBytes, 0o644)
+	if writeErr != nil {
+		e = xerr.NewError(writeErr, "write batch summary", summaryPath)
+		return "", e
+	}
+
+	return summaryPath, nil
+}
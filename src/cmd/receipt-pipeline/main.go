@@ -1,12 +1,13 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,8 +16,11 @@ import (
 	"github.com/tuumbleweed/xerr"
 
 	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/inputfile"
+	"expense-tracker/src/pkg/ledger"
 	"expense-tracker/src/pkg/llm"
-	"expense-tracker/src/pkg/ocr"
+	"expense-tracker/src/pkg/pipeline"
 	"expense-tracker/src/pkg/util"
 )
 
@@ -24,38 +28,66 @@ import (
 main runs the full receipt pipeline.
 
 -input / -image can be:
-  - a single image file (.jpg/.jpeg/.png)
-  - a directory containing images (.jpg/.jpeg/.png)
-
-For each image:
+  - a single image file (.jpg/.jpeg/.png/.webp/.heic/.heif/.pdf)
+  - several comma-separated images: overlapping photos of one long receipt,
+    top to bottom (-image a.jpg,b.jpg)
+  - a directory containing images (.jpg/.jpeg/.png/.webp/.heic/.heif/.pdf);
+    with -group-parts, files named <name>-1, <name>-2, ... (e.g. 12-1.jpg,
+    12-2.jpg) are the parts of one long receipt
+
+Parts that turn out to share no text are processed as separate receipts.
+
+For each image (or group of parts):
+  0) Skip it if the same (or a near-identical) image is already in
+     out/image-index.json, unless -force is given
   1) OCR into an output run directory
   2) Run LLM receipt analysis using OCR text + image
   3) Save receipt-analysis.json into the same run directory
+  4) Store the receipt in the SQLite ledger (ledger.database_path)
+  5) Record the image hashes in the index
+
+With -workers N, up to N images go through steps 1-5 at the same time.
+Images that fail don't stop the batch; they are listed at the end and in
+batch-summary-<timestamp>.json in the output directory.
+
+Every run directory gets a manifest.json with the state and inputs of each
+stage. With -resume, an image that has an earlier run directory (e.g. the
+LLM call failed) continues there and skips the stages whose inputs haven't
+changed.
+
+With -watch, -image must be a directory: the whole tree is watched and
+images are processed as they appear (once they have stopped changing for
+-settle), then moved to -archive-dir, or to -errors-dir with the reason.
 */
 func main() {
-	config.CheckIfEnvVarsPresent("OPENAI_API_KEY")
-
 	// Common flags.
 	configPath := flag.String("config", "./cfg/config.json", "Path to your configuration file.")
 
 	// Program-specific flags.
-	imagePath := flag.String("image", "", "Path to a receipt image OR a directory with images (.jpg/.jpeg/.png).")
+	imagePath := flag.String("image", "", "Path to a receipt image, comma-separated photos of one long receipt, OR a directory with images (.jpg/.jpeg/.png/.webp/.heic/.heif/.pdf).")
 	outputDirPath := flag.String("out", "./out", "Directory where processed images and OCR text will be stored.")
 	language := flag.String("language", "eng+spa", "Language of the receipt. eng, spa, por, spa+eng etc. \"tesseract --list-langs\", \"apt install tesseract-ocr-fra\"")
-	priceDifference := flag.Bool("price-difference", false, "If sum and overall prices are different - stop the program")
+	priceDifference := flag.Bool("price-difference", false, "Fail receipts whose items don't add up to the total (they are still stored and queued for review)")
+	force := flag.Bool("force", false, "Reprocess images even if they (or a near-duplicate photo) were already processed")
+	maxHashDistance := flag.Int("max-hash-distance", imageindex.DefaultMaxDistance, "Max perceptual hash distance (bits) to treat two photos as the same receipt")
+	workers := flag.Int("workers", 1, "Number of images processed concurrently (OCR + LLM). LLM calls are also limited by llm.requests_per_minute")
+	resume := flag.Bool("resume", false, "Continue in the earlier run directory of an image, skipping stages whose inputs haven't changed")
+	groupParts := flag.Bool("group-parts", false, "In an -image directory, process <name>-1, <name>-2, ... as the photos of one long receipt")
+	watch := flag.Bool("watch", false, "Keep running and process images as they appear anywhere under the -image directory")
+	settle := flag.Duration("settle", 3*time.Second, "With -watch: how long a file must stay unchanged before it's considered completely written")
+	archiveDirPath := flag.String("archive-dir", "", "With -watch: where processed originals are moved (default: <image dir>/archive)")
+	errorsDirPath := flag.String("errors-dir", "", "With -watch: where failed originals are moved, with a .error.json reason (default: <image dir>/errors)")
 
 	flag.Parse()
 	util.RequiredFlag(imagePath, "image")
 	util.EnsureFlags()
+	if *workers < 1 {
+		xerr.NewError(fmt.Errorf("-workers must be at least 1"), "invalid -workers value", *workers).QuitIf("error")
+	}
 	config.InitializeConfig(*configPath)
+	config.CheckIfEnvVarsPresent(llm.RequiredEnvVars()...)
 
-	// Build year-month suffix like "september-2006".
-	currentTime := time.Now()
-	monthName := strings.ToLower(currentTime.Month().String())
-	yearValue := currentTime.Year()
-	yearMonthDirName := fmt.Sprintf("%s-%04d", monthName, yearValue)
-
-	finalOutputDirPath := filepath.Join(*outputDirPath, yearMonthDirName)
+	finalOutputDirPath := pipeline.MonthOutputDir(*outputDirPath, time.Now())
 
 	tl.Log(
 		tl.Notice, palette.BlueBold, "%s entrypoint. Config path: '%s'",
@@ -66,12 +98,44 @@ func main() {
 		"Using output directory", finalOutputDirPath,
 	)
 
-	imagesToProcess, e := resolveImagesToProcess(*imagePath)
+	resumeRunDirs := make(map[string]string)
+	if *resume {
+		var e *xerr.Error
+		resumeRunDirs, e = pipeline.FindRunDirsBySource(*outputDirPath)
+		e.QuitIf("error")
+		tl.Log(tl.Info1, palette.Cyan, "Found '%s' run directories to resume from in '%s'", len(resumeRunDirs), *outputDirPath)
+	}
+
+	if *watch {
+		options, e := resolveWatchOptions(*imagePath, *outputDirPath, *archiveDirPath, *errorsDirPath, *settle)
+		e.QuitIf("error")
+
+		index, e := imageindex.Load(filepath.Join(*outputDirPath, imageindex.FileName))
+		e.QuitIf("error")
+
+		receiptLedger, e := ledger.Open(ledger.Cfg.DatabasePath)
+		e.QuitIf("error")
+		defer receiptLedger.Close()
+
+		e = runWatch(options, batchOptions{
+			Language:        *language,
+			PriceDifference: *priceDifference,
+			Force:           *force,
+			MaxHashDistance: *maxHashDistance,
+			Workers:         *workers,
+			Ledger:          receiptLedger,
+			ResumeRunDirs:   resumeRunDirs,
+		}, index)
+		e.QuitIf("error")
+		return
+	}
+
+	imagesToProcess, e := resolveImagesToProcess(*imagePath, *groupParts)
 	e.QuitIf("error")
 
 	if len(imagesToProcess) == 0 {
 		tl.Log(
-			tl.Warning, palette.PurpleBold, "No .jpg/.jpeg/.png files found at: '%s'",
+			tl.Warning, palette.PurpleBold, "No .jpg/.jpeg/.png/.webp/.heic/.heif/.pdf files found at: '%s'",
 			*imagePath,
 		)
 		os.Exit(0)
@@ -79,41 +143,121 @@ func main() {
 
 	if len(imagesToProcess) > 1 {
 		tl.Log(
-			tl.Notice1, palette.GreenBold, "Found '%d' images to process",
+			tl.Notice1, palette.GreenBold, "Found '%d' receipts to process",
 			len(imagesToProcess),
 		)
 	}
 
-	processedCount := 0
-	skippedCount := 0
+	index, e := imageindex.Load(filepath.Join(*outputDirPath, imageindex.FileName))
+	e.QuitIf("error")
 
-	for _, imgPath := range imagesToProcess {
-		tl.Log(tl.Notice, palette.BlueBold, "%s '%s'", "Processing image", imgPath)
+	receiptLedger, e := ledger.Open(ledger.Cfg.DatabasePath)
+	e.QuitIf("error")
+	defer receiptLedger.Close()
+
+	options := batchOptions{
+		FinalOutputDirPath: finalOutputDirPath,
+		Language:           *language,
+		PriceDifference:    *priceDifference,
+		Force:              *force,
+		MaxHashDistance:    *maxHashDistance,
+		Workers:            *workers,
+		Ledger:             receiptLedger,
+		ResumeRunDirs:      resumeRunDirs,
+	}
 
-		runDirPath, e := processOneImage(imgPath, finalOutputDirPath, *language, *priceDifference)
-		if e != nil {
-			skippedCount++
-			tl.Log(
-				tl.Error, palette.RedBold, "Failed processing '%s': '%s'",
-				imgPath, e,
-			)
-			continue
-		}
+	batchStartTime := time.Now()
+	tasks, results := planBatch(imagesToProcess, index, options)
+	if len(tasks) > 0 {
+		tl.Log(
+			tl.Notice1, palette.GreenBold, "Processing '%s' images with '%s' workers",
+			len(tasks), options.Workers,
+		)
+	}
+	runBatch(tasks, results, index, options)
+
+	summary := summarizeBatch(results, batchStartTime, options.Workers)
+	logBatchSummary(summary)
 
-		processedCount++
+	summaryPath, e := saveBatchSummary(finalOutputDirPath, summary)
+	if e != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed saving batch summary: '%s'", e.ErrStr)
+	} else {
+		tl.Log(tl.Info, palette.Green, "%s to '%s'", "Saved batch summary", summaryPath)
+	}
+}
+
+/*
+logDuplicate explains why an image is skipped as already processed.
+*/
+func logDuplicate(imagePath string, match imageindex.Match) {
+	if match.Exact {
 		tl.Log(
-			tl.Notice1, palette.GreenBold, "%s. Results stored in '%s'",
-			"OCR+analysis completed", runDirPath,
+			tl.Notice1, palette.Purple, "Skipping '%s': identical image already processed in '%s' (use -force to reprocess)",
+			imagePath, match.Entry.RunDir,
 		)
+		return
 	}
 
 	tl.Log(
-		tl.Notice, palette.GreenBold, "Done. Processed: '%s', skipped: '%s'",
-		processedCount, skippedCount,
+		tl.Notice1, palette.Purple, "Skipping '%s': looks like '%s' (hash distance %s) already processed in '%s' (use -force to reprocess)",
+		imagePath, match.Entry.SourcePath, fmt.Sprintf("%d", match.Distance), match.Entry.RunDir,
 	)
 }
 
-func resolveImagesToProcess(inputPath string) (images []string, e *xerr.Error) {
+/*
+resolveWatchOptions checks that the watched path is a directory and makes
+all -watch paths absolute, so events and the archive/errors exclusion
+compare the same way.
+*/
+func resolveWatchOptions(rootDirPath, outputDirPath, archiveDirPath, errorsDirPath string, settle time.Duration) (options watchOptions, e *xerr.Error) {
+	info, statErr := os.Stat(rootDirPath)
+	if statErr != nil {
+		e = xerr.NewError(statErr, "stat -image directory to watch", rootDirPath)
+		return options, e
+	}
+	if !info.IsDir() {
+		e = xerr.NewError(fmt.Errorf("not a directory"), "-watch needs -image to be a directory", rootDirPath)
+		return options, e
+	}
+
+	if archiveDirPath == "" {
+		archiveDirPath = filepath.Join(rootDirPath, "archive")
+	}
+	if errorsDirPath == "" {
+		errorsDirPath = filepath.Join(rootDirPath, "errors")
+	}
+
+	options = watchOptions{OutputDirPath: outputDirPath, Settle: settle}
+	options.RootDirPath, e = absolutePath(rootDirPath)
+	if e == nil {
+		options.ArchiveDirPath, e = absolutePath(archiveDirPath)
+	}
+	if e == nil {
+		options.ErrorsDirPath, e = absolutePath(errorsDirPath)
+	}
+	if e != nil {
+		return options, e
+	}
+
+	return options, nil
+}
+
+func absolutePath(path string) (absolute string, e *xerr.Error) {
+	absolute, absErr := filepath.Abs(path)
+	if absErr != nil {
+		e = xerr.NewError(absErr, "resolve absolute path", path)
+		return "", e
+	}
+	return absolute, nil
+}
+
+/*
+resolveImagesToProcess returns the receipts to process, each as its photos
+(more than one for a long receipt photographed in parts: comma-separated
+paths, or <name>-N files of a directory with groupParts).
+*/
+func resolveImagesToProcess(inputPath string, groupParts bool) (imageGroups [][]string, e *xerr.Error) {
 	trimmed := strings.TrimSpace(inputPath)
 	if trimmed == "" {
 		err := fmt.Errorf("input path is empty")
@@ -121,6 +265,23 @@ func resolveImagesToProcess(inputPath string) (images []string, e *xerr.Error) {
 		return
 	}
 
+	// a.jpg,b.jpg: the parts of one receipt.
+	if strings.Contains(trimmed, ",") {
+		var parts []string
+		for _, part := range strings.Split(trimmed, ",") {
+			partGroups, e := resolveImagesToProcess(part, false)
+			if e != nil {
+				return nil, e
+			}
+			if len(partGroups) != 1 || len(partGroups[0]) != 1 {
+				e = xerr.NewError(fmt.Errorf("not a single image"), "parts in -image must be image files", part)
+				return nil, e
+			}
+			parts = append(parts, partGroups[0][0])
+		}
+		return [][]string{parts}, nil
+	}
+
 	info, statErr := os.Stat(trimmed)
 	if statErr != nil {
 		e = xerr.NewError(statErr, "stat -image input path", trimmed)
@@ -128,21 +289,31 @@ func resolveImagesToProcess(inputPath string) (images []string, e *xerr.Error) {
 	}
 
 	if info.IsDir() {
-		return listImagesInDir(trimmed)
+		return listImagesInDir(trimmed, groupParts)
 	}
 
 	// File path
 	ext := strings.ToLower(filepath.Ext(trimmed))
-	if !isAllowedImageExt(ext) {
+	if !pipeline.IsAllowedImageExt(ext) {
 		err := fmt.Errorf("unsupported image extension: %s", ext)
-		e = xerr.NewError(err, "input file is not .jpg/.jpeg/.png", trimmed)
+		e = xerr.NewError(err, "input file is not "+inputfile.SupportedExtensions, trimmed)
 		return
 	}
 
-	return []string{trimmed}, nil
+	return [][]string{{trimmed}}, nil
 }
 
-func listImagesInDir(dirPath string) (images []string, e *xerr.Error) {
+// partNameRegexp matches the base name of a receipt part: <name>-<part number>.
+var partNameRegexp = regexp.MustCompile(`^(.+)-([1-9])$`)
+
+/*
+listImagesInDir returns the images directly in dirPath, sorted. With
+groupParts, images named <name>-1, <name>-2, ... (same name and extension,
+numbered from 1) form one group: the parts of a long receipt in number
+order. A lone <name>-1 is just an image.
+*/
+func listImagesInDir(dirPath string, groupParts bool) (imageGroups [][]string, e *xerr.Error) {
+	var images []string
 	entries, readErr := os.ReadDir(dirPath)
 	if readErr != nil {
 		e = xerr.NewError(readErr, "read directory", dirPath)
@@ -156,121 +327,72 @@ func listImagesInDir(dirPath string) (images []string, e *xerr.Error) {
 
 		nameLower := strings.ToLower(ent.Name())
 		ext := strings.ToLower(filepath.Ext(nameLower))
-		if !isAllowedImageExt(ext) {
+		if !pipeline.IsAllowedImageExt(ext) {
 			continue
 		}
 
 		images = append(images, filepath.Join(dirPath, ent.Name()))
 	}
-
 	sort.Strings(images)
-	return
-}
-
-func isAllowedImageExt(ext string) bool {
-	switch strings.ToLower(ext) {
-	case ".jpg", ".jpeg", ".png":
-		return true
-	default:
-		return false
-	}
-}
-
-func processOneImage(imagePath, finalOutputDirPath, language string, priceDifference bool) (runDirPath string, e *xerr.Error) {
-	// 1) OCR pipeline
-	runDirPath, e = ocr.ProcessImage(imagePath, finalOutputDirPath, language)
-	if e != nil {
-		return "", e
-	}
-
-	// 2) Load OCR outputs for analysis
-	pricesPath := filepath.Join(runDirPath, "prices.json")
-	ocrTextPath := filepath.Join(runDirPath, "ocr.txt")
-
-	ocrTextBytes, readErr := os.ReadFile(ocrTextPath)
-	if readErr != nil {
-		e = xerr.NewError(readErr, "read OCR text file", ocrTextPath)
-		return "", e
-	}
-	ocrText := string(ocrTextBytes)
-
-	ocrPrices, e := llm.ReadOcrPricesFromFile(pricesPath)
-	if e != nil {
-		return "", e
-	}
-
-	origImagePath, e := findOriginalImagePath(runDirPath)
-	if e != nil {
-		return "", e
-	}
-
-	tl.Log(
-		tl.Info1, palette.Cyan, "Loaded OCR artifacts from '%s' (ocr len: '%s', image: '%s')",
-		runDirPath, fmt.Sprintf("%d", len(ocrText)), origImagePath,
-	)
-
-	// 3) LLM analysis
-	receiptAnalysis, analysisErr := llm.GenerateReceiptAnalysisFromImage(origImagePath, ocrText, ocrPrices, nil)
-	if analysisErr != nil {
-		return "", analysisErr
+	if !groupParts {
+		for _, imagePath := range images {
+			imageGroups = append(imageGroups, []string{imagePath})
+		}
+		return imageGroups, nil
 	}
 
-	if priceDifference {
-		// In batch mode, don’t kill the whole run; just skip this image.
-		if receiptAnalysis.Totals.TotalCheckMessage != "" {
-			tl.Log(
-				tl.Warning, palette.PurpleBold, "Receipt total does not match sum of items: '%s'",
-				receiptAnalysis.Totals.TotalCheckMessage,
-			)
-			tl.Log(tl.Warning1, palette.PurpleBold, "%s", "Try taking a photo again")
-			err := fmt.Errorf("totals mismatch")
-			e = xerr.NewError(err, "receipt totals mismatch", runDirPath)
-			return "", e
+	// Collect the parts of each <name>-N group.
+	partsByName := make(map[string]map[int]string)
+	for _, imagePath := range images {
+		name, number, isPart := partName(imagePath)
+		if !isPart {
+			continue
+		}
+		if partsByName[name] == nil {
+			partsByName[name] = make(map[int]string)
 		}
+		partsByName[name][number] = imagePath
 	}
-	
 
-	analysisPath := filepath.Join(runDirPath, "receipt-analysis.json")
-
-	jsonBytes, marshalErr := json.MarshalIndent(receiptAnalysis, "", "  ")
-	if marshalErr != nil {
-		e = xerr.NewError(marshalErr, "marshal receipt analysis to JSON", runDirPath)
-		return "", e
-	}
+	grouped := make(map[string]bool)
+	for _, imagePath := range images {
+		name, _, isPart := partName(imagePath)
+		parts := partsByName[name]
+		if !isPart || len(parts) < 2 || parts[1] == "" {
+			imageGroups = append(imageGroups, []string{imagePath})
+			continue
+		}
+		if grouped[name] {
+			continue
+		}
+		grouped[name] = true
 
-	writeErr := os.WriteFile(analysisPath, jsonBytes, 0o644)
-	if writeErr != nil {
-		e = xerr.NewError(writeErr, "write receipt-analysis.json file", analysisPath)
-		return "", e
+		var group []string
+		for number := 1; parts[number] != ""; number++ {
+			group = append(group, parts[number])
+		}
+		for number, partPath := range parts {
+			if number > len(group) {
+				tl.Log(tl.Warning, palette.PurpleBold, "'%s' doesn't follow part '%s' of its receipt, processing it alone", partPath, len(group))
+				imageGroups = append(imageGroups, []string{partPath})
+			}
+		}
+		imageGroups = append(imageGroups, group)
 	}
 
-	tl.LogJSON(tl.Verbose, palette.CyanDim, "ReceiptAnalysis", receiptAnalysis)
-
-	tl.Log(
-		tl.Notice, palette.GreenBold, "%s",
-		"Receipt analysis generated and saved successfully",
-	)
-	tl.Log(
-		tl.Info, palette.Green, "%s to '%s'",
-		"Saved receipt analysis", analysisPath,
-	)
-
-	return runDirPath, nil
+	return imageGroups, nil
 }
 
-func findOriginalImagePath(runDirPath string) (imagePath string, e *xerr.Error) {
-	pattern := filepath.Join(runDirPath, "orig.*")
-	matches, globErr := filepath.Glob(pattern)
-	if globErr != nil {
-		e = xerr.NewError(globErr, "glob for original image", pattern)
-		return
-	}
-	if len(matches) == 0 {
-		err := fmt.Errorf("no original image found")
-		e = xerr.NewError(err, "missing original image (expected orig.*)", runDirPath)
-		return
+/*
+partName splits "<dir>/<name>-<N>.<ext>" into its group key (dir, name and
+extension) and part number.
+*/
+func partName(imagePath string) (name string, number int, isPart bool) {
+	ext := filepath.Ext(imagePath)
+	match := partNameRegexp.FindStringSubmatch(strings.TrimSuffix(imagePath, ext))
+	if match == nil {
+		return "", 0, false
 	}
-
-	imagePath = matches[0]
-	return
+	number, _ = strconv.Atoi(match[2])
+	return match[1] + strings.ToLower(ext), number, true
 }
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/pipeline"
+)
+
+// watchPollInterval is how often pending files are checked for being complete.
+const watchPollInterval = 500 * time.Millisecond
+
+/*
+watchOptions configure -watch mode.
+
+Fields:
+  - RootDirPath: watched directory tree (-image).
+  - OutputDirPath: -out root; every image goes to the month directory of
+    the moment it is processed.
+  - ArchiveDirPath: processed (and already processed) originals are moved
+    here, keeping their path relative to RootDirPath.
+  - ErrorsDirPath: failed originals are moved here, each next to a
+    <name>.error.json with the reason.
+  - Settle: how long a file's size and modification time must stay the same
+    before it is considered completely written.
+*/
+type watchOptions struct {
+	RootDirPath    string
+	OutputDirPath  string
+	ArchiveDirPath string
+	ErrorsDirPath  string
+	Settle         time.Duration
+}
+
+/*
+pendingFile is an image seen in the tree that may still be being written.
+*/
+type pendingFile struct {
+	Size      int64
+	ModTime   time.Time
+	ChangedAt time.Time
+}
+
+/*
+watchFailure is written to errors/<name>.error.json next to a failed image.
+*/
+type watchFailure struct {
+	ImagePath string         `json:"image_path"`
+	FailedAt  int64          `json:"failed_at"`
+	Stage     pipeline.Stage `json:"stage,omitempty"`
+	RunDir    string         `json:"run_dir,omitempty"`
+	Error     *xerr.Error    `json:"error"`
+}
+
+/*
+folderWatcher holds the -watch state. Everything except the workers runs on
+the loop goroutine, so the maps need no locking.
+*/
+type folderWatcher struct {
+	options   watchOptions
+	batch     batchOptions
+	index     *imageindex.Index
+	fsWatcher *fsnotify.Watcher
+
+	pending       map[string]pendingFile // path -> last seen size/mtime
+	inFlightPaths map[string]string      // queued or running path -> its SHA-256
+	inFlightSHAs  map[string]bool
+	queued        []batchTask
+}
+
+/*
+runWatch processes images that appear anywhere under options.RootDirPath
+until SIGINT/SIGTERM, using batch.Workers workers. Images already in the
+tree when it starts are processed first. On shutdown it waits for the
+running images; queued ones stay in place and are picked up on the next start.
+*/
+func runWatch(options watchOptions, batch batchOptions, index *imageindex.Index) (e *xerr.Error) {
+	fsWatcher, watcherErr := fsnotify.NewWatcher()
+	if watcherErr != nil {
+		e = xerr.NewError(watcherErr, "create file system watcher", options.RootDirPath)
+		return e
+	}
+	defer fsWatcher.Close()
+
+	watcher := &folderWatcher{
+		options:       options,
+		batch:         batch,
+		index:         index,
+		fsWatcher:     fsWatcher,
+		pending:       make(map[string]pendingFile),
+		inFlightPaths: make(map[string]string),
+		inFlightSHAs:  make(map[string]bool),
+	}
+
+	e = watcher.addTree(options.RootDirPath)
+	if e != nil {
+		return e
+	}
+
+	tl.Log(
+		tl.Notice, palette.BlueBold, "Watching '%s' (archive: '%s', errors: '%s', '%s' existing images)",
+		options.RootDirPath, options.ArchiveDirPath, options.ErrorsDirPath, len(watcher.pending),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	taskChannel := make(chan batchTask)
+	resultChannel := make(chan imageResult)
+	var waitGroup sync.WaitGroup
+	for workerNumber := 1; workerNumber <= batch.Workers; workerNumber++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for task := range taskChannel {
+				taskOptions := batch
+				taskOptions.FinalOutputDirPath = pipeline.MonthOutputDir(options.OutputDirPath, time.Now())
+				resultChannel <- processTask(task, index, taskOptions)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		// Only offer a task when there is one; a nil channel never receives.
+		var sendChannel chan batchTask
+		var nextTask batchTask
+		if len(watcher.queued) > 0 {
+			sendChannel = taskChannel
+			nextTask = watcher.queued[0]
+		}
+
+		select {
+		case <-ctx.Done():
+		case sendChannel <- nextTask:
+			watcher.queued = watcher.queued[1:]
+		case result := <-resultChannel:
+			watcher.finish(result)
+		case event, ok := <-fsWatcher.Events:
+			if ok {
+				watcher.handleEvent(event)
+			}
+		case watchErr, ok := <-fsWatcher.Errors:
+			if ok {
+				tl.Log(tl.Error, palette.RedBold, "File system watcher error: '%s'", watchErr)
+			}
+		case <-ticker.C:
+			watcher.checkPending()
+		}
+	}
+
+	tl.Log(
+		tl.Notice, palette.Blue, "Stopping: waiting for '%s' running images ('%s' queued images stay in place)",
+		len(watcher.inFlightPaths)-len(watcher.queued), len(watcher.queued),
+	)
+	close(taskChannel)
+	go func() {
+		waitGroup.Wait()
+		close(resultChannel)
+	}()
+	for result := range resultChannel {
+		watcher.finish(result)
+	}
+
+	return nil
+}
+
+/*
+addTree watches dirPath and its subdirectories (except the archive and
+errors directories) and marks the images already in them as pending.
+*/
+func (watcher *folderWatcher) addTree(dirPath string) (e *xerr.Error) {
+	walkErr := filepath.WalkDir(dirPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			tl.Log(tl.Warning, palette.PurpleBright, "Skipping '%s': '%s'", path, err)
+			return nil
+		}
+		if entry.IsDir() {
+			if path != dirPath && watcher.isIgnored(path) {
+				return filepath.SkipDir
+			}
+			addErr := watcher.fsWatcher.Add(path)
+			if addErr != nil {
+				return fmt.Errorf("watch '%s': %w", path, addErr)
+			}
+			return nil
+		}
+		if watcher.isCandidate(path) {
+			watcher.touch(path)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		e = xerr.NewError(walkErr, "watch directory tree", dirPath)
+		return e
+	}
+	return nil
+}
+
+/*
+isIgnored reports whether path is the archive or errors directory (or inside
+one), or a hidden file or directory such as a sync tool's temporary file.
+*/
+func (watcher *folderWatcher) isIgnored(path string) bool {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return true
+	}
+	for _, ignoredDirPath := range []string{watcher.options.ArchiveDirPath, watcher.options.ErrorsDirPath} {
+		relativePath, relErr := filepath.Rel(ignoredDirPath, path)
+		if relErr == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (watcher *folderWatcher) isCandidate(path string) bool {
+	_, inFlight := watcher.inFlightPaths[path]
+	return pipeline.IsAllowedImageExt(filepath.Ext(path)) && !watcher.isIgnored(path) && !inFlight
+}
+
+/*
+touch (re)starts the settle time of path.
+*/
+func (watcher *folderWatcher) touch(path string) {
+	watcher.pending[path] = pendingFile{Size: -1, ChangedAt: time.Now()}
+}
+
+func (watcher *folderWatcher) handleEvent(event fsnotify.Event) {
+	path := event.Name
+
+	switch {
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		// A rename also creates the new name, which comes as its own Create.
+		delete(watcher.pending, path)
+	case event.Has(fsnotify.Create):
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			return
+		}
+		if info.IsDir() {
+			if watcher.isIgnored(path) {
+				return
+			}
+			e := watcher.addTree(path)
+			if e != nil {
+				tl.Log(tl.Error, palette.RedBold, "Failed watching new directory '%s': '%s'", path, e.ErrStr)
+			}
+			return
+		}
+		if watcher.isCandidate(path) {
+			watcher.touch(path)
+		}
+	case event.Has(fsnotify.Write):
+		if watcher.isCandidate(path) {
+			watcher.touch(path)
+		}
+	}
+}
+
+/*
+checkPending dispatches the pending files whose size and modification time
+haven't changed for options.Settle.
+*/
+func (watcher *folderWatcher) checkPending() {
+	now := time.Now()
+	for path, seen := range watcher.pending {
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			delete(watcher.pending, path)
+			continue
+		}
+
+		if info.Size() != seen.Size || !info.ModTime().Equal(seen.ModTime) {
+			watcher.pending[path] = pendingFile{Size: info.Size(), ModTime: info.ModTime(), ChangedAt: now}
+			continue
+		}
+		if now.Sub(seen.ChangedAt) < watcher.options.Settle {
+			continue
+		}
+
+		delete(watcher.pending, path)
+		watcher.dispatch(path)
+	}
+}
+
+/*
+dispatch hashes a completely written image and either queues it for the
+workers or, if it was already processed, archives it right away.
+*/
+func (watcher *folderWatcher) dispatch(path string) {
+	hashes, e := imageindex.ComputeHashes(path)
+	if e != nil {
+		watcher.moveToErrors(imageResult{ImagePath: path, Status: statusFailed, Stage: pipeline.StageHash, Error: e})
+		return
+	}
+
+	if !watcher.batch.Force {
+		match, found := watcher.index.FindDuplicate(hashes, path, watcher.batch.MaxHashDistance)
+		if found {
+			logDuplicate(path, match)
+			watcher.moveToArchive(path)
+			return
+		}
+		if watcher.inFlightSHAs[hashes.SHA256] {
+			tl.Log(tl.Notice1, palette.Purple, "Skipping '%s': an identical image is being processed", path)
+			watcher.moveToArchive(path)
+			return
+		}
+	}
+
+	watcher.inFlightPaths[path] = hashes.SHA256
+	watcher.inFlightSHAs[hashes.SHA256] = true
+	watcher.queued = append(watcher.queued, batchTask{ImagePath: path, Hashes: hashes})
+}
+
+/*
+finish moves a processed image to the archive and a failed one to errors.
+*/
+func (watcher *folderWatcher) finish(result imageResult) {
+	delete(watcher.inFlightSHAs, watcher.inFlightPaths[result.ImagePath])
+	delete(watcher.inFlightPaths, result.ImagePath)
+
+	if result.Status == statusFailed {
+		watcher.moveToErrors(result)
+		return
+	}
+	watcher.moveToArchive(result.ImagePath)
+}
+
+func (watcher *folderWatcher) moveToArchive(path string) {
+	archivedPath, e := moveUnder(path, watcher.options.RootDirPath, watcher.options.ArchiveDirPath)
+	if e != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed archiving '%s': %s: '%s'", path, e.Msg, e.ErrStr)
+		return
+	}
+	tl.Log(tl.Info1, palette.Green, "Archived '%s' to '%s'", path, archivedPath)
+}
+
+/*
+moveToErrors moves a failed image to the errors directory and writes the
+reason next to it.
+*/
+func (watcher *folderWatcher) moveToErrors(result imageResult) {
+	movedPath, e := moveUnder(result.ImagePath, watcher.options.RootDirPath, watcher.options.ErrorsDirPath)
+	if e != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed moving '%s' to errors: %s: '%s'", result.ImagePath, e.Msg, e.ErrStr)
+		return
+	}
+
+	failure := watchFailure{
+		ImagePath: result.ImagePath,
+		FailedAt:  time.Now().UnixMilli(),
+		Stage:     result.Stage,
+		RunDir:    result.RunDir,
+		Error:     result.Error,
+	}
+	reasonPath := movedPath + ".error.json"
+	jsonBytes, marshalErr := json.MarshalIndent(failure, "", "  ")
+	if marshalErr == nil {
+		marshalErr = os.WriteFile(reasonPath, jsonBytes, 0o644)
+	}
+	if marshalErr != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed writing '%s': '%s'", reasonPath, marshalErr)
+	}
+
+	tl.Log(
+		tl.Warning, palette.PurpleBold, "Moved failed '%s' (stage '%s') to '%s'",
+		result.ImagePath, string(result.Stage), movedPath,
+	)
+}
+
+/*
+moveUnder moves path from inside rootDirPath to the same relative path inside
+destinationRootPath, adding -1, -2, ... to the name if that file exists.
+*/
+func moveUnder(path string, rootDirPath string, destinationRootPath string) (movedPath string, e *xerr.Error) {
+	relativePath, relErr := filepath.Rel(rootDirPath, path)
+	if relErr != nil || strings.HasPrefix(relativePath, "..") {
+		relativePath = filepath.Base(path)
+	}
+
+	movedPath = filepath.Join(destinationRootPath, relativePath)
+	mkdirErr := os.MkdirAll(filepath.Dir(movedPath), 0o755)
+	if mkdirErr != nil {
+		e = xerr.NewError(mkdirErr, "create directory", filepath.Dir(movedPath))
+		return "", e
+	}
+
+	ext := filepath.Ext(movedPath)
+	stem := strings.TrimSuffix(movedPath, ext)
+	for suffix := 1; ; suffix++ {
+		_, statErr := os.Stat(movedPath)
+		if os.IsNotExist(statErr) {
+			break
+		}
+		movedPath = fmt.Sprintf("%s-%d%s", stem, suffix, ext)
+	}
+
+	renameErr := os.Rename(path, movedPath)
+	if renameErr != nil {
+		e = xerr.NewError(renameErr, "move file", movedPath)
+		return "", e
+	}
+	return movedPath, nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/currency"
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/inputfile"
+	"expense-tracker/src/pkg/jobqueue"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/pipeline"
+	"expense-tracker/src/pkg/report"
+)
+
+// uploadsDirName is where uploaded images are kept, inside the -out directory.
+const uploadsDirName = "uploads"
+
+// maxListLimit caps GET /receipts?limit= and GET /jobs?limit=.
+const maxListLimit = 1000
+
+/*
+server holds what the handlers share.
+
+Fields:
+  - Ledger, Index: the same ledger and image index receipt-pipeline uses.
+  - Queue: runs the pipeline for uploads in the background.
+  - OutputDirPath: -out root; uploads go here.
+  - MaxHashDistance: perceptual hash distance for duplicate photos.
+  - MaxUploadBytes: request body limit for POST /receipts.
+  - RatesPath: exchange rates file for reports.
+*/
+type server struct {
+	Ledger          *ledger.Ledger
+	Index           *imageindex.Index
+	Queue           *jobqueue.Queue
+	OutputDirPath   string
+	MaxHashDistance int
+	MaxUploadBytes  int64
+	RatesPath       string
+}
+
+/*
+errorResponse is the JSON body of every non-2xx API response.
+*/
+type errorResponse struct {
+	Error       string         `json:"error"`
+	Detail      string         `json:"detail,omitempty"`
+	Stage       pipeline.Stage `json:"stage,omitempty"`
+	DuplicateOf string         `json:"duplicate_of,omitempty"`
+	ReceiptID   int64          `json:"receipt_id,omitempty"`
+	JobID       int64          `json:"job_id,omitempty"`
+}
+
+func respondError(c echo.Context, status int, e *xerr.Error) error {
+	return c.JSON(status, errorResponse{Error: e.Msg, Detail: e.ErrStr})
+}
+
+/*
+postReceipt stores the uploaded image (multipart field "image") and queues a
+pipeline job for it.
+
+  - 202: queued; body is the job, Location is /jobs/{id} to poll.
+  - 409: the same receipt was already processed or is queued (send
+    force=true to redo a processed one).
+  - 400 / 413 / 415: missing, too large or unsupported upload.
+*/
+func (srv *server) postReceipt(c echo.Context) error {
+	request := c.Request()
+	request.Body = http.MaxBytesReader(c.Response(), request.Body, srv.MaxUploadBytes)
+
+	fileHeader, formErr := c.FormFile("image")
+	if formErr != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(formErr.Error(), "request body too large") {
+			status = http.StatusRequestEntityTooLarge
+		}
+		return respondError(c, status, xerr.NewError(formErr, "read multipart field 'image'", nil))
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
+	if !pipeline.IsAllowedImageExt(ext) {
+		e := xerr.NewError(fmt.Errorf("unsupported image extension: '%s'", ext), "upload is not "+inputfile.SupportedExtensions, fileHeader.Filename)
+		return respondError(c, http.StatusUnsupportedMediaType, e)
+	}
+
+	imagePath, e := srv.saveUpload(fileHeader, ext)
+	if e != nil {
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+
+	hashes, e := imageindex.ComputeHashes(imagePath)
+	if e != nil {
+		_ = os.Remove(imagePath)
+		return c.JSON(http.StatusBadRequest, errorResponse{Error: e.Msg, Detail: e.ErrStr, Stage: pipeline.StageHash})
+	}
+
+	activeJob, found, e := srv.Ledger.FindActiveJob(hashes.SHA256)
+	if e != nil {
+		_ = os.Remove(imagePath)
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+	if found {
+		_ = os.Remove(imagePath)
+		tl.Log(tl.Notice1, palette.Purple, "Upload '%s' is already queued as job '%s'", fileHeader.Filename, activeJob.ID)
+		return c.JSON(http.StatusConflict, errorResponse{Error: "receipt is already queued", JobID: activeJob.ID})
+	}
+
+	force, _ := strconv.ParseBool(c.FormValue("force"))
+	if !force {
+		match, found := srv.Index.FindDuplicate(hashes, imagePath, srv.MaxHashDistance)
+		if found {
+			_ = os.Remove(imagePath)
+			response := errorResponse{Error: "receipt already processed", DuplicateOf: match.Entry.RunDir}
+			response.ReceiptID, _, _ = srv.Ledger.FindReceiptID(match.Entry.RunDir)
+			tl.Log(tl.Notice1, palette.Purple, "Upload '%s' was already processed in '%s'", fileHeader.Filename, match.Entry.RunDir)
+			return c.JSON(http.StatusConflict, response)
+		}
+	}
+
+	job, e := srv.Queue.Enqueue(imagePath, hashes)
+	if e != nil {
+		_ = os.Remove(imagePath)
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/jobs/%d", job.ID))
+	return c.JSON(http.StatusAccepted, job)
+}
+
+/*
+getJob returns a pipeline job. Once its state is "done", receipt_id points
+at GET /receipts/{id} (absent when the photo was split into several
+receipts); "failed" jobs carry failed_stage and error.
+*/
+func (srv *server) getJob(c echo.Context) error {
+	jobID, parseErr := strconv.ParseInt(c.Param("id"), 10, 64)
+	if parseErr != nil {
+		return respondError(c, http.StatusBadRequest, xerr.NewError(parseErr, "invalid job id", c.Param("id")))
+	}
+
+	job, found, e := srv.Ledger.GetJob(jobID)
+	if e != nil {
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "job not found"})
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+/*
+listJobs returns the newest jobs, optionally only those in state (queued,
+ocr, llm, done, failed); limit defaults to 100.
+*/
+func (srv *server) listJobs(c echo.Context) error {
+	state := ledger.JobState(strings.TrimSpace(c.QueryParam("state")))
+	switch state {
+	case "", ledger.JobQueued, ledger.JobOCR, ledger.JobLLM, ledger.JobDone, ledger.JobFailed:
+	default:
+		e := xerr.NewError(fmt.Errorf("unknown job state: '%s'", state), "invalid state", state)
+		return respondError(c, http.StatusBadRequest, e)
+	}
+
+	limit := 100
+	limitParam := c.QueryParam("limit")
+	if limitParam != "" {
+		parsedLimit, parseErr := strconv.Atoi(limitParam)
+		if parseErr != nil || parsedLimit < 1 || parsedLimit > maxListLimit {
+			e := xerr.NewError(fmt.Errorf("limit must be between 1 and %d", maxListLimit), "invalid limit", limitParam)
+			return respondError(c, http.StatusBadRequest, e)
+		}
+		limit = parsedLimit
+	}
+
+	jobs, e := srv.Ledger.ListJobs(state, limit)
+	if e != nil {
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"count": len(jobs),
+		"jobs":  jobs,
+	})
+}
+
+/*
+saveUpload copies the uploaded file to <out>/uploads/<timestamp>-<name> and
+returns its path. The file stays there as the receipt's source image.
+*/
+func (srv *server) saveUpload(fileHeader *multipart.FileHeader, ext string) (imagePath string, e *xerr.Error) {
+	fileName := fileHeader.Filename
+	source, openErr := fileHeader.Open()
+	if openErr != nil {
+		e = xerr.NewError(openErr, "open uploaded file", fileName)
+		return "", e
+	}
+	defer source.Close()
+
+	uploadsDirPath := filepath.Join(srv.OutputDirPath, uploadsDirName)
+	mkdirErr := os.MkdirAll(uploadsDirPath, 0o755)
+	if mkdirErr != nil {
+		e = xerr.NewError(mkdirErr, "create uploads directory", uploadsDirPath)
+		return "", e
+	}
+
+	baseName := sanitizeFileName(strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)))
+	pattern := time.Now().Format("2006-01-02_15-04-05") + "-" + baseName + "-*" + ext
+	destination, createErr := os.CreateTemp(uploadsDirPath, pattern)
+	if createErr != nil {
+		e = xerr.NewError(createErr, "create upload file", uploadsDirPath)
+		return "", e
+	}
+	imagePath = destination.Name()
+
+	_, copyErr := io.Copy(destination, source)
+	closeErr := destination.Close()
+	if copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr != nil {
+		_ = os.Remove(imagePath)
+		e = xerr.NewError(copyErr, "write upload file", imagePath)
+		return "", e
+	}
+
+	tl.Log(tl.Info1, palette.Cyan, "Saved upload '%s' to '%s'", fileName, imagePath)
+	return imagePath, nil
+}
+
+/*
+sanitizeFileName keeps letters, digits, '-' and '_' of a client-provided
+name, so it can't escape the uploads directory.
+*/
+func sanitizeFileName(name string) string {
+	var builder strings.Builder
+	for _, character := range name {
+		switch {
+		case character >= 'a' && character <= 'z', character >= 'A' && character <= 'Z',
+			character >= '0' && character <= '9', character == '-', character == '_':
+			builder.WriteRune(character)
+		default:
+			builder.WriteRune('_')
+		}
+	}
+
+	sanitized := builder.String()
+	if len(sanitized) > 64 {
+		sanitized = sanitized[:64]
+	}
+	if sanitized == "" {
+		sanitized = "receipt"
+	}
+	return sanitized
+}
+
+/*
+getReceipt returns one stored receipt (the analysis plus ledger id and run dir).
+*/
+func (srv *server) getReceipt(c echo.Context) error {
+	receiptID, parseErr := strconv.ParseInt(c.Param("id"), 10, 64)
+	if parseErr != nil {
+		return respondError(c, http.StatusBadRequest, xerr.NewError(parseErr, "invalid receipt id", c.Param("id")))
+	}
+
+	stored, found, e := srv.Ledger.GetReceipt(receiptID)
+	if e != nil {
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, errorResponse{Error: "receipt not found"})
+	}
+
+	return c.JSON(http.StatusOK, stored)
+}
+
+/*
+listReceipts returns receipts matching the query parameters month (YYYY-MM),
+merchant, category, currency and limit (default 100), newest first.
+*/
+func (srv *server) listReceipts(c echo.Context) error {
+	filter := ledger.ReceiptFilter{
+		Month:    strings.TrimSpace(c.QueryParam("month")),
+		Merchant: strings.TrimSpace(c.QueryParam("merchant")),
+		Category: strings.TrimSpace(c.QueryParam("category")),
+		Currency: strings.TrimSpace(c.QueryParam("currency")),
+		Limit:    100,
+	}
+
+	if filter.Month != "" {
+		_, parseErr := time.Parse("2006-01", filter.Month)
+		if parseErr != nil {
+			return respondError(c, http.StatusBadRequest, xerr.NewError(parseErr, "month must be YYYY-MM", filter.Month))
+		}
+	}
+
+	limitParam := c.QueryParam("limit")
+	if limitParam != "" {
+		limit, parseErr := strconv.Atoi(limitParam)
+		if parseErr != nil || limit < 1 || limit > maxListLimit {
+			e := xerr.NewError(fmt.Errorf("limit must be between 1 and %d", maxListLimit), "invalid limit", limitParam)
+			return respondError(c, http.StatusBadRequest, e)
+		}
+		filter.Limit = limit
+	}
+
+	receipts, e := srv.Ledger.FindReceipts(filter)
+	if e != nil {
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"count":    len(receipts),
+		"filter":   filter,
+		"receipts": receipts,
+	})
+}
+
+/*
+getReport renders the monthly HTML report, like the report command.
+Optional query parameters: currency, tz and level (category roll-up level).
+*/
+func (srv *server) getReport(c echo.Context) error {
+	month, parseErr := time.Parse("2006-01", c.Param("month"))
+	if parseErr != nil {
+		return respondError(c, http.StatusBadRequest, xerr.NewError(parseErr, "month must be YYYY-MM", c.Param("month")))
+	}
+
+	options := report.NewOptions(month.Year(), month.Month())
+	options.RatesPath = srv.RatesPath
+	if c.QueryParam("currency") != "" {
+		options.Currency = currency.Normalize(c.QueryParam("currency"))
+	}
+	if c.QueryParam("tz") != "" {
+		_, locationErr := time.LoadLocation(c.QueryParam("tz"))
+		if locationErr != nil {
+			return respondError(c, http.StatusBadRequest, xerr.NewError(locationErr, "invalid timezone", c.QueryParam("tz")))
+		}
+		options.Timezone = c.QueryParam("tz")
+	}
+	if c.QueryParam("level") != "" {
+		level, parseErr := strconv.Atoi(c.QueryParam("level"))
+		if parseErr != nil || level < 0 {
+			e := xerr.NewError(fmt.Errorf("level must be a number >= 0"), "invalid category level", c.QueryParam("level"))
+			return respondError(c, http.StatusBadRequest, e)
+		}
+		options.CategoryLevel = level
+	}
+
+	storedReceipts, e := srv.Ledger.ListReceipts()
+	if e != nil {
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+
+	monthlyReport, e := report.BuildMonthly(options, storedReceipts)
+	if e != nil {
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+
+	htmlText, e := report.RenderHTML(monthlyReport)
+	if e != nil {
+		return respondError(c, http.StatusInternalServerError, e)
+	}
+
+	return c.HTML(http.StatusOK, htmlText)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/config"
+	echomw "expense-tracker/src/pkg/echo-middleware"
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/jobqueue"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/llm"
+)
+
+/*
+main serves the receipt intake HTTP API on server.address:server.port.
+
+Endpoints (all require Authorization: Bearer $EMV_INTAKE_BEARER_TOKEN):
+  - POST /receipts            multipart upload (field "image"), queues a pipeline job
+  - GET  /jobs/:id            job state (queued, ocr, llm, done, failed)
+  - GET  /jobs                recent jobs, optionally by state
+  - GET  /receipts/:id        stored receipt analysis
+  - GET  /receipts            receipts filtered by month, merchant, category, currency
+  - GET  /reports/:yyyy-mm    monthly HTML report
+
+Example:
+
+	go run ./src/cmd/receipt-server -config ./cfg/config.json -out ./out -workers 2
+*/
+func main() {
+	// Common flags.
+	configPath := flag.String("config", "./cfg/config.json", "Path to your configuration file.")
+
+	// Program-specific flags.
+	outputDirPath := flag.String("out", "./out", "Directory where uploads and processed receipts are stored.")
+	language := flag.String("language", "eng+spa", "Language of the receipts for OCR (tesseract language codes).")
+	workers := flag.Int("workers", 2, "Number of jobs processed concurrently; more jobs wait in the queue")
+	maxAttempts := flag.Int("max-attempts", 3, "Attempts per job before it fails; only transient LLM API and write errors are retried")
+	retryDelay := flag.Duration("retry-delay", time.Minute, "Wait before retrying a failed job; doubles with every further attempt")
+	maxUploadMB := flag.Int("max-upload-mb", 25, "Maximum upload size in megabytes")
+	maxHashDistance := flag.Int("max-hash-distance", imageindex.DefaultMaxDistance, "Max perceptual hash distance (bits) to treat two photos as the same receipt")
+	ratesPath := flag.String("rates", "./cfg/exchange-rates.json", "Exchange rates file used by /reports")
+
+	flag.Parse()
+	if *workers < 1 {
+		xerr.NewError(fmt.Errorf("-workers must be at least 1"), "invalid -workers value", *workers).QuitIf("error")
+	}
+	if *maxAttempts < 1 {
+		xerr.NewError(fmt.Errorf("-max-attempts must be at least 1"), "invalid -max-attempts value", *maxAttempts).QuitIf("error")
+	}
+	config.InitializeConfig(*configPath)
+	config.CheckIfEnvVarsPresent(append(llm.RequiredEnvVars(), echomw.EnvIntakeBearerToken)...)
+
+	index, e := imageindex.Load(filepath.Join(*outputDirPath, imageindex.FileName))
+	e.QuitIf("error")
+
+	receiptLedger, e := ledger.Open(ledger.Cfg.DatabasePath)
+	e.QuitIf("error")
+	defer receiptLedger.Close()
+
+	queueOptions := jobqueue.DefaultOptions(*outputDirPath, *language)
+	queueOptions.Workers = *workers
+	queueOptions.MaxAttempts = *maxAttempts
+	queueOptions.RetryDelay = *retryDelay
+	queue := jobqueue.New(receiptLedger, index, queueOptions)
+
+	srv := &server{
+		Ledger:          receiptLedger,
+		Index:           index,
+		Queue:           queue,
+		OutputDirPath:   *outputDirPath,
+		MaxHashDistance: *maxHashDistance,
+		MaxUploadBytes:  int64(*maxUploadMB) << 20,
+		RatesPath:       *ratesPath,
+	}
+
+	echoServer := newEchoServer(srv)
+
+	address := fmt.Sprintf("%s:%d", echomw.Cfg.Address, echomw.Cfg.Port)
+	tl.Log(tl.Notice, palette.BlueBold, "Serving receipt intake on '%s' with '%s' workers", address, *workers)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	queueDone := make(chan struct{})
+	go func() {
+		defer close(queueDone)
+		queue.Run(ctx).QuitIf("error")
+	}()
+
+	go func() {
+		startErr := echoServer.Start(address)
+		if startErr != nil && !errors.Is(startErr, http.ErrServerClosed) {
+			xerr.NewError(startErr, "start HTTP server", address).QuitIf("error")
+		}
+	}()
+
+	<-ctx.Done()
+	tl.Log(tl.Notice, palette.Blue, "%s, waiting for running jobs to finish", "Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	shutdownErr := echoServer.Shutdown(shutdownCtx)
+	if shutdownErr != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed shutting down HTTP server: '%s'", shutdownErr)
+	}
+
+	// A job still running when this gives up is queued again on the next start.
+	select {
+	case <-queueDone:
+	case <-time.After(5 * time.Minute):
+		tl.Log(tl.Warning, palette.PurpleBright, "%s; they will resume on the next start", "Jobs still running")
+	}
+}
+
+/*
+newEchoServer sets up middlewares and routes.
+*/
+func newEchoServer(srv *server) *echo.Echo {
+	echoServer := echo.New()
+	echoServer.HideBanner = true
+	echoServer.HidePort = true
+	echoServer.Server.ReadHeaderTimeout = 10 * time.Second
+
+	echomw.UptdateRateLimits(echomw.Cfg.MiddlewareRateLimit, echomw.Cfg.MiddlewareBurst)
+	echoServer.Use(echomw.RouteAccessLoggerMiddleware, echomw.RateLimiterMiddleware, echomw.RequireBearerToken)
+
+	echoServer.POST("/receipts", srv.postReceipt)
+	echoServer.GET("/jobs", srv.listJobs)
+	echoServer.GET("/jobs/:id", srv.getJob)
+	echoServer.GET("/receipts", srv.listReceipts)
+	echoServer.GET("/receipts/:id", srv.getReceipt)
+	echoServer.GET("/reports/:month", srv.getReport)
+
+	return echoServer
+}
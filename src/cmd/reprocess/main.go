@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"path/filepath"
+	"sort"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/llm"
+	"expense-tracker/src/pkg/ocr"
+	"expense-tracker/src/pkg/pipeline"
+	"expense-tracker/src/pkg/util"
+)
+
+/*
+main reruns only the LLM stage over existing run directories, e.g. after
+switching to another model or changing the prompt. OCR artifacts (ocr.txt,
+prices.json, orig.*) are reused; the new receipt-analysis.json replaces the
+old one and the ledger is updated.
+
+-dir is a single run directory or any directory containing run directories
+(./out, ./out/january-2026). Run directories whose LLM inputs haven't changed
+since their last analysis are skipped unless -force is given.
+
+Example:
+
+	go run ./src/cmd/reprocess -dir ./out/january-2026 -model gpt-5
+*/
+func main() {
+	// Common flags.
+	configPath := flag.String("config", "./cfg/config.json", "Path to your configuration file.")
+
+	// Program-specific flags.
+	dirPath := flag.String("dir", "", "Run directory, or a directory to search recursively for run directories")
+	model := flag.String("model", "", "LLM model to use instead of llm.model from the config")
+	force := flag.Bool("force", false, "Rerun the LLM even where model, prompt and OCR inputs are unchanged")
+	priceDifference := flag.Bool("price-difference", false, "Fail analyses whose items don't add up to the receipt total (they are still stored and queued for review)")
+
+	flag.Parse()
+	util.RequiredFlag(dirPath, "dir")
+	util.EnsureFlags()
+	config.InitializeConfig(*configPath)
+	if *model != "" {
+		llm.Cfg.Model = *model
+	}
+	config.CheckIfEnvVarsPresent(llm.RequiredEnvVars()...)
+
+	runDirPaths, e := findRunDirs(*dirPath)
+	e.QuitIf("error")
+	tl.Log(
+		tl.Notice, palette.BlueBold, "Reprocessing '%s' run directories under '%s' with %s model '%s'",
+		len(runDirPaths), *dirPath, string(llm.Cfg.Provider), llm.Cfg.Model,
+	)
+
+	receiptLedger, e := ledger.Open(ledger.Cfg.DatabasePath)
+	e.QuitIf("error")
+	defer receiptLedger.Close()
+
+	options := pipeline.Options{
+		PriceDifference: *priceDifference,
+		Ledger:          receiptLedger,
+		ForceLLM:        *force,
+	}
+
+	var reanalyzed, unchanged, failed int
+	for _, runDirPath := range runDirPaths {
+		result, failedStage, e := pipeline.ReanalyzeRunDir(runDirPath, options)
+		if e != nil {
+			failed++
+			tl.Log(
+				tl.Warning, palette.PurpleBold, "Failed '%s' at stage '%s': %s: '%s'",
+				runDirPath, string(failedStage), e.Msg, e.ErrStr,
+			)
+			continue
+		}
+
+		if len(result.SkippedStages) > 0 && result.SkippedStages[0] == pipeline.StageLLM {
+			unchanged++
+			tl.Log(tl.Info1, palette.Cyan, "Skipping '%s': already analyzed with the same model and prompt", runDirPath)
+			continue
+		}
+		reanalyzed++
+		tl.Log(tl.Notice1, palette.GreenBold, "Reanalyzed '%s' (receipt '%s')", runDirPath, result.ReceiptID)
+	}
+
+	tl.Log(
+		tl.Notice, palette.GreenBold, "Done. Reanalyzed: '%s', unchanged: '%s', failed: '%s'",
+		reanalyzed, unchanged, failed,
+	)
+}
+
+/*
+findRunDirs returns every directory under rootPath (itself included) that
+holds OCR output (ocr.txt), sorted. The part-N directories of stitched
+receipts are left out: their run directory holds the merged OCR.
+*/
+func findRunDirs(rootPath string) (runDirPaths []string, e *xerr.Error) {
+	walkErr := filepath.WalkDir(rootPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && entry.Name() == "ocr.txt" {
+			runDirPath := filepath.Dir(path)
+			if config.FileExists(filepath.Join(filepath.Dir(runDirPath), ocr.StitchFileName)) {
+				return nil
+			}
+			runDirPaths = append(runDirPaths, runDirPath)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		e = xerr.NewError(walkErr, "search for run directories", rootPath)
+		return nil, e
+	}
+
+	sort.Strings(runDirPaths)
+	return runDirPaths, nil
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/category"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/pipeline"
+	"expense-tracker/src/pkg/receipt"
+)
+
+// maxListedReceipts caps the receipts on the list page, newest receipt date first.
+const maxListedReceipts = 500
+
+// blankItemRows is how many empty rows the items table offers for adding items.
+const blankItemRows = 3
+
+/*
+server holds what the handlers share.
+*/
+type server struct {
+	Ledger *ledger.Ledger
+}
+
+/*
+listRow is one receipt on the list page, with its corrections applied and
+its state in the review queue.
+*/
+type listRow struct {
+	ID          int64
+	Analysis    receipt.Analysis
+	Corrected   bool
+	ReviewState ledger.ReviewState
+}
+
+/*
+listPage is the data of the "list" template.
+*/
+type listPage struct {
+	Month string
+	Rows  []listRow
+}
+
+/*
+itemRow is one row of the items table. Source is the index of the item in
+the analysis shown (corrections applied), -1 for the blank rows. Photo is
+the index in reviewPage.Images of the photo Item.Box is on, -1 when the
+item has no box on a shown photo.
+*/
+type itemRow struct {
+	Index  int
+	Source int
+	Photo  int
+	Item   receipt.Item
+}
+
+/*
+categoryOption is one entry of the category drop-down.
+*/
+type categoryOption struct {
+	Key   string
+	Label string
+}
+
+/*
+reviewPage is the data of the "review" template.
+
+Fields:
+  - Stored: the receipt as the ledger has it (the LLM's analysis).
+  - Current: Stored.Analysis with the corrections applied.
+  - Corrections: the saved corrections, if any; Corrected is true when
+    they change anything.
+  - Images: indexes for GET /receipts/:id/images/:index.
+  - ImageNote: why there is no image, when there is none.
+  - Rows: the items of Current plus blank rows.
+  - Categories: the taxonomy, plus unknown keys the items have.
+  - Saved: the page is shown right after saving.
+*/
+type reviewPage struct {
+	Stored      ledger.StoredReceipt
+	Current     receipt.Analysis
+	Corrections receipt.Corrections
+	Corrected   bool
+	Images      []int
+	ImageNote   string
+	Rows        []itemRow
+	Categories  []categoryOption
+	Saved       bool
+}
+
+/*
+errorPage is the data of the "error" template.
+*/
+type errorPage struct {
+	Message string
+	Detail  string
+}
+
+func (srv *server) render(c echo.Context, status int, templateName string, data any) error {
+	var buffer bytes.Buffer
+	executeErr := pages.ExecuteTemplate(&buffer, templateName, data)
+	if executeErr != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed rendering '%s': '%s'", templateName, executeErr)
+		return c.String(http.StatusInternalServerError, "render page: "+executeErr.Error())
+	}
+	return c.HTML(status, buffer.String())
+}
+
+func (srv *server) renderError(c echo.Context, status int, e *xerr.Error) error {
+	return srv.render(c, status, "error", errorPage{Message: e.Msg, Detail: e.ErrStr})
+}
+
+/*
+listReceipts shows the newest receipts, optionally of one month.
+*/
+func (srv *server) listReceipts(c echo.Context) error {
+	month := strings.TrimSpace(c.QueryParam("month"))
+	if month != "" {
+		_, parseErr := time.Parse("2006-01", month)
+		if parseErr != nil {
+			return srv.renderError(c, http.StatusBadRequest, xerr.NewError(parseErr, "month must be YYYY-MM", month))
+		}
+	}
+
+	storedReceipts, e := srv.Ledger.FindReceipts(ledger.ReceiptFilter{Month: month, Limit: maxListedReceipts})
+	if e != nil {
+		return srv.renderError(c, http.StatusInternalServerError, e)
+	}
+
+	page := listPage{Month: month}
+	for _, stored := range storedReceipts {
+		row := listRow{ID: stored.ID, Analysis: stored.Analysis, ReviewState: stored.ReviewState}
+		corrections, found, e := receipt.LoadCorrections(stored.RunDir)
+		if e != nil {
+			return srv.renderError(c, http.StatusInternalServerError, e)
+		}
+		if found {
+			row.Analysis = corrections.Apply(stored.Analysis)
+			row.Corrected = corrections.Changes()
+		}
+		page.Rows = append(page.Rows, row)
+	}
+	return srv.render(c, http.StatusOK, "list", page)
+}
+
+/*
+loadReceipt returns the receipt named by the :id path parameter and its
+corrections. status is the HTTP status to answer with when e is not nil.
+*/
+func (srv *server) loadReceipt(c echo.Context) (stored ledger.StoredReceipt, corrections receipt.Corrections, found bool, status int, e *xerr.Error) {
+	receiptID, parseErr := strconv.ParseInt(c.Param("id"), 10, 64)
+	if parseErr != nil {
+		e = xerr.NewError(parseErr, "invalid receipt id", c.Param("id"))
+		return stored, corrections, false, http.StatusBadRequest, e
+	}
+
+	stored, receiptFound, e := srv.Ledger.GetReceipt(receiptID)
+	if e != nil {
+		return stored, corrections, false, http.StatusInternalServerError, e
+	}
+	if !receiptFound {
+		e = xerr.NewError(fmt.Errorf("no receipt with id %d", receiptID), "receipt not found", receiptID)
+		return stored, corrections, false, http.StatusNotFound, e
+	}
+
+	corrections, found, e = receipt.LoadCorrections(stored.RunDir)
+	if e != nil {
+		return stored, corrections, false, http.StatusInternalServerError, e
+	}
+	return stored, corrections, found, http.StatusOK, nil
+}
+
+/*
+showReceipt shows the receipt's photo(s) next to a form with its date,
+merchant, total and items, corrections applied.
+*/
+func (srv *server) showReceipt(c echo.Context) error {
+	stored, corrections, found, status, e := srv.loadReceipt(c)
+	if e != nil {
+		return srv.renderError(c, status, e)
+	}
+
+	page := reviewPage{
+		Stored:      stored,
+		Current:     stored.Analysis,
+		Corrections: corrections,
+		Corrected:   corrections.Changes(),
+		Saved:       c.QueryParam("saved") != "",
+	}
+	if found {
+		page.Current = corrections.Apply(stored.Analysis)
+	}
+
+	imagePaths, e := receiptImages(stored)
+	if e != nil {
+		page.ImageNote = "No orig.* photo in the run directory (e-invoice imports have none)."
+	}
+	for index := range imagePaths {
+		page.Images = append(page.Images, index)
+	}
+
+	for index, item := range page.Current.Items {
+		photo := boxPhoto(stored.RunDir, imagePaths, item.Box)
+		page.Rows = append(page.Rows, itemRow{Index: index, Source: index, Photo: photo, Item: item})
+	}
+	for blank := 0; blank < blankItemRows; blank++ {
+		page.Rows = append(page.Rows, itemRow{Index: len(page.Rows), Source: -1, Photo: -1, Item: receipt.Item{Quantity: 1}})
+	}
+	page.Categories = categoryOptions(page.Current.Items)
+
+	return srv.render(c, http.StatusOK, "review", page)
+}
+
+/*
+saveCorrections turns the submitted form into corrections against the
+stored analysis and saves them to the run directory; fields set back to
+the analysis value are dropped from the corrections. Saving confirms the
+receipt, so the product memory learns the corrected items (see
+pipeline.ConfirmReceipt).
+*/
+func (srv *server) saveCorrections(c echo.Context) error {
+	stored, corrections, found, status, e := srv.loadReceipt(c)
+	if e != nil {
+		return srv.renderError(c, status, e)
+	}
+	original := stored.Analysis
+	current := original
+	if found {
+		current = corrections.Apply(original)
+	}
+
+	updated := receipt.Corrections{CorrectedAt: time.Now().Format(time.RFC3339)}
+
+	receiptDate := strings.TrimSpace(c.FormValue("receipt_date"))
+	if receiptDate != "" {
+		_, parseErr := time.Parse("2006-01-02", receiptDate)
+		if parseErr != nil {
+			return srv.renderError(c, http.StatusBadRequest, xerr.NewError(parseErr, "receipt date must be YYYY-MM-DD", receiptDate))
+		}
+	}
+	receiptDateTime := strings.TrimSpace(c.FormValue("receipt_datetime"))
+	if receiptDateTime != "" {
+		_, parseErr := time.Parse("2006-01-02 15:04:05", receiptDateTime)
+		if parseErr != nil {
+			return srv.renderError(c, http.StatusBadRequest, xerr.NewError(parseErr, "receipt date and time must be YYYY-MM-DD HH:MM:SS", receiptDateTime))
+		}
+	}
+	updated.ReceiptDate = changedString(receiptDate, original.ReceiptDate)
+	updated.ReceiptDateTime = changedString(receiptDateTime, original.ReceiptDateTime)
+	updated.MerchantName = changedString(strings.TrimSpace(c.FormValue("merchant_name")), original.MerchantName)
+	updated.MerchantTaxID = changedString(strings.TrimSpace(c.FormValue("merchant_tax_id")), original.MerchantTaxID)
+
+	receiptTotal, e := parseAmount(c.FormValue("receipt_total"), 0, "receipt total")
+	if e != nil {
+		return srv.renderError(c, http.StatusBadRequest, e)
+	}
+	if receiptTotal != original.Totals.ReceiptTotal {
+		updated.ReceiptTotal = &receiptTotal
+	}
+
+	items, e := parseItems(c, current.Items)
+	if e != nil {
+		return srv.renderError(c, http.StatusBadRequest, e)
+	}
+	if len(items)+len(original.Items) > 0 && !reflect.DeepEqual(items, original.Items) {
+		updated.Items = items
+	}
+
+	_, e = pipeline.ConfirmReceipt(srv.Ledger, stored.RunDir, original, updated)
+	if e != nil {
+		return srv.renderError(c, http.StatusInternalServerError, e)
+	}
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/receipts/%d?saved=1", stored.ID))
+}
+
+/*
+revertCorrections removes the receipt's corrections, back to the analysis.
+*/
+func (srv *server) revertCorrections(c echo.Context) error {
+	stored, _, _, status, e := srv.loadReceipt(c)
+	if e != nil {
+		return srv.renderError(c, status, e)
+	}
+
+	e = receipt.SaveCorrections(stored.RunDir, receipt.Corrections{})
+	if e != nil {
+		return srv.renderError(c, http.StatusInternalServerError, e)
+	}
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/receipts/%d", stored.ID))
+}
+
+/*
+getImage serves one of the receipt's orig.* images.
+*/
+func (srv *server) getImage(c echo.Context) error {
+	stored, _, _, status, e := srv.loadReceipt(c)
+	if e != nil {
+		return srv.renderError(c, status, e)
+	}
+
+	imagePaths, e := receiptImages(stored)
+	if e != nil {
+		return srv.renderError(c, http.StatusNotFound, e)
+	}
+	index, parseErr := strconv.Atoi(c.Param("index"))
+	if parseErr != nil || index < 0 || index >= len(imagePaths) {
+		e = xerr.NewError(fmt.Errorf("receipt has %d images", len(imagePaths)), "invalid image index", c.Param("index"))
+		return srv.renderError(c, http.StatusNotFound, e)
+	}
+	return c.File(imagePaths[index])
+}
+
+/*
+receiptImages returns the orig.* images of a receipt: those of its run
+directory (every part of a stitched receipt), or for one receipt of a photo
+with several the whole photo.
+*/
+func receiptImages(stored ledger.StoredReceipt) (imagePaths []string, e *xerr.Error) {
+	imagePaths, e = pipeline.FindOriginalImagePaths(stored.RunDir)
+	if e == nil {
+		return imagePaths, nil
+	}
+
+	source := stored.Analysis.Source
+	if source == nil || source.Segment == nil || source.Segment.RunDir == "" {
+		return nil, e
+	}
+	imagePath, e := pipeline.FindOriginalImagePath(source.Segment.RunDir)
+	if e != nil {
+		return nil, e
+	}
+	return []string{imagePath}, nil
+}
+
+/*
+boxPhoto returns the index in imagePaths of the photo box is on, or -1 when
+there is no box or its photo isn't shown (the whole photo of a receipt that
+was split from it, whose boxes are in another run directory).
+*/
+func boxPhoto(runDirPath string, imagePaths []string, box *receipt.ItemBox) int {
+	if box == nil {
+		return -1
+	}
+	for index, imagePath := range imagePaths {
+		if box.Image == "" && filepath.Dir(imagePath) == filepath.Clean(runDirPath) {
+			return index
+		}
+		if box.Image != "" && imagePath == filepath.Join(runDirPath, box.Image) {
+			return index
+		}
+	}
+	return -1
+}
+
+/*
+parseItems reads the items table of the form. Rows keep the fields the
+form doesn't show (raw line, box, SKU, ...) from the item they were shown
+for; blank rows become new items, and rows marked for removal or left
+without a name and a line total are dropped. An item whose category was
+changed records it in CategoryRule (receipt.ManualCategoryRule) and keeps
+the model's category in LLMCategoryKey.
+*/
+func parseItems(c echo.Context, shownItems []receipt.Item) (items []receipt.Item, e *xerr.Error) {
+	rowCount, parseErr := strconv.Atoi(c.FormValue("item_count"))
+	if parseErr != nil || rowCount < 0 {
+		e = xerr.NewError(fmt.Errorf("missing or invalid item_count"), "invalid items table", c.FormValue("item_count"))
+		return nil, e
+	}
+
+	items = make([]receipt.Item, 0, rowCount)
+	for row := 0; row < rowCount; row++ {
+		field := func(name string) string {
+			return strings.TrimSpace(c.FormValue(fmt.Sprintf("%s_%d", name, row)))
+		}
+		if field("remove") != "" {
+			continue
+		}
+
+		item := receipt.Item{LineIndex: -1}
+		source, sourceErr := strconv.Atoi(field("source"))
+		if sourceErr == nil && source >= 0 && source < len(shownItems) {
+			item = shownItems[source]
+		} else if field("name") == "" && field("line_total") == "" {
+			continue
+		}
+
+		label := fmt.Sprintf("item %d", row+1)
+		item.OriginalProductName = field("name")
+		item.ProductNameEnglish = field("english")
+		item.Quantity, e = parseAmount(field("quantity"), 1, label+" quantity")
+		if e != nil {
+			return nil, e
+		}
+		item.UnitPrice, e = parseAmount(field("unit_price"), 0, label+" unit price")
+		if e != nil {
+			return nil, e
+		}
+		item.LineTotal, e = parseAmount(field("line_total"), 0, label+" line total")
+		if e != nil {
+			return nil, e
+		}
+
+		categoryKey := field("category")
+		if _, known := category.Current.Lookup(categoryKey); !known && categoryKey != item.CategoryKey {
+			e = xerr.NewError(fmt.Errorf("category '%s' is not in the taxonomy", categoryKey), "invalid category", label)
+			return nil, e
+		}
+		if categoryKey != item.CategoryKey {
+			if item.CategoryRule == "" {
+				item.LLMCategoryKey = item.CategoryKey
+			}
+			item.CategoryKey = categoryKey
+			item.CategoryRule = receipt.ManualCategoryRule
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+/*
+parseAmount parses a number typed into the form; empty means
+defaultValue.
+*/
+func parseAmount(value string, defaultValue float64, label string) (amount float64, e *xerr.Error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultValue, nil
+	}
+	amount, parseErr := strconv.ParseFloat(value, 64)
+	if parseErr != nil {
+		e = xerr.NewError(parseErr, label+" must be a number like 12500 or 3.49", value)
+		return 0, e
+	}
+	return amount, nil
+}
+
+// changedString returns &value when it differs from original, nil otherwise.
+func changedString(value string, original string) *string {
+	if value == original {
+		return nil
+	}
+	return &value
+}
+
+/*
+categoryOptions lists the taxonomy for the drop-down, children indented
+under their parents, plus the keys of items that are not in it (so saving
+doesn't change them).
+*/
+func categoryOptions(items []receipt.Item) (options []categoryOption) {
+	known := make(map[string]bool)
+	for _, key := range category.Current.Keys() {
+		indent := strings.Repeat("\u00a0\u00a0\u00a0", len(category.Current.Path(key))-1)
+		options = append(options, categoryOption{Key: key, Label: indent + category.Current.Name(key)})
+		known[key] = true
+	}
+	for _, item := range items {
+		if !known[item.CategoryKey] {
+			options = append(options, categoryOption{Key: item.CategoryKey, Label: item.CategoryKey + " (not in taxonomy)"})
+			known[item.CategoryKey] = true
+		}
+	}
+	return options
+}
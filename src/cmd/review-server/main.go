@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/config"
+	echomw "expense-tracker/src/pkg/echo-middleware"
+	"expense-tracker/src/pkg/ledger"
+)
+
+/*
+main serves a local web UI for reviewing and correcting receipts on
+server.address:-port.
+
+Pages:
+  - GET  /                       receipts, optionally of one month (?month=YYYY-MM)
+  - GET  /receipts/:id           the photo(s) next to an editable items table
+  - POST /receipts/:id           saves the corrections to receipt-corrections.json
+  - POST /receipts/:id/revert    removes the corrections
+  - GET  /receipts/:id/images/:n the n-th orig.* image of the receipt
+
+The analysis itself is never changed; the report applies the corrections on
+top of it. There is no authentication, so keep it on a loopback address.
+Cross-site POSTs (a form on another page submitting to the UI) are refused
+with 403, see rejectCrossOrigin.
+
+Example:
+
+	go run ./src/cmd/review-server -config ./cfg/config.json -port 8402
+*/
+func main() {
+	// Common flags.
+	configPath := flag.String("config", "./cfg/config.json", "Path to your configuration file.")
+	databasePath := flag.String("db", "", "Ledger database path (default: ledger.database_path from config)")
+
+	// Program-specific flags.
+	port := flag.Int("port", 8402, "Port to serve the review UI on (the address is server.address from config)")
+
+	flag.Parse()
+	config.InitializeConfig(*configPath)
+	if *databasePath == "" {
+		*databasePath = ledger.Cfg.DatabasePath
+	}
+
+	receiptLedger, e := ledger.Open(*databasePath)
+	e.QuitIf("error")
+	defer receiptLedger.Close()
+
+	srv := &server{Ledger: receiptLedger}
+	echoServer := newEchoServer(srv)
+
+	address := fmt.Sprintf("%s:%d", echomw.Cfg.Address, *port)
+	if echomw.Cfg.Address != "127.0.0.1" && echomw.Cfg.Address != "localhost" {
+		tl.Log(tl.Warning, palette.PurpleBright, "Review UI has no authentication but listens on '%s'", address)
+	}
+	tl.Log(tl.Notice, palette.BlueBold, "Serving receipt review on 'http://%s/'", address)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		startErr := echoServer.Start(address)
+		if startErr != nil && !errors.Is(startErr, http.ErrServerClosed) {
+			xerr.NewError(startErr, "start HTTP server", address).QuitIf("error")
+		}
+	}()
+
+	<-ctx.Done()
+	tl.Log(tl.Notice, palette.Blue, "%s", "Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	shutdownErr := echoServer.Shutdown(shutdownCtx)
+	if shutdownErr != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed shutting down HTTP server: '%s'", shutdownErr)
+	}
+}
+
+/*
+newEchoServer sets up middlewares and routes.
+*/
+func newEchoServer(srv *server) *echo.Echo {
+	echoServer := echo.New()
+	echoServer.HideBanner = true
+	echoServer.HidePort = true
+	echoServer.Server.ReadHeaderTimeout = 10 * time.Second
+
+	echomw.UptdateRateLimits(echomw.Cfg.MiddlewareRateLimit, echomw.Cfg.MiddlewareBurst)
+	echoServer.Use(echomw.RouteAccessLoggerMiddleware, echomw.RateLimiterMiddleware, rejectCrossOrigin(srv))
+
+	echoServer.GET("/", srv.listReceipts)
+	echoServer.GET("/receipts/:id", srv.showReceipt)
+	echoServer.POST("/receipts/:id", srv.saveCorrections)
+	echoServer.POST("/receipts/:id/revert", srv.revertCorrections)
+	echoServer.GET("/receipts/:id/images/:index", srv.getImage)
+
+	return echoServer
+}
+
+/*
+rejectCrossOrigin refuses state-changing requests that a browser says come
+from another site (Sec-Fetch-Site, or an Origin that isn't this host), so a
+page open in the same browser can't submit forms to the UI. Requests
+without those headers (curl) are let through.
+*/
+func rejectCrossOrigin(srv *server) echo.MiddlewareFunc {
+	protection := http.NewCrossOriginProtection()
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			checkErr := protection.Check(c.Request())
+			if checkErr != nil {
+				tl.Log(
+					tl.Warning, palette.PurpleBright, "Refused cross-origin '%s' to '%s' from '%s'",
+					c.Request().Method, c.Request().URL.Path, c.Request().Header.Get("Origin"),
+				)
+				e := xerr.NewError(checkErr, "cross-origin request refused", c.Request().URL.Path)
+				return srv.renderError(c, http.StatusForbidden, e)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"html/template"
+	"strconv"
+
+	"expense-tracker/src/pkg/category"
+)
+
+/*
+pages are the HTML templates of the review UI: "list", "review" and
+"error". Amounts are shown as plain numbers (12500, 3.49) so they read back
+unchanged when the form is saved.
+*/
+var pages = template.Must(template.New("pages").Funcs(template.FuncMap{
+	"number": func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	},
+	"categoryName": func(key string) string {
+		return category.Current.Name(key)
+	},
+}).Parse(pagesHTML))
+
+const pagesHTML = `
+{{define "head"}}<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+  body { font-family: system-ui, sans-serif; margin: 0; color: #0f172a; background: #f8fafc; }
+  header { padding: 12px 20px; background: #0f172a; color: #f8fafc; }
+  header a { color: #f8fafc; }
+  main { padding: 16px 20px; }
+  table { border-collapse: collapse; width: 100%; background: #fff; }
+  th, td { border-bottom: 1px solid #e2e8f0; padding: 4px 6px; text-align: left; font-size: 14px; vertical-align: top; }
+  th { background: #f1f5f9; }
+  td.number, th.number { text-align: right; }
+  input[type=text] { width: 100%; box-sizing: border-box; font-size: 14px; }
+  input.number { text-align: right; }
+  .review { display: flex; gap: 16px; align-items: flex-start; }
+  .images { flex: 0 0 40%; max-height: 92vh; overflow: auto; position: sticky; top: 8px; }
+  .images img { width: 100%; display: block; }
+  .photo { position: relative; display: block; margin-bottom: 8px; }
+  .highlight { position: absolute; display: none; border: 2px solid #d97706; background: rgba(217, 119, 6, 0.15); pointer-events: none; }
+  .edit { flex: 1; min-width: 0; }
+  .fields { display: grid; grid-template-columns: max-content 1fr; gap: 6px 10px; margin-bottom: 12px; }
+  .notice { padding: 8px 12px; margin-bottom: 12px; background: #ecfdf5; border: 1px solid #059669; }
+  .warning { padding: 8px 12px; margin-bottom: 12px; background: #fffbeb; border: 1px solid #d97706; }
+  .muted { color: #64748b; }
+  .actions { margin: 12px 0; display: flex; gap: 8px; }
+  details { margin-top: 16px; }
+</style>
+</head>
+<body>
+<header><a href="/">Receipts</a></header>
+<main>
+{{end}}
+
+{{define "foot"}}</main>
+</body>
+</html>
+{{end}}
+
+{{define "list"}}{{template "head" "Receipts"}}
+<form method="get" action="/">
+  Month <input name="month" value="{{.Month}}" placeholder="YYYY-MM" size="8">
+  <button type="submit">Show</button>
+</form>
+<p class="muted">{{len .Rows}} receipts, newest first.</p>
+<table>
+  <tr><th>ID</th><th>Date</th><th>Merchant</th><th class="number">Items</th><th class="number">Total</th><th>Currency</th><th>Status</th></tr>
+  {{range .Rows}}
+  <tr>
+    <td><a href="/receipts/{{.ID}}">{{.ID}}</a></td>
+    <td>{{.Analysis.ReceiptDate}}</td>
+    <td>{{.Analysis.MerchantName}}</td>
+    <td class="number">{{len .Analysis.Items}}</td>
+    <td class="number">{{number .Analysis.Totals.ReceiptTotal}}</td>
+    <td>{{.Analysis.EffectiveCurrency}}</td>
+    <td>
+      {{if .Corrected}}corrected{{end}}
+      {{if .ReviewState}}review {{.ReviewState}}{{end}}
+      {{if .Analysis.Totals.TotalCheckMessage}}<span title="{{.Analysis.Totals.TotalCheckMessage}}">totals differ</span>{{end}}
+    </td>
+  </tr>
+  {{end}}
+</table>
+{{template "foot"}}{{end}}
+
+{{define "review"}}{{template "head" (printf "Receipt %d" .Stored.ID)}}
+<h2>Receipt {{.Stored.ID}} <span class="muted">{{.Stored.RunDir}}</span></h2>
+{{if .Saved}}<div class="notice">Corrections saved.</div>{{end}}
+{{if .Current.Totals.TotalCheckMessage}}<div class="warning">{{.Current.Totals.TotalCheckMessage}}</div>{{end}}
+<div class="review">
+  <div class="images">
+    {{range .Images}}<a class="photo" href="/receipts/{{$.Stored.ID}}/images/{{.}}" target="_blank"><img src="/receipts/{{$.Stored.ID}}/images/{{.}}" alt="Receipt photo {{.}}"><span class="highlight"></span></a>{{end}}
+    {{if .ImageNote}}<p class="muted">{{.ImageNote}}</p>{{end}}
+  </div>
+  <div class="edit">
+    <form method="post" action="/receipts/{{.Stored.ID}}">
+      <div class="fields">
+        <label for="receipt_date">Date</label>
+        <input type="text" id="receipt_date" name="receipt_date" value="{{.Current.ReceiptDate}}" placeholder="YYYY-MM-DD">
+        <label for="receipt_datetime">Date and time</label>
+        <input type="text" id="receipt_datetime" name="receipt_datetime" value="{{.Current.ReceiptDateTime}}" placeholder="YYYY-MM-DD HH:MM:SS">
+        <label for="merchant_name">Merchant</label>
+        <input type="text" id="merchant_name" name="merchant_name" value="{{.Current.MerchantName}}">
+        <label for="merchant_tax_id">Tax ID (NIT)</label>
+        <input type="text" id="merchant_tax_id" name="merchant_tax_id" value="{{.Current.MerchantTaxID}}">
+        <label for="receipt_total">Receipt total ({{.Current.EffectiveCurrency}})</label>
+        <input type="text" class="number" id="receipt_total" name="receipt_total" value="{{number .Current.Totals.ReceiptTotal}}">
+        <span>Sum of items</span>
+        <span>{{number .Current.Totals.ComputedItemsTotal}}</span>
+      </div>
+
+      <input type="hidden" name="item_count" value="{{len .Rows}}">
+      <table>
+        <tr>
+          <th>Product (as printed)</th><th>English</th><th class="number">Qty</th>
+          <th class="number">Unit price</th><th class="number">Line total</th><th>Category</th><th>Remove</th>
+        </tr>
+        {{range .Rows}}
+        <tr{{if ge .Photo 0}} data-photo="{{.Photo}}" data-box="{{.Item.Box.Left}},{{.Item.Box.Top}},{{.Item.Box.Right}},{{.Item.Box.Bottom}}"{{end}}>
+          <td>
+            <input type="hidden" name="source_{{.Index}}" value="{{.Source}}">
+            <input type="text" name="name_{{.Index}}" value="{{.Item.OriginalProductName}}">
+            {{if .Item.RawLine}}<div class="muted">{{.Item.RawLine}}</div>{{end}}
+          </td>
+          <td><input type="text" name="english_{{.Index}}" value="{{.Item.ProductNameEnglish}}"></td>
+          <td><input type="text" class="number" size="4" name="quantity_{{.Index}}" value="{{number .Item.Quantity}}"></td>
+          <td><input type="text" class="number" size="8" name="unit_price_{{.Index}}" value="{{if ge .Source 0}}{{number .Item.UnitPrice}}{{end}}"></td>
+          <td><input type="text" class="number" size="8" name="line_total_{{.Index}}" value="{{if ge .Source 0}}{{number .Item.LineTotal}}{{end}}"></td>
+          <td>
+            <select name="category_{{.Index}}">
+              {{$selected := .Item.CategoryKey}}
+              {{if lt .Source 0}}{{$selected = "other"}}{{end}}
+              {{range $.Categories}}<option value="{{.Key}}"{{if eq .Key $selected}} selected{{end}}>{{.Label}}</option>{{end}}
+            </select>
+            {{if .Item.CategoryRule}}<div class="muted">{{if eq .Item.CategoryRule "manual"}}corrected by hand{{else}}set by {{.Item.CategoryRule}}{{end}}{{if .Item.LLMCategoryKey}}, LLM said {{categoryName .Item.LLMCategoryKey}}{{end}}</div>{{end}}
+          </td>
+          <td>{{if ge .Source 0}}<input type="checkbox" name="remove_{{.Index}}" value="1">{{else}}<span class="muted">new</span>{{end}}</td>
+        </tr>
+        {{end}}
+      </table>
+
+      <div class="actions">
+        <button type="submit">Save and confirm</button>
+      </div>
+    </form>
+
+    {{if .Corrected}}
+    <form method="post" action="/receipts/{{.Stored.ID}}/revert">
+      <p class="muted">Corrected {{.Corrections.CorrectedAt}}. The LLM analysis is kept unchanged in receipt-analysis.json.</p>
+      <button type="submit">Revert to the LLM analysis</button>
+    </form>
+
+    <details>
+      <summary>LLM analysis</summary>
+      <p>
+        Date {{.Stored.Analysis.ReceiptDate}} {{.Stored.Analysis.ReceiptDateTime}},
+        merchant {{.Stored.Analysis.MerchantName}} {{.Stored.Analysis.MerchantTaxID}},
+        total {{number .Stored.Analysis.Totals.ReceiptTotal}}
+      </p>
+      <table>
+        <tr><th>Product</th><th>English</th><th class="number">Qty</th><th class="number">Line total</th><th>Category</th></tr>
+        {{range .Stored.Analysis.Items}}
+        <tr>
+          <td>{{.OriginalProductName}}</td><td>{{.ProductNameEnglish}}</td>
+          <td class="number">{{number .Quantity}}</td><td class="number">{{number .LineTotal}}</td>
+          <td>{{categoryName .CategoryKey}}</td>
+        </tr>
+        {{end}}
+      </table>
+    </details>
+    {{end}}
+  </div>
+</div>
+<script>
+// Outline an item's OCR line on its photo while the pointer or focus is on its row.
+// Boxes are in pixels of the photo, so they are scaled by its natural size.
+document.querySelectorAll("tr[data-box]").forEach(function (row) {
+  var photo = document.querySelectorAll(".photo")[Number(row.dataset.photo)];
+  if (!photo) {
+    return;
+  }
+  var image = photo.querySelector("img");
+  var highlight = photo.querySelector(".highlight");
+  var box = row.dataset.box.split(",").map(Number);
+  var show = function () {
+    if (!image.naturalWidth) {
+      return;
+    }
+    highlight.style.left = (100 * box[0] / image.naturalWidth) + "%";
+    highlight.style.top = (100 * box[1] / image.naturalHeight) + "%";
+    highlight.style.width = (100 * (box[2] - box[0]) / image.naturalWidth) + "%";
+    highlight.style.height = (100 * (box[3] - box[1]) / image.naturalHeight) + "%";
+    highlight.style.display = "block";
+    highlight.scrollIntoView({ block: "nearest" });
+  };
+  var hide = function () {
+    highlight.style.display = "none";
+  };
+  row.addEventListener("mouseenter", show);
+  row.addEventListener("focusin", show);
+  row.addEventListener("mouseleave", hide);
+  row.addEventListener("focusout", hide);
+});
+</script>
+{{template "foot"}}{{end}}
+
+{{define "error"}}{{template "head" "Error"}}
+<div class="warning"><strong>{{.Message}}</strong>{{if .Detail}}: {{.Detail}}{{end}}</div>
+<p><a href="javascript:history.back()">Back</a></p>
+{{template "foot"}}{{end}}
+`
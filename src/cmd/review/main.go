@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/pipeline"
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+openLedger parses the common flags plus whatever the subprogram registered
+on subprogramCmd, and opens the ledger.
+*/
+func openLedger(subprogramCmd *flag.FlagSet, flags []string) *ledger.Ledger {
+	configPath := subprogramCmd.String("config", "./cfg/config.json", "Path to your configuration file.")
+	databasePath := subprogramCmd.String("db", "", "Ledger database path (default: ledger.database_path from config)")
+
+	xerr.QuitIfError(subprogramCmd.Parse(flags), "Unable to subprogramCmd.Parse")
+	config.InitializeConfig(*configPath)
+	if *databasePath == "" {
+		*databasePath = ledger.Cfg.DatabasePath
+	}
+
+	receiptLedger, e := ledger.Open(*databasePath)
+	e.QuitIf("error")
+	return receiptLedger
+}
+
+/*
+list prints the oldest reviews first, one per line.
+*/
+func list(subprogram string, flags []string) {
+	subprogramCmd := flag.NewFlagSet(subprogram, flag.ExitOnError)
+	state := subprogramCmd.String("state", string(ledger.ReviewPending), "Only reviews in this state: pending, accepted, rejected (empty = all)")
+	reason := subprogramCmd.String("reason", "", "Only reviews with this reason: total_mismatch, low_ocr_confidence, missing_date, other_items")
+	limit := subprogramCmd.Int("limit", 50, "Maximum number of reviews (0 = all)")
+	receiptLedger := openLedger(subprogramCmd, flags)
+	defer receiptLedger.Close()
+
+	reviews, e := receiptLedger.ListReviews(ledger.ReviewState(*state), ledger.ReviewReason(*reason), *limit)
+	e.QuitIf("error")
+
+	fmt.Printf("%-6s %-8s %-19s %-8s %-40s %s\n", "ID", "STATE", "UPDATED", "RECEIPT", "REASONS", "RUN DIR")
+	for _, review := range reviews {
+		reasons := make([]string, 0, len(review.Findings))
+		for _, reason := range review.Reasons() {
+			reasons = append(reasons, string(reason))
+		}
+		fmt.Printf(
+			"%-6d %-8s %-19s %-8d %-40s %s\n",
+			review.ID, review.State, time.UnixMilli(review.UpdatedAt).Format("2006-01-02 15:04:05"),
+			review.ReceiptID, strings.Join(reasons, ","), review.RunDir,
+		)
+	}
+}
+
+/*
+show prints one review and its receipt (with any corrections applied) as
+JSON.
+*/
+func show(subprogram string, flags []string) {
+	subprogramCmd := flag.NewFlagSet(subprogram, flag.ExitOnError)
+	reviewID := subprogramCmd.Int64("id", 0, "Review id (see list)")
+	receiptLedger := openLedger(subprogramCmd, flags)
+	defer receiptLedger.Close()
+
+	review, found, e := receiptLedger.GetReview(*reviewID)
+	e.QuitIf("error")
+	if !found {
+		tl.Log(tl.Error, palette.Red, "Review '%s' not found in '%s'", *reviewID, receiptLedger.Path)
+		os.Exit(1)
+	}
+
+	shown := struct {
+		Review    ledger.Review     `json:"review"`
+		Corrected bool              `json:"corrected"`
+		Receipt   *receipt.Analysis `json:"receipt"`
+	}{Review: review}
+	if review.ReceiptID != 0 {
+		stored, found, e := receiptLedger.GetReceipt(review.ReceiptID)
+		e.QuitIf("error")
+		if found {
+			analysis := stored.Analysis
+			corrections, correctionsFound, e := receipt.LoadCorrections(review.RunDir)
+			e.QuitIf("error")
+			if correctionsFound {
+				analysis = corrections.Apply(analysis)
+				shown.Corrected = true
+			}
+			shown.Receipt = &analysis
+		}
+	}
+
+	shownJSON, marshalErr := json.MarshalIndent(shown, "", "  ")
+	xerr.QuitIfError(marshalErr, "marshal review")
+	fmt.Println(string(shownJSON))
+}
+
+/*
+resolve accepts or rejects a review. Fix the receipt first (review-server)
+if it needs it; accepting confirms it, so the product memory learns its
+items with the corrections applied (see pipeline.ConfirmReceipt), and
+rejected receipts are left out of reports.
+*/
+func resolve(subprogram string, flags []string, state ledger.ReviewState) {
+	subprogramCmd := flag.NewFlagSet(subprogram, flag.ExitOnError)
+	reviewID := subprogramCmd.Int64("id", 0, "Review id (see list)")
+	note := subprogramCmd.String("note", "", "Why, kept with the review")
+	receiptLedger := openLedger(subprogramCmd, flags)
+	defer receiptLedger.Close()
+
+	review, found, e := receiptLedger.GetReview(*reviewID)
+	e.QuitIf("error")
+	if !found {
+		tl.Log(tl.Error, palette.Red, "Review '%s' not found in '%s'", *reviewID, receiptLedger.Path)
+		os.Exit(1)
+	}
+
+	if state == ledger.ReviewAccepted {
+		analysis, e := receipt.LoadAnalysis(receipt.AnalysisPath(review.RunDir))
+		e.QuitIf("error")
+		corrections, _, e := receipt.LoadCorrections(review.RunDir)
+		e.QuitIf("error")
+		_, e = pipeline.ConfirmReceipt(receiptLedger, review.RunDir, analysis, corrections)
+		e.QuitIf("error")
+	}
+
+	_, e = receiptLedger.ResolveReview(review.ID, state, *note)
+	e.QuitIf("error")
+	tl.Log(tl.Info, palette.Green, "Review '%s' is now '%s'", *reviewID, state)
+}
+
+/*
+main works through the review queue: receipts the pipeline stored but found
+suspicious (totals that don't add up, low OCR confidence, no date, items
+in "other").
+
+Example:
+
+	go run ./src/cmd/review list
+	go run ./src/cmd/review list -reason total_mismatch
+	go run ./src/cmd/review show -id 3
+	go run ./src/cmd/review accept -id 3 -note "tip not itemized"
+	go run ./src/cmd/review reject -id 4 -note "not a receipt"
+*/
+func main() {
+	if len(os.Args) < 2 {
+		tl.Log(
+			tl.Error, palette.Red, "Usage: %s",
+			"go run ./src/cmd/review list [-state S] [-reason R] [-limit N] | show -id N | accept -id N [-note T] | reject -id N [-note T]",
+		)
+		os.Exit(1)
+	}
+	subprogram := os.Args[1]
+	flags := os.Args[2:]
+
+	switch subprogram {
+	case "list":
+		list(subprogram, flags)
+	case "show":
+		show(subprogram, flags)
+	case "accept":
+		resolve(subprogram, flags, ledger.ReviewAccepted)
+	case "reject":
+		resolve(subprogram, flags, ledger.ReviewRejected)
+	default:
+		tl.Log(tl.Error, palette.Red, "Unknown subprogram: %s", subprogram)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,83 @@
+package category
+
+import (
+	"fmt"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+)
+
+/*
+Config points at the category taxonomy and lists the categorization rules.
+
+Set it in cfg/config.json under the "categories" key:
+
+	"categories": {
+	  "taxonomy_path": "./cfg/categories.json",
+	  "rules": [
+	    { "name": "sunflower-oil", "product_name": "aceite\\s+girasol", "category_key": "sunflower_oil" }
+	  ]
+	}
+
+Without it the built-in taxonomy (DefaultTaxonomy) is used. See
+cfg/example.categories.json for the file format and Rule for the rules.
+*/
+type Config struct {
+	TaxonomyPath string `json:"taxonomy_path,omitempty"` // taxonomy JSON file, "" = built-in taxonomy
+	Rules        []Rule `json:"rules,omitempty"`         // applied in order after the LLM, first match wins
+}
+
+func DefaultValueConfig() Config {
+	return Config{
+		TaxonomyPath: "",
+	}
+}
+
+// create config with default values before config gets initialized
+var Cfg Config = DefaultValueConfig() // this one we use to access config values from anywhere
+
+// Current is the taxonomy in use: the file at Cfg.TaxonomyPath, or the built-in one.
+var Current *Taxonomy = DefaultTaxonomy()
+
+/*
+If local Config is provided - use it. Replace all missing values with default ones.
+
+If not provided - just use defaultConfig.
+
+Loads the taxonomy file into Current and checks the rules against it;
+quits if either is invalid.
+*/
+func InitializeConfig(localConfig *Config) {
+	// If not provided - just use defaultConfig
+	if localConfig == nil {
+		tl.Log(tl.Info, palette.Purple, "%s config is %s, keeping %s", "categories", "not provided", "built-in category taxonomy")
+		return
+	}
+
+	defaultConfig := DefaultValueConfig() // Default values to replace some values with during config initialization
+
+	// If local Config is provided - use it
+	Cfg = *localConfig
+
+	tl.ApplyDefaults(&Cfg, defaultConfig, func(field string, defVal any) {
+		tl.Log(
+			tl.Info, palette.Purple,
+			"%s field is %s in %s configuration. Using default value: %v",
+			field, "missing", "categories", tl.PrettyForStderr(defVal),
+		)
+	})
+
+	if Cfg.TaxonomyPath != "" {
+		taxonomy, e := LoadTaxonomy(Cfg.TaxonomyPath)
+		e.QuitIf("error")
+		Current = taxonomy
+		tl.Log(
+			tl.Info, palette.Green, "Loaded category taxonomy from '%s': '%s' categories",
+			Cfg.TaxonomyPath, len(taxonomy.Keys()),
+		)
+	}
+	compileRules(Cfg.Rules, Current).QuitIf("error")
+
+	tl.Log(tl.Info, palette.Green, "%s config was %s, using %s", "categories", "provided", "local categories config")
+	tl.LogJSON(tl.Verbose, palette.CyanDim, fmt.Sprintf("%s configuration", "categories"), Cfg)
+}
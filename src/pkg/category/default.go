@@ -0,0 +1,100 @@
+package category
+
+/*
+DefaultTaxonomy returns the built-in taxonomy, used when no taxonomy file is
+configured. Its leaf keys are the flat categories receipts were analyzed
+into before the taxonomy had groups, so older analyses roll up as well.
+*/
+func DefaultTaxonomy() *Taxonomy {
+	taxonomy, e := NewTaxonomy(defaultCategories())
+	e.QuitIf("error")
+	return taxonomy
+}
+
+func defaultCategories() []Category {
+	return []Category{
+		{
+			Key: "groceries", Name: "Groceries", Color: "#059669",
+			Description: "Food and drinks for home that fit none of the more specific categories below.",
+			Children: []Category{
+				{
+					Key: "dairy", Name: "Dairy",
+					Description: "Dairy products not listed below: cheese, butter, cream, and similar.",
+					Children: []Category{
+						{Key: "milk", Name: "Milk", Description: "Milk (cow/goat), lactose-free milk, flavored milk, and similar."},
+						{Key: "yogurt", Name: "Yogurt", Description: "Yogurt, kefir, drinkable yogurt, Greek yogurt, and similar dairy snacks."},
+					},
+				},
+				{Key: "eggs", Name: "Eggs", Description: "Eggs (any size/type), egg cartons, and similar."},
+				{
+					Key: "produce", Name: "Fruits & vegetables",
+					Description: "Fresh produce not listed below: herbs, mushrooms, and similar.",
+					Children: []Category{
+						{Key: "fruits", Name: "Fruits", Description: "Fresh fruits like apples, oranges, bananas, berries, grapes, and similar."},
+						{Key: "vegetables", Name: "Vegetables", Description: "Fresh vegetables like onions, tomatoes, potatoes, carrots, peppers, greens, and similar."},
+					},
+				},
+				{Key: "meat", Name: "Meat", Description: "Meat and poultry: beef, pork, chicken, turkey, sausages, ground meat, and similar."},
+				{Key: "crops", Name: "Rice, grains & pasta", Description: "Staple dry goods: rice, lentils, beans, chickpeas, oats, pasta, flour, cornmeal, and similar."},
+				{Key: "bakery", Name: "Bakery", Description: "Bread, baked goods, pastries, tortillas, crackers/breadsticks, and similar."},
+				{Key: "snacks_sweets", Name: "Snacks & sweets", Description: "Snacks, cookies, chips, candy, chocolate, desserts, and similar."},
+				{
+					Key: "beverages", Name: "Beverages",
+					Description: "Beverages not listed below.",
+					Children: []Category{
+						{Key: "drinks_soft", Name: "Drinks (non-alcoholic)", Description: "Soft drinks, bottled water, juices, and other non-alcoholic beverages."},
+						{Key: "coffee", Name: "Coffee", Description: "Coffee beans, ground coffee, instant coffee, and coffee-related items like filters."},
+						{Key: "tea", Name: "Tea", Description: "Tea bags, loose tea, herbal/aromática tea, and similar infusions."},
+					},
+				},
+				{
+					Key: "cooking_oils", Name: "Cooking oils",
+					Description: "Cooking oils not listed below: vegetable, canola, coconut oil, and similar.",
+					Children: []Category{
+						{Key: "olive_oil", Name: "Olive oil", Description: "Olive oil and olive-based cooking oils."},
+						{Key: "sunflower_oil", Name: "Sunflower oil", Description: "Sunflower oil and sunflower-based cooking oils."},
+					},
+				},
+				{
+					Key: "condiments", Name: "Condiments",
+					Description: "Condiments not listed below: vinegar, stock cubes, and similar.",
+					Children: []Category{
+						{Key: "spices_salt_sugar", Name: "Spices, salt & sugar", Description: "Spices and seasonings, plus salt and sugar (and similar sweeteners)."},
+						{Key: "soy_sauce", Name: "Soy sauce", Description: "Soy sauce, tamari, and similar soy-based sauces (kept separate from other sauces)."},
+						{Key: "sauces", Name: "Sauces", Description: "Sauces like tomato sauce, bolognese, mayonnaise, ketchup, mustard, hot sauce, dressings, and similar (excluding soy sauce)."},
+					},
+				},
+			},
+		},
+		{
+			Key: "household", Name: "Household", Color: "#2563EB",
+			Description: "Household cleaning and paper goods.",
+			Children: []Category{
+				{Key: "wet_tissue", Name: "Wet tissues", Description: "Wet wipes/wet tissues for cleaning surfaces, hands, or general household use."},
+				{Key: "paper_towels", Name: "Paper towels", Description: "Paper towels, kitchen rolls, and similar disposable paper cleaning rolls."},
+				{Key: "toilet_paper", Name: "Toilet paper", Description: "Toilet paper rolls, tissues intended for bathroom use, and similar."},
+				{Key: "dishwashing", Name: "Dishwashing", Description: "Dishwashing liquid/gel, dishwashing solid/bar, and similar dish-cleaning products."},
+				{Key: "washing_machine", Name: "Laundry", Description: "Laundry detergent (powder/liquid), softener, stain remover, bleach, and similar laundry products."},
+				{Key: "other_household", Name: "Other household", Description: "Other household items like trash bags, dish sponges, washing towels/rags, gloves, foil, cling film, and similar."},
+			},
+		},
+		{
+			Key: "personal_care", Name: "Personal care", Color: "#DB2777",
+			Description: "Personal care products.",
+			Children: []Category{
+				{Key: "shampoo", Name: "Shampoo", Description: "Shampoo, conditioner, and similar hair-washing products."},
+				{Key: "toothpaste", Name: "Toothpaste", Description: "Toothpaste, toothpaste tablets, and similar oral-care paste products."},
+				{Key: "personal_care_other", Name: "Other personal care", Description: "Other personal care items not listed above: deodorant, razors, shaving cream, cotton pads, skincare, cosmetics, feminine hygiene, and similar."},
+			},
+		},
+		{
+			Key: "health", Name: "Health", Color: "#D97706",
+			Description: "Health products.",
+			Children: []Category{
+				{Key: "medicine", Name: "Medicine", Description: "Pharmacy products, medicines, first-aid and other health-related items."},
+				{Key: "supplements", Name: "Supplements", Description: "Supplements like protein, creatine, vitamins, minerals, omega-3, and similar."},
+			},
+		},
+		{Key: Other, Name: "Other", Color: "#64748B", Description: "Anything that does not clearly fit any of the categories above."},
+	}
+}
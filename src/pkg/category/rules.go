@@ -0,0 +1,148 @@
+package category
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+Rule sets the category of matching items, overriding the LLM. Rules are
+configured under "categories" in cfg/config.json:
+
+	"rules": [
+	  { "name": "sunflower-oil", "product_name": "aceite\\s+girasol", "category_key": "sunflower_oil" },
+	  { "name": "pharmacy", "merchant": "farmatodo|900123456", "category_key": "medicine" },
+	  { "name": "bags", "product_name": "^bolsa", "max_price": 500, "category_key": "other_household" }
+	]
+
+Every condition given must match; at least one is required. Regular
+expressions are case-insensitive and match anywhere unless anchored.
+
+Fields:
+  - Name: recorded on the items the rule categorizes (receipt.Item.CategoryRule).
+  - ProductName: regular expression over original_product_name.
+  - Merchant: regular expression over the merchant name or tax ID (NIT,
+    normalized to "900123456-7", see receipt.NormalizeTaxID).
+  - MinPrice, MaxPrice: range of the item's line_total, in the receipt
+    currency (0 = no limit).
+  - CategoryKey: the category to set; must be in the taxonomy.
+*/
+type Rule struct {
+	Name        string  `json:"name"`
+	ProductName string  `json:"product_name,omitempty"`
+	Merchant    string  `json:"merchant,omitempty"`
+	MinPrice    float64 `json:"min_price,omitempty"`
+	MaxPrice    float64 `json:"max_price,omitempty"`
+	CategoryKey string  `json:"category_key"`
+
+	productNameRegexp *regexp.Regexp
+	merchantRegexp    *regexp.Regexp
+}
+
+/*
+compileRules validates rules against taxonomy and compiles their regular
+expressions in place.
+*/
+func compileRules(rules []Rule, taxonomy *Taxonomy) (e *xerr.Error) {
+	names := make(map[string]bool)
+	for index := range rules {
+		rule := &rules[index]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", index+1)
+		}
+		if names[rule.Name] {
+			e = xerr.NewError(fmt.Errorf("rule name is used more than once"), "validate categorization rule", rule.Name)
+			return e
+		}
+		names[rule.Name] = true
+
+		if _, found := taxonomy.Lookup(rule.CategoryKey); !found {
+			e = xerr.NewError(fmt.Errorf("category_key '%s' is not in the taxonomy", rule.CategoryKey), "validate categorization rule", rule.Name)
+			return e
+		}
+		if rule.ProductName == "" && rule.Merchant == "" && rule.MinPrice == 0 && rule.MaxPrice == 0 {
+			e = xerr.NewError(fmt.Errorf("rule has no conditions"), "validate categorization rule", rule.Name)
+			return e
+		}
+		if rule.MaxPrice != 0 && rule.MaxPrice < rule.MinPrice {
+			e = xerr.NewError(fmt.Errorf("max_price is below min_price"), "validate categorization rule", rule.Name)
+			return e
+		}
+
+		var compileErr error
+		if rule.ProductName != "" {
+			rule.productNameRegexp, compileErr = regexp.Compile("(?i)" + rule.ProductName)
+			if compileErr != nil {
+				e = xerr.NewError(compileErr, "compile product_name of categorization rule", rule.Name)
+				return e
+			}
+		}
+		if rule.Merchant != "" {
+			rule.merchantRegexp, compileErr = regexp.Compile("(?i)" + rule.Merchant)
+			if compileErr != nil {
+				e = xerr.NewError(compileErr, "compile merchant of categorization rule", rule.Name)
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether every condition of the rule holds for item of analysis.
+func (rule Rule) matches(item receipt.Item, analysis *receipt.Analysis) bool {
+	if rule.productNameRegexp != nil && !rule.productNameRegexp.MatchString(item.OriginalProductName) {
+		return false
+	}
+	if rule.merchantRegexp != nil &&
+		!rule.merchantRegexp.MatchString(analysis.MerchantName) &&
+		!(analysis.MerchantTaxID != "" && rule.merchantRegexp.MatchString(receipt.NormalizeTaxID(analysis.MerchantTaxID))) {
+		return false
+	}
+	if rule.MinPrice != 0 && item.LineTotal < rule.MinPrice {
+		return false
+	}
+	if rule.MaxPrice != 0 && item.LineTotal > rule.MaxPrice {
+		return false
+	}
+	return true
+}
+
+/*
+ApplyRules runs the configured rules (Cfg.Rules) over the items of
+analysis: the first rule that matches an item sets its CategoryKey and
+CategoryRule, and the LLM's category is kept in LLMCategoryKey. Items a
+rule set earlier get their LLM category back first, so an analysis can be
+run through changed rules again. It returns how many items a rule
+categorized and whether any item changed.
+*/
+func ApplyRules(analysis *receipt.Analysis) (fired int, changed bool) {
+	for index := range analysis.Items {
+		item := &analysis.Items[index]
+		before := *item
+
+		if item.CategoryRule != "" {
+			item.CategoryKey = item.LLMCategoryKey
+			item.CategoryRule, item.LLMCategoryKey = "", ""
+		}
+		for _, rule := range Cfg.Rules {
+			if !rule.matches(*item, analysis) {
+				continue
+			}
+			item.LLMCategoryKey = item.CategoryKey
+			item.CategoryKey = rule.CategoryKey
+			item.CategoryRule = rule.Name
+			fired++
+			break
+		}
+
+		if item.CategoryKey != before.CategoryKey || item.CategoryRule != before.CategoryRule ||
+			item.LLMCategoryKey != before.LLMCategoryKey {
+			changed = true
+		}
+	}
+	return fired, changed
+}
@@ -0,0 +1,261 @@
+/*
+The category taxonomy items are classified into: a tree of categories
+(groceries > dairy > milk) with display names, colors and descriptions.
+The LLM gets the whole tree and picks the most specific key that fits; the
+report can roll item categories up to any level of it.
+*/
+package category
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/tuumbleweed/xerr"
+)
+
+// Other is the fallback category; every taxonomy has it.
+const Other = "other"
+
+// keyRegexp is what a category key may look like.
+var keyRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+/*
+Category is one node of the taxonomy.
+
+Fields:
+  - Key: stable identifier stored with every item (lower case, digits, "_").
+  - Name: display name; the title-cased key when empty.
+  - Description: what belongs here, shown to the LLM.
+  - Color: CSS color of the category in the report; a palette color when empty.
+  - Children: more specific categories inside this one.
+*/
+type Category struct {
+	Key         string     `json:"key"`
+	Name        string     `json:"name,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Color       string     `json:"color,omitempty"`
+	Children    []Category `json:"children,omitempty"`
+}
+
+/*
+Taxonomy is a category tree, usually loaded from the file in
+Cfg.TaxonomyPath:
+
+	{
+	  "categories": [
+	    {
+	      "key": "groceries", "name": "Groceries", "color": "#059669",
+	      "description": "Food and drinks for home.",
+	      "children": [
+	        {
+	          "key": "dairy", "name": "Dairy",
+	          "description": "Milk products.",
+	          "children": [
+	            { "key": "milk", "name": "Milk", "description": "Milk (cow/goat), lactose-free milk." }
+	          ]
+	        }
+	      ]
+	    }
+	  ]
+	}
+
+Items may be put into any category of the tree, a group like "dairy"
+included, when nothing more specific fits. Keys are unique across the
+whole tree.
+*/
+type Taxonomy struct {
+	Categories []Category `json:"categories"`
+
+	nodes map[string]treeNode // by key
+	keys  []string            // tree order (parents before their children)
+}
+
+// treeNode is a category and the keys from the top-level category down to it.
+type treeNode struct {
+	category Category
+	path     []string
+}
+
+/*
+LoadTaxonomy reads and validates a taxonomy file. A top-level "other"
+category is added when the file doesn't have one.
+*/
+func LoadTaxonomy(taxonomyPath string) (taxonomy *Taxonomy, e *xerr.Error) {
+	fileBytes, readErr := os.ReadFile(taxonomyPath)
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read category taxonomy file", taxonomyPath)
+		return nil, e
+	}
+
+	taxonomy = &Taxonomy{}
+	unmarshalErr := json.Unmarshal(fileBytes, taxonomy)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "unmarshal category taxonomy JSON", taxonomyPath)
+		return nil, e
+	}
+
+	indexErr := taxonomy.index()
+	if indexErr != nil {
+		e = xerr.NewError(indexErr, "validate category taxonomy file", taxonomyPath)
+		return nil, e
+	}
+	return taxonomy, nil
+}
+
+/*
+NewTaxonomy builds a taxonomy from a category tree (see LoadTaxonomy).
+*/
+func NewTaxonomy(categories []Category) (taxonomy *Taxonomy, e *xerr.Error) {
+	taxonomy = &Taxonomy{Categories: categories}
+	indexErr := taxonomy.index()
+	if indexErr != nil {
+		e = xerr.NewError(indexErr, "validate category taxonomy", fmt.Sprintf("%d top-level categories", len(categories)))
+		return nil, e
+	}
+	return taxonomy, nil
+}
+
+// index validates the tree, adds "other" if missing and fills nodes and keys.
+func (taxonomy *Taxonomy) index() error {
+	taxonomy.nodes = make(map[string]treeNode)
+	taxonomy.keys = nil
+
+	var visit func(categories []Category, parentPath []string) error
+	visit = func(categories []Category, parentPath []string) error {
+		for _, category := range categories {
+			if !keyRegexp.MatchString(category.Key) {
+				return fmt.Errorf("category key '%s' must be lower case letters, digits and '_'", category.Key)
+			}
+			if _, exists := taxonomy.nodes[category.Key]; exists {
+				return fmt.Errorf("category key '%s' is used more than once", category.Key)
+			}
+			path := append(append([]string{}, parentPath...), category.Key)
+			taxonomy.nodes[category.Key] = treeNode{category: category, path: path}
+			taxonomy.keys = append(taxonomy.keys, category.Key)
+
+			err := visit(category.Children, path)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, hasOther := findTopLevel(taxonomy.Categories, Other); !hasOther {
+		taxonomy.Categories = append(taxonomy.Categories, Category{
+			Key:         Other,
+			Name:        "Other",
+			Description: "Anything that does not clearly fit any of the categories above.",
+		})
+	}
+	return visit(taxonomy.Categories, nil)
+}
+
+func findTopLevel(categories []Category, key string) (Category, bool) {
+	for _, category := range categories {
+		if category.Key == key {
+			return category, true
+		}
+	}
+	return Category{}, false
+}
+
+/*
+Keys returns every category key in tree order: each category is followed by
+its children.
+*/
+func (taxonomy *Taxonomy) Keys() []string {
+	return append([]string{}, taxonomy.keys...)
+}
+
+/*
+Lookup returns the category with key (Children included).
+*/
+func (taxonomy *Taxonomy) Lookup(key string) (category Category, found bool) {
+	node, found := taxonomy.nodes[key]
+	return node.category, found
+}
+
+/*
+Path returns the keys from the top-level category down to key, e.g.
+["groceries", "dairy", "milk"], or nil for unknown keys.
+*/
+func (taxonomy *Taxonomy) Path(key string) []string {
+	node, found := taxonomy.nodes[key]
+	if !found {
+		return nil
+	}
+	return append([]string{}, node.path...)
+}
+
+/*
+RollUp returns the category key is counted under when the taxonomy is cut
+at level: its ancestor at that depth (1 = top-level categories), or key
+itself when it is not that deep. Level 0 and unknown keys (categories given
+before the taxonomy changed) are returned unchanged.
+
+Example (built-in taxonomy):
+
+	RollUp("milk", 1) -> "groceries"
+	RollUp("milk", 2) -> "dairy"
+	RollUp("milk", 3) -> "milk"
+	RollUp("eggs", 2) -> "eggs"
+*/
+func (taxonomy *Taxonomy) RollUp(key string, level int) string {
+	node, found := taxonomy.nodes[key]
+	if level <= 0 || !found || level >= len(node.path) {
+		return key
+	}
+	return node.path[level-1]
+}
+
+/*
+Name returns the display name of key: the configured one, or the key
+title-cased ("personal_care" -> "Personal Care") for keys without a name
+and keys that are not in the taxonomy.
+*/
+func (taxonomy *Taxonomy) Name(key string) string {
+	node, found := taxonomy.nodes[key]
+	if found && node.category.Name != "" {
+		return node.category.Name
+	}
+
+	parts := strings.Split(key, "_")
+	for index, part := range parts {
+		if part == "" {
+			continue
+		}
+		parts[index] = strings.ToUpper(part[:1]) + part[1:]
+	}
+	return strings.Join(parts, " ")
+}
+
+/*
+Color returns the configured color of key, "" when it has none. Colors are
+not inherited, so the children of a colored group can still be told apart.
+*/
+func (taxonomy *Taxonomy) Color(key string) string {
+	return taxonomy.nodes[key].category.Color
+}
+
+/*
+PromptBlock lists the taxonomy for the LLM prompt: a "- key: description"
+line per category in tree order, children indented two spaces more than
+their parent. Categories without a description get their display name.
+*/
+func (taxonomy *Taxonomy) PromptBlock() string {
+	lines := make([]string, 0, len(taxonomy.keys))
+	for _, key := range taxonomy.keys {
+		node := taxonomy.nodes[key]
+		description := node.category.Description
+		if description == "" {
+			description = taxonomy.Name(key)
+		}
+		indent := strings.Repeat("  ", len(node.path)-1)
+		lines = append(lines, fmt.Sprintf("%s- %s: %s", indent, key, description))
+	}
+	return strings.Join(lines, "\n")
+}
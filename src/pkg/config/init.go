@@ -9,16 +9,32 @@ import (
 
 	tl "github.com/tuumbleweed/tintlog/logger"
 	"github.com/tuumbleweed/tintlog/palette"
+
+	"expense-tracker/src/pkg/category"
+	echomw "expense-tracker/src/pkg/echo-middleware"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/llm"
+	"expense-tracker/src/pkg/openai"
+	"expense-tracker/src/pkg/preprocess"
 )
 
 type Config struct {
 	// parts present in configuration file (some of the parameters are generated during initilization process)
-	Logger *tl.Config `json:"logger"`
+	Logger *tl.Config     `json:"logger"`
+	LLM    *llm.Config    `json:"llm,omitempty"`
+	OpenAI *openai.Config `json:"openai,omitempty"`
+	Ledger *ledger.Config `json:"ledger,omitempty"`
+	Server *echomw.Config `json:"server,omitempty"`
+
+	Preprocess *preprocess.Config `json:"preprocess,omitempty"`
+	Categories *category.Config   `json:"categories,omitempty"`
 
 	// those parametrs are initialized during InitializeConfig()
 	CallerProgramName string `json:"caller_program_name,omitempty"`
 }
 
+var Cfg Config // effective config, set by InitializeConfig
+
 func GetDefaultConfig() Config {
 	callerProgramName := GetCallerProgramNamePanicWrapper(5)
 	callerProgramName = strings.TrimPrefix(callerProgramName, "expense-tracker/")
@@ -29,6 +45,24 @@ func GetDefaultConfig() Config {
 func SetEffectiveValues(userConfig Config) Config {
 	userConfig.Logger = &tl.Cfg
 
+	llm.InitializeConfig(userConfig.LLM)
+	userConfig.LLM = &llm.Cfg
+
+	openai.InitializeConfig(userConfig.OpenAI)
+	userConfig.OpenAI = &openai.Cfg
+
+	ledger.InitializeConfig(userConfig.Ledger)
+	userConfig.Ledger = &ledger.Cfg
+
+	echomw.InitializeConfig(userConfig.Server)
+	userConfig.Server = &echomw.Cfg
+
+	preprocess.InitializeConfig(userConfig.Preprocess)
+	userConfig.Preprocess = &preprocess.Cfg
+
+	category.InitializeConfig(userConfig.Categories)
+	userConfig.Categories = &category.Cfg
+
 	return userConfig
 }
 
@@ -58,6 +92,7 @@ func InitializeConfig(configPath string) {
 	tl.InitializeConfig(userConfig.Logger)
 
 	userConfig = SetEffectiveValues(userConfig)
+	Cfg = userConfig
 	tl.Log(tl.Important1, palette.GreenBold, "%s, config path: '%s', caller: '%s'", "Initialized", configPath, userConfig.CallerProgramName)
 	tl.Log(tl.Info, palette.CyanDim, "%s (JSON):\n'''\n%s\n'''", "Effective User Config", userConfig)
 }
@@ -0,0 +1,127 @@
+/*
+Currency codes, amount formatting and conversion with a local exchange
+rates file.
+
+Amounts are aggregated in minor units (cents) of the reporting currency so
+that sums stay exact; currencies like COP that are never printed with cents
+have zero decimals.
+*/
+package currency
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Default is assumed for receipts analyzed before the currency was recorded.
+const Default = "COP"
+
+// zeroDecimals lists currencies whose receipts don't show cents.
+var zeroDecimals = map[string]bool{
+	"COP": true,
+	"CLP": true,
+	"JPY": true,
+	"KRW": true,
+	"PYG": true,
+	"VND": true,
+}
+
+/*
+Normalize returns the upper-case ISO 4217 code, or Default if code is empty.
+*/
+func Normalize(code string) string {
+	normalized := strings.ToUpper(strings.TrimSpace(code))
+	if normalized == "" {
+		return Default
+	}
+	return normalized
+}
+
+/*
+Decimals returns how many minor-unit digits are shown for the currency.
+*/
+func Decimals(code string) int {
+	if zeroDecimals[Normalize(code)] {
+		return 0
+	}
+	return 2
+}
+
+/*
+ToMinor converts an amount to whole minor units of the currency.
+
+Example:
+
+	ToMinor(12.345, "USD") -> 1235
+	ToMinor(71630, "COP")  -> 71630
+*/
+func ToMinor(amount float64, code string) int64 {
+	return int64(math.Round(amount * math.Pow10(Decimals(code))))
+}
+
+/*
+FormatMinor formats an amount given in minor units, e.g. "COP 71.630" or
+"USD 1,234.50". COP uses the Colombian "." thousand separator, other
+currencies use ",".
+*/
+func FormatMinor(amountMinor int64, code string) string {
+	normalized := Normalize(code)
+	decimals := Decimals(normalized)
+
+	sign := ""
+	if amountMinor < 0 {
+		sign = "-"
+		amountMinor = -amountMinor
+	}
+
+	thousandsSep, decimalSep := ",", "."
+	if normalized == "COP" {
+		thousandsSep, decimalSep = ".", ","
+	}
+
+	raw := strconv.FormatInt(amountMinor, 10)
+	if decimals == 0 {
+		return fmt.Sprintf("%s%s %s", sign, normalized, groupThousands(raw, thousandsSep))
+	}
+
+	for len(raw) <= decimals {
+		raw = "0" + raw
+	}
+	whole := raw[:len(raw)-decimals]
+	fraction := raw[len(raw)-decimals:]
+
+	return fmt.Sprintf("%s%s %s%s%s", sign, normalized, groupThousands(whole, thousandsSep), decimalSep, fraction)
+}
+
+/*
+Format formats an amount in major units (as printed on the receipt).
+*/
+func Format(amount float64, code string) string {
+	return FormatMinor(ToMinor(amount, code), code)
+}
+
+/*
+groupThousands groups digits in a base-10 string using the provided separator.
+*/
+func groupThousands(raw string, sep string) string {
+	if len(raw) <= 3 {
+		return raw
+	}
+
+	var builder strings.Builder
+	firstGroupLen := len(raw) % 3
+	if firstGroupLen == 0 {
+		firstGroupLen = 3
+	}
+
+	builder.WriteString(raw[:firstGroupLen])
+
+	for index := firstGroupLen; index < len(raw); index += 3 {
+		builder.WriteString(sep)
+		builder.WriteString(raw[index : index+3])
+	}
+
+	return builder.String()
+}
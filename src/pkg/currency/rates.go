@@ -0,0 +1,160 @@
+package currency
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/tuumbleweed/xerr"
+)
+
+/*
+Rates is a local exchange rates file, keyed by date:
+
+	{
+	  "base": "USD",
+	  "rates": {
+	    "2026-01-02": { "COP": 3925.5, "EUR": 0.91 },
+	    "2026-01-05": { "COP": 3931.0, "EUR": 0.92 }
+	  }
+	}
+
+Each rate is how many units of that currency one unit of Base buys. Any two
+listed currencies can be converted through Base. Dates don't need to be
+contiguous: the latest date on or before the receipt date is used
+(weekends and holidays have no rates).
+
+Fields:
+  - Base: currency the rates are quoted against (rate 1).
+  - Rates: date (YYYY-MM-DD) -> currency code -> rate.
+*/
+type Rates struct {
+	Base  string                        `json:"base"`
+	Rates map[string]map[string]float64 `json:"rates"`
+
+	dates []string // sorted keys of Rates
+}
+
+/*
+Conversion describes the rate applied to one amount.
+
+Fields:
+  - From, To: currency codes.
+  - Rate: multiply an amount in From by Rate to get To.
+  - RateDate: date of the rates used (YYYY-MM-DD), "" when From == To.
+  - Exact: false when RateDate differs from the requested date.
+*/
+type Conversion struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Rate     float64 `json:"rate"`
+	RateDate string  `json:"rate_date,omitempty"`
+	Exact    bool    `json:"exact"`
+}
+
+/*
+LoadRates reads and validates a rates file.
+*/
+func LoadRates(ratesPath string) (rates *Rates, e *xerr.Error) {
+	fileBytes, readErr := os.ReadFile(ratesPath)
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read exchange rates file", ratesPath)
+		return nil, e
+	}
+
+	rates = &Rates{}
+	unmarshalErr := json.Unmarshal(fileBytes, rates)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "unmarshal exchange rates JSON", ratesPath)
+		return nil, e
+	}
+
+	if rates.Base == "" {
+		e = xerr.NewError(fmt.Errorf("'base' is empty"), "validate exchange rates file", ratesPath)
+		return nil, e
+	}
+	rates.Base = Normalize(rates.Base)
+
+	for date, byCurrency := range rates.Rates {
+		_, parseErr := time.Parse(time.DateOnly, date)
+		if parseErr != nil {
+			e = xerr.NewErrorECOL(parseErr, "validate exchange rates date", "path", ratesPath)
+			return nil, e
+		}
+
+		normalized := make(map[string]float64, len(byCurrency))
+		for code, rate := range byCurrency {
+			if rate <= 0 {
+				e = xerr.NewErrorECOL(fmt.Errorf("rate for %s on %s is %v", code, date, rate), "validate exchange rate", "path", ratesPath)
+				return nil, e
+			}
+			normalized[Normalize(code)] = rate
+		}
+		rates.Rates[date] = normalized
+		rates.dates = append(rates.dates, date)
+	}
+	sort.Strings(rates.dates)
+
+	return rates, nil
+}
+
+/*
+Convert returns the rate from one currency to another on date (YYYY-MM-DD).
+
+The latest rates on or before date are used; if date is older than the whole
+file, the earliest rates are used instead (Exact is false in both cases
+when the dates differ). Rates may be nil when no conversion is needed.
+*/
+func (rates *Rates) Convert(from string, to string, date string) (conversion Conversion, e *xerr.Error) {
+	conversion = Conversion{From: Normalize(from), To: Normalize(to), Rate: 1, Exact: true}
+	if conversion.From == conversion.To {
+		return conversion, nil
+	}
+
+	if rates == nil || len(rates.dates) == 0 {
+		e = xerr.NewErrorECOL(fmt.Errorf("no exchange rates loaded"), "convert currency", "pair", conversion.From+"->"+conversion.To)
+		return conversion, e
+	}
+
+	// Index of the first date after the requested one; the one before it is
+	// the latest date on or before.
+	index := sort.SearchStrings(rates.dates, date)
+	if index < len(rates.dates) && rates.dates[index] == date {
+		index += 1
+	}
+
+	candidates := make([]string, 0, len(rates.dates))
+	for position := index - 1; position >= 0; position-- {
+		candidates = append(candidates, rates.dates[position])
+	}
+	for position := index; position < len(rates.dates); position++ {
+		candidates = append(candidates, rates.dates[position])
+	}
+
+	for _, rateDate := range candidates {
+		fromRate, fromOK := rates.rateOn(rateDate, conversion.From)
+		toRate, toOK := rates.rateOn(rateDate, conversion.To)
+		if !fromOK || !toOK {
+			continue
+		}
+
+		conversion.Rate = toRate / fromRate
+		conversion.RateDate = rateDate
+		conversion.Exact = rateDate == date
+		return conversion, nil
+	}
+
+	e = xerr.NewErrorECOL(fmt.Errorf("no rate for %s and %s", conversion.From, conversion.To), "convert currency", "date", date)
+	return conversion, e
+}
+
+// rateOn returns units of code per one Base on date.
+func (rates *Rates) rateOn(date string, code string) (rate float64, ok bool) {
+	if code == rates.Base {
+		return 1, true
+	}
+	rate, ok = rates.Rates[date][code]
+	return rate, ok
+}
@@ -0,0 +1,354 @@
+/*
+Colombian (DIAN) electronic invoices.
+
+Sellers send e-invoices as a UBL 2.1 XML file, usually an AttachedDocument
+wrapping the signed Invoice, often zipped together with the PDF. The XML has
+the exact items, seller NIT, CUFE, taxes and totals, so it is read straight
+into a receipt.Analysis without OCR or the LLM; only ProductNameEnglish and
+CategoryKey of the items are left for the LLM to fill in.
+*/
+package dian
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tuumbleweed/xerr"
+	"golang.org/x/net/html/charset"
+
+	"expense-tracker/src/pkg/receipt"
+)
+
+// ParserVersion changes whenever the same XML would produce a different analysis.
+const ParserVersion = "2"
+
+/*
+IsInvoiceExt reports whether ext (with the dot) is a file type ParseFile
+reads: .xml or .zip.
+*/
+func IsInvoiceExt(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".xml", ".zip":
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+ParseFile reads an e-invoice from an .xml file (Invoice or AttachedDocument)
+or a .zip containing one.
+*/
+func ParseFile(filePath string) (analysis receipt.Analysis, e *xerr.Error) {
+	fileBytes, readErr := os.ReadFile(filePath)
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read e-invoice file", filePath)
+		return analysis, e
+	}
+
+	if strings.ToLower(filepath.Ext(filePath)) == ".zip" {
+		return parseZip(fileBytes, filePath)
+	}
+	return Parse(fileBytes)
+}
+
+/*
+Parse reads an Invoice or AttachedDocument XML document.
+*/
+func Parse(xmlBytes []byte) (analysis receipt.Analysis, e *xerr.Error) {
+	invoice, e := decodeInvoice(xmlBytes)
+	if e != nil {
+		return analysis, e
+	}
+	return invoiceToAnalysis(invoice), nil
+}
+
+/*
+parseZip parses the first XML file in the archive that holds an invoice
+(the archive usually also has the PDF, and sometimes DIAN's response).
+*/
+func parseZip(zipBytes []byte, zipPath string) (analysis receipt.Analysis, e *xerr.Error) {
+	archive, zipErr := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	if zipErr != nil {
+		e = xerr.NewError(zipErr, "open e-invoice zip", zipPath)
+		return analysis, e
+	}
+
+	files := append([]*zip.File(nil), archive.File...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	var lastErr *xerr.Error
+	for _, file := range files {
+		if strings.ToLower(filepath.Ext(file.Name)) != ".xml" {
+			continue
+		}
+		reader, openErr := file.Open()
+		if openErr != nil {
+			lastErr = xerr.NewError(openErr, "open file in e-invoice zip", file.Name)
+			continue
+		}
+		xmlBytes, readErr := io.ReadAll(reader)
+		_ = reader.Close()
+		if readErr != nil {
+			lastErr = xerr.NewError(readErr, "read file in e-invoice zip", file.Name)
+			continue
+		}
+
+		analysis, lastErr = Parse(xmlBytes)
+		if lastErr == nil {
+			return analysis, nil
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = xerr.NewError(fmt.Errorf("no .xml file in archive"), "find e-invoice XML in zip", zipPath)
+	}
+	return analysis, lastErr
+}
+
+/*
+decodeInvoice unwraps an AttachedDocument if needed and decodes the Invoice.
+*/
+func decodeInvoice(xmlBytes []byte) (invoice ublInvoice, e *xerr.Error) {
+	embedded := false
+	rootName, e := rootElementName(xmlBytes, embedded)
+	if e != nil {
+		return invoice, e
+	}
+
+	if rootName == "AttachedDocument" {
+		var attached ublAttachedDocument
+		unmarshalErr := newDecoder(xmlBytes, embedded).Decode(&attached)
+		if unmarshalErr != nil {
+			e = xerr.NewError(unmarshalErr, "parse AttachedDocument XML", nil)
+			return invoice, e
+		}
+		xmlBytes = []byte(strings.TrimSpace(attached.Description))
+		embedded = true
+		rootName, e = rootElementName(xmlBytes, embedded)
+		if e != nil {
+			return invoice, e
+		}
+	}
+
+	if rootName != "Invoice" {
+		err := fmt.Errorf("root element is '%s' (credit and debit notes are not supported)", rootName)
+		e = xerr.NewError(err, "expected a UBL Invoice", rootName)
+		return invoice, e
+	}
+
+	unmarshalErr := newDecoder(xmlBytes, embedded).Decode(&invoice)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "parse Invoice XML", nil)
+		return invoice, e
+	}
+	if len(invoice.Lines) == 0 {
+		e = xerr.NewError(fmt.Errorf("invoice has no InvoiceLine"), "parse Invoice XML", invoice.ID)
+		return invoice, e
+	}
+
+	return invoice, nil
+}
+
+func rootElementName(xmlBytes []byte, embedded bool) (name string, e *xerr.Error) {
+	decoder := newDecoder(xmlBytes, embedded)
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr != nil {
+			e = xerr.NewError(tokenErr, "read XML root element", nil)
+			return "", e
+		}
+		if element, isStart := token.(xml.StartElement); isStart {
+			return element.Name.Local, nil
+		}
+	}
+}
+
+/*
+newDecoder returns an XML decoder that converts the encoding the document
+declares (DIAN XML is often ISO-8859-1) to UTF-8. An Invoice embedded in an
+AttachedDocument was converted along with it, so when embedded is set its
+declaration is ignored and the bytes are read as UTF-8.
+*/
+func newDecoder(xmlBytes []byte, embedded bool) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(xmlBytes))
+	decoder.CharsetReader = charset.NewReaderLabel
+	if embedded {
+		decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+	}
+	return decoder
+}
+
+var issueTimeRegexp = regexp.MustCompile(`^\d{2}:\d{2}:\d{2}`)
+
+/*
+invoiceToAnalysis maps the invoice onto the receipt model. Item line totals
+include their taxes, like the prices on a printed receipt, so they add up
+to the payable amount.
+*/
+func invoiceToAnalysis(invoice ublInvoice) (analysis receipt.Analysis) {
+	supplier := invoice.Supplier
+	analysis.ReceiptDate = strings.TrimSpace(invoice.IssueDate)
+	if issueTime := issueTimeRegexp.FindString(strings.TrimSpace(invoice.IssueTime)); issueTime != "" && analysis.ReceiptDate != "" {
+		analysis.ReceiptDateTime = analysis.ReceiptDate + " " + issueTime
+	}
+	analysis.MerchantName = merchantName(supplier)
+	analysis.MerchantTaxID = merchantTaxID(supplier)
+	analysis.StoreAddress = storeAddress(supplier)
+	analysis.Currency = strings.ToUpper(strings.TrimSpace(invoice.DocumentCurrencyCode))
+
+	analysis.Items = make([]receipt.Item, 0, len(invoice.Lines))
+	for _, line := range invoice.Lines {
+		name := strings.Join(strings.Fields(strings.Join(line.Descriptions, " ")), " ")
+		sku := strings.TrimSpace(line.SellersItemID)
+		if sku == "" {
+			sku = strings.TrimSpace(line.StandardItemID)
+		}
+		if name == "" {
+			name = sku
+		}
+
+		quantity := line.InvoicedQuantity.Value
+		if quantity <= 0 {
+			quantity = 1
+		}
+		lineTotal := line.LineExtensionAmount.Value
+		for _, taxTotal := range line.TaxTotals {
+			lineTotal += taxTotal.TaxAmount.Value
+		}
+
+		analysis.Items = append(analysis.Items, receipt.Item{
+			LineIndex:           -1,
+			RawLine:             name,
+			OriginalProductName: name,
+			Quantity:            quantity,
+			UnitPrice:           roundCents(lineTotal / quantity),
+			LineTotal:           roundCents(lineTotal),
+			SKU:                 sku,
+		})
+		analysis.Totals.ComputedItemsTotal += lineTotal
+	}
+	analysis.Totals.ComputedItemsTotal = roundCents(analysis.Totals.ComputedItemsTotal)
+	analysis.Totals.ReceiptTotal = invoice.MonetaryTotal.PayableAmount.Value
+
+	difference := analysis.Totals.ReceiptTotal - analysis.Totals.ComputedItemsTotal
+	if math.Abs(difference) > 1 {
+		analysis.Totals.TotalCheckMessage = fmt.Sprintf(
+			"Sum of items is %.2f %s but invoice total is %.2f %s (difference: %.2f %s; allowances %.2f, charges %.2f).",
+			analysis.Totals.ComputedItemsTotal, analysis.Currency, analysis.Totals.ReceiptTotal, analysis.Currency,
+			difference, analysis.Currency,
+			invoice.MonetaryTotal.AllowanceTotalAmount.Value, invoice.MonetaryTotal.ChargeTotalAmount.Value,
+		)
+	}
+
+	details := &receipt.Invoice{
+		Number:   strings.TrimSpace(invoice.ID),
+		CUFE:     strings.TrimSpace(invoice.UUID),
+		Subtotal: invoice.MonetaryTotal.LineExtensionAmount.Value,
+		Taxes:    []receipt.Tax{},
+	}
+	for _, taxTotal := range invoice.TaxTotals {
+		details.TaxTotal += taxTotal.TaxAmount.Value
+		for _, subtotal := range taxTotal.TaxSubtotals {
+			scheme := strings.TrimSpace(subtotal.SchemeName)
+			if scheme == "" {
+				scheme = strings.TrimSpace(subtotal.SchemeID)
+			}
+			details.Taxes = append(details.Taxes, receipt.Tax{
+				Scheme:        scheme,
+				Percent:       subtotal.Percent,
+				TaxableAmount: subtotal.TaxableAmount.Value,
+				Amount:        subtotal.TaxAmount.Value,
+			})
+		}
+	}
+	details.TaxTotal = roundCents(details.TaxTotal)
+	analysis.Invoice = details
+
+	return analysis
+}
+
+func merchantName(party ublParty) string {
+	for _, taxScheme := range party.TaxSchemes {
+		if name := strings.TrimSpace(taxScheme.RegistrationName); name != "" {
+			return name
+		}
+	}
+	for _, legalEntity := range party.LegalEntities {
+		if name := strings.TrimSpace(legalEntity.RegistrationName); name != "" {
+			return name
+		}
+	}
+	for _, name := range party.Names {
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+/*
+merchantTaxID returns the NIT as "900123456-7", the check digit taken from
+the schemeID attribute where DIAN puts it.
+*/
+func merchantTaxID(party ublParty) string {
+	var identifiers []ublIdentifier
+	for _, taxScheme := range party.TaxSchemes {
+		identifiers = append(identifiers, taxScheme.CompanyID)
+	}
+	for _, legalEntity := range party.LegalEntities {
+		identifiers = append(identifiers, legalEntity.CompanyID)
+	}
+
+	for _, identifier := range identifiers {
+		nit := strings.TrimSpace(identifier.Value)
+		if nit == "" {
+			continue
+		}
+		checkDigit := strings.TrimSpace(identifier.SchemeID)
+		if len(checkDigit) == 1 && checkDigit[0] >= '0' && checkDigit[0] <= '9' && !strings.Contains(nit, "-") {
+			return nit + "-" + checkDigit
+		}
+		return nit
+	}
+	return ""
+}
+
+func storeAddress(party ublParty) string {
+	addresses := []ublAddress{party.PhysicalAddress}
+	for _, taxScheme := range party.TaxSchemes {
+		addresses = append(addresses, taxScheme.RegistrationAddress)
+	}
+
+	for _, address := range addresses {
+		var parts []string
+		for _, line := range address.Lines {
+			if line = strings.TrimSpace(line); line != "" {
+				parts = append(parts, line)
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		if city := strings.TrimSpace(address.CityName); city != "" {
+			parts = append(parts, city)
+		}
+		return strings.Join(parts, ", ")
+	}
+	return ""
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
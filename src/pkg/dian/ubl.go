@@ -0,0 +1,104 @@
+package dian
+
+import (
+	"encoding/xml"
+)
+
+/*
+UBL 2.1 elements read from DIAN electronic invoices. Tags have no namespace,
+so cbc:/cac: prefixes (and whatever URIs they are bound to) don't matter.
+*/
+
+// ublAttachedDocument is the container DIAN sends to the buyer: the signed
+// invoice XML as text inside Attachment/ExternalReference/Description.
+type ublAttachedDocument struct {
+	XMLName     xml.Name `xml:"AttachedDocument"`
+	Description string   `xml:"Attachment>ExternalReference>Description"`
+}
+
+type ublInvoice struct {
+	XMLName              xml.Name         `xml:"Invoice"`
+	ID                   string           `xml:"ID"`
+	UUID                 string           `xml:"UUID"` // CUFE
+	IssueDate            string           `xml:"IssueDate"`
+	IssueTime            string           `xml:"IssueTime"`
+	DocumentCurrencyCode string           `xml:"DocumentCurrencyCode"`
+	Supplier             ublParty         `xml:"AccountingSupplierParty>Party"`
+	TaxTotals            []ublTaxTotal    `xml:"TaxTotal"`
+	MonetaryTotal        ublMonetaryTotal `xml:"LegalMonetaryTotal"`
+	Lines                []ublInvoiceLine `xml:"InvoiceLine"`
+}
+
+type ublParty struct {
+	Names           []string            `xml:"PartyName>Name"`
+	TaxSchemes      []ublPartyTaxScheme `xml:"PartyTaxScheme"`
+	LegalEntities   []ublLegalEntity    `xml:"PartyLegalEntity"`
+	PhysicalAddress ublAddress          `xml:"PhysicalLocation>Address"`
+}
+
+type ublPartyTaxScheme struct {
+	RegistrationName    string        `xml:"RegistrationName"`
+	CompanyID           ublIdentifier `xml:"CompanyID"`
+	RegistrationAddress ublAddress    `xml:"RegistrationAddress"`
+}
+
+type ublLegalEntity struct {
+	RegistrationName string        `xml:"RegistrationName"`
+	CompanyID        ublIdentifier `xml:"CompanyID"`
+}
+
+// ublIdentifier is a NIT; DIAN puts its check digit (DV) in schemeID.
+type ublIdentifier struct {
+	Value    string `xml:",chardata"`
+	SchemeID string `xml:"schemeID,attr"`
+}
+
+type ublAddress struct {
+	Lines            []string `xml:"AddressLine>Line"`
+	CityName         string   `xml:"CityName"`
+	CountrySubentity string   `xml:"CountrySubentity"`
+}
+
+type ublAmount struct {
+	Value      float64 `xml:",chardata"`
+	CurrencyID string  `xml:"currencyID,attr"`
+}
+
+type ublQuantity struct {
+	Value    float64 `xml:",chardata"`
+	UnitCode string  `xml:"unitCode,attr"`
+}
+
+type ublTaxTotal struct {
+	TaxAmount    ublAmount        `xml:"TaxAmount"`
+	TaxSubtotals []ublTaxSubtotal `xml:"TaxSubtotal"`
+}
+
+type ublTaxSubtotal struct {
+	TaxableAmount ublAmount `xml:"TaxableAmount"`
+	TaxAmount     ublAmount `xml:"TaxAmount"`
+	Percent       float64   `xml:"TaxCategory>Percent"`
+	SchemeID      string    `xml:"TaxCategory>TaxScheme>ID"`
+	SchemeName    string    `xml:"TaxCategory>TaxScheme>Name"`
+}
+
+type ublMonetaryTotal struct {
+	LineExtensionAmount  ublAmount `xml:"LineExtensionAmount"`
+	TaxExclusiveAmount   ublAmount `xml:"TaxExclusiveAmount"`
+	TaxInclusiveAmount   ublAmount `xml:"TaxInclusiveAmount"`
+	AllowanceTotalAmount ublAmount `xml:"AllowanceTotalAmount"`
+	ChargeTotalAmount    ublAmount `xml:"ChargeTotalAmount"`
+	PayableAmount        ublAmount `xml:"PayableAmount"`
+}
+
+type ublInvoiceLine struct {
+	ID                  string        `xml:"ID"`
+	InvoicedQuantity    ublQuantity   `xml:"InvoicedQuantity"`
+	LineExtensionAmount ublAmount     `xml:"LineExtensionAmount"`
+	TaxTotals           []ublTaxTotal `xml:"TaxTotal"`
+	Descriptions        []string      `xml:"Item>Description"`
+	SellersItemID       string        `xml:"Item>SellersItemIdentification>ID"`
+	StandardItemID      string        `xml:"Item>StandardItemIdentification>ID"`
+	PriceAmount         ublAmount     `xml:"Price>PriceAmount"`
+	BaseQuantity        ublQuantity   `xml:"Price>BaseQuantity"`
+}
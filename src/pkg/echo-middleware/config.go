@@ -5,10 +5,12 @@ import (
 
 	tl "github.com/tuumbleweed/tintlog/logger"
 	"github.com/tuumbleweed/tintlog/palette"
-
-	"expense-tracker/src/pkg/config"
 )
 
+/*
+Config is the HTTP server configuration, set under the "server" key in
+cfg/config.json. MiddlewareRateLimit is requests per second per client IP.
+*/
 type Config struct {
 	Address             string `json:"address,omitempty"`
 	Port                int    `json:"port,omitempty"`
@@ -49,10 +51,10 @@ func InitializeConfig(localConfig *Config) {
 		tl.Log(
 			tl.Info, palette.Purple,
 			"%s field is %s in %s configuration. Using default value: %v",
-			field, "missing", config.GetPackageName(), tl.PrettyForStderr(defVal),
+			field, "missing", "echo-middleware", tl.PrettyForStderr(defVal),
 		)
 	})
 
 	tl.Log(tl.Info, palette.Green, "%s config was %s, using %s", "echo-middleware", "provided", "local echo-middleware config")
-	tl.LogJSON(tl.Verbose, palette.CyanDim, fmt.Sprintf("%s configuration", config.GetPackageName()), Cfg)
+	tl.LogJSON(tl.Verbose, palette.CyanDim, fmt.Sprintf("%s configuration", "echo-middleware"), Cfg)
 }
@@ -8,8 +8,6 @@ import (
 	tl "github.com/tuumbleweed/tintlog/logger"
 	"github.com/tuumbleweed/tintlog/palette"
 	"github.com/tuumbleweed/xerr"
-
-	"expense-tracker/src/pkg/util"
 )
 
 /*
@@ -37,6 +35,8 @@ func SendMessage(
 		return nil
 	}
 
+	waitForSendSlot()
+
 	switch provider {
 	case ProviderMailgun:
 		e = SendMessageMailgunWrapper(senderAddress, recipientAddresses, subject, plainTextContent, htmlContent, attachments)
@@ -66,7 +66,6 @@ func SendMessage(
 		return e
 	}
 
-	util.WaitForSeconds(3)
 	return e
 }
 
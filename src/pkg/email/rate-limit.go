@@ -0,0 +1,30 @@
+package email
+
+import (
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"golang.org/x/time/rate"
+)
+
+// MinSendInterval is the minimum time between two messages sent by this process.
+const MinSendInterval = 3 * time.Second
+
+// sendLimiter is shared by every goroutine sending email, so concurrent
+// callers are spaced out instead of each one sleeping after its own send.
+var sendLimiter = rate.NewLimiter(rate.Every(MinSendInterval), 1)
+
+/*
+waitForSendSlot blocks until the next message is allowed to go out.
+The first message is sent immediately.
+*/
+func waitForSendSlot() {
+	reservation := sendLimiter.Reserve()
+	delay := reservation.Delay()
+	if delay > 0 {
+		tl.Log(tl.Debug, palette.CyanDim, "%s for %s before sending the next email", "Waiting", delay)
+	}
+
+	time.Sleep(delay)
+}
@@ -0,0 +1,161 @@
+/*
+Content hashes of receipt images and a persistent index of images that were
+already processed, so re-running the pipeline over the same folder doesn't
+OCR, pay for, or count the same receipt twice.
+*/
+package imageindex
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/inputfile"
+)
+
+/*
+ImageHashes holds both hashes of a single image file.
+
+Fields:
+  - SHA256: hex SHA-256 of the file bytes (exact duplicates).
+  - PerceptualHash: 64-bit dHash as 16 hex chars (near duplicates, e.g. a
+    second photo of the same receipt).
+*/
+type ImageHashes struct {
+	SHA256         string `json:"sha256"`
+	PerceptualHash string `json:"perceptual_hash"`
+}
+
+/*
+ComputeHashes reads imagePath once for SHA-256 and decodes it for the
+perceptual hash.
+*/
+func ComputeHashes(imagePath string) (hashes ImageHashes, e *xerr.Error) {
+	hashes.SHA256, e = FileSHA256(imagePath)
+	if e != nil {
+		return hashes, e
+	}
+
+	hashes.PerceptualHash, e = PerceptualHash(imagePath)
+	if e != nil {
+		return hashes, e
+	}
+
+	return hashes, nil
+}
+
+/*
+FileSHA256 returns the hex SHA-256 digest of the file contents.
+*/
+func FileSHA256(filePath string) (digest string, e *xerr.Error) {
+	file, openErr := os.Open(filePath)
+	if openErr != nil {
+		e = xerr.NewError(openErr, "open file for hashing", filePath)
+		return "", e
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	hasher := sha256.New()
+	_, copyErr := io.Copy(hasher, file)
+	if copyErr != nil {
+		e = xerr.NewError(copyErr, "read file for hashing", filePath)
+		return "", e
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+/*
+GroupHashes identifies a receipt photographed in several parts: SHA256 is
+the SHA-256 of the parts' SHA-256s in order, PerceptualHash that of the
+first part (so the same top photo taken again is still a near duplicate).
+*/
+func GroupHashes(parts []ImageHashes) (hashes ImageHashes) {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+
+	hasher := sha256.New()
+	for _, part := range parts {
+		hasher.Write([]byte(part.SHA256))
+		hasher.Write([]byte{0})
+	}
+	hashes.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	if len(parts) > 0 {
+		hashes.PerceptualHash = parts[0].PerceptualHash
+	}
+	return hashes
+}
+
+/*
+PerceptualHash computes a difference hash (dHash) of the image:
+
+  - auto-orient (EXIF; first page for PDFs), convert to grayscale and shrink to 9x8 pixels;
+  - for each row, set a bit when a pixel is brighter than its right neighbour.
+
+Photos of the same receipt taken seconds apart end up a few bits apart,
+while different receipts differ in roughly half of the 64 bits.
+*/
+func PerceptualHash(imagePath string) (hash string, e *xerr.Error) {
+	sourceImage, e := inputfile.OpenImage(imagePath)
+	if e != nil {
+		return "", e
+	}
+
+	small := imaging.Grayscale(imaging.Resize(sourceImage, 9, 8, imaging.Lanczos))
+
+	var value uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := small.NRGBAAt(x, y).R
+			right := small.NRGBAAt(x+1, y).R
+			value <<= 1
+			if left > right {
+				value |= 1
+			}
+		}
+	}
+
+	return fmt.Sprintf("%016x", value), nil
+}
+
+/*
+IsPhoto reports whether every file of imagePath (one path, or the parts
+of a long receipt joined by ",") is a photo. Only photos are matched by
+perceptual hash: PDFs are rendered from a few templates, so the first pages
+of different receipts look alike to a 9x8 dHash.
+*/
+func IsPhoto(imagePath string) bool {
+	for _, partPath := range strings.Split(imagePath, ",") {
+		kind, supported := inputfile.KindOf(filepath.Ext(partPath))
+		if !supported || kind == inputfile.KindPDF {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+HammingDistance returns the number of differing bits between two hex
+perceptual hashes, or -1 if either is not a valid 64-bit hex value.
+*/
+func HammingDistance(firstHash, secondHash string) int {
+	first, firstErr := strconv.ParseUint(firstHash, 16, 64)
+	second, secondErr := strconv.ParseUint(secondHash, 16, 64)
+	if firstErr != nil || secondErr != nil {
+		return -1
+	}
+
+	return bits.OnesCount64(first ^ second)
+}
@@ -0,0 +1,174 @@
+package imageindex
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+)
+
+// FileName is the name of the index file kept in the root output directory.
+const FileName = "image-index.json"
+
+// DefaultMaxDistance is the largest dHash Hamming distance still treated as
+// "the same receipt photographed again". It is kept tight because receipts
+// of one store photographed on the same table are only a few bits further apart.
+const DefaultMaxDistance = 3
+
+/*
+Entry records one processed original image.
+
+Fields:
+  - Hashes: SHA-256 and perceptual hash of the original image.
+  - SourcePath: path the image was read from.
+  - RunDir: run directory holding the OCR/LLM artifacts for it.
+  - ProcessedAt: Unix milliseconds when the entry was added.
+*/
+type Entry struct {
+	Hashes      ImageHashes `json:"hashes"`
+	SourcePath  string      `json:"source_path"`
+	RunDir      string      `json:"run_dir"`
+	ProcessedAt int64       `json:"processed_at"`
+}
+
+/*
+Index is the on-disk list of processed images (out/image-index.json).
+Its methods are safe to call from several pipeline workers at once.
+*/
+type Index struct {
+	Path    string  `json:"-"`
+	Entries []Entry `json:"entries"`
+
+	mu sync.Mutex
+}
+
+/*
+Match describes why an image was considered already processed.
+Distance is 0 for an exact SHA-256 match.
+*/
+type Match struct {
+	Entry    Entry `json:"entry"`
+	Exact    bool  `json:"exact"`
+	Distance int   `json:"distance"`
+}
+
+/*
+Load reads the index from indexPath. A missing file yields an empty index.
+*/
+func Load(indexPath string) (index *Index, e *xerr.Error) {
+	index = &Index{Path: indexPath, Entries: make([]Entry, 0)}
+
+	fileBytes, readErr := os.ReadFile(indexPath)
+	if errors.Is(readErr, fs.ErrNotExist) {
+		tl.Log(tl.Info, palette.Cyan, "Image index '%s' %s, starting empty", indexPath, "does not exist")
+		return index, nil
+	}
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read image index", indexPath)
+		return index, e
+	}
+
+	unmarshalErr := json.Unmarshal(fileBytes, index)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "unmarshal image index", indexPath)
+		return index, e
+	}
+
+	tl.Log(tl.Info1, palette.Green, "Loaded image index '%s' with '%s' entries", indexPath, len(index.Entries))
+	return index, nil
+}
+
+/*
+Save writes the index back to its Path (creating parent directories).
+*/
+func (index *Index) Save() (e *xerr.Error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	mkdirErr := os.MkdirAll(filepath.Dir(index.Path), 0o755)
+	if mkdirErr != nil {
+		e = xerr.NewError(mkdirErr, "create image index directory", index.Path)
+		return e
+	}
+
+	jsonBytes, marshalErr := json.MarshalIndent(index, "", "  ")
+	if marshalErr != nil {
+		e = xerr.NewError(marshalErr, "marshal image index", index.Path)
+		return e
+	}
+
+	writeErr := os.WriteFile(index.Path, jsonBytes, 0o644)
+	if writeErr != nil {
+		e = xerr.NewError(writeErr, "write image index", index.Path)
+		return e
+	}
+
+	return nil
+}
+
+/*
+FindDuplicate looks for an already processed copy of the file at
+imagePath: first an exact SHA-256 match, then, when both are photos (see
+IsPhoto), the closest perceptual hash within maxDistance bits.
+*/
+func (index *Index) FindDuplicate(hashes ImageHashes, imagePath string, maxDistance int) (match Match, found bool) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	for _, entry := range index.Entries {
+		if entry.Hashes.SHA256 == hashes.SHA256 {
+			return Match{Entry: entry, Exact: true, Distance: 0}, true
+		}
+	}
+	if !IsPhoto(imagePath) {
+		return match, false
+	}
+
+	bestDistance := maxDistance + 1
+	for _, entry := range index.Entries {
+		if !IsPhoto(entry.SourcePath) {
+			continue
+		}
+		distance := HammingDistance(entry.Hashes.PerceptualHash, hashes.PerceptualHash)
+		if distance < 0 || distance >= bestDistance {
+			continue
+		}
+		bestDistance = distance
+		match = Match{Entry: entry, Exact: false, Distance: distance}
+		found = true
+	}
+
+	return match, found
+}
+
+/*
+Add records a processed image. An existing entry with the same SHA-256 is
+replaced (used when reprocessing with -force).
+*/
+func (index *Index) Add(hashes ImageHashes, sourcePath, runDir string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	entry := Entry{
+		Hashes:      hashes,
+		SourcePath:  sourcePath,
+		RunDir:      runDir,
+		ProcessedAt: time.Now().UnixMilli(),
+	}
+
+	for position, existing := range index.Entries {
+		if existing.Hashes.SHA256 == hashes.SHA256 {
+			index.Entries[position] = entry
+			return
+		}
+	}
+
+	index.Entries = append(index.Entries, entry)
+}
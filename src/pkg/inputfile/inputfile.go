@@ -0,0 +1,221 @@
+/*
+Receipt input files that aren't plain JPEG/PNG images.
+
+PDFs (e-invoices, scans), HEIC/HEIF (iPhone photos) and WebP are turned into
+a PNG or JPEG image the OCR and LLM stages can read. PDFs with a text layer
+also give their text and word positions, so OCR can be skipped.
+
+External tools (install with apt):
+  - PDF: pdftoppm and pdftotext from poppler-utils.
+  - HEIC: heif-convert from libheif-examples.
+*/
+package inputfile
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/tuumbleweed/xerr"
+	_ "golang.org/x/image/webp" // registers WebP with image.Decode (and so imaging.Open)
+)
+
+// Kind is how an input file is read.
+type Kind string
+
+const (
+	KindImage Kind = "image" // JPEG or PNG, used as is
+	KindWebP  Kind = "webp"
+	KindHEIC  Kind = "heic"
+	KindPDF   Kind = "pdf"
+)
+
+/*
+KindOf returns the kind of an input file by extension (with the dot).
+supported is false for anything else.
+*/
+func KindOf(ext string) (kind Kind, supported bool) {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg", ".png":
+		return KindImage, true
+	case ".webp":
+		return KindWebP, true
+	case ".heic", ".heif":
+		return KindHEIC, true
+	case ".pdf":
+		return KindPDF, true
+	default:
+		return "", false
+	}
+}
+
+// SupportedExtensions lists the accepted extensions, for messages.
+const SupportedExtensions = ".jpg/.jpeg/.png/.webp/.heic/.heif/.pdf"
+
+/*
+Converted is an input file turned into the working format.
+
+Fields:
+  - ImagePath: the PNG/JPEG image to OCR and send to the LLM (for PDFs the
+    rendered pages stacked top to bottom).
+  - Pages: rendered PDF pages (0 for images).
+  - TextLayer: text embedded in the PDF, one line per text line and a blank
+    line between blocks; "" for images and scanned PDFs.
+  - Words: the words of TextLayer with their position in ImagePath pixels.
+*/
+type Converted struct {
+	ImagePath string
+	Pages     int
+	TextLayer string
+	Words     []Word
+}
+
+/*
+Word is one word of a PDF text layer.
+
+Fields:
+  - Text: the word.
+  - Box: position in the rendered image.
+  - Block, Line: 1-based block number and line number within the block.
+*/
+type Word struct {
+	Text  string
+	Box   image.Rectangle
+	Block int
+	Line  int
+}
+
+/*
+Convert turns sourcePath into the working format inside dirPath, named
+baseName plus .png (.jpg for HEIC). Plain JPEG/PNG images are not touched:
+ImagePath is sourcePath.
+*/
+func Convert(sourcePath, dirPath, baseName string) (converted Converted, e *xerr.Error) {
+	kind, supported := KindOf(filepath.Ext(sourcePath))
+	if !supported {
+		err := fmt.Errorf("unsupported input extension '%s' (want %s)", filepath.Ext(sourcePath), SupportedExtensions)
+		e = xerr.NewError(err, "convert input file", sourcePath)
+		return converted, e
+	}
+
+	switch kind {
+	case KindWebP:
+		converted.ImagePath = filepath.Join(dirPath, baseName+".png")
+		e = convertWebP(sourcePath, converted.ImagePath)
+	case KindHEIC:
+		converted.ImagePath = filepath.Join(dirPath, baseName+".jpg")
+		e = runTool("heif-convert", "libheif-examples", "-q", "95", sourcePath, converted.ImagePath)
+	case KindPDF:
+		converted, e = convertPDF(sourcePath, dirPath, baseName)
+	default:
+		converted.ImagePath = sourcePath
+	}
+	if e != nil {
+		return converted, e
+	}
+
+	return converted, nil
+}
+
+/*
+OpenImage decodes any supported input file (the first page of a PDF),
+auto-oriented like imaging.Open. Used where only the picture matters, e.g.
+perceptual hashes.
+*/
+func OpenImage(sourcePath string) (sourceImage image.Image, e *xerr.Error) {
+	kind, _ := KindOf(filepath.Ext(sourcePath))
+	if kind != KindHEIC && kind != KindPDF {
+		var openErr error
+		sourceImage, openErr = imaging.Open(sourcePath, imaging.AutoOrientation(true))
+		if openErr != nil {
+			e = xerr.NewError(openErr, "open image", sourcePath)
+			return nil, e
+		}
+		return sourceImage, nil
+	}
+
+	temporaryDirPath, mkdirErr := os.MkdirTemp("", "inputfile-")
+	if mkdirErr != nil {
+		e = xerr.NewError(mkdirErr, "create temporary directory for conversion", sourcePath)
+		return nil, e
+	}
+	defer func() {
+		_ = os.RemoveAll(temporaryDirPath)
+	}()
+
+	var imagePath string
+	if kind == KindHEIC {
+		imagePath = filepath.Join(temporaryDirPath, "image.jpg")
+		e = runTool("heif-convert", "libheif-examples", "-q", "95", sourcePath, imagePath)
+	} else {
+		var pagePaths []string
+		pagePaths, e = renderPDFPages(sourcePath, temporaryDirPath, 1)
+		if e == nil {
+			imagePath = pagePaths[0]
+		}
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	sourceImage, openErr := imaging.Open(imagePath, imaging.AutoOrientation(true))
+	if openErr != nil {
+		e = xerr.NewError(openErr, "open converted image", sourcePath)
+		return nil, e
+	}
+	return sourceImage, nil
+}
+
+/*
+convertWebP decodes a WebP image and saves it as PNG.
+*/
+func convertWebP(sourcePath, destinationPath string) (e *xerr.Error) {
+	sourceImage, openErr := imaging.Open(sourcePath)
+	if openErr != nil {
+		e = xerr.NewError(openErr, "decode WebP image", sourcePath)
+		return e
+	}
+
+	destinationFile, createErr := os.Create(destinationPath)
+	if createErr != nil {
+		e = xerr.NewError(createErr, "create converted image", destinationPath)
+		return e
+	}
+	encodeErr := png.Encode(destinationFile, sourceImage)
+	closeErr := destinationFile.Close()
+	if encodeErr == nil {
+		encodeErr = closeErr
+	}
+	if encodeErr != nil {
+		e = xerr.NewError(encodeErr, "write converted image", destinationPath)
+		return e
+	}
+
+	return nil
+}
+
+/*
+runTool runs an external converter, naming the package to install when it
+is missing.
+*/
+func runTool(tool string, packageName string, arguments ...string) (e *xerr.Error) {
+	toolPath, lookErr := exec.LookPath(tool)
+	if lookErr != nil {
+		e = xerr.NewError(lookErr, fmt.Sprintf("find '%s' (apt install %s)", tool, packageName), tool)
+		return e
+	}
+
+	output, runErr := exec.Command(toolPath, arguments...).CombinedOutput()
+	if runErr != nil {
+		err := fmt.Errorf("%w: %s", runErr, strings.TrimSpace(string(output)))
+		e = xerr.NewError(err, fmt.Sprintf("run '%s'", tool), strings.Join(arguments, " "))
+		return e
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package inputfile
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/disintegration/imaging"
+	"github.com/tuumbleweed/xerr"
+)
+
+const (
+	pdfRenderDPI = 200 // enough for OCR of receipt-sized print
+	maxPDFPages  = 5   // pages rendered and read; invoices rarely have more
+	// Letters and digits a text layer needs to be used instead of OCR
+	// (scanned PDFs often carry a few stray characters).
+	minTextLayerCharacters = 20
+)
+
+/*
+convertPDF renders the first pages of a PDF into one image and reads its
+text layer.
+*/
+func convertPDF(sourcePath, dirPath, baseName string) (converted Converted, e *xerr.Error) {
+	pagesDirPath, mkdirErr := os.MkdirTemp(dirPath, ".pdf-pages-")
+	if mkdirErr != nil {
+		e = xerr.NewError(mkdirErr, "create directory for rendered PDF pages", dirPath)
+		return converted, e
+	}
+	defer func() {
+		_ = os.RemoveAll(pagesDirPath)
+	}()
+
+	pagePaths, e := renderPDFPages(sourcePath, pagesDirPath, maxPDFPages)
+	if e != nil {
+		return converted, e
+	}
+
+	// Stack the pages top to bottom on white.
+	var pageImages []image.Image
+	var width, height int
+	for _, pagePath := range pagePaths {
+		pageImage, openErr := imaging.Open(pagePath)
+		if openErr != nil {
+			e = xerr.NewError(openErr, "open rendered PDF page", pagePath)
+			return converted, e
+		}
+		pageImages = append(pageImages, pageImage)
+		width = max(width, pageImage.Bounds().Dx())
+		height += pageImage.Bounds().Dy()
+	}
+	stackedImage := imaging.New(width, height, color.White)
+	pageTops := make([]int, len(pageImages))
+	top := 0
+	for index, pageImage := range pageImages {
+		stackedImage = imaging.Paste(stackedImage, pageImage, image.Pt(0, top))
+		pageTops[index] = top
+		top += pageImage.Bounds().Dy()
+	}
+
+	converted.ImagePath = filepath.Join(dirPath, baseName+".png")
+	converted.Pages = len(pageImages)
+	saveErr := imaging.Save(stackedImage, converted.ImagePath)
+	if saveErr != nil {
+		e = xerr.NewError(saveErr, "save rendered PDF", converted.ImagePath)
+		return converted, e
+	}
+
+	pages, e := readTextLayer(sourcePath)
+	if e != nil {
+		return converted, e
+	}
+	converted.TextLayer, converted.Words = layoutTextLayer(pages, pageImages, pageTops)
+
+	return converted, nil
+}
+
+/*
+renderPDFPages renders up to maxPages pages as PNGs into dirPath and returns
+their paths in page order.
+*/
+func renderPDFPages(sourcePath, dirPath string, maxPages int) (pagePaths []string, e *xerr.Error) {
+	e = runTool(
+		"pdftoppm", "poppler-utils",
+		"-r", strconv.Itoa(pdfRenderDPI), "-png", "-l", strconv.Itoa(maxPages),
+		sourcePath, filepath.Join(dirPath, "page"),
+	)
+	if e != nil {
+		return nil, e
+	}
+
+	// page-1.png ... or page-01.png ..., zero-padded to the page count.
+	pattern := filepath.Join(dirPath, "page-*.png")
+	pagePaths, globErr := filepath.Glob(pattern)
+	if globErr != nil {
+		e = xerr.NewError(globErr, "glob for rendered PDF pages", pattern)
+		return nil, e
+	}
+	if len(pagePaths) == 0 {
+		e = xerr.NewError(fmt.Errorf("pdftoppm rendered no pages"), "render PDF", sourcePath)
+		return nil, e
+	}
+	sort.Strings(pagePaths)
+
+	return pagePaths, nil
+}
+
+// pdfPage is one page of `pdftotext -bbox-layout` output, in PDF points.
+type pdfPage struct {
+	Width, Height float64
+	Words         []pdfWord
+}
+
+type pdfWord struct {
+	Text                   string
+	XMin, YMin, XMax, YMax float64
+	Block, Line            int
+}
+
+/*
+readTextLayer runs `pdftotext -bbox-layout` and parses its XHTML:
+<page> <flow> <block> <line> <word xMin= yMin= xMax= yMax=>text</word>.
+*/
+func readTextLayer(sourcePath string) (pages []pdfPage, e *xerr.Error) {
+	toolPath, lookErr := exec.LookPath("pdftotext")
+	if lookErr != nil {
+		e = xerr.NewError(lookErr, "find 'pdftotext' (apt install poppler-utils)", "pdftotext")
+		return nil, e
+	}
+
+	output, runErr := exec.Command(
+		toolPath, "-bbox-layout", "-l", strconv.Itoa(maxPDFPages), "-enc", "UTF-8", sourcePath, "-",
+	).Output()
+	if runErr != nil {
+		e = xerr.NewError(runErr, "run 'pdftotext'", sourcePath)
+		return nil, e
+	}
+
+	return parseBBoxLayout(output)
+}
+
+func parseBBoxLayout(output []byte) (pages []pdfPage, e *xerr.Error) {
+	decoder := xml.NewDecoder(bytes.NewReader(output))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	attribute := func(element xml.StartElement, name string) float64 {
+		for _, attr := range element.Attr {
+			if attr.Name.Local == name {
+				value, _ := strconv.ParseFloat(attr.Value, 64)
+				return value
+			}
+		}
+		return 0
+	}
+
+	var block, line int
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr == io.EOF {
+			break
+		}
+		if tokenErr != nil {
+			e = xerr.NewError(tokenErr, "parse pdftotext bbox output", nil)
+			return nil, e
+		}
+
+		element, isStart := token.(xml.StartElement)
+		if !isStart {
+			continue
+		}
+		switch element.Name.Local {
+		case "page":
+			pages = append(pages, pdfPage{Width: attribute(element, "width"), Height: attribute(element, "height")})
+			block, line = 0, 0
+		case "block":
+			block++
+			line = 0
+		case "line":
+			line++
+		case "word":
+			if len(pages) == 0 {
+				continue
+			}
+			var text strings.Builder
+			for {
+				inner, innerErr := decoder.Token()
+				if innerErr != nil {
+					break
+				}
+				if characters, isText := inner.(xml.CharData); isText {
+					text.Write(characters)
+				}
+				if end, isEnd := inner.(xml.EndElement); isEnd && end.Name.Local == "word" {
+					break
+				}
+			}
+			page := &pages[len(pages)-1]
+			page.Words = append(page.Words, pdfWord{
+				Text:  strings.TrimSpace(text.String()),
+				XMin:  attribute(element, "xMin"),
+				YMin:  attribute(element, "yMin"),
+				XMax:  attribute(element, "xMax"),
+				YMax:  attribute(element, "yMax"),
+				Block: block,
+				Line:  line,
+			})
+		}
+	}
+
+	return pages, nil
+}
+
+/*
+layoutTextLayer writes the words as text (one line per text line, a blank
+line between blocks) and maps their boxes onto the stacked page images.
+Text layers with too little text yield "" so the PDF is OCR'd instead.
+*/
+func layoutTextLayer(pages []pdfPage, pageImages []image.Image, pageTops []int) (text string, words []Word) {
+	var builder strings.Builder
+	characters := 0
+	blockOffset := 0
+	for pageIndex, page := range pages {
+		if pageIndex >= len(pageImages) || page.Width <= 0 || page.Height <= 0 {
+			break
+		}
+		scaleX := float64(pageImages[pageIndex].Bounds().Dx()) / page.Width
+		scaleY := float64(pageImages[pageIndex].Bounds().Dy()) / page.Height
+
+		lastBlock, lastLine := 0, 0
+		for index, pdfWord := range page.Words {
+			if pdfWord.Text == "" {
+				continue
+			}
+			if index > 0 {
+				switch {
+				case pdfWord.Block != lastBlock:
+					builder.WriteString("\n\n")
+				case pdfWord.Line != lastLine:
+					builder.WriteString("\n")
+				default:
+					builder.WriteString(" ")
+				}
+			} else if builder.Len() > 0 {
+				builder.WriteString("\n\n")
+			}
+			builder.WriteString(pdfWord.Text)
+			lastBlock, lastLine = pdfWord.Block, pdfWord.Line
+
+			for _, character := range pdfWord.Text {
+				if unicode.IsLetter(character) || unicode.IsDigit(character) {
+					characters++
+				}
+			}
+			words = append(words, Word{
+				Text: pdfWord.Text,
+				Box: image.Rect(
+					int(math.Floor(pdfWord.XMin*scaleX)), pageTops[pageIndex]+int(math.Floor(pdfWord.YMin*scaleY)),
+					int(math.Ceil(pdfWord.XMax*scaleX)), pageTops[pageIndex]+int(math.Ceil(pdfWord.YMax*scaleY)),
+				),
+				Block: blockOffset + pdfWord.Block,
+				Line:  pdfWord.Line,
+			})
+		}
+		blockOffset += lastBlock
+	}
+
+	if characters < minTextLayerCharacters {
+		return "", nil
+	}
+	return builder.String() + "\n", words
+}
@@ -0,0 +1,259 @@
+/*
+Persistent pipeline job queue.
+
+Jobs are rows in the ledger's jobs table, so a caller (receipt-server) can
+answer right away with a job id while workers run OCR and the LLM in the
+background. Failed attempts that look transient are retried with a delay,
+and jobs a stopped process left running are queued again on the next start.
+*/
+package jobqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/openai"
+	"expense-tracker/src/pkg/pipeline"
+)
+
+/*
+Options configure the workers.
+
+Fields:
+  - OutputDirPath: -out root; run directories go into its month directory.
+  - Language: OCR language(s).
+  - Workers: jobs processed at once.
+  - MaxAttempts: attempts per job, including the first one.
+  - RetryDelay: wait before the first retry; doubles for every further one.
+  - PollInterval: how often idle workers look for due retries.
+*/
+type Options struct {
+	OutputDirPath string
+	Language      string
+	Workers       int
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	PollInterval  time.Duration
+}
+
+/*
+DefaultOptions returns options with 2 workers, 3 attempts and a 1 minute
+first retry delay.
+*/
+func DefaultOptions(outputDirPath string, language string) Options {
+	return Options{
+		OutputDirPath: outputDirPath,
+		Language:      language,
+		Workers:       2,
+		MaxAttempts:   3,
+		RetryDelay:    time.Minute,
+		PollInterval:  5 * time.Second,
+	}
+}
+
+/*
+Queue hands ledger jobs to workers.
+*/
+type Queue struct {
+	Ledger  *ledger.Ledger
+	Index   *imageindex.Index
+	Options Options
+
+	wake chan struct{}
+}
+
+/*
+New returns a queue; call Run to start processing.
+*/
+func New(receiptLedger *ledger.Ledger, index *imageindex.Index, options Options) *Queue {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = 5 * time.Second
+	}
+	return &Queue{
+		Ledger:  receiptLedger,
+		Index:   index,
+		Options: options,
+		wake:    make(chan struct{}, options.Workers),
+	}
+}
+
+/*
+Enqueue adds a job for an image whose hashes are already computed and wakes
+an idle worker.
+*/
+func (queue *Queue) Enqueue(imagePath string, hashes imageindex.ImageHashes) (job ledger.Job, e *xerr.Error) {
+	job, e = queue.Ledger.CreateJob(ledger.Job{
+		ImagePath:            imagePath,
+		SourceSHA256:         hashes.SHA256,
+		SourcePerceptualHash: hashes.PerceptualHash,
+		MaxAttempts:          queue.Options.MaxAttempts,
+	})
+	if e != nil {
+		return job, e
+	}
+
+	tl.Log(tl.Info1, palette.Cyan, "Queued job '%s' for '%s'", job.ID, imagePath)
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+/*
+Run requeues jobs interrupted by a previous stop, then processes jobs until
+ctx is cancelled. It returns once the jobs that were running at that point
+have finished.
+*/
+func (queue *Queue) Run(ctx context.Context) (e *xerr.Error) {
+	requeued, failed, e := queue.Ledger.RequeueInterruptedJobs()
+	if e != nil {
+		return e
+	}
+	if requeued > 0 || failed > 0 {
+		tl.Log(
+			tl.Notice, palette.Blue, "Resuming '%s' interrupted jobs ('%s' had no attempts left and failed)",
+			requeued, failed,
+		)
+	}
+
+	var waitGroup sync.WaitGroup
+	for worker := 1; worker <= queue.Options.Workers; worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			queue.work(ctx)
+		}()
+	}
+	waitGroup.Wait()
+
+	return nil
+}
+
+func (queue *Queue) work(ctx context.Context) {
+	ticker := time.NewTicker(queue.Options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, found, e := queue.Ledger.ClaimNextJob()
+		if e != nil {
+			tl.Log(tl.Error, palette.RedBold, "Failed claiming a job: %s: '%s'", e.Msg, e.ErrStr)
+		}
+		if found {
+			queue.process(job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-queue.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+process runs one attempt of a claimed job and records the outcome.
+*/
+func (queue *Queue) process(job ledger.Job) {
+	tl.Log(
+		tl.Notice, palette.BlueBold, "Processing job '%s' ('%s', attempt '%s' of '%s')",
+		job.ID, job.ImagePath, job.Attempts, job.MaxAttempts,
+	)
+	hashes := imageindex.ImageHashes{SHA256: job.SourceSHA256, PerceptualHash: job.SourcePerceptualHash}
+
+	// A retry, or an attempt interrupted by a stop, continues in the run
+	// directory of the previous attempt (recorded as soon as it is created),
+	// so stages that already succeeded (OCR, a saved analysis, the stored
+	// receipt) aren't redone.
+	result, failedStage, e := pipeline.ProcessImage(job.ImagePath, hashes, pipeline.Options{
+		OutputDirPath: pipeline.MonthOutputDir(queue.Options.OutputDirPath, time.Now()),
+		Language:      queue.Options.Language,
+		Ledger:        queue.Ledger,
+		ResumeRunDir:  job.RunDir,
+		OnStage: func(stage pipeline.Stage) {
+			if stage == pipeline.StageLLM {
+				queue.setState(job.ID, ledger.JobLLM)
+			}
+		},
+		OnRunDir: func(runDirPath string) {
+			recordErr := queue.Ledger.SetJobRunDir(job.ID, runDirPath)
+			if recordErr != nil {
+				tl.Log(tl.Error, palette.RedBold, "Failed recording job '%s' run dir: %s: '%s'", job.ID, recordErr.Msg, recordErr.ErrStr)
+			}
+		},
+	})
+
+	if pipeline.ShouldIndex(failedStage) {
+		pipeline.AddToIndex(queue.Index, hashes, job.ImagePath, result.RunDir)
+	}
+
+	if e == nil {
+		recordErr := queue.Ledger.FinishJob(job.ID, result.RunDir, result.ReceiptID)
+		if recordErr != nil {
+			tl.Log(tl.Error, palette.RedBold, "Failed recording job '%s' as done: %s: '%s'", job.ID, recordErr.Msg, recordErr.ErrStr)
+		}
+		if len(result.Receipts) > 0 {
+			tl.Log(tl.Notice, palette.GreenBold, "Job '%s' done, '%s' receipts in '%s'", job.ID, len(result.Receipts), result.RunDir)
+			return
+		}
+		tl.Log(tl.Notice, palette.GreenBold, "Job '%s' done, receipt '%s'", job.ID, result.ReceiptID)
+		return
+	}
+
+	errorText := fmt.Sprintf("%s: %s", e.Msg, e.ErrStr)
+	var recordErr *xerr.Error
+	if isRetryable(failedStage, e) && job.Attempts < job.MaxAttempts {
+		delay := queue.Options.RetryDelay << (job.Attempts - 1)
+		tl.Log(
+			tl.Warning, palette.PurpleBold, "Job '%s' failed at stage '%s' (%s), retrying in '%s'",
+			job.ID, string(failedStage), errorText, delay,
+		)
+		recordErr = queue.Ledger.RetryJob(job.ID, result.RunDir, string(failedStage), errorText, time.Now().Add(delay).UnixMilli())
+	} else {
+		tl.Log(tl.Warning, palette.PurpleBold, "Job '%s' failed at stage '%s': %s", job.ID, string(failedStage), errorText)
+		recordErr = queue.Ledger.FailJob(job.ID, result.RunDir, string(failedStage), errorText)
+	}
+	if recordErr != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed recording job '%s' failure: %s: '%s'", job.ID, recordErr.Msg, recordErr.ErrStr)
+	}
+}
+
+func (queue *Queue) setState(jobID int64, state ledger.JobState) {
+	e := queue.Ledger.SetJobState(jobID, state)
+	if e != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed updating job '%s' state: %s: '%s'", jobID, e.Msg, e.ErrStr)
+	}
+}
+
+/*
+isRetryable reports whether another attempt may succeed: transient LLM API
+errors (see openai.IsRetryable) and local write failures. OCR and validation
+failures would fail the same way again.
+*/
+func isRetryable(failedStage pipeline.Stage, e *xerr.Error) bool {
+	switch failedStage {
+	case pipeline.StageLLM:
+		return openai.IsRetryable(e)
+	case pipeline.StageSave, pipeline.StageLedger:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package ledger
+
+import (
+	"fmt"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+)
+
+/*
+Config points at the SQLite ledger database.
+
+Set it in cfg/config.json under the "ledger" key:
+
+	"ledger": {
+	  "database_path": "./out/ledger.db"
+	}
+*/
+type Config struct {
+	DatabasePath string `json:"database_path,omitempty"` // SQLite file, created on first use
+}
+
+func DefaultValueConfig() Config {
+	return Config{
+		DatabasePath: "./out/ledger.db",
+	}
+}
+
+// create config with default values before config gets initialized
+var Cfg Config = DefaultValueConfig() // this one we use to access config values from anywhere
+
+/*
+If local Config is provided - use it. Replace all missing values with default ones.
+
+If not provided - just use defaultConfig.
+*/
+func InitializeConfig(localConfig *Config) {
+	// If not provided - just use defaultConfig
+	if localConfig == nil {
+		tl.Log(tl.Info, palette.Purple, "%s config is %s, keeping %s", "ledger", "not provided", "default ledger config")
+		return
+	}
+
+	defaultConfig := DefaultValueConfig() // Default values to replace some values with during config initialization
+
+	// If local Config is provided - use it
+	Cfg = *localConfig
+
+	tl.ApplyDefaults(&Cfg, defaultConfig, func(field string, defVal any) {
+		tl.Log(
+			tl.Info, palette.Purple,
+			"%s field is %s in %s configuration. Using default value: %v",
+			field, "missing", "ledger", tl.PrettyForStderr(defVal),
+		)
+	})
+
+	tl.Log(tl.Info, palette.Green, "%s config was %s, using %s", "ledger", "provided", "local ledger config")
+	tl.LogJSON(tl.Verbose, palette.CyanDim, fmt.Sprintf("%s configuration", "ledger"), Cfg)
+}
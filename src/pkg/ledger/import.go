@@ -0,0 +1,96 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+ImportStats counts the outcome of ImportTree.
+*/
+type ImportStats struct {
+	Found    int `json:"found"`
+	Imported int `json:"imported"`
+	Failed   int `json:"failed"`
+}
+
+/*
+ImportTree walks rootDir recursively and stores every receipt-analysis.json
+it finds, keyed by its run directory, and learns the products of confirmed
+ones. Already imported runs are replaced, so it is safe to run repeatedly. Unreadable files are logged and counted, not
+fatal.
+*/
+func (ledger *Ledger) ImportTree(rootDir string) (stats ImportStats, e *xerr.Error) {
+	analysisPaths, e := CollectAnalysisFiles(rootDir)
+	if e != nil {
+		return stats, e
+	}
+	stats.Found = len(analysisPaths)
+
+	for _, analysisPath := range analysisPaths {
+		analysis, loadErr := receipt.LoadAnalysis(analysisPath)
+		if loadErr != nil {
+			stats.Failed++
+			tl.Log(tl.Warning, palette.PurpleBright, "Skipping unreadable JSON '%s': %s", analysisPath, loadErr.ErrStr)
+			continue
+		}
+
+		_, saveErr := ledger.SaveReceipt(filepath.Dir(analysisPath), analysis)
+		if saveErr != nil {
+			stats.Failed++
+			tl.Log(tl.Warning, palette.PurpleBright, "Failed importing '%s': %s", analysisPath, saveErr.ErrStr)
+			continue
+		}
+
+		// Rebuilding a ledger learns the confirmed receipts again.
+		corrections, _, correctionsErr := receipt.LoadCorrections(filepath.Dir(analysisPath))
+		if correctionsErr != nil {
+			tl.Log(tl.Warning, palette.PurpleBright, "Importing '%s' without corrections: %s", analysisPath, correctionsErr.ErrStr)
+		}
+		if confirmed := corrections.Apply(analysis); confirmed.ConfirmedAt != "" {
+			learnErr := ledger.LearnProducts(confirmed)
+			if learnErr != nil {
+				tl.Log(tl.Warning, palette.PurpleBright, "Failed learning the products of '%s': %s", analysisPath, learnErr.ErrStr)
+			}
+		}
+
+		stats.Imported++
+		tl.Log(tl.Info1, palette.Green, "Imported '%s'", analysisPath)
+	}
+
+	return stats, nil
+}
+
+/*
+CollectAnalysisFiles recursively walks rootDir and returns all
+receipt-analysis.json file paths.
+*/
+func CollectAnalysisFiles(rootDir string) (paths []string, e *xerr.Error) {
+	paths = make([]string, 0)
+
+	walkErr := filepath.WalkDir(rootDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(strings.ToLower(entry.Name()), receipt.AnalysisFileName) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		e = xerr.NewErrorEC(walkErr, "walk out directory", "rootDir", rootDir, false)
+		return paths, e
+	}
+
+	return paths, nil
+}
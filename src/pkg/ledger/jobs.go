@@ -0,0 +1,320 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tuumbleweed/xerr"
+)
+
+// JobState is where a pipeline job is.
+type JobState string
+
+const (
+	JobQueued JobState = "queued" // waiting for a worker (new, retry, or resumed after a restart)
+	JobOCR    JobState = "ocr"    // claimed by a worker, running OCR
+	JobLLM    JobState = "llm"    // waiting for the LLM analysis
+	JobDone   JobState = "done"   // receipt stored, ReceiptID is set
+	JobFailed JobState = "failed" // gave up, see FailedStage and Error
+)
+
+/*
+Job is one image going through the pipeline, kept in the ledger so it
+survives restarts.
+
+Fields:
+  - ID: job id.
+  - State: see JobState.
+  - ImagePath: image to process.
+  - SourceSHA256, SourcePerceptualHash: image hashes computed when the job
+    was created.
+  - Attempts: how many times a worker picked the job up.
+  - MaxAttempts: attempts allowed before the job fails for good.
+  - NextAttemptAt: Unix milliseconds before which a queued job isn't picked
+    up (retry backoff).
+  - RunDir: run directory of the last attempt, once OCR created it.
+  - ReceiptID: ledger receipt id once done (0 if the receipt was since
+    replaced or removed, or if the photo held several receipts: their run
+    directories are inside RunDir).
+  - FailedStage, Error: why the last attempt failed (kept while a retry is
+    queued).
+  - CreatedAt, UpdatedAt: Unix milliseconds.
+*/
+type Job struct {
+	ID                   int64    `json:"id"`
+	State                JobState `json:"state"`
+	ImagePath            string   `json:"image_path"`
+	SourceSHA256         string   `json:"source_sha256,omitempty"`
+	SourcePerceptualHash string   `json:"source_perceptual_hash,omitempty"`
+	Attempts             int      `json:"attempts"`
+	MaxAttempts          int      `json:"max_attempts"`
+	NextAttemptAt        int64    `json:"next_attempt_at,omitempty"`
+	RunDir               string   `json:"run_dir,omitempty"`
+	ReceiptID            int64    `json:"receipt_id,omitempty"`
+	FailedStage          string   `json:"failed_stage,omitempty"`
+	Error                string   `json:"error,omitempty"`
+	CreatedAt            int64    `json:"created_at"`
+	UpdatedAt            int64    `json:"updated_at"`
+}
+
+// IsActive reports whether the job is still queued or running.
+func (job Job) IsActive() bool {
+	return job.State != JobDone && job.State != JobFailed
+}
+
+// jobColumns are the jobs columns in scanJob order.
+const jobColumns = `id, state, image_path, source_sha256, source_perceptual_hash, attempts, max_attempts,
+	next_attempt_at, run_dir, receipt_id, failed_stage, error, created_at, updated_at`
+
+/*
+CreateJob queues a job for imagePath. Only ImagePath, the source hashes and
+MaxAttempts (at least 1) are taken from job; the stored job is returned.
+*/
+func (ledger *Ledger) CreateJob(job Job) (created Job, e *xerr.Error) {
+	if job.MaxAttempts < 1 {
+		job.MaxAttempts = 1
+	}
+	now := time.Now().UnixMilli()
+
+	row := ledger.db.QueryRow(`
+		INSERT INTO jobs (state, image_path, source_sha256, source_perceptual_hash, max_attempts, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING `+jobColumns,
+		JobQueued, job.ImagePath, job.SourceSHA256, job.SourcePerceptualHash, job.MaxAttempts, now, now,
+	)
+	created, scanErr := scanJob(row)
+	if scanErr != nil {
+		e = xerr.NewError(scanErr, "insert ledger job", job.ImagePath)
+		return created, e
+	}
+
+	return created, nil
+}
+
+/*
+GetJob returns a job by id. found is false if there is no job with that id.
+*/
+func (ledger *Ledger) GetJob(jobID int64) (job Job, found bool, e *xerr.Error) {
+	row := ledger.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, jobID)
+	job, scanErr := scanJob(row)
+	if errors.Is(scanErr, sql.ErrNoRows) {
+		return job, false, nil
+	}
+	if scanErr != nil {
+		e = xerr.NewErrorECOL(scanErr, "query ledger job", "id", jobID)
+		return job, false, e
+	}
+
+	return job, true, nil
+}
+
+/*
+ListJobs returns the newest jobs first, only those in state unless it's
+empty, at most limit (all if limit is 0).
+*/
+func (ledger *Ledger) ListJobs(state JobState, limit int) (jobs []Job, e *xerr.Error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	args := make([]any, 0)
+	if state != "" {
+		query += ` WHERE state = ?`
+		args = append(args, state)
+	}
+	query += ` ORDER BY id DESC`
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, limit)
+	}
+
+	return ledger.queryJobs(query, args...)
+}
+
+/*
+FindActiveJob returns a queued or running job for the image with this
+SHA-256, so the same upload isn't queued twice.
+*/
+func (ledger *Ledger) FindActiveJob(sourceSHA256 string) (job Job, found bool, e *xerr.Error) {
+	row := ledger.db.QueryRow(
+		`SELECT `+jobColumns+` FROM jobs WHERE source_sha256 = ? AND state NOT IN (?, ?) ORDER BY id LIMIT 1`,
+		sourceSHA256, JobDone, JobFailed,
+	)
+	job, scanErr := scanJob(row)
+	if errors.Is(scanErr, sql.ErrNoRows) {
+		return job, false, nil
+	}
+	if scanErr != nil {
+		e = xerr.NewError(scanErr, "find active ledger job", sourceSHA256)
+		return job, false, e
+	}
+
+	return job, true, nil
+}
+
+/*
+ClaimNextJob moves the oldest queued job that is due into JobOCR, counts the
+attempt and returns it. found is false when nothing is due.
+*/
+func (ledger *Ledger) ClaimNextJob() (job Job, found bool, e *xerr.Error) {
+	now := time.Now().UnixMilli()
+	row := ledger.db.QueryRow(`
+		UPDATE jobs SET state = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id = (
+			SELECT id FROM jobs WHERE state = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT 1
+		)
+		RETURNING `+jobColumns,
+		JobOCR, now, JobQueued, now,
+	)
+	job, scanErr := scanJob(row)
+	if errors.Is(scanErr, sql.ErrNoRows) {
+		return job, false, nil
+	}
+	if scanErr != nil {
+		e = xerr.NewError(scanErr, "claim next ledger job", ledger.Path)
+		return job, false, e
+	}
+
+	return job, true, nil
+}
+
+/*
+SetJobState records that a running job moved on (JobOCR -> JobLLM).
+*/
+func (ledger *Ledger) SetJobState(jobID int64, state JobState) (e *xerr.Error) {
+	_, execErr := ledger.db.Exec(`UPDATE jobs SET state = ?, updated_at = ? WHERE id = ?`, state, time.Now().UnixMilli(), jobID)
+	if execErr != nil {
+		e = xerr.NewErrorECOL(execErr, "update ledger job state", "id", jobID)
+		return e
+	}
+	return nil
+}
+
+/*
+SetJobRunDir records the run directory of a running job as soon as it
+exists, so an attempt that is interrupted is resumed in it.
+*/
+func (ledger *Ledger) SetJobRunDir(jobID int64, runDir string) (e *xerr.Error) {
+	_, execErr := ledger.db.Exec(`UPDATE jobs SET run_dir = ?, updated_at = ? WHERE id = ?`, runDir, time.Now().UnixMilli(), jobID)
+	if execErr != nil {
+		e = xerr.NewErrorECOL(execErr, "update ledger job run dir", "id", jobID)
+		return e
+	}
+	return nil
+}
+
+/*
+FinishJob marks a job done with the receipt it stored. receiptID is 0 for a
+photo split into several receipts; it is stored as NULL (receipt_id
+references receipts).
+*/
+func (ledger *Ledger) FinishJob(jobID int64, runDir string, receiptID int64) (e *xerr.Error) {
+	_, execErr := ledger.db.Exec(
+		`UPDATE jobs SET state = ?, run_dir = ?, receipt_id = ?, failed_stage = '', error = '', updated_at = ? WHERE id = ?`,
+		JobDone, runDir, sql.NullInt64{Int64: receiptID, Valid: receiptID != 0}, time.Now().UnixMilli(), jobID,
+	)
+	if execErr != nil {
+		e = xerr.NewErrorECOL(execErr, "finish ledger job", "id", jobID)
+		return e
+	}
+	return nil
+}
+
+/*
+RetryJob puts a failed attempt back in the queue, to be picked up again at
+nextAttemptAt (Unix milliseconds).
+*/
+func (ledger *Ledger) RetryJob(jobID int64, runDir string, failedStage string, errorText string, nextAttemptAt int64) (e *xerr.Error) {
+	return ledger.recordJobFailure(jobID, JobQueued, runDir, failedStage, errorText, nextAttemptAt)
+}
+
+/*
+FailJob marks a job failed for good.
+*/
+func (ledger *Ledger) FailJob(jobID int64, runDir string, failedStage string, errorText string) (e *xerr.Error) {
+	return ledger.recordJobFailure(jobID, JobFailed, runDir, failedStage, errorText, 0)
+}
+
+func (ledger *Ledger) recordJobFailure(jobID int64, state JobState, runDir string, failedStage string, errorText string, nextAttemptAt int64) (e *xerr.Error) {
+	_, execErr := ledger.db.Exec(
+		`UPDATE jobs SET state = ?, run_dir = ?, failed_stage = ?, error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?`,
+		state, runDir, failedStage, errorText, nextAttemptAt, time.Now().UnixMilli(), jobID,
+	)
+	if execErr != nil {
+		e = xerr.NewErrorECOL(execErr, "record ledger job failure", "id", jobID)
+		return e
+	}
+	return nil
+}
+
+/*
+RequeueInterruptedJobs is called on startup: jobs left in JobOCR or JobLLM
+by a process that stopped are queued again, or failed if they have no
+attempts left.
+*/
+func (ledger *Ledger) RequeueInterruptedJobs() (requeued int64, failed int64, e *xerr.Error) {
+	now := time.Now().UnixMilli()
+	const interrupted = "interrupted: the process stopped while the job was running"
+
+	result, execErr := ledger.db.Exec(
+		`UPDATE jobs SET state = ?, error = ?, next_attempt_at = 0, updated_at = ? WHERE state IN (?, ?) AND attempts < max_attempts`,
+		JobQueued, interrupted, now, JobOCR, JobLLM,
+	)
+	if execErr == nil {
+		requeued, execErr = result.RowsAffected()
+	}
+	if execErr != nil {
+		e = xerr.NewError(execErr, "requeue interrupted ledger jobs", ledger.Path)
+		return 0, 0, e
+	}
+
+	result, execErr = ledger.db.Exec(
+		`UPDATE jobs SET state = ?, error = ?, updated_at = ? WHERE state IN (?, ?)`,
+		JobFailed, interrupted, now, JobOCR, JobLLM,
+	)
+	if execErr == nil {
+		failed, execErr = result.RowsAffected()
+	}
+	if execErr != nil {
+		e = xerr.NewError(execErr, "fail interrupted ledger jobs", ledger.Path)
+		return requeued, 0, e
+	}
+
+	return requeued, failed, nil
+}
+
+func (ledger *Ledger) queryJobs(query string, args ...any) (jobs []Job, e *xerr.Error) {
+	rows, queryErr := ledger.db.Query(query, args...)
+	if queryErr != nil {
+		e = xerr.NewError(queryErr, "query ledger jobs", ledger.Path)
+		return nil, e
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	jobs = make([]Job, 0)
+	for rows.Next() {
+		job, scanErr := scanJob(rows)
+		if scanErr != nil {
+			e = xerr.NewError(scanErr, "scan ledger job", ledger.Path)
+			return nil, e
+		}
+		jobs = append(jobs, job)
+	}
+	rowsErr := rows.Err()
+	if rowsErr != nil {
+		e = xerr.NewError(rowsErr, "iterate ledger jobs", ledger.Path)
+		return nil, e
+	}
+
+	return jobs, nil
+}
+
+func scanJob(row rowScanner) (job Job, err error) {
+	var receiptID sql.NullInt64
+	err = row.Scan(
+		&job.ID, &job.State, &job.ImagePath, &job.SourceSHA256, &job.SourcePerceptualHash, &job.Attempts, &job.MaxAttempts,
+		&job.NextAttemptAt, &job.RunDir, &receiptID, &job.FailedStage, &job.Error, &job.CreatedAt, &job.UpdatedAt,
+	)
+	job.ReceiptID = receiptID.Int64
+	return job, err
+}
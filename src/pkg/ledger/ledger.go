@@ -0,0 +1,77 @@
+/*
+Local SQLite expense ledger.
+
+receipt-pipeline writes every successfully analyzed receipt here (receipts,
+their items, merchants, categories and the LLM run that produced them), and
+report and other tools query it instead of walking ./out and re-parsing
+receipt-analysis.json files. The JSON files stay the source of truth for a
+single run; ledger-import rebuilds the ledger from them.
+*/
+package ledger
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+)
+
+/*
+Ledger is an open ledger database. It is safe for concurrent use.
+*/
+type Ledger struct {
+	Path string
+	db   *sql.DB
+}
+
+/*
+Open opens (creating if needed) the SQLite database at databasePath and
+applies pending schema migrations.
+*/
+func Open(databasePath string) (ledger *Ledger, e *xerr.Error) {
+	mkdirErr := os.MkdirAll(filepath.Dir(databasePath), 0o755)
+	if mkdirErr != nil {
+		e = xerr.NewError(mkdirErr, "create ledger directory", databasePath)
+		return nil, e
+	}
+
+	dataSourceName := "file:" + databasePath + "?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL"
+	db, openErr := sql.Open("sqlite3", dataSourceName)
+	if openErr != nil {
+		e = xerr.NewError(openErr, "open ledger database", databasePath)
+		return nil, e
+	}
+	// SQLite allows a single writer; one connection serializes pipeline
+	// workers instead of having them fail with "database is locked".
+	db.SetMaxOpenConns(1)
+
+	pingErr := db.Ping()
+	if pingErr != nil {
+		_ = db.Close()
+		e = xerr.NewError(pingErr, "connect to ledger database", databasePath)
+		return nil, e
+	}
+
+	e = migrate(db, databasePath)
+	if e != nil {
+		_ = db.Close()
+		return nil, e
+	}
+
+	tl.Log(tl.Info1, palette.Green, "Opened ledger '%s'", databasePath)
+	return &Ledger{Path: databasePath, db: db}, nil
+}
+
+/*
+Close closes the database.
+*/
+func (ledger *Ledger) Close() {
+	closeErr := ledger.db.Close()
+	if closeErr != nil {
+		tl.Log(tl.Warning, palette.PurpleBright, "Failed closing ledger '%s': %s", ledger.Path, closeErr)
+	}
+}
@@ -0,0 +1,313 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/receipt"
+)
+
+// ProductMemoryRule is receipt.Item.CategoryRule of items categorized from the product memory.
+const ProductMemoryRule = "product-memory"
+
+// skuKeyPrefix marks product keys that are a merchant's SKU rather than a product name.
+const skuKeyPrefix = "sku:"
+
+/*
+Product is an entry of the product memory: the category and English name
+of a product, learned from confirmed receipts (LearnProducts) or set by
+hand. Product names are remembered for every merchant; SKUs only
+for the merchant that printed them.
+
+Fields:
+  - MerchantKey: "" for product names, the merchant (see MerchantKey) for SKUs.
+  - ProductKey: NormalizeProductName of the name, or "sku:" + the SKU.
+  - ProductName: the name as printed, the last time it was learned.
+  - ProductNameEnglish, CategoryKey: what items of the product get.
+  - UpdatedAt: Unix milliseconds of the last time it was learned.
+*/
+type Product struct {
+	MerchantKey        string `json:"merchant_key,omitempty"`
+	ProductKey         string `json:"product_key"`
+	ProductName        string `json:"product_name"`
+	ProductNameEnglish string `json:"product_name_english"`
+	CategoryKey        string `json:"category_key"`
+	UpdatedAt          int64  `json:"updated_at"`
+}
+
+var accentReplacer = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n")
+
+/*
+NormalizeProductName returns the product memory key of a printed product
+name: lower case without accents or punctuation, and without the words
+that contain digits (sizes, weights, codes), so the same product in
+another size or with another OCR'd code still matches.
+
+Example:
+
+	NormalizeProductName("ACEITE GIRASOL PREMIER 1000ML") -> "aceite girasol premier"
+	NormalizeProductName("Café  Sello-Rojo x500g")        -> "cafe sello rojo"
+*/
+func NormalizeProductName(name string) string {
+	lowered := accentReplacer.Replace(strings.ToLower(name))
+	words := strings.FieldsFunc(lowered, func(character rune) bool {
+		return !unicode.IsLetter(character) && !unicode.IsDigit(character)
+	})
+
+	kept := make([]string, 0, len(words))
+	for _, word := range words {
+		if strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+			continue
+		}
+		kept = append(kept, word)
+	}
+	return strings.Join(kept, " ")
+}
+
+// SKUKey returns the product key of a merchant's SKU.
+func SKUKey(sku string) string {
+	return skuKeyPrefix + strings.TrimSpace(sku)
+}
+
+/*
+itemProducts returns the product memory entries an item of analysis
+teaches: one for its name and, with a merchant, one for its SKU.
+*/
+func itemProducts(analysis receipt.Analysis, item receipt.Item) (products []Product) {
+	categoryKey := strings.ToLower(strings.TrimSpace(item.CategoryKey))
+	if categoryKey == "" {
+		return nil
+	}
+	learned := Product{
+		ProductName:        strings.TrimSpace(item.OriginalProductName),
+		ProductNameEnglish: strings.TrimSpace(item.ProductNameEnglish),
+		CategoryKey:        categoryKey,
+	}
+
+	if productKey := NormalizeProductName(item.OriginalProductName); productKey != "" {
+		byName := learned
+		byName.ProductKey = productKey
+		products = append(products, byName)
+	}
+	if merchantKey := MerchantKey(analysis); merchantKey != "" && strings.TrimSpace(item.SKU) != "" {
+		bySKU := learned
+		bySKU.MerchantKey = merchantKey
+		bySKU.ProductKey = SKUKey(item.SKU)
+		products = append(products, bySKU)
+	}
+	return products
+}
+
+/*
+LearnProducts remembers the category and English name of every item of a
+confirmed receipt (corrections applied), see Product.
+*/
+func (ledger *Ledger) LearnProducts(analysis receipt.Analysis) (e *xerr.Error) {
+	tx, beginErr := ledger.db.Begin()
+	if beginErr != nil {
+		e = xerr.NewError(beginErr, "begin ledger transaction", ledger.Path)
+		return e
+	}
+	e = learnProductsTx(tx, analysis)
+	if e != nil {
+		_ = tx.Rollback()
+		return e
+	}
+	commitErr := tx.Commit()
+	if commitErr != nil {
+		e = xerr.NewError(commitErr, "commit ledger transaction", ledger.Path)
+		return e
+	}
+	return nil
+}
+
+// learnProductsTx remembers the products of a confirmed receipt.
+func learnProductsTx(tx *sql.Tx, analysis receipt.Analysis) (e *xerr.Error) {
+	for _, item := range analysis.Items {
+		for _, product := range itemProducts(analysis, item) {
+			e = saveProductTx(tx, product)
+			if e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func saveProductTx(tx *sql.Tx, product Product) (e *xerr.Error) {
+	_, execErr := tx.Exec(
+		`INSERT INTO product_memory (
+			merchant_key, product_key, product_name, product_name_english, category_key, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(merchant_key, product_key) DO UPDATE SET
+			product_name         = excluded.product_name,
+			product_name_english = excluded.product_name_english,
+			category_key         = excluded.category_key,
+			updated_at           = excluded.updated_at`,
+		product.MerchantKey, product.ProductKey, product.ProductName, product.ProductNameEnglish,
+		product.CategoryKey, time.Now().UnixMilli(),
+	)
+	if execErr != nil {
+		e = xerr.NewError(execErr, "save product memory entry", product.MerchantKey+"/"+product.ProductKey)
+		return e
+	}
+	return nil
+}
+
+/*
+SaveProduct adds or replaces a product memory entry by hand. ProductKey is
+derived from ProductName when empty.
+*/
+func (ledger *Ledger) SaveProduct(product Product) (e *xerr.Error) {
+	if product.ProductKey == "" {
+		product.ProductKey = NormalizeProductName(product.ProductName)
+	}
+
+	tx, beginErr := ledger.db.Begin()
+	if beginErr != nil {
+		e = xerr.NewError(beginErr, "begin ledger transaction", product.ProductKey)
+		return e
+	}
+	e = saveProductTx(tx, product)
+	if e != nil {
+		_ = tx.Rollback()
+		return e
+	}
+	commitErr := tx.Commit()
+	if commitErr != nil {
+		e = xerr.NewError(commitErr, "commit ledger transaction", product.ProductKey)
+		return e
+	}
+	return nil
+}
+
+/*
+ForgetProduct removes a product memory entry. found is false if there was
+none.
+*/
+func (ledger *Ledger) ForgetProduct(merchantKey, productKey string) (found bool, e *xerr.Error) {
+	result, execErr := ledger.db.Exec(`DELETE FROM product_memory WHERE merchant_key = ? AND product_key = ?`, merchantKey, productKey)
+	if execErr != nil {
+		e = xerr.NewError(execErr, "delete product memory entry", merchantKey+"/"+productKey)
+		return false, e
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted > 0, nil
+}
+
+/*
+ListProducts returns the whole product memory, most recently learned first.
+*/
+func (ledger *Ledger) ListProducts() (products []Product, e *xerr.Error) {
+	rows, queryErr := ledger.db.Query(
+		`SELECT merchant_key, product_key, product_name, product_name_english, category_key, updated_at
+		FROM product_memory ORDER BY updated_at DESC, merchant_key, product_key`,
+	)
+	if queryErr != nil {
+		e = xerr.NewError(queryErr, "query product memory", ledger.Path)
+		return nil, e
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var product Product
+		scanErr := rows.Scan(
+			&product.MerchantKey, &product.ProductKey, &product.ProductName,
+			&product.ProductNameEnglish, &product.CategoryKey, &product.UpdatedAt,
+		)
+		if scanErr != nil {
+			e = xerr.NewError(scanErr, "scan product memory entry", ledger.Path)
+			return nil, e
+		}
+		products = append(products, product)
+	}
+	rowsErr := rows.Err()
+	if rowsErr != nil {
+		e = xerr.NewError(rowsErr, "iterate product memory", ledger.Path)
+		return nil, e
+	}
+	return products, nil
+}
+
+/*
+LookupProducts returns the remembered product of every item of analysis
+that has one, by item index. The merchant's SKU wins over the name.
+*/
+func (ledger *Ledger) LookupProducts(analysis receipt.Analysis) (byItem map[int]Product, e *xerr.Error) {
+	byItem = make(map[int]Product)
+	merchantKey := MerchantKey(analysis)
+	for index, item := range analysis.Items {
+		candidates := make([][2]string, 0, 2)
+		if merchantKey != "" && strings.TrimSpace(item.SKU) != "" {
+			candidates = append(candidates, [2]string{merchantKey, SKUKey(item.SKU)})
+		}
+		if productKey := NormalizeProductName(item.OriginalProductName); productKey != "" {
+			candidates = append(candidates, [2]string{"", productKey})
+		}
+
+		for _, candidate := range candidates {
+			var product Product
+			queryErr := ledger.db.QueryRow(
+				`SELECT merchant_key, product_key, product_name, product_name_english, category_key, updated_at
+				FROM product_memory WHERE merchant_key = ? AND product_key = ?`,
+				candidate[0], candidate[1],
+			).Scan(
+				&product.MerchantKey, &product.ProductKey, &product.ProductName,
+				&product.ProductNameEnglish, &product.CategoryKey, &product.UpdatedAt,
+			)
+			if errors.Is(queryErr, sql.ErrNoRows) {
+				continue
+			}
+			if queryErr != nil {
+				e = xerr.NewError(queryErr, "look up product memory", candidate[0]+"/"+candidate[1])
+				return nil, e
+			}
+			byItem[index] = product
+			break
+		}
+	}
+	return byItem, nil
+}
+
+/*
+ProductHints returns up to limit remembered product names that appear in
+text (usually the OCR text of a receipt), most recently learned first.
+They are given to the LLM as examples.
+*/
+func (ledger *Ledger) ProductHints(text string, limit int) (hints []Product, e *xerr.Error) {
+	products, e := ledger.ListProducts()
+	if e != nil {
+		return nil, e
+	}
+
+	lines := strings.Split(text, "\n")
+	normalizedLines := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if normalized := NormalizeProductName(line); normalized != "" {
+			normalizedLines = append(normalizedLines, " "+normalized+" ")
+		}
+	}
+
+	for _, product := range products {
+		if len(hints) >= limit {
+			break
+		}
+		if product.MerchantKey != "" {
+			continue
+		}
+		for _, line := range normalizedLines {
+			if strings.Contains(line, " "+product.ProductKey+" ") {
+				hints = append(hints, product)
+				break
+			}
+		}
+	}
+	return hints, nil
+}
@@ -0,0 +1,301 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/currency"
+	"expense-tracker/src/pkg/openai"
+	"expense-tracker/src/pkg/receipt"
+)
+
+// receiptSelect reads a receipt row together with its merchant, llm run, invoice and review state.
+const receiptSelect = `
+SELECT
+	r.id, r.run_dir, r.updated_at,
+	r.receipt_date, r.receipt_datetime, r.currency,
+	r.receipt_total, r.computed_items_total, r.total_check_message,
+	r.source_image_path, r.source_sha256, r.source_perceptual_hash, r.source_segment, r.confirmed_at,
+	COALESCE(m.name, ''), COALESCE(m.tax_id, ''), COALESCE(m.address, ''),
+	l.id, COALESCE(l.provider, ''), COALESCE(l.response_id, ''), COALESCE(l.response_logs_url, ''), COALESCE(l.model, ''),
+	COALESCE(l.model_snapshot, ''), COALESCE(l.status, ''), COALESCE(l.reasoning_effort, ''),
+	COALESCE(l.temperature, 0), COALESCE(l.tokens_in, 0), COALESCE(l.tokens_cached, 0),
+	COALESCE(l.tokens_out, 0), COALESCE(l.tokens_reasoning, 0), COALESCE(l.tokens_total, 0), COALESCE(l.cost_usd, 0),
+	COALESCE(l.started_at, 0), COALESCE(l.finished_at, 0), COALESCE(l.elapsed, 0),
+	v.receipt_id, COALESCE(v.number, ''), COALESCE(v.cufe, ''), COALESCE(v.subtotal, 0), COALESCE(v.tax_total, 0),
+	COALESCE(v.taxes, '[]'),
+	COALESCE(q.state, '')
+FROM receipts r
+LEFT JOIN merchants m ON m.id = r.merchant_id
+LEFT JOIN llm_runs l ON l.id = r.llm_run_id
+LEFT JOIN invoices v ON v.receipt_id = r.id
+LEFT JOIN review_queue q ON q.run_dir = r.run_dir
+`
+
+/*
+ReceiptFilter narrows FindReceipts. Zero values don't filter.
+
+Fields:
+  - Month: "YYYY-MM", matched against receipt_date (receipts without a
+    printed date never match).
+  - Merchant: case-insensitive substring of the merchant name or exact tax id.
+  - Category: receipts with at least one item in this category key.
+  - Currency: receipt currency code ("COP" also matches receipts without one).
+  - Limit: maximum number of receipts, newest receipt date first.
+*/
+type ReceiptFilter struct {
+	Month    string `json:"month,omitempty"`
+	Merchant string `json:"merchant,omitempty"`
+	Category string `json:"category,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+}
+
+/*
+ListReceipts returns every receipt in the ledger with its items, ordered by
+id (insertion order).
+*/
+func (ledger *Ledger) ListReceipts() (receipts []StoredReceipt, e *xerr.Error) {
+	return ledger.FindReceipts(ReceiptFilter{})
+}
+
+/*
+FindReceipts returns the receipts matching filter with their items. Without
+a Limit they are ordered by id, with one by receipt date (newest first).
+*/
+func (ledger *Ledger) FindReceipts(filter ReceiptFilter) (receipts []StoredReceipt, e *xerr.Error) {
+	where, args := filter.whereClause()
+
+	query := receiptSelect + where + ` ORDER BY r.id`
+	if filter.Limit > 0 {
+		query = receiptSelect + where + fmt.Sprintf(` ORDER BY r.receipt_date DESC, r.id DESC LIMIT %d`, filter.Limit)
+	}
+
+	rows, queryErr := ledger.db.Query(query, args...)
+	if queryErr != nil {
+		e = xerr.NewError(queryErr, "query ledger receipts", ledger.Path)
+		return nil, e
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	receipts = make([]StoredReceipt, 0)
+	positionByID := make(map[int64]int)
+	for rows.Next() {
+		stored, scanErr := scanReceipt(rows)
+		if scanErr != nil {
+			e = xerr.NewError(scanErr, "scan ledger receipt", ledger.Path)
+			return nil, e
+		}
+		positionByID[stored.ID] = len(receipts)
+		receipts = append(receipts, stored)
+	}
+	rowsErr := rows.Err()
+	if rowsErr != nil {
+		e = xerr.NewError(rowsErr, "iterate ledger receipts", ledger.Path)
+		return nil, e
+	}
+
+	itemsQuery := `SELECT receipt_id, ` + itemColumns + ` FROM items ORDER BY receipt_id, position`
+	if where != "" {
+		itemsQuery = `SELECT receipt_id, ` + itemColumns + ` FROM items WHERE receipt_id IN (
+			SELECT r.id FROM receipts r LEFT JOIN merchants m ON m.id = r.merchant_id` + where + `
+		) ORDER BY receipt_id, position`
+	}
+	itemsByReceiptID, e := ledger.loadItems(itemsQuery, args...)
+	if e != nil {
+		return nil, e
+	}
+	for receiptID, items := range itemsByReceiptID {
+		position, exists := positionByID[receiptID]
+		if exists {
+			receipts[position].Analysis.Items = items
+		}
+	}
+
+	return receipts, nil
+}
+
+/*
+whereClause returns the SQL condition (with a leading WHERE, or "" for an
+empty filter) over receipts r and merchants m.
+*/
+func (filter ReceiptFilter) whereClause() (where string, args []any) {
+	conditions := make([]string, 0)
+
+	if filter.Month != "" {
+		conditions = append(conditions, `r.receipt_date LIKE ? || '-%'`)
+		args = append(args, filter.Month)
+	}
+	if filter.Merchant != "" {
+		conditions = append(conditions, `(m.name LIKE '%' || ? || '%' OR m.tax_id = ?)`)
+		args = append(args, filter.Merchant, filter.Merchant)
+	}
+	if filter.Category != "" {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM items i WHERE i.receipt_id = r.id AND i.category_key = ?)`)
+		args = append(args, strings.ToLower(strings.TrimSpace(filter.Category)))
+	}
+	if filter.Currency != "" {
+		code := currency.Normalize(filter.Currency)
+		if code == currency.Default {
+			conditions = append(conditions, `r.currency IN (?, '')`)
+		} else {
+			conditions = append(conditions, `r.currency = ?`)
+		}
+		args = append(args, code)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return ` WHERE ` + strings.Join(conditions, ` AND `), args
+}
+
+/*
+FindReceiptID returns the id of the receipt stored for runDir.
+*/
+func (ledger *Ledger) FindReceiptID(runDir string) (receiptID int64, found bool, e *xerr.Error) {
+	queryErr := ledger.db.QueryRow(`SELECT id FROM receipts WHERE run_dir = ?`, runDir).Scan(&receiptID)
+	if errors.Is(queryErr, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if queryErr != nil {
+		e = xerr.NewError(queryErr, "find ledger receipt by run dir", runDir)
+		return 0, false, e
+	}
+
+	return receiptID, true, nil
+}
+
+/*
+GetReceipt returns a single receipt by id. found is false if there is no
+receipt with that id.
+*/
+func (ledger *Ledger) GetReceipt(receiptID int64) (stored StoredReceipt, found bool, e *xerr.Error) {
+	row := ledger.db.QueryRow(receiptSelect+` WHERE r.id = ?`, receiptID)
+	stored, scanErr := scanReceipt(row)
+	if errors.Is(scanErr, sql.ErrNoRows) {
+		return stored, false, nil
+	}
+	if scanErr != nil {
+		e = xerr.NewErrorECOL(scanErr, "query ledger receipt", "id", receiptID)
+		return stored, false, e
+	}
+
+	itemsByReceiptID, e := ledger.loadItems(`SELECT receipt_id, `+itemColumns+` FROM items WHERE receipt_id = ? ORDER BY position`, receiptID)
+	if e != nil {
+		return stored, false, e
+	}
+	stored.Analysis.Items = itemsByReceiptID[receiptID]
+
+	return stored, true, nil
+}
+
+// itemColumns are the items columns in receipt.Item field order.
+const itemColumns = `line_index, raw_line, original_product_name, product_name_english, quantity, unit_price, line_total, category_key,
+	category_rule, llm_category_key, sku, box_image, box_line, box_left, box_top, box_right, box_bottom`
+
+func (ledger *Ledger) loadItems(query string, args ...any) (itemsByReceiptID map[int64][]receipt.Item, e *xerr.Error) {
+	rows, queryErr := ledger.db.Query(query, args...)
+	if queryErr != nil {
+		e = xerr.NewError(queryErr, "query ledger items", ledger.Path)
+		return nil, e
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	itemsByReceiptID = make(map[int64][]receipt.Item)
+	for rows.Next() {
+		var receiptID int64
+		var item receipt.Item
+		var box receipt.ItemBox
+		var boxLine sql.NullInt64
+		scanErr := rows.Scan(
+			&receiptID, &item.LineIndex, &item.RawLine, &item.OriginalProductName, &item.ProductNameEnglish,
+			&item.Quantity, &item.UnitPrice, &item.LineTotal, &item.CategoryKey,
+			&item.CategoryRule, &item.LLMCategoryKey, &item.SKU,
+			&box.Image, &boxLine, &box.Left, &box.Top, &box.Right, &box.Bottom,
+		)
+		if scanErr != nil {
+			e = xerr.NewError(scanErr, "scan ledger item", ledger.Path)
+			return nil, e
+		}
+		if boxLine.Valid {
+			box.Line = int(boxLine.Int64)
+			item.Box = &box
+		}
+		itemsByReceiptID[receiptID] = append(itemsByReceiptID[receiptID], item)
+	}
+	rowsErr := rows.Err()
+	if rowsErr != nil {
+		e = xerr.NewError(rowsErr, "iterate ledger items", ledger.Path)
+		return nil, e
+	}
+
+	return itemsByReceiptID, nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanReceipt(row rowScanner) (stored StoredReceipt, err error) {
+	var analysis receipt.Analysis
+	var source receipt.Source
+	var sourceSegment int
+	var meta openai.LLMRunMetadata
+	var llmRunID sql.NullInt64
+	var reasoningEffort string
+	var invoice receipt.Invoice
+	var invoiceID sql.NullInt64
+	var taxesJSON string
+
+	err = row.Scan(
+		&stored.ID, &stored.RunDir, &stored.UpdatedAt,
+		&analysis.ReceiptDate, &analysis.ReceiptDateTime, &analysis.Currency,
+		&analysis.Totals.ReceiptTotal, &analysis.Totals.ComputedItemsTotal, &analysis.Totals.TotalCheckMessage,
+		&source.ImagePath, &source.SHA256, &source.PerceptualHash, &sourceSegment, &analysis.ConfirmedAt,
+		&analysis.MerchantName, &analysis.MerchantTaxID, &analysis.StoreAddress,
+		&llmRunID, &meta.Provider, &meta.ResponseID, &meta.ResponseLogsUrl, &meta.Model,
+		&meta.ModelSnapshot, &meta.Status, &reasoningEffort,
+		&meta.Temperature, &meta.TokensIn, &meta.TokensCached,
+		&meta.TokensOut, &meta.TokensReasoning, &meta.TokensTotal, &meta.CostUSD,
+		&meta.StartedAt, &meta.FinishedAt, &meta.Elapsed,
+		&invoiceID, &invoice.Number, &invoice.CUFE, &invoice.Subtotal, &invoice.TaxTotal,
+		&taxesJSON,
+		&stored.ReviewState,
+	)
+	if err != nil {
+		return stored, err
+	}
+
+	if sourceSegment > 0 {
+		source.Segment = &receipt.Segment{Index: sourceSegment}
+	}
+	if source != (receipt.Source{}) {
+		analysis.Source = &source
+	}
+	if llmRunID.Valid {
+		meta.ReasoningEffort = openai.Effort(reasoningEffort)
+		analysis.LLMRunMetadata = &meta
+	}
+	if invoiceID.Valid {
+		err = json.Unmarshal([]byte(taxesJSON), &invoice.Taxes)
+		if err != nil {
+			return stored, err
+		}
+		analysis.Invoice = &invoice
+	}
+	analysis.Items = make([]receipt.Item, 0)
+
+	stored.Analysis = analysis
+	return stored, nil
+}
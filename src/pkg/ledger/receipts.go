@@ -0,0 +1,256 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/openai"
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+StoredReceipt is a receipt as kept in the ledger.
+
+Fields:
+  - ID: ledger receipt id.
+  - RunDir: pipeline run directory the receipt came from (unique).
+  - UpdatedAt: Unix milliseconds of the last write.
+  - Analysis: the receipt rebuilt from the ledger rows, in the same shape as
+    receipt-analysis.json.
+  - ReviewState: state of the receipt in the review queue ("" when it was
+    never queued).
+*/
+type StoredReceipt struct {
+	ID          int64            `json:"id"`
+	RunDir      string           `json:"run_dir"`
+	UpdatedAt   int64            `json:"updated_at"`
+	Analysis    receipt.Analysis `json:"analysis"`
+	ReviewState ReviewState      `json:"review_state,omitempty"`
+}
+
+/*
+SaveReceipt stores analysis under runDir, replacing whatever was stored for
+that run directory before (reprocessing or re-importing is idempotent).
+Merchants and categories are created as needed. Products are not learned
+here (see LearnProducts): a reanalyzed receipt keeps its ConfirmedAt but
+not the items that were confirmed.
+*/
+func (ledger *Ledger) SaveReceipt(runDir string, analysis receipt.Analysis) (receiptID int64, e *xerr.Error) {
+	tx, beginErr := ledger.db.Begin()
+	if beginErr != nil {
+		e = xerr.NewError(beginErr, "begin ledger transaction", runDir)
+		return 0, e
+	}
+
+	receiptID, e = saveReceiptTx(tx, runDir, analysis)
+	if e != nil {
+		_ = tx.Rollback()
+		return 0, e
+	}
+
+	commitErr := tx.Commit()
+	if commitErr != nil {
+		e = xerr.NewError(commitErr, "commit ledger transaction", runDir)
+		return 0, e
+	}
+
+	return receiptID, nil
+}
+
+func saveReceiptTx(tx *sql.Tx, runDir string, analysis receipt.Analysis) (receiptID int64, e *xerr.Error) {
+	// Drop the previous version of this run (items and its llm run go with it).
+	_, execErr := tx.Exec(`DELETE FROM llm_runs WHERE id = (SELECT llm_run_id FROM receipts WHERE run_dir = ?)`, runDir)
+	if execErr == nil {
+		_, execErr = tx.Exec(`DELETE FROM receipts WHERE run_dir = ?`, runDir)
+	}
+	if execErr != nil {
+		e = xerr.NewError(execErr, "delete previous ledger receipt", runDir)
+		return 0, e
+	}
+
+	merchantID, e := upsertMerchant(tx, analysis)
+	if e != nil {
+		return 0, e
+	}
+
+	llmRunID, e := insertLLMRun(tx, analysis.LLMRunMetadata)
+	if e != nil {
+		return 0, e
+	}
+
+	source := receipt.Source{}
+	if analysis.Source != nil {
+		source = *analysis.Source
+	}
+	sourceSegment := 0
+	if source.Segment != nil {
+		sourceSegment = source.Segment.Index
+	}
+
+	result, execErr := tx.Exec(
+		`INSERT INTO receipts (
+			run_dir, merchant_id, llm_run_id, receipt_date, receipt_datetime, currency,
+			receipt_total, computed_items_total, total_check_message,
+			source_image_path, source_sha256, source_perceptual_hash, source_segment, confirmed_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		runDir, merchantID, llmRunID, analysis.ReceiptDate, analysis.ReceiptDateTime, strings.ToUpper(strings.TrimSpace(analysis.Currency)),
+		analysis.Totals.ReceiptTotal, analysis.Totals.ComputedItemsTotal, analysis.Totals.TotalCheckMessage,
+		source.ImagePath, source.SHA256, source.PerceptualHash, sourceSegment, analysis.ConfirmedAt, time.Now().UnixMilli(),
+	)
+	if execErr != nil {
+		e = xerr.NewError(execErr, "insert ledger receipt", runDir)
+		return 0, e
+	}
+	receiptID, _ = result.LastInsertId()
+
+	e = insertInvoice(tx, receiptID, runDir, analysis.Invoice)
+	if e != nil {
+		return 0, e
+	}
+
+	for position, item := range analysis.Items {
+		categoryKey := strings.ToLower(strings.TrimSpace(item.CategoryKey))
+		_, execErr = tx.Exec(`INSERT OR IGNORE INTO categories (key) VALUES (?)`, categoryKey)
+		if execErr != nil {
+			e = xerr.NewError(execErr, "insert ledger category", categoryKey)
+			return 0, e
+		}
+
+		box := receipt.ItemBox{}
+		boxLine := sql.NullInt64{}
+		if item.Box != nil {
+			box = *item.Box
+			boxLine = sql.NullInt64{Int64: int64(box.Line), Valid: true}
+		}
+
+		_, execErr = tx.Exec(
+			`INSERT INTO items (
+				receipt_id, position, line_index, raw_line, original_product_name,
+				product_name_english, quantity, unit_price, line_total, category_key,
+				category_rule, llm_category_key, sku,
+				box_image, box_line, box_left, box_top, box_right, box_bottom
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			receiptID, position, item.LineIndex, item.RawLine, item.OriginalProductName,
+			item.ProductNameEnglish, item.Quantity, item.UnitPrice, item.LineTotal, categoryKey,
+			item.CategoryRule, item.LLMCategoryKey, item.SKU,
+			box.Image, boxLine, box.Left, box.Top, box.Right, box.Bottom,
+		)
+		if execErr != nil {
+			e = xerr.NewErrorECOL(execErr, "insert ledger item", "item", fmt.Sprintf("%s #%d", runDir, position))
+			return 0, e
+		}
+	}
+
+	return receiptID, nil
+}
+
+/*
+MerchantKey returns how the ledger keys the merchant of a receipt: its tax
+id when printed (see receipt.NormalizeTaxID, so "NIT 900.123.456-7" and
+"900123456-7" are one merchant), otherwise its lower-cased name ("" if it
+has neither).
+*/
+func MerchantKey(analysis receipt.Analysis) string {
+	merchantKey := receipt.NormalizeTaxID(analysis.MerchantTaxID)
+	if merchantKey == "" {
+		merchantKey = strings.ToLower(strings.TrimSpace(analysis.MerchantName))
+	}
+	return merchantKey
+}
+
+/*
+upsertMerchant returns the merchant id for the receipt header, or nil when the
+receipt has neither a merchant name nor a tax id. Merchants are keyed by
+MerchantKey and keep the normalized tax id; name and address are updated
+to the latest non-empty values.
+*/
+func upsertMerchant(tx *sql.Tx, analysis receipt.Analysis) (merchantID any, e *xerr.Error) {
+	name := strings.TrimSpace(analysis.MerchantName)
+	taxID := receipt.NormalizeTaxID(analysis.MerchantTaxID)
+	address := strings.TrimSpace(analysis.StoreAddress)
+
+	merchantKey := MerchantKey(analysis)
+	if merchantKey == "" {
+		return nil, nil
+	}
+
+	var id int64
+	queryErr := tx.QueryRow(
+		`INSERT INTO merchants (merchant_key, name, tax_id, address) VALUES (?, ?, ?, ?)
+		ON CONFLICT(merchant_key) DO UPDATE SET
+			name    = CASE WHEN excluded.name    != '' THEN excluded.name    ELSE merchants.name    END,
+			address = CASE WHEN excluded.address != '' THEN excluded.address ELSE merchants.address END
+		RETURNING id`,
+		merchantKey, name, taxID, address,
+	).Scan(&id)
+	if queryErr != nil {
+		e = xerr.NewError(queryErr, "upsert ledger merchant", merchantKey)
+		return nil, e
+	}
+
+	return id, nil
+}
+
+/*
+insertInvoice stores the electronic invoice details of a receipt, if it has
+any (only e-invoice imports do).
+*/
+func insertInvoice(tx *sql.Tx, receiptID int64, runDir string, invoice *receipt.Invoice) (e *xerr.Error) {
+	if invoice == nil {
+		return nil
+	}
+
+	taxes := invoice.Taxes
+	if taxes == nil {
+		taxes = make([]receipt.Tax, 0)
+	}
+	taxesJSON, marshalErr := json.Marshal(taxes)
+	if marshalErr != nil {
+		e = xerr.NewError(marshalErr, "marshal invoice taxes to JSON", runDir)
+		return e
+	}
+
+	_, execErr := tx.Exec(
+		`INSERT INTO invoices (receipt_id, number, cufe, subtotal, tax_total, taxes) VALUES (?, ?, ?, ?, ?, ?)`,
+		receiptID, invoice.Number, invoice.CUFE, invoice.Subtotal, invoice.TaxTotal, string(taxesJSON),
+	)
+	if execErr != nil {
+		e = xerr.NewError(execErr, "insert ledger invoice", runDir)
+		return e
+	}
+
+	return nil
+}
+
+/*
+insertLLMRun stores the run metadata and returns its id, or nil if the
+receipt has none (e.g. imported from a non-LLM source).
+*/
+func insertLLMRun(tx *sql.Tx, meta *openai.LLMRunMetadata) (llmRunID any, e *xerr.Error) {
+	if meta == nil {
+		return nil, nil
+	}
+
+	result, execErr := tx.Exec(
+		`INSERT INTO llm_runs (
+			provider, response_id, response_logs_url, model, model_snapshot, status, reasoning_effort, temperature,
+			tokens_in, tokens_cached, tokens_out, tokens_reasoning, tokens_total, cost_usd,
+			started_at, finished_at, elapsed
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		meta.Provider, meta.ResponseID, meta.ResponseLogsUrl, meta.Model, meta.ModelSnapshot, meta.Status, string(meta.ReasoningEffort), meta.Temperature,
+		meta.TokensIn, meta.TokensCached, meta.TokensOut, meta.TokensReasoning, meta.TokensTotal, meta.CostUSD,
+		meta.StartedAt, meta.FinishedAt, meta.Elapsed,
+	)
+	if execErr != nil {
+		e = xerr.NewError(execErr, "insert ledger llm run", meta.ResponseID)
+		return nil, e
+	}
+
+	id, _ := result.LastInsertId()
+	return id, nil
+}
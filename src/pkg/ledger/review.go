@@ -0,0 +1,269 @@
+package ledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tuumbleweed/xerr"
+)
+
+// ReviewReason is why a receipt was put in the review queue.
+type ReviewReason string
+
+const (
+	ReviewTotalMismatch    ReviewReason = "total_mismatch"     // items don't add up to the receipt total
+	ReviewLowOCRConfidence ReviewReason = "low_ocr_confidence" // OCR quality score below preprocess.min_quality_score
+	ReviewMissingDate      ReviewReason = "missing_date"       // no receipt date, the receipt lands in no month
+	ReviewOtherItems       ReviewReason = "other_items"        // items categorized as "other" (or not at all)
+)
+
+// ReviewState is where a queued receipt is.
+type ReviewState string
+
+const (
+	ReviewPending  ReviewState = "pending"  // waiting for someone to look at it
+	ReviewAccepted ReviewState = "accepted" // checked (and corrected if needed), counted in reports
+	ReviewRejected ReviewState = "rejected" // not a valid receipt, left out of reports
+)
+
+/*
+ReviewFinding is one reason a receipt was queued.
+
+Fields:
+  - Reason: reason code.
+  - Detail: what exactly was found, for people.
+*/
+type ReviewFinding struct {
+	Reason ReviewReason `json:"reason"`
+	Detail string       `json:"detail"`
+}
+
+/*
+Review is a receipt in the review queue. Entries are keyed by run directory
+because receipt ids change when a receipt is stored again.
+
+Fields:
+  - ID: review id.
+  - RunDir: pipeline run directory of the receipt.
+  - ReceiptID: ledger receipt id of RunDir (0 if it isn't in the ledger).
+  - State: see ReviewState.
+  - Findings: why the receipt was queued.
+  - Note: what the reviewer wrote when accepting or rejecting.
+  - CreatedAt, UpdatedAt, ResolvedAt: Unix milliseconds (ResolvedAt is 0
+    while pending).
+*/
+type Review struct {
+	ID         int64           `json:"id"`
+	RunDir     string          `json:"run_dir"`
+	ReceiptID  int64           `json:"receipt_id,omitempty"`
+	State      ReviewState     `json:"state"`
+	Findings   []ReviewFinding `json:"findings"`
+	Note       string          `json:"note,omitempty"`
+	CreatedAt  int64           `json:"created_at"`
+	UpdatedAt  int64           `json:"updated_at"`
+	ResolvedAt int64           `json:"resolved_at,omitempty"`
+}
+
+// Reasons returns the reason codes of the findings.
+func (review Review) Reasons() []ReviewReason {
+	reasons := make([]ReviewReason, 0, len(review.Findings))
+	for _, finding := range review.Findings {
+		reasons = append(reasons, finding.Reason)
+	}
+	return reasons
+}
+
+// reviewSelect reads a review row together with the id of its receipt.
+const reviewSelect = `
+SELECT q.id, q.run_dir, COALESCE(r.id, 0), q.state, q.findings, q.note, q.created_at, q.updated_at, q.resolved_at
+FROM review_queue q
+LEFT JOIN receipts r ON r.run_dir = q.run_dir
+`
+
+/*
+QueueReview puts the receipt of runDir in the review queue with findings
+and returns its entry; queued is true when it is pending afterwards.
+
+  - No findings: a pending entry is removed (the receipt was fixed),
+    resolved ones are kept.
+  - New receipt: queued as pending.
+  - Pending: the findings are replaced.
+  - Accepted or rejected: kept as is when the reasons are the same,
+    otherwise queued again as pending (the note is dropped).
+*/
+func (ledger *Ledger) QueueReview(runDir string, findings []ReviewFinding) (review Review, queued bool, e *xerr.Error) {
+	if len(findings) == 0 {
+		_, execErr := ledger.db.Exec(`DELETE FROM review_queue WHERE run_dir = ? AND state = ?`, runDir, ReviewPending)
+		if execErr != nil {
+			e = xerr.NewError(execErr, "remove pending ledger review", runDir)
+			return review, false, e
+		}
+		return review, false, nil
+	}
+
+	existing, found, e := ledger.findReview(`q.run_dir = ?`, runDir)
+	if e != nil {
+		return review, false, e
+	}
+	if found && existing.State != ReviewPending && slices.Equal(existing.Reasons(), reasonsOf(findings)) {
+		return existing, false, nil
+	}
+
+	findingsJSON, marshalErr := json.Marshal(findings)
+	if marshalErr != nil {
+		e = xerr.NewError(marshalErr, "marshal review findings to JSON", runDir)
+		return review, false, e
+	}
+	reasons := joinReasons(reasonsOf(findings))
+	now := time.Now().UnixMilli()
+
+	_, execErr := ledger.db.Exec(`
+		INSERT INTO review_queue (run_dir, state, reasons, findings, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (run_dir) DO UPDATE SET
+			state = excluded.state, reasons = excluded.reasons, findings = excluded.findings,
+			note = '', updated_at = excluded.updated_at, resolved_at = 0`,
+		runDir, ReviewPending, reasons, string(findingsJSON), now, now,
+	)
+	if execErr != nil {
+		e = xerr.NewError(execErr, "queue ledger review", runDir)
+		return review, false, e
+	}
+
+	review, _, e = ledger.findReview(`q.run_dir = ?`, runDir)
+	if e != nil {
+		return review, false, e
+	}
+	return review, true, nil
+}
+
+/*
+GetReview returns a review by id. found is false if there is no review with
+that id.
+*/
+func (ledger *Ledger) GetReview(reviewID int64) (review Review, found bool, e *xerr.Error) {
+	return ledger.findReview(`q.id = ?`, reviewID)
+}
+
+/*
+ListReviews returns the oldest reviews first, only those in state and with
+reason unless they're empty, at most limit (all if limit is 0).
+*/
+func (ledger *Ledger) ListReviews(state ReviewState, reason ReviewReason, limit int) (reviews []Review, e *xerr.Error) {
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+	if state != "" {
+		conditions = append(conditions, `q.state = ?`)
+		args = append(args, state)
+	}
+	if reason != "" {
+		conditions = append(conditions, `(',' || q.reasons || ',') LIKE ?`)
+		args = append(args, "%,"+string(reason)+",%")
+	}
+
+	query := reviewSelect
+	if len(conditions) > 0 {
+		query += `WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	query += ` ORDER BY q.id`
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, limit)
+	}
+
+	rows, queryErr := ledger.db.Query(query, args...)
+	if queryErr != nil {
+		e = xerr.NewError(queryErr, "query ledger reviews", ledger.Path)
+		return nil, e
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	reviews = make([]Review, 0)
+	for rows.Next() {
+		review, scanErr := scanReview(rows)
+		if scanErr != nil {
+			e = xerr.NewError(scanErr, "scan ledger review", ledger.Path)
+			return nil, e
+		}
+		reviews = append(reviews, review)
+	}
+	rowsErr := rows.Err()
+	if rowsErr != nil {
+		e = xerr.NewError(rowsErr, "iterate ledger reviews", ledger.Path)
+		return nil, e
+	}
+
+	return reviews, nil
+}
+
+/*
+ResolveReview accepts or rejects a review with an optional note. found is
+false if there is no review with that id.
+*/
+func (ledger *Ledger) ResolveReview(reviewID int64, state ReviewState, note string) (found bool, e *xerr.Error) {
+	if state != ReviewAccepted && state != ReviewRejected {
+		e = xerr.NewError(fmt.Errorf("state must be '%s' or '%s'", ReviewAccepted, ReviewRejected), "resolve ledger review", state)
+		return false, e
+	}
+
+	now := time.Now().UnixMilli()
+	result, execErr := ledger.db.Exec(
+		`UPDATE review_queue SET state = ?, note = ?, updated_at = ?, resolved_at = ? WHERE id = ?`,
+		state, note, now, now, reviewID,
+	)
+	var affected int64
+	if execErr == nil {
+		affected, execErr = result.RowsAffected()
+	}
+	if execErr != nil {
+		e = xerr.NewErrorECOL(execErr, "resolve ledger review", "id", reviewID)
+		return false, e
+	}
+
+	return affected > 0, nil
+}
+
+func (ledger *Ledger) findReview(condition string, arg any) (review Review, found bool, e *xerr.Error) {
+	row := ledger.db.QueryRow(reviewSelect+`WHERE `+condition, arg)
+	review, scanErr := scanReview(row)
+	if errors.Is(scanErr, sql.ErrNoRows) {
+		return review, false, nil
+	}
+	if scanErr != nil {
+		e = xerr.NewErrorECOL(scanErr, "query ledger review", "key", arg)
+		return review, false, e
+	}
+
+	return review, true, nil
+}
+
+func scanReview(row rowScanner) (review Review, err error) {
+	var findingsJSON string
+	err = row.Scan(
+		&review.ID, &review.RunDir, &review.ReceiptID, &review.State, &findingsJSON, &review.Note,
+		&review.CreatedAt, &review.UpdatedAt, &review.ResolvedAt,
+	)
+	if err != nil {
+		return review, err
+	}
+	err = json.Unmarshal([]byte(findingsJSON), &review.Findings)
+	return review, err
+}
+
+func reasonsOf(findings []ReviewFinding) []ReviewReason {
+	return Review{Findings: findings}.Reasons()
+}
+
+func joinReasons(reasons []ReviewReason) string {
+	parts := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		parts = append(parts, string(reason))
+	}
+	return strings.Join(parts, ",")
+}
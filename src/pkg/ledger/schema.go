@@ -0,0 +1,224 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+)
+
+/*
+migrations are applied in order; PRAGMA user_version stores how many have
+already run. Never edit an existing entry - append a new one instead.
+*/
+var migrations = []string{
+	// 1: initial schema
+	`
+	CREATE TABLE merchants (
+		id           INTEGER PRIMARY KEY,
+		merchant_key TEXT NOT NULL UNIQUE, -- tax id if printed, else lower-cased name
+		name         TEXT NOT NULL DEFAULT '',
+		tax_id       TEXT NOT NULL DEFAULT '',
+		address      TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE categories (
+		key          TEXT PRIMARY KEY,
+		display_name TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE llm_runs (
+		id                INTEGER PRIMARY KEY,
+		provider          TEXT NOT NULL DEFAULT '',
+		response_id       TEXT NOT NULL DEFAULT '',
+		response_logs_url TEXT NOT NULL DEFAULT '',
+		model             TEXT NOT NULL DEFAULT '',
+		model_snapshot    TEXT NOT NULL DEFAULT '',
+		status            TEXT NOT NULL DEFAULT '',
+		reasoning_effort  TEXT NOT NULL DEFAULT '',
+		temperature       REAL NOT NULL DEFAULT 0,
+		tokens_in         INTEGER NOT NULL DEFAULT 0,
+		tokens_cached     INTEGER NOT NULL DEFAULT 0,
+		tokens_out        INTEGER NOT NULL DEFAULT 0,
+		tokens_reasoning  INTEGER NOT NULL DEFAULT 0,
+		tokens_total      INTEGER NOT NULL DEFAULT 0,
+		started_at        INTEGER NOT NULL DEFAULT 0,
+		finished_at       INTEGER NOT NULL DEFAULT 0,
+		elapsed           INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE receipts (
+		id                     INTEGER PRIMARY KEY,
+		run_dir                TEXT NOT NULL UNIQUE,
+		merchant_id            INTEGER REFERENCES merchants(id),
+		llm_run_id             INTEGER REFERENCES llm_runs(id) ON DELETE SET NULL,
+		receipt_date           TEXT NOT NULL DEFAULT '',
+		receipt_datetime       TEXT NOT NULL DEFAULT '',
+		receipt_total          REAL NOT NULL DEFAULT 0,
+		computed_items_total   REAL NOT NULL DEFAULT 0,
+		total_check_message    TEXT NOT NULL DEFAULT '',
+		source_image_path      TEXT NOT NULL DEFAULT '',
+		source_sha256          TEXT NOT NULL DEFAULT '',
+		source_perceptual_hash TEXT NOT NULL DEFAULT '',
+		updated_at             INTEGER NOT NULL
+	);
+	CREATE INDEX receipts_receipt_date ON receipts(receipt_date);
+	CREATE INDEX receipts_source_sha256 ON receipts(source_sha256);
+
+	CREATE TABLE items (
+		id                    INTEGER PRIMARY KEY,
+		receipt_id            INTEGER NOT NULL REFERENCES receipts(id) ON DELETE CASCADE,
+		position              INTEGER NOT NULL,
+		line_index            INTEGER NOT NULL DEFAULT -1,
+		raw_line              TEXT NOT NULL DEFAULT '',
+		original_product_name TEXT NOT NULL DEFAULT '',
+		product_name_english  TEXT NOT NULL DEFAULT '',
+		quantity              REAL NOT NULL DEFAULT 0,
+		unit_price            REAL NOT NULL DEFAULT 0,
+		line_total            REAL NOT NULL DEFAULT 0,
+		category_key          TEXT NOT NULL DEFAULT '' REFERENCES categories(key)
+	);
+	CREATE INDEX items_receipt_id ON items(receipt_id);
+	CREATE INDEX items_category_key ON items(category_key);
+	`,
+
+	// 2: estimated LLM cost
+	`
+	ALTER TABLE llm_runs ADD COLUMN cost_usd REAL NOT NULL DEFAULT 0;
+	`,
+
+	// 3: receipt currency ('' for receipts analyzed before it was recorded)
+	`
+	ALTER TABLE receipts ADD COLUMN currency TEXT NOT NULL DEFAULT '';
+	`,
+
+	// 4: pipeline job queue
+	`
+	CREATE TABLE jobs (
+		id                     INTEGER PRIMARY KEY,
+		state                  TEXT NOT NULL, -- queued, ocr, llm, done, failed
+		image_path             TEXT NOT NULL,
+		source_sha256          TEXT NOT NULL DEFAULT '',
+		source_perceptual_hash TEXT NOT NULL DEFAULT '',
+		attempts               INTEGER NOT NULL DEFAULT 0,
+		max_attempts           INTEGER NOT NULL DEFAULT 1,
+		next_attempt_at        INTEGER NOT NULL DEFAULT 0,
+		run_dir                TEXT NOT NULL DEFAULT '',
+		receipt_id             INTEGER REFERENCES receipts(id) ON DELETE SET NULL,
+		failed_stage           TEXT NOT NULL DEFAULT '',
+		error                  TEXT NOT NULL DEFAULT '',
+		created_at             INTEGER NOT NULL,
+		updated_at             INTEGER NOT NULL
+	);
+	CREATE INDEX jobs_state ON jobs(state, next_attempt_at);
+	CREATE INDEX jobs_source_sha256 ON jobs(source_sha256);
+	`,
+
+	// 5: receipt position in a photo of several receipts (0 = the whole photo)
+	`
+	ALTER TABLE receipts ADD COLUMN source_segment INTEGER NOT NULL DEFAULT 0;
+	`,
+
+	// 6: categorization rule that overrode the LLM's category, and the LLM's guess
+	`
+	ALTER TABLE items ADD COLUMN category_rule TEXT NOT NULL DEFAULT '';
+	ALTER TABLE items ADD COLUMN llm_category_key TEXT NOT NULL DEFAULT '';
+	`,
+
+	// 7: product memory learned from confirmed receipts
+	`
+	ALTER TABLE receipts ADD COLUMN confirmed_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE items ADD COLUMN sku TEXT NOT NULL DEFAULT '';
+
+	CREATE TABLE product_memory (
+		merchant_key         TEXT NOT NULL DEFAULT '', -- '' for product names, the merchant for SKUs
+		product_key          TEXT NOT NULL,            -- normalized product name, or 'sku:' + SKU
+		product_name         TEXT NOT NULL DEFAULT '', -- as printed, last time it was learned
+		product_name_english TEXT NOT NULL DEFAULT '',
+		category_key         TEXT NOT NULL,
+		updated_at           INTEGER NOT NULL,
+		PRIMARY KEY (merchant_key, product_key)
+	);
+	`,
+
+	// 8: review queue of suspicious receipts, by run directory (receipt ids change when a receipt is stored again)
+	`
+	CREATE TABLE review_queue (
+		id          INTEGER PRIMARY KEY,
+		run_dir     TEXT NOT NULL UNIQUE,
+		state       TEXT NOT NULL,            -- pending, accepted, rejected
+		reasons     TEXT NOT NULL,            -- reason codes joined with ','
+		findings    TEXT NOT NULL,            -- JSON array of {reason, detail}
+		note        TEXT NOT NULL DEFAULT '',
+		created_at  INTEGER NOT NULL,
+		updated_at  INTEGER NOT NULL,
+		resolved_at INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX review_queue_state ON review_queue(state);
+	`,
+
+	// 9: OCR line box of each item (box_line is NULL when the item has none)
+	`
+	ALTER TABLE items ADD COLUMN box_image TEXT NOT NULL DEFAULT '';
+	ALTER TABLE items ADD COLUMN box_line INTEGER;
+	ALTER TABLE items ADD COLUMN box_left INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE items ADD COLUMN box_top INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE items ADD COLUMN box_right INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE items ADD COLUMN box_bottom INTEGER NOT NULL DEFAULT 0;
+	`,
+
+	// 10: electronic invoice details of receipts imported from DIAN XML
+	`
+	CREATE TABLE invoices (
+		receipt_id INTEGER PRIMARY KEY REFERENCES receipts(id) ON DELETE CASCADE,
+		number     TEXT NOT NULL DEFAULT '',
+		cufe       TEXT NOT NULL DEFAULT '',
+		subtotal   REAL NOT NULL DEFAULT 0,
+		tax_total  REAL NOT NULL DEFAULT 0,
+		taxes      TEXT NOT NULL DEFAULT '[]' -- JSON array of {scheme, percent, taxable_amount, amount}
+	);
+	`,
+}
+
+/*
+migrate brings the database schema up to date.
+*/
+func migrate(db *sql.DB, databasePath string) (e *xerr.Error) {
+	var currentVersion int
+	queryErr := db.QueryRow(`PRAGMA user_version`).Scan(&currentVersion)
+	if queryErr != nil {
+		e = xerr.NewError(queryErr, "read ledger schema version", databasePath)
+		return e
+	}
+
+	for version := currentVersion + 1; version <= len(migrations); version++ {
+		tx, beginErr := db.Begin()
+		if beginErr != nil {
+			e = xerr.NewError(beginErr, "begin ledger migration", databasePath)
+			return e
+		}
+
+		_, execErr := tx.Exec(migrations[version-1])
+		if execErr == nil {
+			// PRAGMA doesn't accept bound parameters.
+			_, execErr = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version))
+		}
+		if execErr != nil {
+			_ = tx.Rollback()
+			e = xerr.NewErrorECOL(execErr, "apply ledger migration", "version", version)
+			return e
+		}
+
+		commitErr := tx.Commit()
+		if commitErr != nil {
+			e = xerr.NewErrorECOL(commitErr, "commit ledger migration", "version", version)
+			return e
+		}
+
+		tl.Log(tl.Info1, palette.Green, "Applied ledger migration '%s' to '%s'", version, databasePath)
+	}
+
+	return nil
+}
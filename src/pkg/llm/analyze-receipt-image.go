@@ -6,14 +6,15 @@ import (
 	"mime"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 
 	tl "github.com/tuumbleweed/tintlog/logger"
 	"github.com/tuumbleweed/tintlog/palette"
 	"github.com/tuumbleweed/xerr"
 
+	"expense-tracker/src/pkg/category"
 	"expense-tracker/src/pkg/openai"
+	"expense-tracker/src/pkg/receipt"
 )
 
 /*
@@ -41,20 +42,53 @@ func buildImageDataURL(imagePath string) (dataURL string, e *xerr.Error) {
 }
 
 /*
-GenerateReceiptAnalysisFromImage takes an image of a receipt, noisy OCR text,
+KnownProduct is a product whose category and English name were confirmed
+on an earlier receipt.
+*/
+type KnownProduct struct {
+	ProductName        string // as printed
+	ProductNameEnglish string
+	CategoryKey        string
+}
+
+/*
+knownProductsBlock lists knownProducts for the prompt, or returns "" when
+there are none.
+*/
+func knownProductsBlock(knownProducts []KnownProduct) string {
+	if len(knownProducts) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString("\nProducts confirmed on earlier receipts (name as printed -> category_key, product_name_english).\n")
+	builder.WriteString("When one of them is on this receipt, even in another size, give it the same category_key and product_name_english:\n")
+	for _, knownProduct := range knownProducts {
+		builder.WriteString(fmt.Sprintf(
+			"- %q -> %s, %q\n",
+			knownProduct.ProductName, knownProduct.CategoryKey, knownProduct.ProductNameEnglish,
+		))
+	}
+	return builder.String()
+}
+
+/*
+GenerateReceiptAnalysisFromImage takes images of a receipt, noisy OCR text,
 and a list of regex-parsed price candidates, and produces a structured
-ReceiptAnalysis using the OpenAI Responses API with vision.
+ReceiptAnalysis using the LLM provider selected in Cfg (vision-capable model).
 
 Parameters:
-  - imagePath: path to the original receipt image (photo).
-  - ocrText: noisy OCR text extracted locally.
+  - imagePaths: paths to the original receipt image (photo), or to several
+    overlapping photos of a long receipt, top to bottom.
+  - ocrText: noisy OCR text extracted locally (merged for several photos).
   - priceCandidates: list of numeric price strings parsed via regex from
     numeric-only OCR (used as hints).
-  - categories: optional category map (key -> description). If nil/empty, the
-    default set of categories is used.
+  - taxonomy: categories to choose from; nil means category.Current.
+  - knownProducts: products on the receipt whose category and English name
+    were confirmed before (may be empty), given to the model as examples.
 
 Behavior:
-  - Sends the receipt image plus text (OCR + price list) to the model.
+  - Sends the receipt image(s) plus text (OCR + price list) to the model.
   - The model is instructed to:
     * read prices and items primarily from the image,
     * use OCR and priceCandidates as hints,
@@ -62,45 +96,77 @@ Behavior:
     * compute totals and compare them.
 */
 func GenerateReceiptAnalysisFromImage(
-	imagePath string,
+	imagePaths []string,
 	ocrText string,
 	priceCandidates []string,
-	categories map[string]string,
-) (receiptAnalysis ReceiptAnalysis, e *xerr.Error) {
-	model := "gpt-5-mini"
-	reasoningEffort := openai.EffortLow
-	tools := []any{} // still disabling tools
-	toolChoice := "auto"
+	taxonomy *category.Taxonomy,
+	knownProducts []KnownProduct,
+) (receiptAnalysis receipt.Analysis, e *xerr.Error) {
+	provider, e := NewProvider(Cfg)
+	if e != nil {
+		return receiptAnalysis, e
+	}
 
 	tl.Log(
 		tl.Notice, palette.BlueBold, "%s with %s model %s, reasoning effort is %s",
-		"Generating receipt analysis from image", "OpenAI", model, reasoningEffort,
+		"Generating receipt analysis from image", provider.Name(), provider.Model(), Cfg.ReasoningEffort,
 	)
 
-	imageDataURL, e := buildImageDataURL(imagePath)
+	request, e := buildReceiptImageRequest(imagePaths, ocrText, priceCandidates, taxonomy, knownProducts)
 	if e != nil {
 		return receiptAnalysis, e
 	}
 
-	// Ensure we have a category map; fall back to the default set if needed.
-	effectiveCategories := categories
-	if len(effectiveCategories) == 0 {
-		effectiveCategories = buildDefaultReceiptCategories()
+	var llmRunMetadata *openai.LLMRunMetadata
+
+	receiptAnalysis, llmRunMetadata, e = generateStructured[receipt.Analysis](provider, request)
+	if e != nil {
+		return receiptAnalysis, e
 	}
 
-	// Build a human-readable list of categories for the instructions.
-	categoryLines := make([]string, 0, len(effectiveCategories))
-	for key, description := range effectiveCategories {
-		line := fmt.Sprintf("- %s: %s", key, description)
-		categoryLines = append(categoryLines, line)
+	receiptAnalysis.LLMRunMetadata = llmRunMetadata
+
+	tl.Log(
+		tl.Notice1, palette.GreenBold, "%s with %s model %s, reasoning effort is %s",
+		"Generated receipt analysis from image", provider.Name(), provider.Model(), Cfg.ReasoningEffort,
+	)
+	tl.LogJSON(tl.Info, palette.Cyan, "ReceiptAnalysis (image)", receiptAnalysis)
+
+	return receiptAnalysis, nil
+}
+
+/*
+buildReceiptImageRequest builds the request GenerateReceiptAnalysisFromImage
+sends (see there for the parameters).
+*/
+func buildReceiptImageRequest(
+	imagePaths []string,
+	ocrText string,
+	priceCandidates []string,
+	taxonomy *category.Taxonomy,
+	knownProducts []KnownProduct,
+) (request StructuredRequest, e *xerr.Error) {
+	imageDataURLs := make([]string, 0, len(imagePaths))
+	for _, imagePath := range imagePaths {
+		imageDataURL, e := buildImageDataURL(imagePath)
+		if e != nil {
+			return request, e
+		}
+		imageDataURLs = append(imageDataURLs, imageDataURL)
 	}
-	sort.Strings(categoryLines)
-	categoryBlock := strings.Join(categoryLines, "\n")
 
 	// Build the user text that accompanies the image: OCR + regex prices.
 	var userTextBuilder strings.Builder
 	userTextBuilder.WriteString("Below is noisy OCR text of a purchase receipt, followed by a list of regex-parsed price candidates.\n")
 	userTextBuilder.WriteString("Use the attached receipt image as the primary source of truth; use the OCR text and price list only as hints.\n\n")
+	if len(imagePaths) > 1 {
+		userTextBuilder.WriteString(fmt.Sprintf(
+			"The receipt is too long for one photo: the %d attached images are overlapping photos of the SAME receipt, top to bottom. "+
+				"Lines in the overlapping parts appear in two photos; count every item only once. "+
+				"The OCR text below is already merged into one receipt.\n\n",
+			len(imagePaths),
+		))
+	}
 
 	userTextBuilder.WriteString("=== OCR TEXT START ===\n")
 	userTextBuilder.WriteString(ocrText)
@@ -128,32 +194,45 @@ using BOTH:
 Your task:
 - Carefully read the attached receipt image. Treat the IMAGE as the main ground truth.
 - Use the OCR text and the "PRICE CANDIDATES" list only as hints for resolving ambiguous glyphs.
+- Extract the receipt header fields from the image:
+  - receipt_date: purchase date as YYYY-MM-DD, or "" if no date is printed.
+  - receipt_datetime: purchase date and time as YYYY-MM-DD HH:MM:SS, or "" if no time is printed.
+  - merchant_name: store/business name as printed on the receipt.
+  - merchant_tax_id: merchant tax ID (NIT), e.g. "900123456-7", or "" if not printed.
+  - store_address: store address as printed, or "" if not printed.
+  - currency: ISO 4217 code of the amounts ("COP", "USD", "EUR", ...).
 - Identify each purchased product line in the receipt.
 - For each item, extract:
   - original_product_name: cleaned product name as it appears on the receipt without the price.
   - product_name_english: short English translation of the product name.
   - quantity: numeric quantity (use 1.0 if not explicitly given but implied).
-  - unit_price: unit price in COP if you can infer it, otherwise 0.
-  - line_total: total amount for that item in COP.
+  - unit_price: unit price in the receipt currency if you can infer it, otherwise 0.
+  - line_total: total amount for that item in the receipt currency.
   - category_key: one of the allowed category keys listed below (or "other" if nothing fits).
 
 - Compute and compare totals:
-  - Determine receipt_total: the total amount charged according to the receipt (in COP).
+  - Determine receipt_total: the total amount charged according to the receipt (in the receipt currency).
   - Determine computed_items_total: sum of all item line_total values.
   - Compare them:
-      * If they are equal within 1 COP, set total_check_message to "" (empty string).
+      * If they are equal within 1 unit of the currency, set total_check_message to "" (empty string).
       * Otherwise, set total_check_message to a short English explanation such as:
         "Sum of items is 10,470 COP but receipt total is 10,480 COP (difference: 10 COP)."
 
 Allowed category keys and descriptions:
 %s
 
+Rules:
+%s
+%s
 Additional hints:
-- Receipts are in Colombian pesos (COP) and often use "." or "," as thousand separators but no cents.
+- Most receipts are in Colombian pesos (COP) and often use "." or "," as thousand separators but no cents.
+  Use another currency only when the receipt shows it (code, symbol such as "€" or "US$", country, decimal cents);
+  do not convert amounts, keep them as printed.
+- Colombian receipts print dates as DD/MM/YYYY (day first); convert them to YYYY-MM-DD.
 - A trailing "A" after a price in the OCR often indicates a tax/IVA code and is not part of the numeric price.
 - The list under "PRICE CANDIDATES" in the user message are likely price values from the receipt; prefer them when they are consistent with the image.
 - Do NOT invent products that are not visually or textually implied by the receipt.
-`, categoryBlock)
+`, categoryBlock(taxonomy), categoryRules, knownProductsBlock(knownProducts))
 
 	developerMessage := `
 Return only a single JSON object matching the provided schema.
@@ -168,110 +247,42 @@ but do not create items that do not appear visually on the receipt.
 Perform a best-effort reconstruction of items and totals from the image + noisy text.
 `
 
-	// Same JSON schema properties as in GenerateReceiptAnalysis.
-	schemaProperties := map[string]any{
-		"items": map[string]any{
-			"type":        "array",
-			"description": "List of line items parsed from the receipt.",
-			"items": map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"line_index": map[string]any{
-						"type":        "integer",
-						"description": "Zero-based index of the main OCR line for this item, or -1 if unknown.",
-					},
-					"raw_line": map[string]any{
-						"type":        "string",
-						"description": "Raw OCR text line(s) used to derive this item.",
-					},
-					"original_product_name": map[string]any{
-						"type":        "string",
-						"description": "Cleaned product name as it is in the OCR text/image, without the price.",
-					},
-					"product_name_english": map[string]any{
-						"type":        "string",
-						"description": "Short English translation of the product name.",
-					},
-					"quantity": map[string]any{
-						"type":        "number",
-						"description": "Quantity of the item (1.0 if not explicitly given).",
-					},
-					"unit_price": map[string]any{
-						"type":        "number",
-						"description": "Unit price in COP, or 0 if unknown.",
-					},
-					"line_total": map[string]any{
-						"type":        "number",
-						"description": "Total amount for this item in COP.",
-					},
-					"category_key": map[string]any{
-						"type":        "string",
-						"description": "One of the allowed category keys or 'other'.",
-					},
-				},
-				"required": []string{
-					"line_index",
-					"raw_line",
-					"original_product_name",
-					"product_name_english",
-					"quantity",
-					"unit_price",
-					"line_total",
-					"category_key",
-				},
-				"additionalProperties": false,
-			},
-		},
-		"totals": map[string]any{
-			"type":        "object",
-			"description": "Summary totals for the receipt.",
-			"properties": map[string]any{
-				"receipt_total": map[string]any{
-					"type":        "number",
-					"description": "Total amount as written on the receipt (in COP).",
-				},
-				"computed_items_total": map[string]any{
-					"type":        "number",
-					"description": "Sum of all item line_total values (in COP).",
-				},
-				"total_check_message": map[string]any{
-					"type":        "string",
-					"description": "Empty string if sums match within 1 COP; otherwise a short English explanation.",
-				},
-			},
-			"required":             []string{"receipt_total", "computed_items_total", "total_check_message"},
-			"additionalProperties": false,
-		},
+	// JSON Schema properties are derived from the shared receipt model.
+	schemaProperties, e := receipt.AnalysisSchemaProperties()
+	if e != nil {
+		return request, e
 	}
 
-	var llmRunMetadata *openai.LLMRunMetadata
-
-	// This wrapper needs to construct a Responses API request with:
-	// - system + developer messages as input_text
-	// - user: content = [ {type: "input_text", text: userMessage}, {type: "input_image", image_url: imageDataURL} ]
-	receiptAnalysis, llmRunMetadata, e = openai.UseChatGPTResponsesAPIWithImage[ReceiptAnalysis](
-		model,
-		reasoningEffort,
-		instructions,
-		developerMessage,
-		userMessage,
-		imageDataURL,
-		schemaProperties,
-		4096,
-		tools,
-		toolChoice,
-	)
-	if e != nil {
-		return receiptAnalysis, e
+	request = StructuredRequest{
+		Instructions:     instructions,
+		DeveloperMessage: developerMessage,
+		UserText:         userMessage,
+		ImageDataURLs:    imageDataURLs,
+		SchemaProperties: schemaProperties,
 	}
 
-	receiptAnalysis.LLMRunMetadata = llmRunMetadata
+	return request, nil
+}
 
-	tl.Log(
-		tl.Notice1, palette.GreenBold, "%s with %s model %s, reasoning effort is %s",
-		"Generated receipt analysis from image", "OpenAI", model, reasoningEffort,
-	)
-	tl.LogJSON(tl.Info, palette.Cyan, "OpenAI ReceiptAnalysis (image)", receiptAnalysis)
+/*
+ReceiptImageRequestFingerprint returns a SHA-256 over everything that
+determines the result of GenerateReceiptAnalysisFromImage for these inputs:
+provider, model and its settings, and the full request (prompts, image,
+OCR text, schema). Run manifests compare it to decide whether the LLM stage
+must run again. The product memory hints are left out: the memory grows
+with every confirmed receipt and is applied to saved analyses without the
+LLM, so it must not make earlier runs look stale.
+*/
+func ReceiptImageRequestFingerprint(
+	imagePaths []string,
+	ocrText string,
+	priceCandidates []string,
+	taxonomy *category.Taxonomy,
+) (fingerprint string, e *xerr.Error) {
+	request, e := buildReceiptImageRequest(imagePaths, ocrText, priceCandidates, taxonomy, nil)
+	if e != nil {
+		return "", e
+	}
 
-	return receiptAnalysis, nil
+	return requestFingerprint(request)
 }
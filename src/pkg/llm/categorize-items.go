@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/category"
+	"expense-tracker/src/pkg/openai"
+)
+
+/*
+ItemCategory is the model's answer for one item name.
+
+Fields:
+  - Index: zero-based position of the name in the list that was sent.
+  - ProductNameEnglish: short English translation of the name.
+  - CategoryKey: one of the allowed category keys (or "other").
+*/
+type ItemCategory struct {
+	Index              int    `json:"index" desc:"Zero-based index of the item name in the numbered list."`
+	ProductNameEnglish string `json:"product_name_english" desc:"Short English translation of the product name."`
+	CategoryKey        string `json:"category_key" desc:"One of the allowed category keys or 'other'."`
+}
+
+/*
+ItemCategories is the structured output of CategorizeItemNames.
+*/
+type ItemCategories struct {
+	LLMRunMetadata *openai.LLMRunMetadata `json:"llm_run_metadata,omitempty" schema:"-"`
+	Items          []ItemCategory         `json:"items" desc:"One entry per item name, in the order of the list."`
+}
+
+/*
+CategorizeItemNames translates and categorizes product names whose prices
+are already known (e.g. from an electronic invoice). Only the names are sent
+to the LLM provider selected in Cfg.
+
+Parameters:
+  - names: product names as printed, in item order.
+  - taxonomy: categories to choose from; nil means category.Current.
+
+The result has one entry per name, ordered by Index; names the model left
+out get category "other".
+*/
+func CategorizeItemNames(names []string, taxonomy *category.Taxonomy) (itemCategories ItemCategories, e *xerr.Error) {
+	provider, e := NewProvider(Cfg)
+	if e != nil {
+		return itemCategories, e
+	}
+
+	tl.Log(
+		tl.Notice, palette.BlueBold, "%s with %s model %s ('%s' names)",
+		"Categorizing item names", provider.Name(), provider.Model(), len(names),
+	)
+
+	request, e := buildItemNamesRequest(names, taxonomy)
+	if e != nil {
+		return itemCategories, e
+	}
+
+	answer, llmRunMetadata, e := generateStructured[ItemCategories](provider, request)
+	if e != nil {
+		return itemCategories, e
+	}
+
+	// One entry per name, whatever order or gaps the model answered with.
+	itemCategories.LLMRunMetadata = llmRunMetadata
+	itemCategories.Items = make([]ItemCategory, len(names))
+	for index := range itemCategories.Items {
+		itemCategories.Items[index] = ItemCategory{Index: index, CategoryKey: "other"}
+	}
+	for _, item := range answer.Items {
+		if item.Index < 0 || item.Index >= len(names) {
+			continue
+		}
+		itemCategories.Items[item.Index] = item
+	}
+
+	tl.LogJSON(tl.Info, palette.Cyan, "ItemCategories", itemCategories)
+	return itemCategories, nil
+}
+
+/*
+buildItemNamesRequest builds the request CategorizeItemNames sends.
+*/
+func buildItemNamesRequest(names []string, taxonomy *category.Taxonomy) (request StructuredRequest, e *xerr.Error) {
+	var userTextBuilder strings.Builder
+	userTextBuilder.WriteString("Product names from an electronic invoice, numbered from 0:\n")
+	for index, name := range names {
+		userTextBuilder.WriteString(fmt.Sprintf("%d. %s\n", index, name))
+	}
+
+	instructions := fmt.Sprintf(`
+You are an assistant that categorizes purchased products.
+The user message is a numbered list of product names exactly as printed on an
+electronic invoice (mostly Colombian Spanish, often abbreviated or in capitals).
+
+For every name return:
+  - index: its number in the list.
+  - product_name_english: short English translation of the product name.
+  - category_key: one of the allowed category keys listed below (or "other" if nothing fits).
+
+Allowed category keys and descriptions:
+%s
+
+Rules:
+- Return exactly one entry per name, in list order.
+%s
+`, categoryBlock(taxonomy), categoryRules)
+
+	developerMessage := `
+Return only a single JSON object matching the provided schema.
+Do not include any additional commentary or explanation outside the JSON.
+`
+
+	schemaProperties, e := openai.SchemaPropertiesFromStruct(ItemCategories{})
+	if e != nil {
+		return request, e
+	}
+
+	request = StructuredRequest{
+		Instructions:     instructions,
+		DeveloperMessage: developerMessage,
+		UserText:         userTextBuilder.String(),
+		SchemaProperties: schemaProperties,
+	}
+	return request, nil
+}
+
+/*
+ItemNamesRequestFingerprint is ReceiptImageRequestFingerprint for
+CategorizeItemNames.
+*/
+func ItemNamesRequestFingerprint(names []string, taxonomy *category.Taxonomy) (fingerprint string, e *xerr.Error) {
+	request, e := buildItemNamesRequest(names, taxonomy)
+	if e != nil {
+		return "", e
+	}
+
+	return requestFingerprint(request)
+}
@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"fmt"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+
+	"expense-tracker/src/pkg/openai"
+)
+
+/*
+Config selects which LLM backend and model are used for receipt analysis.
+
+Set it in cfg/config.json under the "llm" key:
+
+	"llm": {
+	  "provider": "local",
+	  "model": "qwen2.5vl:7b",
+	  "local_base_url": "http://127.0.0.1:11434/v1"
+	}
+*/
+type Config struct {
+	Provider          ProviderName  `json:"provider,omitempty"`              // "openai" | "local"
+	Model             string        `json:"model,omitempty"`                 // e.g. "gpt-5-mini" or "qwen2.5vl:7b"
+	ReasoningEffort   openai.Effort `json:"reasoning_effort,omitempty"`      // only used by the openai provider
+	MaxOutputTokens   int           `json:"max_output_tokens,omitempty"`     // cap for the structured JSON output
+	LocalBaseURL      string        `json:"local_base_url,omitempty"`        // OpenAI-compatible base URL (Ollama, llama.cpp)
+	LocalAPIKeyEnvVar string        `json:"local_api_key_env_var,omitempty"` // env var holding the local API key, if any
+	RequestsPerMinute int           `json:"requests_per_minute,omitempty"`   // shared across all workers of the process; -1 disables the limit
+}
+
+func DefaultValueConfig() Config {
+	return Config{
+		Provider:          ProviderOpenAI,
+		Model:             "gpt-5-mini",
+		ReasoningEffort:   openai.EffortLow,
+		MaxOutputTokens:   4096,
+		LocalBaseURL:      "http://127.0.0.1:11434/v1",
+		LocalAPIKeyEnvVar: "LOCAL_LLM_API_KEY",
+		RequestsPerMinute: 20,
+	}
+}
+
+// create config with default values before config gets initialized
+var Cfg Config = DefaultValueConfig() // this one we use to access config values from anywhere
+
+/*
+If local Config is provided - use it. Replace all missing values with default ones.
+
+If not provided - just use defaultConfig.
+*/
+func InitializeConfig(localConfig *Config) {
+	// If not provided - just use defaultConfig
+	if localConfig == nil {
+		tl.Log(tl.Info, palette.Purple, "%s config is %s, keeping %s", "llm", "not provided", "default llm config")
+		return
+	}
+
+	defaultConfig := DefaultValueConfig() // Default values to replace some values with during config initialization
+
+	// If local Config is provided - use it
+	Cfg = *localConfig
+
+	tl.ApplyDefaults(&Cfg, defaultConfig, func(field string, defVal any) {
+		tl.Log(
+			tl.Info, palette.Purple,
+			"%s field is %s in %s configuration. Using default value: %v",
+			field, "missing", "llm", tl.PrettyForStderr(defVal),
+		)
+	})
+
+	tl.Log(tl.Info, palette.Green, "%s config was %s, using %s", "llm", "provided", "local llm config")
+	tl.LogJSON(tl.Verbose, palette.CyanDim, fmt.Sprintf("%s configuration", "llm"), Cfg)
+}
+
+/*
+RequiredEnvVars returns the environment variables the configured provider
+needs, so entrypoints can check them with config.CheckIfEnvVarsPresent.
+*/
+func RequiredEnvVars() []string {
+	if Cfg.Provider == ProviderOpenAI {
+		return []string{"OPENAI_API_KEY"}
+	}
+	return nil
+}
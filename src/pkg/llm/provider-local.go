@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"os"
+
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/openai"
+)
+
+/*
+localProvider talks to an OpenAI-compatible /chat/completions endpoint,
+e.g. Ollama ("http://127.0.0.1:11434/v1") or llama.cpp server
+("http://127.0.0.1:8080/v1"). Use a vision-capable model when images are sent.
+*/
+type localProvider struct {
+	model           string
+	baseURL         string
+	apiKey          string
+	maxOutputTokens int
+}
+
+func newLocalProvider(cfg Config) *localProvider {
+	apiKey := ""
+	if cfg.LocalAPIKeyEnvVar != "" {
+		apiKey = os.Getenv(cfg.LocalAPIKeyEnvVar)
+	}
+
+	return &localProvider{
+		model:           cfg.Model,
+		baseURL:         cfg.LocalBaseURL,
+		apiKey:          apiKey,
+		maxOutputTokens: cfg.MaxOutputTokens,
+	}
+}
+
+func (provider *localProvider) Name() ProviderName { return ProviderLocal }
+func (provider *localProvider) Model() string      { return provider.model }
+
+/*
+GenerateJSON sends instructions and developer message as system messages and
+the user text (+ images as image_url parts) as the user message, asking for
+a strict json_schema response_format.
+*/
+func (provider *localProvider) GenerateJSON(request StructuredRequest) (responseText string, meta openai.LLMRunMetadata, e *xerr.Error) {
+	var userContent any = request.UserText
+	if len(request.ImageDataURLs) > 0 {
+		parts := []openai.ChatContentPart{
+			{Type: "text", Text: request.UserText},
+		}
+		for _, imageDataURL := range request.ImageDataURLs {
+			parts = append(parts, openai.ChatContentPart{Type: "image_url", ImageURL: &openai.ChatImageURL{URL: imageDataURL}})
+		}
+		userContent = parts
+	}
+
+	payload := openai.ChatCompletionRequest{
+		Model: provider.model,
+		Messages: []openai.ChatMessage{
+			{Role: "system", Content: request.Instructions},
+			{Role: "system", Content: request.DeveloperMessage},
+			{Role: "user", Content: userContent},
+		},
+		ResponseFormat: &openai.ChatResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &openai.ChatJSONSchema{
+				Name:   "schema-name",
+				Schema: openai.StrictObj(request.SchemaProperties),
+				Strict: true,
+			},
+		},
+		MaxTokens: provider.maxOutputTokens,
+	}
+
+	return openai.SendChatCompletion(provider.baseURL, provider.apiKey, payload)
+}
@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"os"
+
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/openai"
+	"expense-tracker/src/pkg/util"
+)
+
+/*
+openAIProvider sends requests to the OpenAI Responses API.
+Reads the API key from OPENAI_API_KEY.
+*/
+type openAIProvider struct {
+	model           string
+	reasoningEffort openai.Effort
+	maxOutputTokens int
+}
+
+func newOpenAIProvider(cfg Config) *openAIProvider {
+	return &openAIProvider{
+		model:           cfg.Model,
+		reasoningEffort: cfg.ReasoningEffort,
+		maxOutputTokens: cfg.MaxOutputTokens,
+	}
+}
+
+func (provider *openAIProvider) Name() ProviderName { return ProviderOpenAI }
+func (provider *openAIProvider) Model() string      { return provider.model }
+
+/*
+GenerateJSON builds a Responses API request with a strict JSON schema and
+returns the raw output text. Images (if any) are sent as input_image parts
+after the user text.
+*/
+func (provider *openAIProvider) GenerateJSON(request StructuredRequest) (responseText string, meta openai.LLMRunMetadata, e *xerr.Error) {
+	schema := openai.StrictObj(request.SchemaProperties)
+	textOptions := openai.TextAsJSONSchema("schema-name", schema, true)
+
+	// Plain string when there are no images, text + images otherwise.
+	var userContent any = request.UserText
+	if len(request.ImageDataURLs) > 0 {
+		parts := []map[string]any{
+			{"type": "input_text", "text": request.UserText},
+		}
+		for _, imageDataURL := range request.ImageDataURLs {
+			parts = append(parts, map[string]any{"type": "input_image", "image_url": imageDataURL})
+		}
+		userContent = parts
+	}
+
+	maxOutputTokens := provider.maxOutputTokens
+	inputParameters := openai.InputParameters{
+		OpenAIAPIKey: os.Getenv("OPENAI_API_KEY"),
+		Model:        provider.model,
+		Reasoning:    &openai.Reasoning{Effort: util.Ptr(provider.reasoningEffort)},
+		Instructions: request.Instructions,
+		Input: []openai.InputItem{
+			{Role: openai.RoleDeveloper, Content: request.DeveloperMessage},
+			{Role: openai.RoleUser, Content: userContent},
+		},
+		Temperature:     util.Ptr(1.0), // with GPT-5 family have to pass 1.0 or omit. They do not support temperature.
+		MaxOutputTokens: &maxOutputTokens,
+		Text:            &textOptions,
+		ToolChoice:      "auto",
+	}
+
+	return openai.SendPromptReturnResponse(inputParameters)
+}
@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/openai"
+)
+
+// ProviderName identifies an LLM backend.
+type ProviderName string
+
+const (
+	ProviderOpenAI ProviderName = "openai" // OpenAI Responses API
+	ProviderLocal  ProviderName = "local"  // OpenAI-compatible local server (Ollama, llama.cpp)
+)
+
+var AllowedProviders = []ProviderName{ProviderOpenAI, ProviderLocal}
+
+/*
+StructuredRequest is a provider-agnostic request for a single structured
+(JSON schema) completion.
+
+Fields:
+  - Instructions: system-level instructions.
+  - DeveloperMessage: additional developer/system message.
+  - UserText: user message text.
+  - ImageDataURLs: optional images attached to the user message (data URLs).
+  - SchemaProperties: JSON schema properties of the expected output object.
+*/
+type StructuredRequest struct {
+	Instructions     string
+	DeveloperMessage string
+	UserText         string
+	ImageDataURLs    []string
+	SchemaProperties map[string]any
+}
+
+/*
+requestFingerprint returns a SHA-256 over the provider, the model and its
+settings, and the full request. See ReceiptImageRequestFingerprint.
+*/
+func requestFingerprint(request StructuredRequest) (fingerprint string, e *xerr.Error) {
+	fingerprintInput := struct {
+		Provider        ProviderName      `json:"provider"`
+		Model           string            `json:"model"`
+		ReasoningEffort openai.Effort     `json:"reasoning_effort"`
+		MaxOutputTokens int               `json:"max_output_tokens"`
+		Request         StructuredRequest `json:"request"`
+	}{Cfg.Provider, Cfg.Model, Cfg.ReasoningEffort, Cfg.MaxOutputTokens, request}
+
+	jsonBytes, marshalErr := json.Marshal(fingerprintInput)
+	if marshalErr != nil {
+		e = xerr.NewError(marshalErr, "marshal LLM request fingerprint", Cfg.Model)
+		return "", e
+	}
+
+	digest := sha256.Sum256(jsonBytes)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+/*
+Provider is an LLM backend able to return a JSON object matching a schema.
+
+Implementations return the raw JSON text; decoding into the target type is
+done by generateStructured so every provider behaves the same way.
+*/
+type Provider interface {
+	Name() ProviderName
+	Model() string
+	GenerateJSON(request StructuredRequest) (responseText string, meta openai.LLMRunMetadata, e *xerr.Error)
+}
+
+/*
+NewProvider builds the Provider selected in cfg.
+*/
+func NewProvider(cfg Config) (provider Provider, e *xerr.Error) {
+	switch cfg.Provider {
+	case ProviderOpenAI:
+		return newOpenAIProvider(cfg), nil
+	case ProviderLocal:
+		return newLocalProvider(cfg), nil
+	default:
+		e = xerr.NewError(
+			fmt.Errorf("unsupported LLM provider: '%s'", cfg.Provider),
+			fmt.Sprintf("Provider must be among those: %v", AllowedProviders),
+			cfg.Provider,
+		)
+		return nil, e
+	}
+}
+
+/*
+IsValidProvider checks if the given name matches a known provider.
+*/
+func IsValidProvider(name ProviderName) bool {
+	return slices.Contains(AllowedProviders, name)
+}
+
+/*
+generateStructured sends request through provider and decodes the JSON answer
+into T. The returned metadata has Provider filled in.
+
+Calls are spaced out by the shared request limiter (Cfg.RequestsPerMinute),
+so any number of concurrent callers stays within the configured rate.
+*/
+func generateStructured[T any](provider Provider, request StructuredRequest) (result T, llmRunMetadata *openai.LLMRunMetadata, e *xerr.Error) {
+	waitForRequestSlot()
+
+	responseText, runMetadata, e := provider.GenerateJSON(request)
+	if e != nil {
+		return result, nil, e
+	}
+	runMetadata.Provider = string(provider.Name())
+
+	tl.Log(tl.Info1, palette.Green, "%s from %s, id is '%s'", "Received response", provider.Name(), runMetadata.ResponseID)
+	tl.Log(tl.Verbose, palette.Cyan, "Response text:\n```\n%s\n```", responseText)
+
+	unmarshalErr := json.Unmarshal([]byte(responseText), &result)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "Unable to json.Unmarshal([]byte(responseText), &result)", responseText)
+		return result, &runMetadata, e
+	}
+
+	return result, &runMetadata, nil
+}
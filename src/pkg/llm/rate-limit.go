@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"sync"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"golang.org/x/time/rate"
+)
+
+var (
+	requestLimiter     *rate.Limiter
+	requestLimiterOnce sync.Once
+)
+
+/*
+getRequestLimiter returns the process-wide LLM request limiter, creating it
+from Cfg.RequestsPerMinute on first use (after config initialization).
+A negative value disables limiting; 0 (or leaving it out) gets the default
+from DefaultValueConfig when the config is loaded.
+*/
+func getRequestLimiter() *rate.Limiter {
+	requestLimiterOnce.Do(func() {
+		if Cfg.RequestsPerMinute <= 0 {
+			requestLimiter = rate.NewLimiter(rate.Inf, 1)
+			return
+		}
+		interval := time.Minute / time.Duration(Cfg.RequestsPerMinute)
+		requestLimiter = rate.NewLimiter(rate.Every(interval), 1)
+	})
+	return requestLimiter
+}
+
+/*
+waitForRequestSlot blocks until the next LLM request is allowed.
+*/
+func waitForRequestSlot() {
+	reservation := getRequestLimiter().Reserve()
+	delay := reservation.Delay()
+	if delay > 0 {
+		tl.Log(tl.Debug, palette.CyanDim, "%s for %s before the next LLM request", "Waiting", delay)
+	}
+
+	time.Sleep(delay)
+}
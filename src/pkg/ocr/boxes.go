@@ -0,0 +1,233 @@
+package ocr
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/otiai10/gosseract/v2"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/preprocess"
+)
+
+// BoxesFileName holds the word and line boxes of the OCR text in a run directory.
+const BoxesFileName = "ocr-boxes.json"
+
+/*
+Rect is an axis-aligned rectangle in original image pixels.
+*/
+type Rect struct {
+	Left   int `json:"left"`
+	Top    int `json:"top"`
+	Right  int `json:"right"`
+	Bottom int `json:"bottom"`
+}
+
+/*
+WordBox is one recognized word.
+
+Fields:
+  - Text, Confidence: as tesseract read it (confidence 0-100).
+  - Box: bounding rectangle on the original image.
+  - Polygon: the four corners of the word on the original image (top-left,
+    top-right, bottom-right, bottom-left); not axis-aligned when the image
+    was deskewed or perspective-warped.
+*/
+type WordBox struct {
+	Text       string    `json:"text"`
+	Confidence float64   `json:"confidence"`
+	Box        Rect      `json:"box"`
+	Polygon    [4][2]int `json:"polygon"`
+}
+
+/*
+LineBox is one recognized text line.
+
+Fields:
+  - Index: position in Boxes.Lines.
+  - OCRLine: zero-based line of ocr.txt with this text (what
+    receipt.Item.LineIndex refers to), -1 if it couldn't be matched.
+  - Text: words joined by single spaces.
+  - Confidence: mean word confidence.
+  - Box, Polygon: as for WordBox, covering all words.
+  - Words: the words of the line.
+  - Image: for receipts stitched from several photos, the photo the line
+    is on (relative to the run directory); "" means Boxes.ImagePath.
+*/
+type LineBox struct {
+	Index      int       `json:"index"`
+	OCRLine    int       `json:"ocr_line"`
+	Text       string    `json:"text"`
+	Confidence float64   `json:"confidence"`
+	Box        Rect      `json:"box"`
+	Polygon    [4][2]int `json:"polygon"`
+	Words      []WordBox `json:"words"`
+	Image      string    `json:"image,omitempty"`
+}
+
+/*
+Boxes is <run dir>/ocr-boxes.json.
+
+Fields:
+  - ImagePath: the original image in the run directory (orig.<ext>; for
+    stitched receipts the first part's, see LineBox.Image).
+  - ImageWidth, ImageHeight: its size after EXIF orientation; all
+    coordinates are in these pixels.
+  - Lines: recognized lines in reading order.
+*/
+type Boxes struct {
+	ImagePath   string    `json:"image_path"`
+	ImageWidth  int       `json:"image_width"`
+	ImageHeight int       `json:"image_height"`
+	Lines       []LineBox `json:"lines"`
+}
+
+/*
+buildBoxes groups tesseract words (processed image pixels) into lines, maps
+them onto the original image with geometry and matches each line to its
+line in ocrText.
+*/
+func buildBoxes(words []gosseract.BoundingBox, geometry preprocess.Geometry, ocrText string, originalImagePath string) (boxes Boxes) {
+	boxes = Boxes{
+		ImagePath:   filepath.Base(originalImagePath),
+		ImageWidth:  geometry.SourceWidth,
+		ImageHeight: geometry.SourceHeight,
+		Lines:       []LineBox{},
+	}
+
+	var lineWords []gosseract.BoundingBox
+	flush := func() {
+		if len(lineWords) == 0 {
+			return
+		}
+		line := LineBox{Index: len(boxes.Lines), OCRLine: -1}
+		lineRect := lineWords[0].Box
+		var texts []string
+		for _, word := range lineWords {
+			wordBox := WordBox{Text: word.Word, Confidence: word.Confidence}
+			wordBox.Polygon, wordBox.Box = mapRect(word.Box.Min.X, word.Box.Min.Y, word.Box.Max.X, word.Box.Max.Y, geometry)
+			line.Words = append(line.Words, wordBox)
+			line.Confidence += word.Confidence / float64(len(lineWords))
+			lineRect = lineRect.Union(word.Box)
+			texts = append(texts, word.Word)
+		}
+		line.Text = strings.Join(texts, " ")
+		line.Polygon, line.Box = mapRect(lineRect.Min.X, lineRect.Min.Y, lineRect.Max.X, lineRect.Max.Y, geometry)
+		boxes.Lines = append(boxes.Lines, line)
+		lineWords = lineWords[:0]
+	}
+	for index, word := range words {
+		if index > 0 {
+			previous := words[index-1]
+			if word.BlockNum != previous.BlockNum || word.ParNum != previous.ParNum || word.LineNum != previous.LineNum {
+				flush()
+			}
+		}
+		lineWords = append(lineWords, word)
+	}
+	flush()
+
+	matchOCRLines(boxes.Lines, ocrText)
+	return boxes
+}
+
+/*
+matchOCRLines sets OCRLine of every line. Tesseract writes the lines to the
+text in the same order, one per line, with blank lines between blocks, so
+lines are matched in order; a line whose words don't match the next text
+line is looked for a few lines further before falling back to that one.
+*/
+func matchOCRLines(lines []LineBox, ocrText string) {
+	textLines := strings.Split(ocrText, "\n")
+	next := 0
+	for index := range lines {
+		for next < len(textLines) && strings.TrimSpace(textLines[next]) == "" {
+			next++
+		}
+		if next >= len(textLines) {
+			return
+		}
+
+		matched := next
+		for candidate := next; candidate < len(textLines) && candidate <= next+3; candidate++ {
+			if strings.Join(strings.Fields(textLines[candidate]), " ") == lines[index].Text {
+				matched = candidate
+				break
+			}
+		}
+		lines[index].OCRLine = matched
+		next = matched + 1
+	}
+}
+
+/*
+mapRect maps a processed image rectangle onto the original image.
+*/
+func mapRect(left, top, right, bottom int, geometry preprocess.Geometry) (polygon [4][2]int, box Rect) {
+	corners := [4][2]float64{
+		{float64(left), float64(top)},
+		{float64(right), float64(top)},
+		{float64(right), float64(bottom)},
+		{float64(left), float64(bottom)},
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for index, corner := range corners {
+		x, y := geometry.ToSource(corner[0], corner[1])
+		x = min(max(x, 0), float64(geometry.SourceWidth))
+		y = min(max(y, 0), float64(geometry.SourceHeight))
+		polygon[index] = [2]int{int(math.Round(x)), int(math.Round(y))}
+		minX, minY = min(minX, x), min(minY, y)
+		maxX, maxY = max(maxX, x), max(maxY, y)
+	}
+
+	box = Rect{
+		Left:   int(math.Floor(minX)),
+		Top:    int(math.Floor(minY)),
+		Right:  int(math.Ceil(maxX)),
+		Bottom: int(math.Ceil(maxY)),
+	}
+	return polygon, box
+}
+
+/*
+LoadBoxes reads <run dir>/ocr-boxes.json. found is false for run
+directories OCR'd before boxes were saved.
+*/
+func LoadBoxes(runDirPath string) (boxes Boxes, found bool, e *xerr.Error) {
+	boxesPath := filepath.Join(runDirPath, BoxesFileName)
+	jsonBytes, readErr := os.ReadFile(boxesPath)
+	if os.IsNotExist(readErr) {
+		return boxes, false, nil
+	}
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read OCR boxes", boxesPath)
+		return boxes, false, e
+	}
+
+	unmarshalErr := json.Unmarshal(jsonBytes, &boxes)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "parse OCR boxes", boxesPath)
+		return boxes, false, e
+	}
+	return boxes, true, nil
+}
+
+/*
+LineForOCRLine returns the line box of ocr.txt line ocrLine.
+*/
+func (boxes Boxes) LineForOCRLine(ocrLine int) (line LineBox, found bool) {
+	if ocrLine < 0 {
+		return line, false
+	}
+	for _, candidate := range boxes.Lines {
+		if candidate.OCRLine == ocrLine {
+			return candidate, true
+		}
+	}
+	return line, false
+}
@@ -2,9 +2,12 @@ package ocr
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 
 	tl "github.com/tuumbleweed/tintlog/logger"
 	"github.com/tuumbleweed/tintlog/palette"
@@ -31,6 +34,40 @@ func ensureOutputDirectory(outputDirPath string) (e *xerr.Error) {
 	return e
 }
 
+/*
+createRunDirectory creates a new per-run directory named baseName inside
+rootDirPath. If that name is already taken (several images started within
+the same second, e.g. with concurrent workers) a numeric suffix is added:
+baseName-2, baseName-3, ...
+
+os.Mkdir fails on existing directories, so two goroutines never end up
+sharing a run directory.
+*/
+func createRunDirectory(rootDirPath, baseName string) (runDirPath string, e *xerr.Error) {
+	for attempt := 1; attempt <= 1000; attempt++ {
+		dirName := baseName
+		if attempt > 1 {
+			dirName = fmt.Sprintf("%s-%d", baseName, attempt)
+		}
+		runDirPath = filepath.Join(rootDirPath, dirName)
+
+		mkdirErr := os.Mkdir(runDirPath, 0o755)
+		if errors.Is(mkdirErr, fs.ErrExist) {
+			continue
+		}
+		if mkdirErr != nil {
+			e = xerr.NewError(mkdirErr, "create run directory", runDirPath)
+			return runDirPath, e
+		}
+
+		tl.Log(tl.Info1, palette.Blue, "Created run directory '%s'", runDirPath)
+		return runDirPath, nil
+	}
+
+	err := fmt.Errorf("too many run directories named '%s'", baseName)
+	e = xerr.NewError(err, "create run directory", rootDirPath)
+	return "", e
+}
 
 /*
 copyOriginalImage copies the input image file into the target path.
@@ -121,3 +158,33 @@ func saveJSONToFile(destinationPath string, value any) (e *xerr.Error) {
 
 	return e
 }
+
+/*
+readTextFile returns the contents of a text artifact (ocr.txt, ...).
+*/
+func readTextFile(filePath string) (text string, e *xerr.Error) {
+	textBytes, readErr := os.ReadFile(filePath)
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read text file", filePath)
+		return "", e
+	}
+	return string(textBytes), nil
+}
+
+/*
+loadJSONFromFile reads a JSON artifact into value.
+*/
+func loadJSONFromFile(filePath string, value any) (e *xerr.Error) {
+	jsonBytes, readErr := os.ReadFile(filePath)
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read JSON file", filePath)
+		return e
+	}
+
+	unmarshalErr := json.Unmarshal(jsonBytes, value)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "parse JSON file", filePath)
+		return e
+	}
+	return nil
+}
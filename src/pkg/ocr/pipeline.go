@@ -9,6 +9,9 @@ import (
 	tl "github.com/tuumbleweed/tintlog/logger"
 	"github.com/tuumbleweed/tintlog/palette"
 	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/inputfile"
+	"expense-tracker/src/pkg/preprocess"
 )
 
 /*
@@ -18,10 +21,16 @@ It performs the following steps:
   1. Validates the input image path.
   2. Ensures the root output directory exists.
   3. Creates a per-run directory under the root, named by timestamp.
-  4. Copies the original image into that run directory as orig.<ext>.
+  4. Copies the original image into that run directory as orig.<ext>
+     (PDF, HEIC and WebP inputs are kept as source.<ext> and converted to
+     orig.png or orig.jpg; a PDF text layer replaces steps 5-6).
   5. Creates a processed version of the image in that run directory as clean.png.
-  6. Runs OCR on clean.png using gosseract.
-  7. Saves the OCR text into ocr.txt in the same run directory.
+  6. Runs OCR on clean.png using gosseract; when its quality score is low,
+     alternate preprocessing profiles and page segmentation modes are tried
+     and the best result is kept.
+  7. Saves the OCR text into ocr.txt, the quality into ocr-quality.json and
+     word/line boxes (original image coordinates) into ocr-boxes.json in the
+     same run directory.
 
 If any step fails, it returns a *xerr.Error describing the problem.
 */
@@ -31,17 +40,26 @@ func ProcessImage(imagePath, outputDirPath, language string) (runDirPath string,
         return
     }
 
-	// Normalize and log initial intent.
+	runDirPath, e = CreateRunDir(outputDirPath)
+	if e != nil {
+		return runDirPath, e
+	}
+
+	e = ProcessImageInRunDir(imagePath, runDirPath, language)
+	return runDirPath, e
+}
+
+/*
+CreateRunDir creates a new run directory under outputDirPath (default
+./out), named by the current time.
+*/
+func CreateRunDir(outputDirPath string) (runDirPath string, e *xerr.Error) {
+	// Normalize the root.
 	normalizedOutputDirPath := strings.TrimSpace(outputDirPath)
 	if normalizedOutputDirPath == "" {
 		normalizedOutputDirPath = "./out"
 	}
 
-	tl.Log(
-		tl.Notice, palette.BlueBold, "%s image processing for '%s' into root '%s'",
-		"Starting", imagePath, normalizedOutputDirPath,
-	)
-
 	// Ensure root output directory exists (e.g. ./out).
 	e = ensureOutputDirectory(normalizedOutputDirPath)
 	if e != nil {
@@ -53,48 +71,64 @@ func ProcessImage(imagePath, outputDirPath, language string) (runDirPath string,
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
 
 	// Per-run directory inside the root, e.g. ./out/2025-11-26_16-35-31
-	runDirPath = filepath.Join(normalizedOutputDirPath, timestamp)
+	// (./out/2025-11-26_16-35-31-2 if another image started in the same second).
+	return createRunDirectory(normalizedOutputDirPath, timestamp)
+}
 
-	// Ensure per-run directory exists.
-	e = ensureOutputDirectory(runDirPath)
+/*
+ProcessImageInRunDir runs steps 4-7 of ProcessImage into an existing run
+directory, overwriting earlier OCR artifacts (used to redo OCR when resuming).
+*/
+func ProcessImageInRunDir(imagePath, runDirPath, language string) (e *xerr.Error) {
+	e = validateImagePath(imagePath)
 	if e != nil {
-		return runDirPath, e
+		return e
 	}
 
-	// Determine original extension (keep the dot).
-	originalExt := strings.ToLower(filepath.Ext(imagePath))
-	if originalExt == "" {
-		originalExt = ".jpg"
-	}
+	tl.Log(
+		tl.Notice, palette.BlueBold, "%s image processing for '%s' into '%s'",
+		"Starting", imagePath, runDirPath,
+	)
 
 	// Build all output paths inside the per-run directory.
-	originalOutPath := filepath.Join(runDirPath, "orig"+originalExt)
 	processedOutPath := filepath.Join(runDirPath, "clean.png")
 	ocrOutPath := filepath.Join(runDirPath, "ocr.txt")
 	ocrNumbersOutPath := filepath.Join(runDirPath, "numbers-ocr.txt")
 	pricesPath := filepath.Join(runDirPath, "prices.json")
+	qualityPath := filepath.Join(runDirPath, QualityFileName)
+	boxesPath := filepath.Join(runDirPath, BoxesFileName)
 
-	// Copy original image to the run directory.
-	e = copyOriginalImage(imagePath, originalOutPath)
+	converted, e := copyInputToRunDir(imagePath, runDirPath)
 	if e != nil {
-		return runDirPath, e
+		return e
+	}
+	originalOutPath := converted.ImagePath
+
+	// PDFs with a text layer need no OCR: their text and word positions are exact.
+	if converted.TextLayer != "" {
+		e = saveTextLayer(converted, runDirPath)
+		if e != nil {
+			return e
+		}
+		tl.Log(
+			tl.Info1, palette.Green, "Used the PDF text layer of '%s' ('%s' pages). Run dir: '%s', image: '%s'",
+			imagePath, converted.Pages, runDirPath, originalOutPath,
+		)
+		return nil
 	}
 
-	// Create a processed version of the image for better OCR.
-	e = createProcessedImage(imagePath, processedOutPath)
+	// Create a processed version of the image for better OCR and run OCR
+	// on it, retrying other preprocessing when the result reads badly.
+	best, qualityReport, e := runBestOcr(originalOutPath, processedOutPath, language, preprocess.Cfg)
 	if e != nil {
-		return runDirPath, e
+		return e
 	}
+	ocrText := best.text
 
-	// Run OCR on the processed image.
-	var numbersOcr, ocrText string
+	var numbersOcr string
 	numbersOcr, e = runOcrForNumbers(processedOutPath)
 	if e != nil {
-		return runDirPath, e
-	}
-	ocrText, e = runOcrOnImage(processedOutPath, language)
-	if e != nil {
-		return runDirPath, e
+		return e
 	}
 
 	prices := ExtractPriceCandidates(numbersOcr)
@@ -103,19 +137,31 @@ func ProcessImage(imagePath, outputDirPath, language string) (runDirPath string,
 	// Save OCR result into a text file.
 	e = saveOcrTextToFile(ocrNumbersOutPath, numbersOcr)
 	if e != nil {
-		return runDirPath, e
+		return e
 	}
 
 	// Save OCR result into a text file.
 	e = saveOcrTextToFile(ocrOutPath, ocrText)
 	if e != nil {
-		return runDirPath, e
+		return e
 	}
 
 	// Save OCR result into a text file.
 	e = saveJSONToFile(pricesPath, prices)
 	if e != nil {
-		return runDirPath, e
+		return e
+	}
+
+	// Save how readable the OCR was, and what was tried.
+	e = saveJSONToFile(qualityPath, qualityReport)
+	if e != nil {
+		return e
+	}
+
+	// Save where every word and line is on the original image.
+	e = saveJSONToFile(boxesPath, buildBoxes(best.words, best.geometry, ocrText, originalOutPath))
+	if e != nil {
+		return e
 	}
 
 	tl.Log(
@@ -123,7 +169,34 @@ func ProcessImage(imagePath, outputDirPath, language string) (runDirPath string,
 		imagePath, runDirPath, originalOutPath, processedOutPath, ocrOutPath,
 	)
 
-	return runDirPath, e
+	return e
+}
+
+/*
+copyInputToRunDir copies the input into the run directory as orig.<ext>.
+PDFs, HEIC and WebP are kept as source.<ext> and converted into
+orig.png/orig.jpg, the image the OCR and LLM stages work on.
+*/
+func copyInputToRunDir(imagePath, runDirPath string) (converted inputfile.Converted, e *xerr.Error) {
+	// Determine original extension (keep the dot).
+	originalExt := strings.ToLower(filepath.Ext(imagePath))
+	if originalExt == "" {
+		originalExt = ".jpg"
+	}
+
+	if kind, _ := inputfile.KindOf(originalExt); kind != inputfile.KindImage {
+		sourceOutPath := filepath.Join(runDirPath, SourceFileBaseName+originalExt)
+		e = copyOriginalImage(imagePath, sourceOutPath)
+		if e != nil {
+			return converted, e
+		}
+		return inputfile.Convert(sourceOutPath, runDirPath, "orig")
+	}
+
+	// Copy original image to the run directory.
+	converted.ImagePath = filepath.Join(runDirPath, "orig"+originalExt)
+	e = copyOriginalImage(imagePath, converted.ImagePath)
+	return converted, e
 }
 
 /*
@@ -0,0 +1,265 @@
+package ocr
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/otiai10/gosseract/v2"
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/preprocess"
+)
+
+const (
+	QualityFileName = "ocr-quality.json"
+
+	// Words a readable receipt has at least; fewer scale the score down.
+	minUsefulWords = 15
+	// Tesseract confidence below which a word counts as a likely misread.
+	lowConfidence = 60
+)
+
+// ConfiguredProfile names the attempt with the steps configured directly under "preprocess".
+const ConfiguredProfile = "configured"
+
+/*
+Quality summarizes how readable tesseract found an image.
+
+Fields:
+  - Score: 0-100; MeanConfidence scaled down when fewer than 15 words were
+    read (an almost empty result can be confidently wrong).
+  - MeanConfidence: per-word tesseract confidence, weighted by word length,
+    over words with at least one letter or digit.
+  - Words: such words.
+  - LowConfidenceWords: those with confidence below 60.
+*/
+type Quality struct {
+	Score              float64 `json:"score"`
+	MeanConfidence     float64 `json:"mean_confidence"`
+	Words              int     `json:"words"`
+	LowConfidenceWords int     `json:"low_confidence_words"`
+}
+
+/*
+Attempt is one preprocessing profile + page segmentation mode combination.
+*/
+type Attempt struct {
+	Profile     string  `json:"profile"`
+	PageSegMode string  `json:"page_seg_mode"`
+	Quality     Quality `json:"quality"`
+	Error       string  `json:"error,omitempty"`
+
+	imagePath string
+	text      string
+	words     []gosseract.BoundingBox
+	geometry  preprocess.Geometry
+}
+
+/*
+QualityReport is <run dir>/ocr-quality.json: the attempt that was kept
+(its text is ocr.txt, its image clean.png) and every attempt made.
+*/
+type QualityReport struct {
+	Quality
+	Profile         string    `json:"profile"`
+	PageSegMode     string    `json:"page_seg_mode"`
+	MinQualityScore float64   `json:"min_quality_score"`
+	Attempts        []Attempt `json:"attempts"`
+}
+
+/*
+LoadQuality reads <run dir>/ocr-quality.json. found is false for run
+directories OCR'd before the quality was saved.
+*/
+func LoadQuality(runDirPath string) (report QualityReport, found bool, e *xerr.Error) {
+	qualityPath := filepath.Join(runDirPath, QualityFileName)
+	jsonBytes, readErr := os.ReadFile(qualityPath)
+	if os.IsNotExist(readErr) {
+		return report, false, nil
+	}
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read OCR quality", qualityPath)
+		return report, false, e
+	}
+
+	unmarshalErr := json.Unmarshal(jsonBytes, &report)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "parse OCR quality", qualityPath)
+		return report, false, e
+	}
+	return report, true, nil
+}
+
+// pageSegModes maps config names to tesseract --psm values.
+var pageSegModes = map[string]gosseract.PageSegMode{
+	"auto":          gosseract.PSM_AUTO,          // 3
+	"single_column": gosseract.PSM_SINGLE_COLUMN, // 4
+	"single_block":  gosseract.PSM_SINGLE_BLOCK,  // 6
+	"sparse_text":   gosseract.PSM_SPARSE_TEXT,   // 11
+}
+
+/*
+scoreWords computes the Quality of word-level OCR results.
+*/
+func scoreWords(wordBoxes []gosseract.BoundingBox) (quality Quality) {
+	var weightedConfidence, totalWeight float64
+	for _, wordBox := range wordBoxes {
+		isWord := false
+		for _, character := range wordBox.Word {
+			if unicode.IsLetter(character) || unicode.IsDigit(character) {
+				isWord = true
+				break
+			}
+		}
+		if !isWord {
+			continue
+		}
+
+		weight := float64(utf8.RuneCountInString(wordBox.Word))
+		weightedConfidence += wordBox.Confidence * weight
+		totalWeight += weight
+		quality.Words++
+		if wordBox.Confidence < lowConfidence {
+			quality.LowConfidenceWords++
+		}
+	}
+	if totalWeight == 0 {
+		return quality
+	}
+
+	quality.MeanConfidence = weightedConfidence / totalWeight
+	quality.Score = quality.MeanConfidence * min(float64(quality.Words)/minUsefulWords, 1)
+	return quality
+}
+
+/*
+runBestOcr preprocesses and OCRs the image with the configured steps and the
+first page segmentation mode. While the quality stays below
+config.MinQualityScore (and config.OCRAttempts allows) it tries:
+
+ 1. each retry profile with the first page segmentation mode,
+ 2. the other page segmentation modes on the best image so far.
+
+The best image ends up at processedOutPath; the others are removed unless
+intermediate images are kept. Only a failure of the first attempt is
+returned as an error; later failures are recorded in the report.
+*/
+func runBestOcr(imagePath, processedOutPath, language string, config preprocess.Config) (best Attempt, report QualityReport, e *xerr.Error) {
+	report.MinQualityScore = config.MinQualityScore
+	if len(config.PageSegModes) == 0 {
+		config.PageSegModes = []string{"single_block"}
+	}
+	firstMode := config.PageSegModes[0]
+
+	best, e = runAttempt(imagePath, processedOutPath, language, ConfiguredProfile, config, firstMode, true)
+	report.Attempts = append(report.Attempts, best)
+	if e != nil {
+		return best, report, e
+	}
+
+	good := func() bool {
+		return best.Quality.Score >= config.MinQualityScore || len(report.Attempts) >= config.OCRAttempts
+	}
+	keep := func(attempt Attempt) {
+		report.Attempts = append(report.Attempts, attempt)
+		if attempt.Error == "" && attempt.Quality.Score > best.Quality.Score {
+			best = attempt
+		}
+	}
+
+	runDirPath := filepath.Dir(processedOutPath)
+	for _, profile := range config.RetryProfiles {
+		if good() {
+			break
+		}
+		tl.Log(
+			tl.Info1, palette.Yellow, "OCR quality '%s' is below '%s', trying profile '%s'",
+			fmt.Sprintf("%.1f", best.Quality.Score), fmt.Sprintf("%.1f", config.MinQualityScore), profile.Name,
+		)
+		profileImagePath := filepath.Join(runDirPath, "clean-"+profile.Name+".png")
+		attempt, _ := runAttempt(imagePath, profileImagePath, language, profile.Name, config.WithProfile(profile), firstMode, true)
+		keep(attempt)
+	}
+
+	bestImage := best
+	for _, mode := range config.PageSegModes[1:] {
+		if good() {
+			break
+		}
+		tl.Log(
+			tl.Info1, palette.Yellow, "OCR quality '%s' is below '%s', trying page segmentation '%s'",
+			fmt.Sprintf("%.1f", best.Quality.Score), fmt.Sprintf("%.1f", config.MinQualityScore), mode,
+		)
+		attempt, _ := runAttempt(imagePath, bestImage.imagePath, language, bestImage.Profile, config, mode, false)
+		attempt.geometry = bestImage.geometry
+		keep(attempt)
+	}
+
+	// The kept image becomes clean.png, alternatives go away.
+	for _, attempt := range report.Attempts {
+		if attempt.imagePath == processedOutPath || attempt.imagePath == best.imagePath || config.SaveIntermediates {
+			continue
+		}
+		_ = os.Remove(attempt.imagePath)
+	}
+	if best.imagePath != processedOutPath {
+		renameErr := os.Rename(best.imagePath, processedOutPath)
+		if renameErr != nil {
+			e = xerr.NewError(renameErr, "replace processed image with the best attempt", best.imagePath)
+			return best, report, e
+		}
+	}
+
+	report.Quality = best.Quality
+	report.Profile = best.Profile
+	report.PageSegMode = best.PageSegMode
+	if best.Quality.Score < config.MinQualityScore {
+		tl.Log(
+			tl.Warning, palette.PurpleBold, "OCR quality of '%s' is low ('%s' after '%s' attempts), the analysis may be unreliable",
+			imagePath, fmt.Sprintf("%.1f", best.Quality.Score), len(report.Attempts),
+		)
+	}
+
+	return best, report, nil
+}
+
+/*
+runAttempt OCRs processedPath with mode, creating it from imagePath with
+config first when preprocessImage is set.
+*/
+func runAttempt(imagePath, processedPath, language, profileName string, config preprocess.Config, mode string, preprocessImage bool) (attempt Attempt, e *xerr.Error) {
+	attempt = Attempt{Profile: profileName, PageSegMode: mode, imagePath: processedPath}
+	defer func() {
+		if e != nil {
+			attempt.Error = fmt.Sprintf("%s: %s", e.Msg, e.ErrStr)
+			tl.Log(tl.Warning, palette.PurpleBright, "OCR attempt '%s'/'%s' failed: %s", profileName, mode, attempt.Error)
+		}
+	}()
+
+	pageSegMode, known := pageSegModes[mode]
+	if !known {
+		e = xerr.NewError(fmt.Errorf("unknown page segmentation mode (want auto, single_column, single_block or sparse_text)"), "run OCR", mode)
+		return attempt, e
+	}
+
+	if preprocessImage {
+		attempt.geometry, e = createProcessedImage(imagePath, processedPath, config)
+		if e != nil {
+			return attempt, e
+		}
+	}
+
+	attempt.text, attempt.words, e = runOcrOnImage(processedPath, language, pageSegMode)
+	if e != nil {
+		return attempt, e
+	}
+	attempt.Quality = scoreWords(attempt.words)
+	tl.Log(tl.Info1, palette.Green, "OCR attempt '%s'/'%s' quality: '%s'", profileName, mode, fmt.Sprintf("%.1f", attempt.Quality.Score))
+	return attempt, nil
+}
@@ -0,0 +1,135 @@
+package ocr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/inputfile"
+	"expense-tracker/src/pkg/preprocess"
+)
+
+// SplitFileName lists the receipts found in the photo of a run directory.
+const SplitFileName = "split.json"
+
+/*
+SplitReceipt is one receipt of a photo with several receipts.
+
+Fields:
+  - Dir: its own run directory inside the photo's (receipt-1, ...).
+  - ImagePath: its crop of the photo (receipt-1.png, ...), the input of
+    that run directory.
+  - Region: where the crop is on the photo, in orig.* pixels.
+*/
+type SplitReceipt struct {
+	Dir       string `json:"dir"`
+	ImagePath string `json:"image_path"`
+	Region    Rect   `json:"region"`
+}
+
+/*
+Split is <run dir>/split.json. Paths are relative to the run directory;
+Receipts is empty when the photo is a single receipt.
+*/
+type Split struct {
+	ImagePath   string         `json:"image_path"`
+	ImageWidth  int            `json:"image_width"`
+	ImageHeight int            `json:"image_height"`
+	Receipts    []SplitReceipt `json:"receipts"`
+}
+
+/*
+ReceiptDirName returns the run directory name of receipt number (1-based)
+of a split photo.
+*/
+func ReceiptDirName(number int) string {
+	return fmt.Sprintf("receipt-%d", number)
+}
+
+/*
+SplitReceiptsInRunDir copies imagePath into the run directory as orig.*
+(like ProcessImageInRunDir) and looks for several receipts on it with
+preprocess.FindReceipts. When it finds two or more, every receipt is cropped
+into receipt-N.png and gets an empty receipt-N run directory to be
+processed in. split.json is written either way. PDFs are never split.
+*/
+func SplitReceiptsInRunDir(imagePath, runDirPath string, config preprocess.Config) (split Split, e *xerr.Error) {
+	split.Receipts = []SplitReceipt{}
+	if kind, _ := inputfile.KindOf(filepath.Ext(imagePath)); kind == inputfile.KindPDF {
+		return split, saveJSONToFile(filepath.Join(runDirPath, SplitFileName), split)
+	}
+
+	converted, e := copyInputToRunDir(imagePath, runDirPath)
+	if e != nil {
+		return split, e
+	}
+	photo, openErr := imaging.Open(converted.ImagePath, imaging.AutoOrientation(true))
+	if openErr != nil {
+		e = xerr.NewError(openErr, "open image to look for several receipts", converted.ImagePath)
+		return split, e
+	}
+	split.ImagePath = filepath.Base(converted.ImagePath)
+	split.ImageWidth, split.ImageHeight = photo.Bounds().Dx(), photo.Bounds().Dy()
+
+	regions := preprocess.FindReceipts(photo, config.MinReceiptArea)
+	for index, region := range regions {
+		splitReceipt := SplitReceipt{
+			Dir:       ReceiptDirName(index + 1),
+			ImagePath: ReceiptDirName(index+1) + ".png",
+			Region:    Rect{Left: region.Min.X, Top: region.Min.Y, Right: region.Max.X, Bottom: region.Max.Y},
+		}
+
+		cropPath := filepath.Join(runDirPath, splitReceipt.ImagePath)
+		saveErr := imaging.Save(imaging.Crop(photo, region), cropPath)
+		if saveErr != nil {
+			e = xerr.NewError(saveErr, "save receipt crop", cropPath)
+			return split, e
+		}
+		receiptDirPath := filepath.Join(runDirPath, splitReceipt.Dir)
+		mkdirErr := os.MkdirAll(receiptDirPath, 0o755)
+		if mkdirErr != nil {
+			e = xerr.NewError(mkdirErr, "create receipt run directory", receiptDirPath)
+			return split, e
+		}
+		split.Receipts = append(split.Receipts, splitReceipt)
+	}
+
+	e = saveJSONToFile(filepath.Join(runDirPath, SplitFileName), split)
+	if e != nil {
+		return split, e
+	}
+
+	if len(split.Receipts) > 0 {
+		dirs := make([]string, len(split.Receipts))
+		for index, splitReceipt := range split.Receipts {
+			dirs[index] = splitReceipt.Dir
+		}
+		tl.Log(
+			tl.Info1, palette.Green, "Found '%s' receipts in '%s', split into '%s'",
+			len(split.Receipts), imagePath, strings.Join(dirs, "', '"),
+		)
+	}
+	return split, nil
+}
+
+/*
+LoadSplit reads split.json of a run directory. found is false when the
+photo was never checked for several receipts.
+*/
+func LoadSplit(runDirPath string) (split Split, found bool, e *xerr.Error) {
+	splitPath := filepath.Join(runDirPath, SplitFileName)
+	if _, statErr := os.Stat(splitPath); os.IsNotExist(statErr) {
+		return split, false, nil
+	}
+	e = loadJSONFromFile(splitPath, &split)
+	if e != nil {
+		return split, false, e
+	}
+	return split, true, nil
+}
@@ -0,0 +1,361 @@
+package ocr
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+)
+
+const (
+	// StitchFileName describes how the parts of a stitched receipt were merged.
+	StitchFileName = "stitch.json"
+
+	// Lines at the end of one part and the start of the next searched for the overlap.
+	overlapWindow = 40
+	// Lines of a part allowed after its overlap with the next part (cut-off lines at the photo edge).
+	maxTrailingLines = 3
+	// Normalized characters a one-line overlap needs, so "TOTAL" alone doesn't glue parts together.
+	minSingleLineOverlap = 12
+	// Minimum similarity (1 - edit distance / length) of two OCR readings of the same line.
+	minLineSimilarity = 0.75
+)
+
+/*
+ErrNoOverlap is returned by ProcessImagesInRunDir when two consecutive
+photos share no text: they are most likely not parts of the same receipt.
+*/
+var ErrNoOverlap = errors.New("consecutive photos share no text")
+
+/*
+StitchPart is one photo of a receipt photographed in several parts.
+
+Fields:
+  - ImagePath: the input photo.
+  - Dir: its directory inside the run directory (part-1, part-2, ...),
+    holding the usual OCR artifacts of that photo.
+  - OverlapLines: lines at its top that were also at the bottom of the
+    previous part and were dropped (0 for the first part).
+  - QualityScore: OCR quality score of the part.
+*/
+type StitchPart struct {
+	ImagePath    string  `json:"image_path"`
+	Dir          string  `json:"dir"`
+	OverlapLines int     `json:"overlap_lines"`
+	QualityScore float64 `json:"quality_score"`
+}
+
+/*
+Stitch is <run dir>/stitch.json.
+*/
+type Stitch struct {
+	Parts []StitchPart `json:"parts"`
+}
+
+/*
+PartDirName returns the directory name of part number (1-based) of a
+stitched receipt.
+*/
+func PartDirName(number int) string {
+	return fmt.Sprintf("part-%d", number)
+}
+
+/*
+FindPartDirs returns the part directories of a stitched run directory in
+part order (none for single-photo runs).
+*/
+func FindPartDirs(runDirPath string) (partDirPaths []string, e *xerr.Error) {
+	pattern := filepath.Join(runDirPath, "part-*")
+	matches, globErr := filepath.Glob(pattern)
+	if globErr != nil {
+		e = xerr.NewError(globErr, "glob for receipt part directories", pattern)
+		return nil, e
+	}
+
+	numbers := make(map[string]int)
+	for _, match := range matches {
+		var number int
+		_, scanErr := fmt.Sscanf(filepath.Base(match), "part-%d", &number)
+		if scanErr != nil {
+			continue
+		}
+		numbers[match] = number
+		partDirPaths = append(partDirPaths, match)
+	}
+	sort.Slice(partDirPaths, func(i, j int) bool { return numbers[partDirPaths[i]] < numbers[partDirPaths[j]] })
+	return partDirPaths, nil
+}
+
+/*
+ProcessImagesInRunDir is ProcessImageInRunDir for a long receipt
+photographed in overlapping parts (imagePaths top to bottom). Every photo is
+processed into its own part-N directory; the run directory gets the merged
+ocr.txt (lines in the overlap kept once), numbers-ocr.txt, prices.json,
+ocr-boxes.json (lines point at their part's image), the quality report of
+the worst part and stitch.json. When two consecutive photos share no text
+it returns ErrNoOverlap and leaves only the part-N directories.
+*/
+func ProcessImagesInRunDir(imagePaths []string, runDirPath, language string) (e *xerr.Error) {
+	stitch := Stitch{}
+	var ocrTexts, numbersTexts []string
+	var partBoxes []Boxes
+	var worstQuality QualityReport
+	for index, imagePath := range imagePaths {
+		part := StitchPart{ImagePath: imagePath, Dir: PartDirName(index + 1)}
+		partDirPath := filepath.Join(runDirPath, part.Dir)
+		mkdirErr := os.MkdirAll(partDirPath, 0o755)
+		if mkdirErr != nil {
+			e = xerr.NewError(mkdirErr, "create receipt part directory", partDirPath)
+			return e
+		}
+
+		e = ProcessImageInRunDir(imagePath, partDirPath, language)
+		if e != nil {
+			return e
+		}
+
+		ocrText, e := readTextFile(filepath.Join(partDirPath, "ocr.txt"))
+		if e != nil {
+			return e
+		}
+		numbersText, e := readTextFile(filepath.Join(partDirPath, "numbers-ocr.txt"))
+		if e != nil {
+			return e
+		}
+		boxes, _, e := LoadBoxes(partDirPath)
+		if e != nil {
+			return e
+		}
+		var quality QualityReport
+		e = loadJSONFromFile(filepath.Join(partDirPath, QualityFileName), &quality)
+		if e != nil {
+			return e
+		}
+
+		ocrTexts = append(ocrTexts, ocrText)
+		numbersTexts = append(numbersTexts, numbersText)
+		partBoxes = append(partBoxes, boxes)
+		part.QualityScore = quality.Score
+		if index == 0 || quality.Score < worstQuality.Score {
+			worstQuality = quality
+		}
+		stitch.Parts = append(stitch.Parts, part)
+	}
+
+	lines, overlaps := mergeOCRTexts(ocrTexts)
+	mergedText := make([]string, len(lines))
+	for index, line := range lines {
+		mergedText[index] = line.Text
+	}
+	for index := range stitch.Parts {
+		stitch.Parts[index].OverlapLines = overlaps[index]
+		if index > 0 && overlaps[index] == 0 {
+			e = xerr.NewError(ErrNoOverlap, "stitch receipt parts", stitch.Parts[index-1].ImagePath+" / "+stitch.Parts[index].ImagePath)
+			return e
+		}
+	}
+	ocrText := strings.Join(mergedText, "\n") + "\n"
+	numbersText := strings.Join(numbersTexts, "\n")
+
+	e = saveOcrTextToFile(filepath.Join(runDirPath, "ocr.txt"), ocrText)
+	if e != nil {
+		return e
+	}
+	e = saveOcrTextToFile(filepath.Join(runDirPath, "numbers-ocr.txt"), numbersText)
+	if e != nil {
+		return e
+	}
+	e = saveJSONToFile(filepath.Join(runDirPath, "prices.json"), ExtractPriceCandidates(numbersText))
+	if e != nil {
+		return e
+	}
+	e = saveJSONToFile(filepath.Join(runDirPath, QualityFileName), worstQuality)
+	if e != nil {
+		return e
+	}
+	e = saveJSONToFile(filepath.Join(runDirPath, BoxesFileName), mergeBoxes(lines, partBoxes, stitch.Parts))
+	if e != nil {
+		return e
+	}
+	e = saveJSONToFile(filepath.Join(runDirPath, StitchFileName), stitch)
+	if e != nil {
+		return e
+	}
+
+	tl.Log(
+		tl.Info1, palette.Green, "Stitched '%s' photos into '%s' OCR lines in '%s' (overlaps: '%s')",
+		len(imagePaths), len(lines), runDirPath, fmt.Sprint(overlaps[1:]),
+	)
+	return nil
+}
+
+// mergedLine is a line of the merged OCR text and where it came from.
+type mergedLine struct {
+	Text string
+	Part int // index into the parts
+	Line int // line in the part's ocr.txt, -1 for a separator
+}
+
+/*
+mergeOCRTexts joins the OCR texts of consecutive photos. Where the bottom
+of the text so far and the top of the next part read the same (see
+findOverlap), the lines before and including the overlap come from the
+earlier part and the rest from the next; without an overlap the parts are
+appended with a blank line between them. overlaps[i] is the number of lines
+part i shared with the text before it.
+*/
+func mergeOCRTexts(texts []string) (lines []mergedLine, overlaps []int) {
+	overlaps = make([]int, len(texts))
+	for part, text := range texts {
+		var partLines []mergedLine
+		for index, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+			partLines = append(partLines, mergedLine{Text: line, Part: part, Line: index})
+		}
+		if part == 0 {
+			lines = partLines
+			continue
+		}
+
+		lastKept, lastSkipped, run := findOverlap(lines, partLines)
+		overlaps[part] = run
+		if run == 0 {
+			lines = append(lines, mergedLine{Part: part, Line: -1})
+			lines = append(lines, partLines...)
+			continue
+		}
+		lines = append(lines[:lastKept+1], partLines[lastSkipped+1:]...)
+	}
+	return lines, overlaps
+}
+
+/*
+findOverlap looks for the longest run of consecutive non-blank lines that
+reads (almost) the same near the end of earlier and near the start of next,
+ending at most maxTrailingLines lines before the end of earlier. Runs are
+compared by their amount of text, and a single line only counts when it is
+long enough. It returns the index of the run's last line in earlier and in
+next, and the run length (0 if there is no overlap).
+*/
+func findOverlap(earlier, next []mergedLine) (lastEarlier, lastNext, run int) {
+	earlierIndexes, earlierNormalized := nonBlankLines(earlier)
+	nextIndexes, nextNormalized := nonBlankLines(next)
+
+	bestScore := 0
+	for i := max(0, len(earlierIndexes)-overlapWindow); i < len(earlierIndexes); i++ {
+		for j := 0; j < min(len(nextIndexes), overlapWindow); j++ {
+			length, score := 0, 0
+			for i+length < len(earlierIndexes) && j+length < len(nextIndexes) &&
+				similarLines(earlierNormalized[i+length], nextNormalized[j+length]) {
+				score += len(earlierNormalized[i+length])
+				length++
+			}
+			if length == 0 || len(earlierIndexes)-(i+length) > maxTrailingLines {
+				continue
+			}
+			if length == 1 && score < minSingleLineOverlap {
+				continue
+			}
+			if score > bestScore {
+				bestScore = score
+				lastEarlier = earlierIndexes[i+length-1]
+				lastNext = nextIndexes[j+length-1]
+				run = length
+			}
+		}
+	}
+	return lastEarlier, lastNext, run
+}
+
+// nonBlankLines returns the indexes of lines with letters or digits and those lines normalized.
+func nonBlankLines(lines []mergedLine) (indexes []int, normalized [][]rune) {
+	for index, line := range lines {
+		var runes []rune
+		for _, character := range strings.ToLower(line.Text) {
+			if unicode.IsLetter(character) || unicode.IsDigit(character) {
+				runes = append(runes, character)
+			}
+		}
+		if len(runes) == 0 {
+			continue
+		}
+		indexes = append(indexes, index)
+		normalized = append(normalized, runes)
+	}
+	return indexes, normalized
+}
+
+// similarLines reports whether two normalized lines are probably the same printed line.
+func similarLines(a, b []rune) bool {
+	longest := max(len(a), len(b))
+	if longest == 0 {
+		return false
+	}
+	if float64(abs(len(a)-len(b)))/float64(longest) > 1-minLineSimilarity {
+		return false
+	}
+	return 1-float64(editDistance(a, b))/float64(longest) >= minLineSimilarity
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+/*
+mergeBoxes builds the ocr-boxes.json of a stitched receipt: the line boxes
+of the merged lines, in merged order, with OCRLine pointing into the merged
+text and Image at the part image the box is on.
+*/
+func mergeBoxes(lines []mergedLine, partBoxes []Boxes, parts []StitchPart) (boxes Boxes) {
+	boxes = Boxes{Lines: []LineBox{}}
+	if len(partBoxes) > 0 {
+		boxes.ImagePath = filepath.Join(parts[0].Dir, partBoxes[0].ImagePath)
+		boxes.ImageWidth = partBoxes[0].ImageWidth
+		boxes.ImageHeight = partBoxes[0].ImageHeight
+	}
+
+	for mergedIndex, line := range lines {
+		if line.Line < 0 {
+			continue
+		}
+		lineBox, found := partBoxes[line.Part].LineForOCRLine(line.Line)
+		if !found {
+			continue
+		}
+		lineBox.Index = len(boxes.Lines)
+		lineBox.OCRLine = mergedIndex
+		lineBox.Image = filepath.Join(parts[line.Part].Dir, partBoxes[line.Part].ImagePath)
+		boxes.Lines = append(boxes.Lines, lineBox)
+	}
+	return boxes
+}
@@ -0,0 +1,203 @@
+package ocr
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestMergeOCRTexts(t *testing.T) {
+	tests := []struct {
+		name         string
+		texts        []string
+		wantLines    []string
+		wantParts    []int
+		wantOverlaps []int
+	}{
+		{
+			name: "overlap",
+			texts: []string{
+				"TIENDA EJEMPLO\nLECHE ENTERA 1L 4.900\nPAN TAJADO 500G 6.200\nHUEVOS AA X12 14.500\n",
+				"PAN TAJADO 500G 6.200\nHUEVOS AA X12 14.500\nARROZ DIANA 1KG 5.300\nTOTAL 30.900\n",
+			},
+			wantLines: []string{
+				"TIENDA EJEMPLO", "LECHE ENTERA 1L 4.900", "PAN TAJADO 500G 6.200", "HUEVOS AA X12 14.500",
+				"ARROZ DIANA 1KG 5.300", "TOTAL 30.900",
+			},
+			wantParts:    []int{0, 0, 0, 0, 1, 1},
+			wantOverlaps: []int{0, 2},
+		},
+		{
+			name: "overlap read with OCR mistakes",
+			texts: []string{
+				"LECHE ENTERA 1L 4.900\nPAN TAJADO 500G 6.200\nHUEVOS AA X12 14.500\n",
+				"PAN TAJAD0 500G 6.200\nHUEV0S AA X12 14.500\nTOTAL 25.600\n",
+			},
+			wantLines:    []string{"LECHE ENTERA 1L 4.900", "PAN TAJADO 500G 6.200", "HUEVOS AA X12 14.500", "TOTAL 25.600"},
+			wantParts:    []int{0, 0, 0, 1},
+			wantOverlaps: []int{0, 2},
+		},
+		{
+			name: "no overlap",
+			texts: []string{
+				"LECHE ENTERA 1L 4.900\nPAN TAJADO 500G 6.200\n",
+				"ARROZ DIANA 1KG 5.300\nTOTAL 16.400\n",
+			},
+			wantLines:    []string{"LECHE ENTERA 1L 4.900", "PAN TAJADO 500G 6.200", "", "ARROZ DIANA 1KG 5.300", "TOTAL 16.400"},
+			wantParts:    []int{0, 0, 1, 1, 1},
+			wantOverlaps: []int{0, 0},
+		},
+		{
+			name: "short single line is not an overlap",
+			texts: []string{
+				"LECHE ENTERA 1L 4.900\nTOTAL\n",
+				"TOTAL\nEFECTIVO 10.000\n",
+			},
+			wantLines:    []string{"LECHE ENTERA 1L 4.900", "TOTAL", "", "TOTAL", "EFECTIVO 10.000"},
+			wantParts:    []int{0, 0, 1, 1, 1},
+			wantOverlaps: []int{0, 0},
+		},
+		{
+			name: "cut-off trailing lines come from the next part",
+			texts: []string{
+				"LECHE ENTERA 1L 4.900\nPAN TAJADO 500G 6.200\nHUEVOS AA X12 14.500\nARR0Z D\n",
+				"PAN TAJADO 500G 6.200\nHUEVOS AA X12 14.500\nARROZ DIANA 1KG 5.300\n",
+			},
+			wantLines:    []string{"LECHE ENTERA 1L 4.900", "PAN TAJADO 500G 6.200", "HUEVOS AA X12 14.500", "ARROZ DIANA 1KG 5.300"},
+			wantParts:    []int{0, 0, 0, 1},
+			wantOverlaps: []int{0, 2},
+		},
+		{
+			name: "overlap too far from the end of the earlier part",
+			texts: []string{
+				"PAN TAJADO 500G 6.200\nHUEVOS AA X12 14.500\nLINEA UNO\nLINEA DOS\nLINEA TRES\nLINEA CUATRO\n",
+				"PAN TAJADO 500G 6.200\nHUEVOS AA X12 14.500\n",
+			},
+			wantLines: []string{
+				"PAN TAJADO 500G 6.200", "HUEVOS AA X12 14.500", "LINEA UNO", "LINEA DOS", "LINEA TRES", "LINEA CUATRO",
+				"", "PAN TAJADO 500G 6.200", "HUEVOS AA X12 14.500",
+			},
+			wantParts:    []int{0, 0, 0, 0, 0, 0, 1, 1, 1},
+			wantOverlaps: []int{0, 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, overlaps := mergeOCRTexts(test.texts)
+
+			var gotLines []string
+			var gotParts []int
+			for _, line := range lines {
+				gotLines = append(gotLines, line.Text)
+				gotParts = append(gotParts, line.Part)
+			}
+			if !slices.Equal(gotLines, test.wantLines) {
+				t.Errorf("lines = %q, want %q", gotLines, test.wantLines)
+			}
+			if !slices.Equal(gotParts, test.wantParts) {
+				t.Errorf("parts = %v, want %v", gotParts, test.wantParts)
+			}
+			if !slices.Equal(overlaps, test.wantOverlaps) {
+				t.Errorf("overlaps = %v, want %v", overlaps, test.wantOverlaps)
+			}
+		})
+	}
+}
+
+func TestFindOverlap(t *testing.T) {
+	tests := []struct {
+		name            string
+		earlier         []string
+		next            []string
+		wantLastEarlier int
+		wantLastNext    int
+		wantRun         int
+	}{
+		{
+			name:            "run at the end of earlier",
+			earlier:         []string{"TIENDA EJEMPLO", "LECHE ENTERA 1L 4.900", "PAN TAJADO 500G 6.200"},
+			next:            []string{"LECHE ENTERA 1L 4.900", "PAN TAJADO 500G 6.200", "TOTAL 11.100"},
+			wantLastEarlier: 2,
+			wantLastNext:    1,
+			wantRun:         2,
+		},
+		{
+			name:            "blank lines are skipped",
+			earlier:         []string{"LECHE ENTERA 1L 4.900", "", "PAN TAJADO 500G 6.200", "---"},
+			next:            []string{"LECHE ENTERA 1L 4.900", "PAN TAJADO 500G 6.200", "TOTAL 11.100"},
+			wantLastEarlier: 2,
+			wantLastNext:    1,
+			wantRun:         2,
+		},
+		{
+			name:            "trailing lines cut off in the earlier photo",
+			earlier:         []string{"LECHE ENTERA 1L 4.900", "PAN TAJADO 500G 6.200", "ARR0Z D", "T0"},
+			next:            []string{"LECHE ENTERA 1L 4.900", "PAN TAJADO 500G 6.200", "ARROZ DIANA 1KG 5.300", "TOTAL 16.400"},
+			wantLastEarlier: 1,
+			wantLastNext:    1,
+			wantRun:         2,
+		},
+		{
+			name:    "no common line",
+			earlier: []string{"LECHE ENTERA 1L 4.900"},
+			next:    []string{"ARROZ DIANA 1KG 5.300"},
+			wantRun: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lastEarlier, lastNext, run := findOverlap(toMergedLines(test.earlier, 0), toMergedLines(test.next, 1))
+			if run != test.wantRun {
+				t.Fatalf("run = %d, want %d", run, test.wantRun)
+			}
+			if run > 0 && (lastEarlier != test.wantLastEarlier || lastNext != test.wantLastNext) {
+				t.Errorf("last lines = (%d, %d), want (%d, %d)", lastEarlier, lastNext, test.wantLastEarlier, test.wantLastNext)
+			}
+		})
+	}
+}
+
+func TestSimilarLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{name: "identical", a: "LECHE ENTERA 1L 4.900", b: "LECHE ENTERA 1L 4.900", want: true},
+		{name: "one misread character", a: "HUEVOS AA X12 14.500", b: "HUEV0S AA X12 14.500", want: true},
+		{name: "punctuation and case ignored", a: "Pan tajado 500g $6.200", b: "PAN TAJADO 500G 6,200", want: true},
+		{name: "different lines", a: "LECHE ENTERA 1L 4.900", b: "ARROZ DIANA 1KG 5.300", want: false},
+		{name: "cut-off line", a: "ARROZ DIANA 1KG 5.300", b: "ARROZ D", want: false},
+		{name: "empty", a: "", b: "", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := similarLines(normalizedLine(test.a), normalizedLine(test.b))
+			if got != test.want {
+				t.Errorf("similarLines(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+			}
+		})
+	}
+}
+
+func toMergedLines(texts []string, part int) (lines []mergedLine) {
+	for index, text := range texts {
+		lines = append(lines, mergedLine{Text: text, Part: part, Line: index})
+	}
+	return lines
+}
+
+// normalizedLine normalizes text the way nonBlankLines does.
+func normalizedLine(text string) (runes []rune) {
+	for _, character := range strings.ToLower(text) {
+		if unicode.IsLetter(character) || unicode.IsDigit(character) {
+			runes = append(runes, character)
+		}
+	}
+	return runes
+}
@@ -7,17 +7,20 @@ import (
 	tl "github.com/tuumbleweed/tintlog/logger"
 	"github.com/tuumbleweed/tintlog/palette"
 	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/preprocess"
 )
 
 /*
 runOcrOnImage performs OCR on the given image path using gosseract.
 
-The OCR is configured for Spanish ("spa") and uses the processed image to
-extract text. It returns the raw OCR text or a *xerr.Error if something
-goes wrong (for example, Tesseract missing, language data missing, or a
-read failure).
+The OCR uses the given language(s) and page segmentation mode on the
+processed image. It returns the raw OCR text and the recognized words
+(box in processed image pixels, confidence, block/paragraph/line numbers),
+or a *xerr.Error if something goes wrong (for example, Tesseract missing,
+language data missing, or a read failure).
 */
-func runOcrOnImage(imagePath, language string) (ocrText string, e *xerr.Error) {
+func runOcrOnImage(imagePath, language string, pageSegMode gosseract.PageSegMode) (ocrText string, words []gosseract.BoundingBox, e *xerr.Error) {
 	tl.Log(tl.Info1, palette.Cyan, "Running OCR on processed image '%s'", imagePath)
 
 	client := gosseract.NewClient()
@@ -27,43 +30,49 @@ func runOcrOnImage(imagePath, language string) (ocrText string, e *xerr.Error) {
 
 	err := client.SetLanguage(language)
 	if err != nil {
-		return "", xerr.NewError(err, "unable to client.SetLanguage(\"spa\")", imagePath)
+		return "", nil, xerr.NewError(err, "unable to client.SetLanguage(\"spa\")", imagePath)
 	}
 
 	err = client.SetVariable("tessedit_char_blacklist", "+")
 	if err != nil {
-		return "", xerr.NewError(err, "unable to SetVariable(tessedit_char_blacklist,\"+\")", imagePath)
+		return "", nil, xerr.NewError(err, "unable to SetVariable(tessedit_char_blacklist,\"+\")", imagePath)
 	}
 
 	// 🔹 Preserve multiple spaces between words/columns
 	err = client.SetVariable("preserve_interword_spaces", "1")
 	if err != nil {
-		return "", xerr.NewError(err, "unable to client.SetVariable(\"preserve_interword_spaces\", \"1\")", imagePath)
+		return "", nil, xerr.NewError(err, "unable to client.SetVariable(\"preserve_interword_spaces\", \"1\")", imagePath)
 	}
 
-	// Match CLI: `--psm 6` (single uniform block of text).
-	err = client.SetPageSegMode(gosseract.PSM_SINGLE_BLOCK)
+	// Match CLI: `--psm N` (6 = single uniform block of text).
+	err = client.SetPageSegMode(pageSegMode)
 	if err != nil {
-		e = xerr.NewError(err, "unable to client.SetPageSegMode(PSM_SINGLE_BLOCK)", imagePath)
+		e = xerr.NewError(err, "unable to client.SetPageSegMode", fmt.Sprintf("%d", pageSegMode))
 		return
 	}
 
 	err = client.SetImage(imagePath)
 	if err != nil {
-		return "", xerr.NewError(err, "unable to client.SetImage(imagePath)", imagePath)
+		return "", nil, xerr.NewError(err, "unable to client.SetImage(imagePath)", imagePath)
 	}
 
 	ocrText, ocrErr := client.Text()
 	if ocrErr != nil {
-		return "", xerr.NewError(ocrErr, "unable to run OCR on image", imagePath)
+		return "", nil, xerr.NewError(ocrErr, "unable to run OCR on image", imagePath)
+	}
+
+	// Same recognition result, per word.
+	words, boxesErr := client.GetBoundingBoxesVerbose()
+	if boxesErr != nil {
+		return "", nil, xerr.NewError(boxesErr, "unable to get OCR word boxes", imagePath)
 	}
 
 	tl.Log(
-		tl.Info1, palette.Green, "OCR completed for '%s' (text length: %s)",
-		imagePath, fmt.Sprintf("%d", len(ocrText)),
+		tl.Info1, palette.Green, "OCR completed for '%s' (text length: %s, words: %s)",
+		imagePath, fmt.Sprintf("%d", len(ocrText)), fmt.Sprintf("%d", len(words)),
 	)
 
-	return ocrText, e
+	return ocrText, words, e
 }
 
 func runOcrForNumbers(imagePath string) (string, *xerr.Error) {
@@ -104,3 +113,20 @@ func runOcrForNumbers(imagePath string) (string, *xerr.Error) {
 
 	return text, nil
 }
+
+/*
+PipelineVersion identifies the preprocessing and tesseract settings used by
+ProcessImage. Bump it when they change, so resumed runs redo OCR.
+*/
+const PipelineVersion = "3"
+
+/*
+ToolVersions returns what produced the OCR artifacts, for run manifests.
+*/
+func ToolVersions() map[string]string {
+	return map[string]string{
+		"tesseract":    gosseract.Version(),
+		"ocr_pipeline": PipelineVersion,
+		"preprocess":   preprocess.Cfg.Fingerprint(),
+	}
+}
@@ -0,0 +1,89 @@
+package ocr
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+
+	"github.com/otiai10/gosseract/v2"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/inputfile"
+	"expense-tracker/src/pkg/preprocess"
+)
+
+const (
+	// SourceFileBaseName is the copy of a converted input file (PDF, HEIC, WebP) in a run directory.
+	SourceFileBaseName = "source"
+	// TextLayerProfile names the "attempt" of a PDF whose text layer was used instead of OCR.
+	TextLayerProfile = "pdf-text-layer"
+)
+
+/*
+saveTextLayer writes the OCR artifacts (ocr.txt, numbers-ocr.txt,
+prices.json, ocr-quality.json, ocr-boxes.json) of a PDF from its text layer
+instead of tesseract output. Words get confidence 100 and their boxes are
+already in converted.ImagePath pixels.
+*/
+func saveTextLayer(converted inputfile.Converted, runDirPath string) (e *xerr.Error) {
+	imageFile, openErr := os.Open(converted.ImagePath)
+	if openErr != nil {
+		e = xerr.NewError(openErr, "open rendered PDF", converted.ImagePath)
+		return e
+	}
+	imageConfig, _, decodeErr := image.DecodeConfig(imageFile)
+	_ = imageFile.Close()
+	if decodeErr != nil {
+		e = xerr.NewError(decodeErr, "read rendered PDF size", converted.ImagePath)
+		return e
+	}
+
+	words := make([]gosseract.BoundingBox, 0, len(converted.Words))
+	for _, word := range converted.Words {
+		words = append(words, gosseract.BoundingBox{
+			Box:        word.Box,
+			Word:       word.Text,
+			Confidence: 100,
+			BlockNum:   word.Block,
+			LineNum:    word.Line,
+		})
+	}
+	geometry := preprocess.Geometry{
+		SourceWidth:  imageConfig.Width,
+		SourceHeight: imageConfig.Height,
+		ScaleX:       1,
+		ScaleY:       1,
+		Width:        imageConfig.Width,
+		Height:       imageConfig.Height,
+	}
+
+	qualityReport := QualityReport{
+		Quality:         scoreWords(words),
+		Profile:         TextLayerProfile,
+		MinQualityScore: preprocess.Cfg.MinQualityScore,
+	}
+	qualityReport.Attempts = []Attempt{{Profile: TextLayerProfile, Quality: qualityReport.Quality}}
+
+	e = saveOcrTextToFile(filepath.Join(runDirPath, "ocr.txt"), converted.TextLayer)
+	if e != nil {
+		return e
+	}
+	e = saveOcrTextToFile(filepath.Join(runDirPath, "numbers-ocr.txt"), converted.TextLayer)
+	if e != nil {
+		return e
+	}
+	e = saveJSONToFile(filepath.Join(runDirPath, "prices.json"), ExtractPriceCandidates(converted.TextLayer))
+	if e != nil {
+		return e
+	}
+	e = saveJSONToFile(filepath.Join(runDirPath, QualityFileName), qualityReport)
+	if e != nil {
+		return e
+	}
+	e = saveJSONToFile(filepath.Join(runDirPath, BoxesFileName), buildBoxes(words, geometry, converted.TextLayer, converted.ImagePath))
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
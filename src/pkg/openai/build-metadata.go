@@ -50,6 +50,9 @@ func ExtractLLMRunMetadata(resp responseObject, startTime time.Time) (meta LLMRu
 		}
 	}
 
+	// Cost from token counts and the configured price table
+	applyCost(&meta)
+
 	// Timing: use startTime instead of CreatedAt (they truncate milliseconds) FinishedAt is "now".
 	meta.StartedAt = startTime.UnixMilli()
 	meta.FinishedAt = time.Now().UnixMilli()
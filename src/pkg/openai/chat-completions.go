@@ -0,0 +1,167 @@
+/*
+Minimal client for OpenAI-compatible POST /v1/chat/completions endpoints.
+
+The Responses API is OpenAI-only, but local servers (Ollama, llama.cpp
+server, vLLM, LM Studio) expose the older chat completions API with
+structured outputs via response_format. Only the fields we use are modeled.
+*/
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+)
+
+const ChatCompletionTimeout = 600 * time.Second // local models on CPU can be slow
+
+// ----- Request types we send -----
+
+type ChatMessage struct {
+	Role    string `json:"role"`    // "system" | "user" | "assistant"
+	Content any    `json:"content"` // string or []ChatContentPart
+}
+
+type ChatContentPart struct {
+	Type     string        `json:"type"`           // "text" | "image_url"
+	Text     string        `json:"text,omitempty"` // set when type == "text"
+	ImageURL *ChatImageURL `json:"image_url,omitempty"`
+}
+
+type ChatImageURL struct {
+	URL string `json:"url"` // data URL or http(s) URL
+}
+
+type ChatResponseFormat struct {
+	Type       string          `json:"type"` // "json_schema"
+	JSONSchema *ChatJSONSchema `json:"json_schema,omitempty"`
+}
+
+type ChatJSONSchema struct {
+	Name   string         `json:"name"`
+	Schema map[string]any `json:"schema"`
+	Strict bool           `json:"strict"`
+}
+
+type ChatCompletionRequest struct {
+	Model          string              `json:"model"`
+	Messages       []ChatMessage       `json:"messages"`
+	ResponseFormat *ChatResponseFormat `json:"response_format,omitempty"`
+	MaxTokens      int                 `json:"max_tokens,omitempty"`
+	Temperature    *float64            `json:"temperature,omitempty"`
+	Stream         bool                `json:"stream"`
+}
+
+// ----- Response types we parse -----
+
+type chatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Model   string                 `json:"model"`
+	Choices []chatCompletionChoice `json:"choices"`
+	Usage   *chatCompletionUsage   `json:"usage,omitempty"`
+}
+
+type chatCompletionChoice struct {
+	Index        int         `json:"index"`
+	FinishReason string      `json:"finish_reason"`
+	Message      ChatMessage `json:"message"`
+}
+
+type chatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+/*
+SendChatCompletion performs POST {baseURL}/chat/completions and returns the
+assistant text of the first choice plus run metadata.
+
+apiKey may be empty (most local servers do not check it). Transient failures
+are retried like Responses API calls and recorded in meta.Retries.
+*/
+func SendChatCompletion(baseURL, apiKey string, payload ChatCompletionRequest) (responseText string, meta LLMRunMetadata, e *xerr.Error) {
+	url := strings.TrimRight(baseURL, "/") + "/chat/completions"
+	tl.Log(tl.Info, palette.Blue, "%s %s to '%s' with model '%s'", "Sending", "chat completion", url, payload.Model)
+	startTime := time.Now()
+
+	encoded, marshalErr := json.Marshal(payload)
+	if marshalErr != nil {
+		return "", meta, xerr.NewError(marshalErr, "Failed to marshal chat completion payload", payload.Model)
+	}
+
+	newRequest := func() (*http.Request, error) {
+		req, newReqErr := http.NewRequest("POST", url, bytes.NewReader(encoded))
+		if newReqErr != nil {
+			return nil, newReqErr
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		return req, nil
+	}
+
+	retries := &retryLog{}
+	client := &http.Client{Timeout: ChatCompletionTimeout}
+	respBody, e := doWithRetry(client, "POST /chat/completions", newRequest, DefaultRetryPolicy, retries)
+	if e != nil {
+		meta.Retries = retries.records
+		return "", meta, e
+	}
+	tl.LogJSON(tl.Debug, palette.CyanDim, "chat completion response body", respBody)
+
+	var parsed chatCompletionResponse
+	decodeErr := json.Unmarshal(respBody, &parsed)
+	if decodeErr != nil {
+		return "", meta, xerr.NewError(decodeErr, "Failed to decode chat completion body", string(respBody))
+	}
+	if len(parsed.Choices) == 0 {
+		return "", meta, xerr.NewError(fmt.Errorf("no choices"), "Chat completion returned no choices", string(respBody))
+	}
+
+	content, isString := parsed.Choices[0].Message.Content.(string)
+	if !isString {
+		return "", meta, xerr.NewError(fmt.Errorf("content is not a string"), "Unexpected chat completion message content", string(respBody))
+	}
+
+	meta = extractChatCompletionMetadata(parsed, startTime)
+	meta.Retries = retries.records
+	tl.Log(tl.Info1, palette.Green, "%s in %s for '%s' (finish reason '%s')", "Chat completion done", time.Since(startTime), parsed.Model, parsed.Choices[0].FinishReason)
+
+	return content, meta, nil
+}
+
+/*
+extractChatCompletionMetadata maps a chat completion response onto the same
+LLMRunMetadata shape the Responses API produces, so reports don't care which
+backend was used.
+*/
+func extractChatCompletionMetadata(resp chatCompletionResponse, startTime time.Time) (meta LLMRunMetadata) {
+	meta.ResponseID = resp.ID
+	meta.Model, meta.ModelSnapshot = ParseModelSnapshot(resp.Model)
+	meta.Status = "completed"
+	if len(resp.Choices) > 0 && resp.Choices[0].FinishReason == "length" {
+		meta.Status = "incomplete"
+	}
+
+	if resp.Usage != nil {
+		meta.TokensIn = resp.Usage.PromptTokens
+		meta.TokensOut = resp.Usage.CompletionTokens
+		meta.TokensTotal = resp.Usage.TotalTokens
+	}
+	applyCost(&meta)
+
+	meta.StartedAt = startTime.UnixMilli()
+	meta.FinishedAt = time.Now().UnixMilli()
+	meta.Elapsed = meta.FinishedAt - meta.StartedAt
+
+	return meta
+}
@@ -0,0 +1,70 @@
+package openai
+
+import (
+	"fmt"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+)
+
+/*
+Config holds settings of the OpenAI client itself.
+
+Set it in cfg/config.json under the "openai" key. Prices are USD per
+1M tokens; models listed here override (or add to) the built-in table:
+
+	"openai": {
+	  "model_prices": {
+	    "gpt-5-mini": { "input": 0.25, "cached_input": 0.025, "output": 2.0 }
+	  }
+	}
+*/
+type Config struct {
+	ModelPrices map[string]ModelPrice `json:"model_prices,omitempty"` // keyed by model name without snapshot date
+}
+
+func DefaultValueConfig() Config {
+	return Config{
+		ModelPrices: DefaultModelPrices(),
+	}
+}
+
+// create config with default values before config gets initialized
+var Cfg Config = DefaultValueConfig() // this one we use to access config values from anywhere
+
+/*
+If local Config is provided - use it. Replace all missing values with default ones.
+
+If not provided - just use defaultConfig.
+*/
+func InitializeConfig(localConfig *Config) {
+	// If not provided - just use defaultConfig
+	if localConfig == nil {
+		tl.Log(tl.Info, palette.Purple, "%s config is %s, keeping %s", "openai", "not provided", "default openai config")
+		return
+	}
+
+	defaultConfig := DefaultValueConfig() // Default values to replace some values with during config initialization
+
+	// If local Config is provided - use it
+	Cfg = *localConfig
+
+	tl.ApplyDefaults(&Cfg, defaultConfig, func(field string, defVal any) {
+		tl.Log(
+			tl.Info, palette.Purple,
+			"%s field is %s in %s configuration. Using default value: %v",
+			field, "missing", "openai", tl.PrettyForStderr(defVal),
+		)
+	})
+
+	// A partial price table only overrides the models it lists.
+	for model, price := range defaultConfig.ModelPrices {
+		_, exists := Cfg.ModelPrices[model]
+		if !exists {
+			Cfg.ModelPrices[model] = price
+		}
+	}
+
+	tl.Log(tl.Info, palette.Green, "%s config was %s, using %s", "openai", "provided", "local openai config")
+	tl.LogJSON(tl.Verbose, palette.CyanDim, fmt.Sprintf("%s configuration", "openai"), Cfg)
+}
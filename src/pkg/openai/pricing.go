@@ -0,0 +1,85 @@
+package openai
+
+import (
+	"fmt"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+)
+
+/*
+ModelPrice is the price of a model in USD per 1M tokens.
+
+Fields:
+  - Input: uncached input tokens.
+  - CachedInput: cached input tokens.
+  - Output: output tokens (reasoning tokens are billed as output).
+*/
+type ModelPrice struct {
+	Input       float64 `json:"input"`
+	CachedInput float64 `json:"cached_input"`
+	Output      float64 `json:"output"`
+}
+
+/*
+DefaultModelPrices returns the built-in price table (standard tier).
+Check https://openai.com/api/pricing and override it in config when prices change.
+*/
+func DefaultModelPrices() map[string]ModelPrice {
+	return map[string]ModelPrice{
+		"gpt-5":        {Input: 1.25, CachedInput: 0.125, Output: 10.00},
+		"gpt-5-mini":   {Input: 0.25, CachedInput: 0.025, Output: 2.00},
+		"gpt-5-nano":   {Input: 0.05, CachedInput: 0.005, Output: 0.40},
+		"gpt-4.1":      {Input: 2.00, CachedInput: 0.50, Output: 8.00},
+		"gpt-4.1-mini": {Input: 0.40, CachedInput: 0.10, Output: 1.60},
+		"gpt-4.1-nano": {Input: 0.10, CachedInput: 0.025, Output: 0.40},
+		"gpt-4o":       {Input: 2.50, CachedInput: 1.25, Output: 10.00},
+		"gpt-4o-mini":  {Input: 0.15, CachedInput: 0.075, Output: 0.60},
+	}
+}
+
+/*
+EstimateCostUSD prices the token counts of meta with Cfg.ModelPrices.
+known is false when the model is not in the table (cost is then 0).
+
+TokensIn includes TokensCached, so cached tokens are only billed at the
+cached rate; TokensOut includes TokensReasoning.
+*/
+func EstimateCostUSD(meta LLMRunMetadata) (costUSD float64, known bool) {
+	price, known := Cfg.ModelPrices[meta.Model]
+	if !known {
+		return 0, false
+	}
+
+	uncachedIn := max(meta.TokensIn-meta.TokensCached, 0)
+	costUSD = float64(uncachedIn)*price.Input +
+		float64(meta.TokensCached)*price.CachedInput +
+		float64(meta.TokensOut)*price.Output
+
+	return costUSD / 1_000_000, true
+}
+
+/*
+applyCost sets meta.CostUSD, logging when the model has no price.
+*/
+func applyCost(meta *LLMRunMetadata) {
+	costUSD, known := EstimateCostUSD(*meta)
+	if !known {
+		tl.Log(tl.Detailed, palette.PurpleDim, "No price for model '%s', %s", meta.Model, "cost_usd is 0")
+		return
+	}
+
+	meta.CostUSD = costUSD
+	tl.Log(tl.Detailed, palette.CyanDim, "Estimated cost: %s", FormatUSD(costUSD))
+}
+
+/*
+FormatUSD formats a cost: four decimals below one dollar (single runs cost
+fractions of a cent), two decimals otherwise.
+*/
+func FormatUSD(amountUSD float64) string {
+	if amountUSD < 1 {
+		return fmt.Sprintf("$%.4f", amountUSD)
+	}
+	return fmt.Sprintf("$%.2f", amountUSD)
+}
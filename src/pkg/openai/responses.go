@@ -12,14 +12,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"expense-tracker/src/pkg/util"
 	"time"
 
 	tl "github.com/tuumbleweed/tintlog/logger"
 	"github.com/tuumbleweed/tintlog/palette"
 	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/util"
 )
 
 const (
@@ -33,8 +33,10 @@ const OpenAIAPIURL = "https://api.openai.com/v1"
 /*
 createResponse performs POST /v1/responses and returns the parsed response object.
 It may return a "completed" response immediately, or an "in_progress" one (future-friendly).
+
+Transient failures are retried per DefaultRetryPolicy and recorded in retries.
 */
-func createResponse(apiKey string, payload requestPayload) (response responseObject, e *xerr.Error) {
+func createResponse(apiKey string, payload requestPayload, retries *retryLog) (response responseObject, e *xerr.Error) {
 	tl.Log(tl.Info, palette.Blue, "%s %s to '%s'", "Creating", "response", OpenAIAPIURL+"/responses")
 
 	encoded, marshalErr := json.Marshal(payload)
@@ -43,27 +45,21 @@ func createResponse(apiKey string, payload requestPayload) (response responseObj
 	}
 
 	url := fmt.Sprintf("%s/responses", OpenAIAPIURL)
-	req, newReqErr := http.NewRequest("POST", url, bytes.NewBuffer(encoded))
-	if newReqErr != nil {
-		return responseObject{}, xerr.NewError(newReqErr, "Failed to create HTTP request", nil)
+	newRequest := func() (*http.Request, error) {
+		req, newReqErr := http.NewRequest("POST", url, bytes.NewReader(encoded))
+		if newReqErr != nil {
+			return nil, newReqErr
+		}
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
 	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{Timeout: CreateResponseTimeout}
-	resp, httpErr := client.Do(req)
-	if httpErr != nil {
-		return responseObject{}, xerr.NewError(httpErr, "HTTP error during createResponse", map[string]any{"url": url})
-	}
-	defer resp.Body.Close()
-
-	respBody, e := GetBody(resp, resp.Request.URL.String())
+	respBody, e := doWithRetry(client, "POST /responses", newRequest, DefaultRetryPolicy, retries)
 	if e != nil {
 		return responseObject{}, e
 	}
-	if resp.StatusCode != http.StatusOK {
-		return responseObject{}, xerr.NewError(fmt.Errorf("status is '%s'", resp.Status), "API error from /v1/responses", string(respBody))
-	}
 	tl.LogJSON(tl.Debug, palette.CyanDim, "openai response body", respBody)
 
 	var parsed responseObject
@@ -77,39 +73,26 @@ func createResponse(apiKey string, payload requestPayload) (response responseObj
 
 /*
 getResponseByID performs GET /v1/responses/{id} and returns the parsed response object.
+
+Transient failures are retried per DefaultRetryPolicy and recorded in retries.
 */
-func getResponseByID(apiKey, responseID string) (response responseObject, e *xerr.Error) {
+func getResponseByID(apiKey, responseID string, retries *retryLog) (response responseObject, e *xerr.Error) {
 	url := fmt.Sprintf("%s/responses/%s", OpenAIAPIURL, responseID)
 
-	req, newReqErr := http.NewRequest("GET", url, nil)
-	if newReqErr != nil {
-		return responseObject{}, xerr.NewError(newReqErr, "Failed to create HTTP request", map[string]any{"response_id": responseID})
-	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	client := &http.Client{Timeout: GetResponseTimeout}
-	resp, httpErr := client.Do(req)
-	if httpErr != nil {
-		return responseObject{}, xerr.NewError(httpErr, "HTTP error during getResponseByID", map[string]any{"url": url})
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		// Read everything once
-		respBody, readErr := io.ReadAll(resp.Body)
-		if readErr != nil {
-			return responseObject{}, xerr.NewError(readErr, "Failed to read response body", nil)
+	newRequest := func() (*http.Request, error) {
+		req, newReqErr := http.NewRequest("GET", url, nil)
+		if newReqErr != nil {
+			return nil, newReqErr
 		}
-		return responseObject{}, xerr.NewError(fmt.Errorf("status is '%s'", resp.Status), "API error from GET /v1/responses/{id}", string(respBody))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		return req, nil
 	}
 
-	respBody, e := GetBody(resp, resp.Request.URL.String())
+	client := &http.Client{Timeout: GetResponseTimeout}
+	respBody, e := doWithRetry(client, "GET /responses/{id}", newRequest, DefaultRetryPolicy, retries)
 	if e != nil {
 		return responseObject{}, e
 	}
-	if resp.StatusCode != http.StatusOK {
-		return responseObject{}, xerr.NewError(fmt.Errorf("status is '%s'", resp.Status), "API error from /v1/responses", string(respBody))
-	}
 	tl.LogJSON(tl.Debug, palette.CyanDim, "openai response body", respBody)
 
 	var parsed responseObject
@@ -147,8 +130,12 @@ waitForResponseCompletion polls GET /v1/responses/{id} every interval until term
 or until timeout is reached (if timeout > 0). On success, returns the final response object.
 On failure/cancel/expire/timeout, returns a *xerr.Error with the API's error payload in Context
 (where available) and logs a heartbeat each poll.
+
+A poll that still fails after its retries with a transient error (429, 5xx, timeout) doesn't
+abort the wait: the response keeps running server-side, so we keep polling until timeout.
+Fatal errors (e.g. 401, 404) are returned right away.
 */
-func waitForResponseCompletion(apiKey, responseID string, waitInterval, timeout time.Duration) (final responseObject, e *xerr.Error) {
+func waitForResponseCompletion(apiKey, responseID string, waitInterval, timeout time.Duration, retries *retryLog) (final responseObject, e *xerr.Error) {
 	previousStatus := ""
 	poll := 0
 
@@ -174,9 +161,14 @@ func waitForResponseCompletion(apiKey, responseID string, waitInterval, timeout
 
 		poll += 1
 
-		resp, getErr := getResponseByID(apiKey, responseID)
+		resp, getErr := getResponseByID(apiKey, responseID, retries)
 		if getErr != nil {
-			return lastResp, getErr
+			if !IsRetryable(getErr) {
+				return lastResp, getErr
+			}
+			tl.Log(tl.Warning, palette.YellowDim, "Poll #%s failed (%s), %s", poll, getErr.ErrStr, "will keep polling")
+			util.WaitForSeconds(waitInterval.Seconds())
+			continue
 		}
 		lastResp = resp
 
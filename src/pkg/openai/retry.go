@@ -0,0 +1,282 @@
+package openai
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+)
+
+/*
+RetryPolicy controls how a single HTTP call to the API is retried.
+
+Fields:
+  - MaxAttempts: total attempts per call, including the first one.
+  - BaseDelay: backoff before the second attempt; doubles with every attempt.
+  - MaxDelay: upper bound for a single wait, also applied to Retry-After.
+*/
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used for every Responses API and chat completions call.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+/*
+RetryRecord describes one failed attempt that was retried. They are kept in
+LLMRunMetadata.Retries.
+
+Fields:
+  - Operation: the call that failed, e.g. "POST /responses".
+  - Attempt: 1-based number of the failed attempt.
+  - StatusCode: HTTP status, 0 for network errors and timeouts.
+  - Error: short error description.
+  - WaitMs: how long we waited before the next attempt.
+  - FromRetryAfter: the wait came from the server's Retry-After header.
+*/
+type RetryRecord struct {
+	Operation      string `json:"operation"`
+	Attempt        int    `json:"attempt"`
+	StatusCode     int    `json:"status_code,omitempty"`
+	Error          string `json:"error"`
+	WaitMs         int64  `json:"wait_ms"`
+	FromRetryAfter bool   `json:"from_retry_after,omitempty"`
+}
+
+/*
+RetryableError marks a failure that is transient (rate limit, server error,
+timeout) and was still failing when the attempts ran out. Callers can retry
+the whole operation later; see IsRetryable.
+*/
+type RetryableError struct {
+	StatusCode int
+	Err        error
+}
+
+func (retryableErr *RetryableError) Error() string {
+	return retryableErr.Err.Error()
+}
+
+func (retryableErr *RetryableError) Unwrap() error {
+	return retryableErr.Err
+}
+
+/*
+IsRetryable reports whether e was caused by a transient error, as opposed
+to a fatal one such as 400 Bad Request or 401 Unauthorized.
+*/
+func IsRetryable(e *xerr.Error) bool {
+	if e == nil {
+		return false
+	}
+	var retryableErr *RetryableError
+	return errors.As(e.Err, &retryableErr)
+}
+
+// retryLog collects retried attempts of all calls made for one LLM run.
+type retryLog struct {
+	records []RetryRecord
+}
+
+func (log *retryLog) add(record RetryRecord) {
+	if log == nil {
+		return
+	}
+	log.records = append(log.records, record)
+}
+
+/*
+doWithRetry sends the request built by newRequest (called again for every
+attempt, so the body can be re-read) and returns the body of the first
+200 OK response.
+
+  - Retryable: network errors and timeouts, 408, 429, 500, 502, 503, 504.
+    The wait is Retry-After (or retry-after-ms) when the server sends it,
+    otherwise exponential backoff with jitter.
+  - Fatal: any other status (400, 401, 403, 404, ...) fails immediately.
+
+Requests other than GET and HEAD get one Idempotency-Key for all attempts,
+so a POST that reached the provider but got no response (timeout, dropped
+connection) can be sent again without starting and billing a second run.
+
+When all attempts fail with retryable errors, the returned error wraps
+*RetryableError. Every retried attempt is added to retries.
+*/
+func doWithRetry(
+	client *http.Client, operation string, newRequest func() (*http.Request, error),
+	policy RetryPolicy, retries *retryLog,
+) (body []byte, e *xerr.Error) {
+	idempotencyKey := cryptorand.Text()
+	for attempt := 1; ; attempt++ {
+		req, newReqErr := newRequest()
+		if newReqErr != nil {
+			return nil, xerr.NewError(newReqErr, "Failed to create HTTP request", operation)
+		}
+		requestURL := req.URL.String()
+		if req.Method != http.MethodGet && req.Method != http.MethodHead && req.Header.Get("Idempotency-Key") == "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+
+		var attemptErr error
+		var statusCode int
+		var retryAfter time.Duration
+		var hasRetryAfter bool
+
+		resp, httpErr := client.Do(req)
+		if httpErr != nil {
+			if !isRetryableNetworkError(httpErr) {
+				return nil, xerr.NewError(httpErr, "HTTP error during "+operation, map[string]any{"url": requestURL})
+			}
+			attemptErr = httpErr
+		} else {
+			respBody, bodyErr := GetBody(resp, requestURL)
+			_ = resp.Body.Close()
+			body = respBody
+
+			switch {
+			case bodyErr != nil:
+				attemptErr = bodyErr.Err
+			case resp.StatusCode == http.StatusOK:
+				return body, nil
+			case !isRetryableStatus(resp.StatusCode):
+				statusErr := fmt.Errorf("status is '%s'", resp.Status)
+				return nil, xerr.NewError(statusErr, "API error from "+operation, string(body))
+			default:
+				statusCode = resp.StatusCode
+				attemptErr = fmt.Errorf("status is '%s'", resp.Status)
+				retryAfter, hasRetryAfter = parseRetryAfter(resp.Header, time.Now())
+			}
+		}
+
+		if attempt >= policy.MaxAttempts {
+			msg := fmt.Sprintf("%s failed after %d attempts", operation, attempt)
+			return nil, xerr.NewError(&RetryableError{StatusCode: statusCode, Err: attemptErr}, msg, string(body))
+		}
+
+		wait := policy.backoff(attempt)
+		if hasRetryAfter {
+			wait = min(retryAfter, policy.MaxDelay)
+		}
+
+		retries.add(RetryRecord{
+			Operation:      operation,
+			Attempt:        attempt,
+			StatusCode:     statusCode,
+			Error:          attemptErr.Error(),
+			WaitMs:         wait.Milliseconds(),
+			FromRetryAfter: hasRetryAfter,
+		})
+		tl.Log(
+			tl.Warning, palette.YellowDim, "%s attempt %s failed (%s), retrying in %s",
+			operation, attempt, attemptErr.Error(), wait.Round(time.Millisecond),
+		)
+		time.Sleep(wait)
+	}
+}
+
+/*
+backoff returns the wait after the given failed attempt: BaseDelay doubled
+per attempt, capped at MaxDelay, with "equal jitter" (a random value between
+half and the full delay) so concurrent workers don't retry in lockstep.
+*/
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay << min(attempt-1, 30)
+	if delay <= 0 || delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+isRetryableNetworkError treats timeouts, dropped connections and other
+transport-level failures as transient. TLS certificate problems and
+malformed URLs are not: retrying can't fix them.
+*/
+func isRetryableNetworkError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) {
+		return false
+	}
+
+	// *url.Error itself implements net.Error, look at what it wraps.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+/*
+parseRetryAfter reads the wait requested by the server. OpenAI sends
+retry-after-ms (milliseconds); the standard Retry-After is either seconds
+or an HTTP date.
+*/
+func parseRetryAfter(header http.Header, now time.Time) (wait time.Duration, ok bool) {
+	milliseconds := header.Get("Retry-After-Ms")
+	if milliseconds != "" {
+		value, parseErr := strconv.ParseFloat(milliseconds, 64)
+		if parseErr == nil && value >= 0 {
+			return time.Duration(value * float64(time.Millisecond)), true
+		}
+	}
+
+	retryAfter := header.Get("Retry-After")
+	if retryAfter == "" {
+		return 0, false
+	}
+
+	seconds, parseErr := strconv.ParseFloat(retryAfter, 64)
+	if parseErr == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+
+	date, dateErr := http.ParseTime(retryAfter)
+	if dateErr == nil {
+		return max(date.Sub(now), 0), true
+	}
+
+	return 0, false
+}
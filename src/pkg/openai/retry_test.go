@@ -0,0 +1,180 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRetryPolicy keeps the waits short so the tests don't sleep for seconds.
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    5 * time.Millisecond,
+}
+
+func TestDoWithRetry(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int // status of each attempt; the last one repeats
+		wantBody      string
+		wantAttempts  int32
+		wantErr       bool
+		wantRetryable bool
+		wantRetries   int
+	}{
+		{name: "ok", statuses: []int{200}, wantBody: "ok", wantAttempts: 1},
+		{name: "429 then ok", statuses: []int{429, 200}, wantBody: "ok", wantAttempts: 2, wantRetries: 1},
+		{name: "503 then ok", statuses: []int{503, 200}, wantBody: "ok", wantAttempts: 2, wantRetries: 1},
+		{name: "500 until attempts run out", statuses: []int{500}, wantAttempts: 3, wantErr: true, wantRetryable: true, wantRetries: 2},
+		{name: "429 until attempts run out", statuses: []int{429}, wantAttempts: 3, wantErr: true, wantRetryable: true, wantRetries: 2},
+		{name: "400 is fatal", statuses: []int{400}, wantAttempts: 1, wantErr: true},
+		{name: "401 is fatal", statuses: []int{401}, wantAttempts: 1, wantErr: true},
+		{name: "404 after 502 is fatal", statuses: []int{502, 404}, wantAttempts: 2, wantErr: true, wantRetries: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempt := int(attempts.Add(1))
+				status := test.statuses[min(attempt, len(test.statuses))-1]
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					_, _ = w.Write([]byte("ok"))
+				}
+			}))
+			defer server.Close()
+
+			newRequest := func() (*http.Request, error) {
+				return http.NewRequest(http.MethodPost, server.URL, strings.NewReader("{}"))
+			}
+			retries := &retryLog{}
+			body, e := doWithRetry(server.Client(), "POST /responses", newRequest, testRetryPolicy, retries)
+
+			if got := attempts.Load(); got != test.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, test.wantAttempts)
+			}
+			if len(retries.records) != test.wantRetries {
+				t.Errorf("retry records = %d, want %d", len(retries.records), test.wantRetries)
+			}
+			if (e != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", e, test.wantErr)
+			}
+			if e != nil {
+				if IsRetryable(e) != test.wantRetryable {
+					t.Errorf("IsRetryable = %v, want %v", IsRetryable(e), test.wantRetryable)
+				}
+				return
+			}
+			if string(body) != test.wantBody {
+				t.Errorf("body = %q, want %q", body, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestDoWithRetrySendsOneIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	newRequest := func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, server.URL, strings.NewReader("{}"))
+	}
+	_, e := doWithRetry(server.Client(), "POST /responses", newRequest, testRetryPolicy, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	if len(keys) != 3 || keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("Idempotency-Key per attempt = %q, want the same non-empty key 3 times", keys)
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{200, false},
+		{400, false},
+		{401, false},
+		{403, false},
+		{404, false},
+		{408, true},
+		{429, true},
+		{500, true},
+		{502, true},
+		{503, true},
+		{504, true},
+		{501, false},
+	}
+
+	for _, test := range tests {
+		got := isRetryableStatus(test.status)
+		if got != test.want {
+			t.Errorf("isRetryableStatus(%d) = %v, want %v", test.status, got, test.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		header   map[string]string
+		wantWait time.Duration
+		wantOK   bool
+	}{
+		{name: "none", header: map[string]string{}, wantOK: false},
+		{name: "seconds", header: map[string]string{"Retry-After": "20"}, wantWait: 20 * time.Second, wantOK: true},
+		{name: "fractional seconds", header: map[string]string{"Retry-After": "1.5"}, wantWait: 1500 * time.Millisecond, wantOK: true},
+		{
+			name:     "HTTP date",
+			header:   map[string]string{"Retry-After": now.Add(90 * time.Second).Format(http.TimeFormat)},
+			wantWait: 90 * time.Second,
+			wantOK:   true,
+		},
+		{
+			name:     "HTTP date in the past",
+			header:   map[string]string{"Retry-After": now.Add(-time.Minute).Format(http.TimeFormat)},
+			wantWait: 0,
+			wantOK:   true,
+		},
+		{name: "milliseconds", header: map[string]string{"Retry-After-Ms": "250"}, wantWait: 250 * time.Millisecond, wantOK: true},
+		{
+			name:     "milliseconds win over seconds",
+			header:   map[string]string{"Retry-After-Ms": "250", "Retry-After": "20"},
+			wantWait: 250 * time.Millisecond,
+			wantOK:   true,
+		},
+		{name: "negative seconds", header: map[string]string{"Retry-After": "-5"}, wantOK: false},
+		{name: "garbage", header: map[string]string{"Retry-After": "soon"}, wantOK: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range test.header {
+				header.Set(key, value)
+			}
+
+			wait, ok := parseRetryAfter(header, now)
+			if ok != test.wantOK || wait != test.wantWait {
+				t.Errorf("parseRetryAfter = (%s, %v), want (%s, %v)", wait, ok, test.wantWait, test.wantOK)
+			}
+		})
+	}
+}
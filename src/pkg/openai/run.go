@@ -7,8 +7,6 @@ import (
 	tl "github.com/tuumbleweed/tintlog/logger"
 	"github.com/tuumbleweed/tintlog/palette"
 	"github.com/tuumbleweed/xerr"
-
-	"expense-tracker/src/pkg/util"
 )
 
 /*
@@ -24,6 +22,9 @@ Behavior:
   - "failed"|"cancelled"|"expired" -> purple, returns *xerr.Error
 
 3) Log token usage (when available).
+
+Every HTTP call is retried on transient errors (see doWithRetry); retries
+are recorded in meta.Retries.
 NOTE: We purposely DO NOT print the full response text here to avoid duplicate printing.
 
 	The caller (entrypoint) should print responseText.
@@ -49,9 +50,10 @@ func SendPromptReturnResponse(inputParameters InputParameters) (responseText str
 
 	tl.LogJSON(tl.Debug, palette.CyanDim, "request body", requestPayload)
 
-	initial, createErr := createResponse(inputParameters.OpenAIAPIKey, requestPayload)
+	retries := &retryLog{}
+	initial, createErr := createResponse(inputParameters.OpenAIAPIKey, requestPayload, retries)
 	if createErr != nil {
-		return "", LLMRunMetadata{}, createErr
+		return "", LLMRunMetadata{Retries: retries.records}, createErr
 	}
 
 	var finalResp responseObject
@@ -62,15 +64,19 @@ func SendPromptReturnResponse(inputParameters InputParameters) (responseText str
 	default:
 		// Explicit waiting log so the user sees progress right away
 		tl.Log(tl.Info, palette.Cyan, "%s current status is '%s' id - '%s' (polling every 2s)...", "Waiting for completion,", initial.Status, initial.ID)
-		resp, waitErr := waitForResponseCompletion(inputParameters.OpenAIAPIKey, initial.ID, 2*time.Second, 5*time.Minute)
+		resp, waitErr := waitForResponseCompletion(inputParameters.OpenAIAPIKey, initial.ID, 2*time.Second, 5*time.Minute, retries)
 		if waitErr != nil {
-			return "", LLMRunMetadata{ResponseID: initial.ID}, waitErr
+			return "", LLMRunMetadata{ResponseID: initial.ID, Retries: retries.records}, waitErr
 		}
 		finalResp = resp
 	}
 
 	text := extractOutputText(&finalResp)
 	meta = ExtractLLMRunMetadata(finalResp, startTime)
+	meta.Retries = retries.records
+	if len(meta.Retries) > 0 {
+		tl.Log(tl.Detailed, palette.YellowDim, "%s HTTP calls were retried for response '%s'", len(meta.Retries), finalResp.ID)
+	}
 
 	// Token usage logging (if available)
 	if finalResp.Usage != nil {
@@ -97,6 +103,5 @@ func SendPromptReturnResponse(inputParameters InputParameters) (responseText str
 	tl.Log(tl.Debug1, palette.GreenDim, "You can %s at '%s'", "view conversation URL", conversationUrl)
 	// Do NOT log the full text here — leave that to the caller to avoid duplicates.
 
-	util.WaitForSeconds(3)
 	return text, meta, nil
 }
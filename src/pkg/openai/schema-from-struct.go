@@ -0,0 +1,128 @@
+package openai
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/tuumbleweed/xerr"
+)
+
+/*
+SchemaPropertiesFromStruct builds a JSON Schema "properties" map from a Go
+struct value, ready to be passed to StrictObj / UseChatGPTResponsesAPI.
+
+Struct tags used:
+  - json:"name"        property name (fields with json:"-" are skipped)
+  - schema:"-"         skip the field (e.g. metadata filled in by our code, not the model)
+  - desc:"..."         property description shown to the model
+
+Behavior:
+  - string -> "string", ints -> "integer", floats -> "number", bool -> "boolean".
+  - Slices/arrays become {"type":"array","items":<element schema>}.
+  - Nested structs become strict objects (all properties required,
+    additionalProperties=false), same as StrictObj.
+  - Pointers are dereferenced.
+  - Any other kind (maps, interfaces, channels) returns a *xerr.Error, since
+    strict structured outputs cannot describe them.
+*/
+func SchemaPropertiesFromStruct(value any) (properties map[string]any, e *xerr.Error) {
+	valueType := reflect.TypeOf(value)
+	for valueType != nil && valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+	if valueType == nil || valueType.Kind() != reflect.Struct {
+		e = xerr.NewError(fmt.Errorf("expected a struct, got '%v'", valueType), "build JSON schema properties", value)
+		return nil, e
+	}
+
+	return structSchemaProperties(valueType)
+}
+
+/*
+structSchemaProperties walks exported fields of structType and returns the
+"properties" map for it.
+*/
+func structSchemaProperties(structType reflect.Type) (properties map[string]any, e *xerr.Error) {
+	properties = make(map[string]any)
+
+	for index := 0; index < structType.NumField(); index++ {
+		field := structType.Field(index)
+		if !field.IsExported() || field.Tag.Get("schema") == "-" {
+			continue
+		}
+
+		name := schemaFieldName(field)
+		if name == "" {
+			continue
+		}
+
+		fieldSchema, fieldErr := typeSchema(field.Type, structType.Name()+"."+field.Name)
+		if fieldErr != nil {
+			return nil, fieldErr
+		}
+
+		description := field.Tag.Get("desc")
+		if description != "" {
+			fieldSchema["description"] = description
+		}
+
+		properties[name] = fieldSchema
+	}
+
+	return properties, nil
+}
+
+/*
+typeSchema returns the JSON Schema fragment for a single Go type.
+fieldPath is only used for error context.
+*/
+func typeSchema(fieldType reflect.Type, fieldPath string) (schema map[string]any, e *xerr.Error) {
+	for fieldType.Kind() == reflect.Pointer {
+		fieldType = fieldType.Elem()
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Slice, reflect.Array:
+		itemSchema, itemErr := typeSchema(fieldType.Elem(), fieldPath+"[]")
+		if itemErr != nil {
+			return nil, itemErr
+		}
+		return map[string]any{"type": "array", "items": itemSchema}, nil
+	case reflect.Struct:
+		nestedProperties, nestedErr := structSchemaProperties(fieldType)
+		if nestedErr != nil {
+			return nil, nestedErr
+		}
+		return StrictObj(nestedProperties), nil
+	default:
+		e = xerr.NewError(fmt.Errorf("unsupported kind '%s'", fieldType.Kind()), "build JSON schema for field", fieldPath)
+		return nil, e
+	}
+}
+
+/*
+schemaFieldName returns the JSON property name for a struct field, or "" if
+the field is excluded from JSON.
+*/
+func schemaFieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
@@ -105,12 +105,13 @@ type Reasoning struct {
 // Keep it alongside your result payload for auditing and cost tracking.
 type LLMRunMetadata struct {
 	// Core
-	ResponseID      string `json:"response_id"`       // can make url out of it to see it at https://platform.openai.com/logs/<ResponseID>
-	ResponseLogsUrl string `json:"response_logs_url"` // https://platform.openai.com/logs/<ResponseID>
-	Model           string `json:"model"`             // e.g., "gpt-5-mini"
-	ModelSnapshot   string `json:"model_snapshot"`    // Parsed snapshot date, e.g. "2025-08-07" if present/valid
-	Status          string `json:"status"`            // response.status (e.g., "completed")
-	ReasoningEffort Effort `json:"reasoning_effort"`  // "minimal" | "low" | "medium" | "high"
+	Provider        string `json:"provider,omitempty"` // which backend produced it, e.g. "openai" or "local"
+	ResponseID      string `json:"response_id"`        // can make url out of it to see it at https://platform.openai.com/logs/<ResponseID>
+	ResponseLogsUrl string `json:"response_logs_url"`  // https://platform.openai.com/logs/<ResponseID>
+	Model           string `json:"model"`              // e.g., "gpt-5-mini"
+	ModelSnapshot   string `json:"model_snapshot"`     // Parsed snapshot date, e.g. "2025-08-07" if present/valid
+	Status          string `json:"status"`             // response.status (e.g., "completed")
+	ReasoningEffort Effort `json:"reasoning_effort"`   // "minimal" | "low" | "medium" | "high"
 
 	// Parameters
 	Temperature float64 `json:"temperature"`
@@ -122,8 +123,14 @@ type LLMRunMetadata struct {
 	TokensReasoning int `json:"tokens_reasoning"` // tokens spent on reasoning
 	TokensTotal     int `json:"tokens_total"`
 
+	// Cost
+	CostUSD float64 `json:"cost_usd"` // estimated from token counts and Cfg.ModelPrices, 0 if the model has no price
+
 	// Timing & IDs
 	StartedAt  int64 `json:"started_at"`
 	FinishedAt int64 `json:"finished_at"`
 	Elapsed    int64 `json:"elapsed"` // milliseconds
+
+	// Reliability
+	Retries []RetryRecord `json:"retries,omitempty"` // failed HTTP attempts that were retried
 }
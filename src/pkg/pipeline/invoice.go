@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/category"
+	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/dian"
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/llm"
+	"expense-tracker/src/pkg/ocr"
+	"expense-tracker/src/pkg/receipt"
+)
+
+// InvoiceFileBaseName is the copy of the e-invoice (.xml or .zip) in a run directory.
+const InvoiceFileBaseName = "invoice"
+
+/*
+ImportInvoice is ProcessImage for a DIAN electronic invoice (.xml or .zip):
+the UBL XML is parsed into the analysis instead of OCR + LLM, then only the
+item names go to the LLM for English names and categories, and the receipt
+is saved and stored in the ledger like a photographed one. Run directories
+get invoice.<ext> and no OCR artifacts. hashes only needs SHA256.
+*/
+func ImportInvoice(invoicePath string, hashes imageindex.ImageHashes, options Options) (result Result, failedStage Stage, e *xerr.Error) {
+	runDirPath := options.ResumeRunDir
+	manifest := Manifest{Stages: make(map[Stage]StageRecord)}
+	if runDirPath != "" {
+		var found bool
+		manifest, found, e = LoadManifest(runDirPath)
+		if e != nil {
+			return result, StageParse, e
+		}
+		if found {
+			tl.Log(tl.Info1, palette.Cyan, "Resuming '%s' in run dir '%s'", invoicePath, runDirPath)
+		}
+	} else {
+		runDirPath, e = ocr.CreateRunDir(options.OutputDirPath)
+		if e != nil {
+			return result, StageParse, e
+		}
+		if options.OnRunDir != nil {
+			options.OnRunDir(runDirPath)
+		}
+	}
+	result.RunDir = runDirPath
+	manifest.ImagePath = invoicePath
+	manifest.SourceSHA256 = hashes.SHA256
+
+	// 1) Parse the XML
+	options.startStage(StageParse)
+	startedAt := time.Now()
+	parseTools := map[string]string{"dian_parser": dian.ParserVersion}
+	parseInputHash := hashInputs(parseTools, hashes.SHA256)
+	analysis, e := parseInvoiceIntoRunDir(invoicePath, runDirPath)
+	manifest.recordStage(runDirPath, StageParse, parseInputHash, parseTools, startedAt, e)
+	if e != nil {
+		return result, StageParse, e
+	}
+	analysis.Source = &receipt.Source{ImagePath: invoicePath, SHA256: hashes.SHA256}
+
+	// 2) Item names to the LLM, checks and receipt-analysis.json
+	result, failedStage, e = categorizeInvoice(&manifest, result, analysis, options)
+	if e != nil {
+		return result, failedStage, e
+	}
+
+	// 3) Ledger
+	result, e = storeInLedger(&manifest, result, options.Ledger)
+	if e != nil {
+		return result, StageLedger, e
+	}
+
+	// 4) Review queue
+	failedStage, e = reviewReceipt(&manifest, result, options)
+	if e != nil {
+		return result, failedStage, e
+	}
+
+	tl.Log(
+		tl.Notice, palette.GreenBold, "Imported e-invoice '%s' ('%s' items) into '%s'",
+		invoicePath, len(result.Analysis.Items), runDirPath,
+	)
+	return result, "", nil
+}
+
+/*
+parseInvoiceIntoRunDir copies the invoice into the run directory and
+parses it.
+*/
+func parseInvoiceIntoRunDir(invoicePath, runDirPath string) (analysis receipt.Analysis, e *xerr.Error) {
+	invoiceBytes, readErr := os.ReadFile(invoicePath)
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read e-invoice", invoicePath)
+		return analysis, e
+	}
+	copyPath := filepath.Join(runDirPath, InvoiceFileBaseName+strings.ToLower(filepath.Ext(invoicePath)))
+	writeErr := os.WriteFile(copyPath, invoiceBytes, 0o644)
+	if writeErr != nil {
+		e = xerr.NewError(writeErr, "copy e-invoice to run directory", copyPath)
+		return analysis, e
+	}
+
+	analysis, e = dian.ParseFile(copyPath)
+	if e != nil {
+		return analysis, e
+	}
+	tl.Log(
+		tl.Info1, palette.Green, "Parsed e-invoice '%s' of '%s': '%s' items, total '%s' %s",
+		analysis.Invoice.Number, analysis.MerchantName, len(analysis.Items),
+		fmt.Sprintf("%.2f", analysis.Totals.ReceiptTotal), analysis.Currency,
+	)
+	return analysis, nil
+}
+
+/*
+categorizeInvoice is the LLM stage of ImportInvoice: it fills in English
+names and categories, checks totals and saves receipt-analysis.json, or
+keeps the saved analysis if the same names were already categorized with
+the same model and prompt. Items the product memory knows are not sent to
+the LLM.
+*/
+func categorizeInvoice(manifest *Manifest, result Result, analysis receipt.Analysis, options Options) (Result, Stage, *xerr.Error) {
+	runDirPath := result.RunDir
+	analysisPath := receipt.AnalysisPath(runDirPath)
+
+	// Items the product memory knows don't go to the LLM, unless their
+	// remembered category left the taxonomy (recategorize skips those).
+	remembered, e := options.Ledger.LookupProducts(analysis)
+	if e != nil {
+		return result, StageLLM, e
+	}
+	names := make([]string, 0, len(analysis.Items))
+	askedItems := make([]int, 0, len(analysis.Items))
+	for index, item := range analysis.Items {
+		if product, known := remembered[index]; known {
+			if _, found := category.Current.Lookup(product.CategoryKey); found {
+				continue
+			}
+		}
+		names = append(names, item.OriginalProductName)
+		askedItems = append(askedItems, index)
+	}
+
+	llmInputHash, e := llm.ItemNamesRequestFingerprint(names, category.Current)
+	if e != nil {
+		return result, StageLLM, e
+	}
+	// The parsed invoice is part of the input: a parser change redoes the analysis.
+	llmInputHash = hashInputs(nil, llmInputHash, manifest.Stages[StageParse].InputHash)
+
+	if !options.ForceLLM && manifest.IsCurrent(StageLLM, llmInputHash) && config.FileExists(analysisPath) {
+		result.Analysis, e = receipt.LoadAnalysis(analysisPath)
+		if e == nil {
+			// The rules and the product memory may have changed since; they don't need the LLM.
+			e = recategorizeSaved(options.Ledger, analysisPath, &result.Analysis)
+			if e != nil {
+				return result, StageSave, e
+			}
+			result.SkippedStages = append(result.SkippedStages, StageLLM)
+			return result, "", nil
+		}
+		tl.Log(tl.Warning, palette.PurpleBright, "Redoing LLM stage, saved analysis is unreadable: '%s'", e.ErrStr)
+	}
+
+	options.startStage(StageLLM)
+	startedAt := time.Now()
+	llmTools := map[string]string{
+		"provider":         string(llm.Cfg.Provider),
+		"model":            llm.Cfg.Model,
+		"reasoning_effort": string(llm.Cfg.ReasoningEffort),
+	}
+
+	if len(names) > 0 {
+		itemCategories, e := llm.CategorizeItemNames(names, category.Current)
+		if e != nil {
+			manifest.recordStage(runDirPath, StageLLM, llmInputHash, llmTools, startedAt, e)
+			return result, StageLLM, e
+		}
+		if itemCategories.LLMRunMetadata != nil && itemCategories.LLMRunMetadata.ModelSnapshot != "" {
+			llmTools["model_snapshot"] = itemCategories.LLMRunMetadata.ModelSnapshot
+		}
+		analysis.LLMRunMetadata = itemCategories.LLMRunMetadata
+		for index, itemCategory := range itemCategories.Items {
+			analysis.Items[askedItems[index]].ProductNameEnglish = itemCategory.ProductNameEnglish
+			analysis.Items[askedItems[index]].CategoryKey = itemCategory.CategoryKey
+		}
+	} else {
+		tl.Log(tl.Info1, palette.Green, "All '%s' items are in the product memory, not calling the LLM", len(analysis.Items))
+	}
+	_, e = recategorize(options.Ledger, &analysis)
+	if e != nil {
+		manifest.recordStage(runDirPath, StageLLM, llmInputHash, llmTools, startedAt, e)
+		return result, StageLLM, e
+	}
+
+	e = receipt.SaveAnalysis(analysisPath, analysis)
+	if e != nil {
+		return result, StageSave, e
+	}
+	result.Analysis = analysis
+
+	manifest.recordStage(runDirPath, StageLLM, llmInputHash, llmTools, startedAt, nil)
+	return result, "", nil
+}
@@ -0,0 +1,227 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/receipt"
+)
+
+// ManifestFileName is the run manifest inside every run directory.
+const ManifestFileName = "manifest.json"
+
+// StageStatus is the outcome of the last time a stage ran.
+type StageStatus string
+
+const (
+	StageDone   StageStatus = "done"
+	StageFailed StageStatus = "failed"
+)
+
+/*
+StageRecord is the last run of one stage.
+
+Fields:
+  - Status: done or failed.
+  - InputHash: SHA-256 over everything the stage output depends on; the
+    stage is skipped when resuming if it is done and this still matches.
+  - Tools: versions and settings that produced the output (tesseract
+    version, LLM provider and model, ...).
+  - StartedAt, FinishedAt: Unix milliseconds.
+  - Error: why it failed (failed only).
+*/
+type StageRecord struct {
+	Status     StageStatus       `json:"status"`
+	InputHash  string            `json:"input_hash,omitempty"`
+	Tools      map[string]string `json:"tools,omitempty"`
+	StartedAt  int64             `json:"started_at"`
+	FinishedAt int64             `json:"finished_at"`
+	Error      *xerr.Error       `json:"error,omitempty"`
+}
+
+/*
+Manifest is <run dir>/manifest.json: where the run came from and the state
+of each stage (StageSplit, StageOCR or StageParse, StageLLM, StageValidate,
+StageLedger).
+
+Fields:
+  - ImagePath, SourceSHA256, SourcePerceptualHash: the input image (for a
+    receipt stitched from several photos: the paths joined with "," and
+    imageindex.GroupHashes).
+  - Segment: for a receipt split from a photo of several receipts, where
+    it is on the photo (ImagePath and the hashes are then the photo's).
+  - Language: OCR language(s).
+  - Stages: last run of each stage.
+  - UpdatedAt: Unix milliseconds of the last write.
+*/
+type Manifest struct {
+	ImagePath            string                `json:"image_path"`
+	SourceSHA256         string                `json:"source_sha256"`
+	SourcePerceptualHash string                `json:"source_perceptual_hash"`
+	Segment              *receipt.Segment      `json:"segment,omitempty"`
+	Language             string                `json:"language"`
+	Stages               map[Stage]StageRecord `json:"stages"`
+	UpdatedAt            int64                 `json:"updated_at"`
+}
+
+/*
+ManifestPath returns the manifest path inside runDirPath.
+*/
+func ManifestPath(runDirPath string) string {
+	return filepath.Join(runDirPath, ManifestFileName)
+}
+
+/*
+LoadManifest reads the manifest of a run directory. found is false for run
+directories created before manifests were written.
+*/
+func LoadManifest(runDirPath string) (manifest Manifest, found bool, e *xerr.Error) {
+	manifestPath := ManifestPath(runDirPath)
+	manifest.Stages = make(map[Stage]StageRecord)
+
+	jsonBytes, readErr := os.ReadFile(manifestPath)
+	if os.IsNotExist(readErr) {
+		return manifest, false, nil
+	}
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read run manifest", manifestPath)
+		return manifest, false, e
+	}
+
+	unmarshalErr := json.Unmarshal(jsonBytes, &manifest)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "parse run manifest", manifestPath)
+		return manifest, false, e
+	}
+	if manifest.Stages == nil {
+		manifest.Stages = make(map[Stage]StageRecord)
+	}
+
+	return manifest, true, nil
+}
+
+/*
+SaveManifest writes the manifest of a run directory (through a temporary
+file, so an interrupted write doesn't leave half a manifest).
+*/
+func SaveManifest(runDirPath string, manifest Manifest) (e *xerr.Error) {
+	manifestPath := ManifestPath(runDirPath)
+	manifest.UpdatedAt = time.Now().UnixMilli()
+
+	jsonBytes, marshalErr := json.MarshalIndent(manifest, "", "  ")
+	if marshalErr != nil {
+		e = xerr.NewError(marshalErr, "marshal run manifest", manifestPath)
+		return e
+	}
+
+	temporaryPath := manifestPath + ".tmp"
+	writeErr := os.WriteFile(temporaryPath, jsonBytes, 0o644)
+	if writeErr == nil {
+		writeErr = os.Rename(temporaryPath, manifestPath)
+	}
+	if writeErr != nil {
+		e = xerr.NewError(writeErr, "write run manifest", manifestPath)
+		return e
+	}
+
+	return nil
+}
+
+/*
+IsCurrent reports whether stage is done with exactly these inputs.
+*/
+func (manifest Manifest) IsCurrent(stage Stage, inputHash string) bool {
+	record, exists := manifest.Stages[stage]
+	return exists && record.Status == StageDone && inputHash != "" && record.InputHash == inputHash
+}
+
+/*
+recordStage stores the outcome of a stage run and saves the manifest. A
+manifest that can't be saved is only logged: the stage output is still good,
+the next resume just redoes the stage.
+*/
+func (manifest *Manifest) recordStage(runDirPath string, stage Stage, inputHash string, tools map[string]string, startedAt time.Time, stageErr *xerr.Error) {
+	record := StageRecord{
+		Status:     StageDone,
+		InputHash:  inputHash,
+		Tools:      tools,
+		StartedAt:  startedAt.UnixMilli(),
+		FinishedAt: time.Now().UnixMilli(),
+	}
+	if stageErr != nil {
+		record.Status = StageFailed
+		record.Error = stageErr
+	}
+	manifest.Stages[stage] = record
+
+	e := SaveManifest(runDirPath, *manifest)
+	if e != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed saving run manifest: %s: '%s'", e.Msg, e.ErrStr)
+	}
+}
+
+/*
+FindRunDirsBySource maps the source image SHA-256 of every run directory
+with a manifest under outputDirPath (<out>/<month-year>/<run>) to the most
+recently updated one, for -resume.
+*/
+func FindRunDirsBySource(outputDirPath string) (runDirsBySHA256 map[string]string, e *xerr.Error) {
+	pattern := filepath.Join(outputDirPath, "*", "*", ManifestFileName)
+	manifestPaths, globErr := filepath.Glob(pattern)
+	if globErr != nil {
+		e = xerr.NewError(globErr, "glob for run manifests", pattern)
+		return nil, e
+	}
+
+	runDirsBySHA256 = make(map[string]string)
+	updatedAtBySHA256 := make(map[string]int64)
+	for _, manifestPath := range manifestPaths {
+		runDirPath := filepath.Dir(manifestPath)
+		manifest, found, loadErr := LoadManifest(runDirPath)
+		if loadErr != nil {
+			tl.Log(tl.Warning, palette.PurpleBright, "Skipping run manifest '%s': '%s'", manifestPath, loadErr.ErrStr)
+			continue
+		}
+		if !found || manifest.SourceSHA256 == "" {
+			continue
+		}
+		if manifest.UpdatedAt >= updatedAtBySHA256[manifest.SourceSHA256] {
+			runDirsBySHA256[manifest.SourceSHA256] = runDirPath
+			updatedAtBySHA256[manifest.SourceSHA256] = manifest.UpdatedAt
+		}
+	}
+
+	return runDirsBySHA256, nil
+}
+
+/*
+hashInputs returns a SHA-256 over parts and the sorted tools map.
+*/
+func hashInputs(tools map[string]string, parts ...string) string {
+	hasher := sha256.New()
+	for _, part := range parts {
+		hasher.Write([]byte(part))
+		hasher.Write([]byte{0})
+	}
+
+	toolNames := make([]string, 0, len(tools))
+	for toolName := range tools {
+		toolNames = append(toolNames, toolName)
+	}
+	sort.Strings(toolNames)
+	for _, toolName := range toolNames {
+		hasher.Write([]byte(toolName + "=" + tools[toolName]))
+		hasher.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -0,0 +1,577 @@
+/*
+Single-image receipt pipeline: OCR, LLM analysis, receipt-analysis.json and
+the ledger. Used by receipt-pipeline (batches of files) and receipt-server
+(uploads).
+*/
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/category"
+	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/inputfile"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/llm"
+	"expense-tracker/src/pkg/ocr"
+	"expense-tracker/src/pkg/preprocess"
+	"expense-tracker/src/pkg/receipt"
+)
+
+// Stage names the step an image failed at.
+type Stage string
+
+const (
+	StageHash     Stage = "hash"
+	StageSplit    Stage = "split" // several receipts in one photo
+	StageOCR      Stage = "ocr"
+	StageParse    Stage = "parse" // e-invoices, instead of StageOCR
+	StageLLM      Stage = "llm"
+	StageValidate Stage = "validate"
+	StageSave     Stage = "save"
+	StageLedger   Stage = "ledger"
+)
+
+/*
+Options are the per-image settings.
+
+Fields:
+  - OutputDirPath: directory run directories are created in (usually
+    MonthOutputDir of the -out directory).
+  - Language: Tesseract language(s), e.g. "eng+spa".
+  - PriceDifference: fail at StageValidate when the items don't add up to
+    the receipt total (the receipt is still stored and queued for review).
+  - Ledger: where the receipt is stored.
+  - OnStage: optional, called when a stage starts (StageOCR, StageLLM), so
+    callers like the job queue can report progress.
+  - OnRunDir: optional, called with the run directory right after it is
+    created, so callers like the job queue can resume in it after a crash.
+  - ResumeRunDir: optional earlier run directory of the same image to
+    continue in; stages its manifest shows done with the same inputs are
+    skipped.
+  - ForceLLM: run the LLM stage even if its inputs haven't changed.
+*/
+type Options struct {
+	OutputDirPath   string
+	Language        string
+	PriceDifference bool
+	Ledger          *ledger.Ledger
+	OnStage         func(stage Stage)
+	OnRunDir        func(runDirPath string)
+	ResumeRunDir    string
+	ForceLLM        bool
+
+	segmentOf *segmentOf // set for the receipts of a split photo
+}
+
+/*
+segmentOf is where a receipt cropped from a photo of several receipts came
+from (see processSplitReceipts).
+*/
+type segmentOf struct {
+	ImagePath string // the photo given to the pipeline
+	Segment   receipt.Segment
+}
+
+/*
+Result is what a processed image produced.
+
+Fields:
+  - RunDir: run directory with the OCR/LLM artifacts. Also set on failure
+    once it was created, so partial artifacts can be inspected.
+  - ReceiptID: ledger id of the stored receipt.
+  - Analysis: the saved receipt analysis.
+  - SkippedStages: stages reused from an earlier run because their inputs
+    hadn't changed.
+  - Receipts: for a photo of several receipts, or photos given as parts of
+    one receipt that share no text, the result of each receipt (RunDir is
+    then the photo's or group's run directory, with no analysis or
+    ReceiptID of its own).
+*/
+type Result struct {
+	RunDir        string           `json:"run_dir"`
+	ReceiptID     int64            `json:"receipt_id,omitempty"`
+	Analysis      receipt.Analysis `json:"analysis"`
+	SkippedStages []Stage          `json:"skipped_stages,omitempty"`
+	Receipts      []Result         `json:"receipts,omitempty"`
+}
+
+/*
+MonthOutputDir returns "<outputDirPath>/<month>-<year>" for the given time,
+e.g. "./out/september-2006".
+*/
+func MonthOutputDir(outputDirPath string, now time.Time) string {
+	monthName := strings.ToLower(now.Month().String())
+	yearMonthDirName := fmt.Sprintf("%s-%04d", monthName, now.Year())
+	return filepath.Join(outputDirPath, yearMonthDirName)
+}
+
+/*
+IsAllowedImageExt reports whether ext (with the dot) is an input type the
+pipeline accepts: JPEG/PNG images, WebP, HEIC/HEIF and PDF.
+*/
+func IsAllowedImageExt(ext string) bool {
+	_, supported := inputfile.KindOf(ext)
+	return supported
+}
+
+/*
+ProcessImage runs OCR and LLM analysis for a single image, saves
+receipt-analysis.json and stores the receipt in the ledger, recording every
+stage in the run manifest. On failure it also returns the stage that failed.
+
+With preprocess split_receipts set, a photo of several receipts is first
+split, and every receipt is processed in its own run directory inside the
+photo's (see Result.Receipts).
+*/
+func ProcessImage(imagePath string, hashes imageindex.ImageHashes, options Options) (result Result, failedStage Stage, e *xerr.Error) {
+	return ProcessImages([]string{imagePath}, hashes, options)
+}
+
+/*
+ProcessImages is ProcessImage for a long receipt photographed in several
+overlapping parts (imagePaths top to bottom, hashes from
+imageindex.GroupHashes): each photo is OCR'd, the texts are merged into one
+and all photos go to the LLM together. With a single path it is
+ProcessImage.
+*/
+func ProcessImages(imagePaths []string, hashes imageindex.ImageHashes, options Options) (result Result, failedStage Stage, e *xerr.Error) {
+	imagePath := strings.Join(imagePaths, ",")
+	runDirPath := options.ResumeRunDir
+	manifest := Manifest{Stages: make(map[Stage]StageRecord)}
+	if runDirPath != "" {
+		var found bool
+		manifest, found, e = LoadManifest(runDirPath)
+		if e != nil {
+			return result, StageOCR, e
+		}
+		if found {
+			tl.Log(tl.Info1, palette.Cyan, "Resuming '%s' in run dir '%s'", imagePath, runDirPath)
+		}
+	} else {
+		runDirPath, e = ocr.CreateRunDir(options.OutputDirPath)
+		if e != nil {
+			return result, StageOCR, e
+		}
+		if options.OnRunDir != nil {
+			options.OnRunDir(runDirPath)
+		}
+	}
+	result.RunDir = runDirPath
+	manifest.ImagePath = imagePath
+	manifest.SourceSHA256 = hashes.SHA256
+	manifest.SourcePerceptualHash = hashes.PerceptualHash
+	manifest.Language = options.Language
+	ocrInputParts := []string{hashes.SHA256, options.Language}
+	if options.segmentOf != nil {
+		manifest.ImagePath = options.segmentOf.ImagePath
+		manifest.Segment = &options.segmentOf.Segment
+		ocrInputParts = append(ocrInputParts, fmt.Sprint(*manifest.Segment))
+	}
+
+	// 1) Several receipts in one photo
+	if len(imagePaths) == 1 && options.segmentOf == nil && preprocess.Cfg.SplitReceipts {
+		split, e := splitPhoto(&manifest, &result, imagePaths[0], hashes, options)
+		if e != nil {
+			return result, StageSplit, e
+		}
+		if len(split.Receipts) > 0 {
+			return processSplitReceipts(result, split, imagePaths[0], hashes, options)
+		}
+	}
+
+	// 2) OCR pipeline
+	ocrTools := ocr.ToolVersions()
+	ocrInputHash := hashInputs(ocrTools, ocrInputParts...)
+	if manifest.IsCurrent(StageOCR, ocrInputHash) {
+		result.SkippedStages = append(result.SkippedStages, StageOCR)
+	} else {
+		options.startStage(StageOCR)
+		startedAt := time.Now()
+		if len(imagePaths) == 1 {
+			e = ocr.ProcessImageInRunDir(imagePaths[0], runDirPath, options.Language)
+		} else {
+			e = ocr.ProcessImagesInRunDir(imagePaths, runDirPath, options.Language)
+		}
+		manifest.recordStage(runDirPath, StageOCR, ocrInputHash, ocrTools, startedAt, e)
+		if e != nil && errors.Is(e.Err, ocr.ErrNoOverlap) {
+			return processPartsSeparately(result, imagePaths, options)
+		}
+		if e != nil {
+			return result, StageOCR, e
+		}
+	}
+
+	// 3) LLM analysis, checks and receipt-analysis.json
+	result, failedStage, e = analyzeRunDir(&manifest, result, options)
+	if e != nil {
+		return result, failedStage, e
+	}
+
+	// 4) Ledger
+	result, e = storeInLedger(&manifest, result, options.Ledger)
+	if e != nil {
+		return result, StageLedger, e
+	}
+
+	// 5) Review queue
+	failedStage, e = reviewReceipt(&manifest, result, options)
+	if e != nil {
+		return result, failedStage, e
+	}
+
+	tl.LogJSON(tl.Verbose, palette.CyanDim, "ReceiptAnalysis", result.Analysis)
+	tl.Log(
+		tl.Notice, palette.GreenBold, "%s",
+		"Receipt analysis generated and saved successfully",
+	)
+	if len(result.SkippedStages) > 0 {
+		tl.Log(tl.Info, palette.Cyan, "Reused stages '%s' from '%s'", fmt.Sprint(result.SkippedStages), runDirPath)
+	}
+
+	return result, "", nil
+}
+
+/*
+processPartsSeparately is the fallback for photos given as parts of one
+long receipt that share no text (ocr.ErrNoOverlap): they are most likely
+separate receipts, so each goes through the pipeline on its own, in its
+part-N directory of the group's run directory. A failed photo doesn't stop
+the others; the first failure is returned.
+*/
+func processPartsSeparately(result Result, imagePaths []string, options Options) (Result, Stage, *xerr.Error) {
+	tl.Log(
+		tl.Warning, palette.PurpleBold, "Photos '%s' share no text, processing them as separate receipts",
+		strings.Join(imagePaths, ", "),
+	)
+
+	var failedStage Stage
+	var firstErr *xerr.Error
+	for index, imagePath := range imagePaths {
+		partResult := Result{RunDir: filepath.Join(result.RunDir, ocr.PartDirName(index+1))}
+		partFailedStage := StageHash
+		hashes, e := imageindex.ComputeHashes(imagePath)
+		if e == nil {
+			partOptions := options
+			partOptions.ResumeRunDir = partResult.RunDir
+			partResult, partFailedStage, e = ProcessImages([]string{imagePath}, hashes, partOptions)
+		}
+		result.Receipts = append(result.Receipts, partResult)
+		if e != nil {
+			tl.Log(
+				tl.Warning, palette.PurpleBold, "Photo '%s' failed at stage '%s': %s: '%s'",
+				imagePath, string(partFailedStage), e.Msg, e.ErrStr,
+			)
+			if firstErr == nil {
+				failedStage, firstErr = partFailedStage, e
+			}
+		}
+	}
+	return result, failedStage, firstErr
+}
+
+/*
+ReanalyzeRunDir reruns the LLM stage over an existing run directory (its
+ocr.txt, prices.json and orig.* image, or those of its parts) and stores
+the new analysis in the ledger. Unless options.ForceLLM is set, run directories whose LLM inputs
+(model, prompt, OCR text, image) haven't changed are left alone.
+*/
+func ReanalyzeRunDir(runDirPath string, options Options) (result Result, failedStage Stage, e *xerr.Error) {
+	result.RunDir = runDirPath
+	manifest, found, e := LoadManifest(runDirPath)
+	if e != nil {
+		return result, StageLLM, e
+	}
+
+	if !found {
+		// Run dirs from before manifests: take the source from the old analysis.
+		previous, loadErr := receipt.LoadAnalysis(receipt.AnalysisPath(runDirPath))
+		if loadErr == nil && previous.Source != nil {
+			manifest.ImagePath = previous.Source.ImagePath
+			manifest.SourceSHA256 = previous.Source.SHA256
+			manifest.SourcePerceptualHash = previous.Source.PerceptualHash
+		}
+	}
+
+	result, failedStage, e = analyzeRunDir(&manifest, result, options)
+	if e != nil {
+		return result, failedStage, e
+	}
+
+	result, e = storeInLedger(&manifest, result, options.Ledger)
+	if e != nil {
+		return result, StageLedger, e
+	}
+
+	failedStage, e = reviewReceipt(&manifest, result, options)
+	if e != nil {
+		return result, failedStage, e
+	}
+
+	return result, "", nil
+}
+
+/*
+analyzeRunDir is the LLM stage: it sends the OCR artifacts of
+result.RunDir to the LLM, checks totals and saves receipt-analysis.json, or
+loads the saved analysis if the manifest shows the same inputs were already
+analyzed.
+*/
+func analyzeRunDir(manifest *Manifest, result Result, options Options) (Result, Stage, *xerr.Error) {
+	runDirPath := result.RunDir
+	pricesPath := filepath.Join(runDirPath, "prices.json")
+	ocrTextPath := filepath.Join(runDirPath, "ocr.txt")
+	analysisPath := receipt.AnalysisPath(runDirPath)
+
+	ocrTextBytes, readErr := os.ReadFile(ocrTextPath)
+	if readErr != nil {
+		e := xerr.NewError(readErr, "read OCR text file", ocrTextPath)
+		return result, StageOCR, e
+	}
+	ocrText := string(ocrTextBytes)
+
+	ocrPrices, e := llm.ReadOcrPricesFromFile(pricesPath)
+	if e != nil {
+		return result, StageOCR, e
+	}
+
+	origImagePaths, e := FindOriginalImagePaths(runDirPath)
+	if e != nil {
+		return result, StageOCR, e
+	}
+
+	tl.Log(
+		tl.Info1, palette.Cyan, "Loaded OCR artifacts from '%s' (ocr len: '%s', images: '%s')",
+		runDirPath, fmt.Sprintf("%d", len(ocrText)), strings.Join(origImagePaths, "', '"),
+	)
+
+	llmInputHash, e := llm.ReceiptImageRequestFingerprint(origImagePaths, ocrText, ocrPrices, category.Current)
+	if e != nil {
+		return result, StageLLM, e
+	}
+
+	if !options.ForceLLM && manifest.IsCurrent(StageLLM, llmInputHash) && config.FileExists(analysisPath) {
+		result.Analysis, e = receipt.LoadAnalysis(analysisPath)
+		if e == nil {
+			// The rules and the product memory may have changed since; they don't need the LLM.
+			e = recategorizeSaved(options.Ledger, analysisPath, &result.Analysis)
+			if e != nil {
+				return result, StageSave, e
+			}
+			result.SkippedStages = append(result.SkippedStages, StageLLM)
+			return result, "", nil
+		}
+		tl.Log(tl.Warning, palette.PurpleBright, "Redoing LLM stage, saved analysis is unreadable: '%s'", e.ErrStr)
+	}
+
+	knownProducts, e := knownProductsInText(options.Ledger, ocrText)
+	if e != nil {
+		return result, StageLLM, e
+	}
+
+	options.startStage(StageLLM)
+	startedAt := time.Now()
+	llmTools := map[string]string{
+		"provider":         string(llm.Cfg.Provider),
+		"model":            llm.Cfg.Model,
+		"reasoning_effort": string(llm.Cfg.ReasoningEffort),
+	}
+
+	receiptAnalysis, e := llm.GenerateReceiptAnalysisFromImage(origImagePaths, ocrText, ocrPrices, category.Current, knownProducts)
+	if e != nil {
+		manifest.recordStage(runDirPath, StageLLM, llmInputHash, llmTools, startedAt, e)
+		return result, StageLLM, e
+	}
+	if receiptAnalysis.LLMRunMetadata != nil && receiptAnalysis.LLMRunMetadata.ModelSnapshot != "" {
+		llmTools["model_snapshot"] = receiptAnalysis.LLMRunMetadata.ModelSnapshot
+	}
+
+	_, e = recategorize(options.Ledger, &receiptAnalysis)
+	if e != nil {
+		manifest.recordStage(runDirPath, StageLLM, llmInputHash, llmTools, startedAt, e)
+		return result, StageLLM, e
+	}
+	linkItemBoxes(runDirPath, &receiptAnalysis)
+
+	if manifest.SourceSHA256 != "" {
+		receiptAnalysis.Source = &receipt.Source{
+			ImagePath:      manifest.ImagePath,
+			SHA256:         manifest.SourceSHA256,
+			PerceptualHash: manifest.SourcePerceptualHash,
+			Segment:        manifest.Segment,
+		}
+	}
+	if config.FileExists(analysisPath) {
+		// Keep the confirmation of analyses confirmed before it went into the corrections.
+		previous, loadErr := receipt.LoadAnalysis(analysisPath)
+		if loadErr == nil {
+			receiptAnalysis.ConfirmedAt = previous.ConfirmedAt
+		}
+	}
+
+	e = receipt.SaveAnalysis(analysisPath, receiptAnalysis)
+	if e != nil {
+		return result, StageSave, e
+	}
+	result.Analysis = receiptAnalysis
+
+	manifest.recordStage(runDirPath, StageLLM, llmInputHash, llmTools, startedAt, nil)
+
+	tl.Log(
+		tl.Info, palette.Green, "%s to '%s'",
+		"Saved receipt analysis", analysisPath,
+	)
+	return result, "", nil
+}
+
+/*
+linkItemBoxes points every item at the ocr-boxes.json line its LineIndex
+refers to. Run directories without boxes (OCR'd before they were saved)
+keep items without one.
+*/
+func linkItemBoxes(runDirPath string, analysis *receipt.Analysis) {
+	boxes, found, e := ocr.LoadBoxes(runDirPath)
+	if e != nil {
+		tl.Log(tl.Warning, palette.PurpleBright, "Items won't reference OCR boxes: %s: '%s'", e.Msg, e.ErrStr)
+		return
+	}
+	if !found {
+		return
+	}
+
+	linked := 0
+	for index := range analysis.Items {
+		item := &analysis.Items[index]
+		line, lineFound := boxes.LineForOCRLine(item.LineIndex)
+		if !lineFound {
+			continue
+		}
+		item.Box = &receipt.ItemBox{
+			Image:  line.Image,
+			Line:   line.Index,
+			Left:   line.Box.Left,
+			Top:    line.Box.Top,
+			Right:  line.Box.Right,
+			Bottom: line.Box.Bottom,
+		}
+		linked++
+	}
+	tl.Log(tl.Info1, palette.Cyan, "Linked '%s' of '%s' items to OCR line boxes", linked, len(analysis.Items))
+}
+
+/*
+storeInLedger is the ledger stage. It is skipped when the manifest shows
+this exact receipt-analysis.json was stored and the receipt is still there.
+*/
+func storeInLedger(manifest *Manifest, result Result, receiptLedger *ledger.Ledger) (Result, *xerr.Error) {
+	runDirPath := result.RunDir
+	analysisHash, e := imageindex.FileSHA256(receipt.AnalysisPath(runDirPath))
+	if e != nil {
+		return result, e
+	}
+
+	if manifest.IsCurrent(StageLedger, analysisHash) {
+		receiptID, found, findErr := receiptLedger.FindReceiptID(runDirPath)
+		if findErr == nil && found {
+			result.ReceiptID = receiptID
+			result.SkippedStages = append(result.SkippedStages, StageLedger)
+			return result, nil
+		}
+	}
+
+	startedAt := time.Now()
+	result.ReceiptID, e = receiptLedger.SaveReceipt(runDirPath, result.Analysis)
+	manifest.recordStage(runDirPath, StageLedger, analysisHash, map[string]string{"ledger": receiptLedger.Path}, startedAt, e)
+	if e != nil {
+		return result, e
+	}
+	tl.Log(tl.Info1, palette.Green, "Stored receipt '%s' in ledger '%s'", result.ReceiptID, receiptLedger.Path)
+
+	return result, nil
+}
+
+func (options Options) startStage(stage Stage) {
+	if options.OnStage != nil {
+		options.OnStage(stage)
+	}
+}
+
+/*
+ShouldIndex reports whether an image that ended with failedStage must be
+recorded in the image index: after success, after a ledger failure
+(receipt-analysis.json is saved, so don't pay for the LLM again;
+ledger-import can fill the ledger from it) and after a validate failure
+(the receipt is stored and waits in the review queue).
+*/
+func ShouldIndex(failedStage Stage) bool {
+	return failedStage == "" || failedStage == StageLedger || failedStage == StageValidate
+}
+
+/*
+AddToIndex records a processed image and saves the index right away, so an
+interrupted run keeps its progress.
+*/
+func AddToIndex(index *imageindex.Index, hashes imageindex.ImageHashes, imagePath string, runDirPath string) {
+	index.Add(hashes, imagePath, runDirPath)
+	e := index.Save()
+	if e != nil {
+		tl.Log(tl.Error, palette.RedBold, "Failed saving image index '%s': '%s'", index.Path, e)
+	}
+}
+
+/*
+FindOriginalImagePath returns the orig.* image copied into the run directory.
+*/
+func FindOriginalImagePath(runDirPath string) (imagePath string, e *xerr.Error) {
+	pattern := filepath.Join(runDirPath, "orig.*")
+	matches, globErr := filepath.Glob(pattern)
+	if globErr != nil {
+		e = xerr.NewError(globErr, "glob for original image", pattern)
+		return
+	}
+	if len(matches) == 0 {
+		err := fmt.Errorf("no original image found")
+		e = xerr.NewError(err, "missing original image (expected orig.*)", runDirPath)
+		return
+	}
+
+	imagePath = matches[0]
+	return
+}
+
+/*
+FindOriginalImagePaths returns the images of a run directory: orig.*, or
+for a stitched receipt the orig.* of every part directory in part order.
+*/
+func FindOriginalImagePaths(runDirPath string) (imagePaths []string, e *xerr.Error) {
+	partDirPaths, e := ocr.FindPartDirs(runDirPath)
+	if e != nil {
+		return nil, e
+	}
+	if len(partDirPaths) == 0 {
+		imagePath, e := FindOriginalImagePath(runDirPath)
+		if e != nil {
+			return nil, e
+		}
+		return []string{imagePath}, nil
+	}
+
+	for _, partDirPath := range partDirPaths {
+		imagePath, e := FindOriginalImagePath(partDirPath)
+		if e != nil {
+			return nil, e
+		}
+		imagePaths = append(imagePaths, imagePath)
+	}
+	return imagePaths, nil
+}
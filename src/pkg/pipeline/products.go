@@ -0,0 +1,136 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/category"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/llm"
+	"expense-tracker/src/pkg/receipt"
+)
+
+// maxProductHints caps how many remembered products are given to the LLM as examples.
+const maxProductHints = 30
+
+/*
+knownProductsInText returns the remembered products whose names appear in
+the OCR text, as examples for the LLM prompt.
+*/
+func knownProductsInText(receiptLedger *ledger.Ledger, ocrText string) (knownProducts []llm.KnownProduct, e *xerr.Error) {
+	hints, e := receiptLedger.ProductHints(ocrText, maxProductHints)
+	if e != nil {
+		return nil, e
+	}
+	for _, hint := range hints {
+		knownProducts = append(knownProducts, llm.KnownProduct{
+			ProductName:        hint.ProductName,
+			ProductNameEnglish: hint.ProductNameEnglish,
+			CategoryKey:        hint.CategoryKey,
+		})
+	}
+	return knownProducts, nil
+}
+
+/*
+recategorize overrides the categories the LLM gave: the categorization
+rules first (see category.ApplyRules), then the product memory for the
+items no rule matched (see ledger.Product). Like a rule, the memory keeps
+the LLM's category in LLMCategoryKey; it also sets the remembered English
+name. It reports whether any item changed.
+*/
+func recategorize(receiptLedger *ledger.Ledger, analysis *receipt.Analysis) (changed bool, e *xerr.Error) {
+	before := append([]receipt.Item{}, analysis.Items...)
+
+	fired, _ := category.ApplyRules(analysis)
+	remembered, e := receiptLedger.LookupProducts(*analysis)
+	if e != nil {
+		return false, e
+	}
+
+	applied := 0
+	for index, product := range remembered {
+		item := &analysis.Items[index]
+		if item.CategoryRule != "" {
+			continue
+		}
+		if _, found := category.Current.Lookup(product.CategoryKey); !found {
+			continue // remembered before the taxonomy changed
+		}
+		item.LLMCategoryKey = item.CategoryKey
+		item.CategoryKey = product.CategoryKey
+		item.CategoryRule = ledger.ProductMemoryRule
+		if product.ProductNameEnglish != "" {
+			item.ProductNameEnglish = product.ProductNameEnglish
+		}
+		applied++
+	}
+
+	if fired > 0 || applied > 0 {
+		tl.Log(
+			tl.Info1, palette.Green, "Categorization rules set '%s' and the product memory '%s' of '%s' item categories",
+			fired, applied, len(analysis.Items),
+		)
+	}
+
+	for index, item := range analysis.Items {
+		old := before[index]
+		if item.CategoryKey != old.CategoryKey || item.CategoryRule != old.CategoryRule ||
+			item.LLMCategoryKey != old.LLMCategoryKey || item.ProductNameEnglish != old.ProductNameEnglish {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+/*
+recategorizeSaved runs recategorize over an analysis loaded from
+analysisPath and saves it back if anything changed. Confirmed analyses
+(here or in the corrections) are left alone: their categories are the ones
+the memory was taught.
+*/
+func recategorizeSaved(receiptLedger *ledger.Ledger, analysisPath string, analysis *receipt.Analysis) (e *xerr.Error) {
+	corrections, _, e := receipt.LoadCorrections(filepath.Dir(analysisPath))
+	if e != nil {
+		return e
+	}
+	if corrections.Apply(*analysis).ConfirmedAt != "" {
+		return nil
+	}
+	changed, e := recategorize(receiptLedger, analysis)
+	if e != nil {
+		return e
+	}
+	if !changed {
+		return nil
+	}
+	return receipt.SaveAnalysis(analysisPath, *analysis)
+}
+
+/*
+ConfirmReceipt records that a person checked the receipt of runDirPath:
+corrections (those of the run directory, possibly just edited) are saved
+with ConfirmedAt set, and the product memory learns the items of analysis
+with them applied. analysis itself and receipt-analysis.json are left as
+they are, so reanalyzing loses neither the corrections nor the
+confirmation.
+*/
+func ConfirmReceipt(receiptLedger *ledger.Ledger, runDirPath string, analysis receipt.Analysis, corrections receipt.Corrections) (confirmed receipt.Analysis, e *xerr.Error) {
+	corrections.ConfirmedAt = time.Now().Format(time.RFC3339)
+	e = receipt.SaveCorrections(runDirPath, corrections)
+	if e != nil {
+		return confirmed, e
+	}
+
+	confirmed = corrections.Apply(analysis)
+	e = receiptLedger.LearnProducts(confirmed)
+	if e != nil {
+		return confirmed, e
+	}
+	tl.Log(tl.Info1, palette.Green, "Learned the products of '%s' items of '%s'", len(confirmed.Items), runDirPath)
+	return confirmed, nil
+}
@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/category"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/ocr"
+	"expense-tracker/src/pkg/receipt"
+)
+
+// maxReviewItemNames caps how many "other" items are named in a finding.
+const maxReviewItemNames = 5
+
+/*
+reviewFindings checks a stored receipt (with its corrections applied) for
+what makes it worth a second look, see ledger.ReviewReason.
+*/
+func reviewFindings(runDirPath string, analysis receipt.Analysis) (findings []ledger.ReviewFinding) {
+	corrections, found, e := receipt.LoadCorrections(runDirPath)
+	if e != nil {
+		tl.Log(tl.Warning, palette.PurpleBright, "Reviewing without corrections: %s: '%s'", e.Msg, e.ErrStr)
+	} else if found {
+		analysis = corrections.Apply(analysis)
+	}
+
+	if analysis.Totals.TotalCheckMessage != "" {
+		findings = append(findings, ledger.ReviewFinding{Reason: ledger.ReviewTotalMismatch, Detail: analysis.Totals.TotalCheckMessage})
+	}
+
+	quality, found, e := ocr.LoadQuality(runDirPath)
+	if e != nil {
+		tl.Log(tl.Warning, palette.PurpleBright, "Reviewing without OCR quality: %s: '%s'", e.Msg, e.ErrStr)
+	} else if found && quality.Score < quality.MinQualityScore {
+		findings = append(findings, ledger.ReviewFinding{
+			Reason: ledger.ReviewLowOCRConfidence,
+			Detail: fmt.Sprintf(
+				"OCR quality score %.1f is below %.1f (mean word confidence %.1f, %d of %d words low).",
+				quality.Score, quality.MinQualityScore, quality.MeanConfidence, quality.LowConfidenceWords, quality.Words,
+			),
+		})
+	}
+
+	if analysis.ReceiptDate == "" && analysis.ReceiptDateTime == "" {
+		findings = append(findings, ledger.ReviewFinding{Reason: ledger.ReviewMissingDate, Detail: "The receipt has no date."})
+	}
+
+	otherNames := make([]string, 0)
+	for _, item := range analysis.Items {
+		if item.CategoryKey == category.Other || item.CategoryKey == "" {
+			otherNames = append(otherNames, item.OriginalProductName)
+		}
+	}
+	if len(otherNames) > 0 {
+		detail := fmt.Sprintf("%d of %d items are in \"other\": %s", len(otherNames), len(analysis.Items), strings.Join(otherNames[:min(len(otherNames), maxReviewItemNames)], ", "))
+		if len(otherNames) > maxReviewItemNames {
+			detail += ", ..."
+		}
+		findings = append(findings, ledger.ReviewFinding{Reason: ledger.ReviewOtherItems, Detail: detail + "."})
+	}
+
+	return findings
+}
+
+/*
+reviewReceipt is StageValidate, run after the receipt is stored: it queues
+the receipt for review when reviewFindings finds anything (and takes it out
+of the queue when a pending one no longer has findings). The receipt stays
+in the ledger either way; with options.PriceDifference a receipt queued
+for a total mismatch also fails the stage, so batch runs report it.
+*/
+func reviewReceipt(manifest *Manifest, result Result, options Options) (Stage, *xerr.Error) {
+	runDirPath := result.RunDir
+	startedAt := time.Now()
+	inputHash := manifest.Stages[StageLedger].InputHash
+
+	review, queued, e := options.Ledger.QueueReview(runDirPath, reviewFindings(runDirPath, result.Analysis))
+	if e != nil {
+		manifest.recordStage(runDirPath, StageValidate, inputHash, nil, startedAt, e)
+		return StageValidate, e
+	}
+	if !queued {
+		manifest.recordStage(runDirPath, StageValidate, inputHash, nil, startedAt, nil)
+		return "", nil
+	}
+
+	for _, finding := range review.Findings {
+		tl.Log(tl.Warning, palette.PurpleBold, "Queued for review as '%s': '%s'", finding.Reason, finding.Detail)
+	}
+	tl.Log(tl.Warning1, palette.PurpleBold, "See it with 'review show -id %s'", review.ID)
+
+	if options.PriceDifference && slices.Contains(review.Reasons(), ledger.ReviewTotalMismatch) {
+		e = xerr.NewError(fmt.Errorf("totals mismatch"), "receipt totals mismatch; stored and queued for review", runDirPath)
+		manifest.recordStage(runDirPath, StageValidate, inputHash, nil, startedAt, e)
+		return StageValidate, e
+	}
+
+	manifest.recordStage(runDirPath, StageValidate, inputHash, nil, startedAt, nil)
+	return "", nil
+}
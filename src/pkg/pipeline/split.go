@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/ocr"
+	"expense-tracker/src/pkg/preprocess"
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+splitPhoto is the split stage: it looks for several receipts in the photo
+(ocr.SplitReceiptsInRunDir), or reuses split.json if the manifest shows the
+photo was already checked with the same settings.
+*/
+func splitPhoto(manifest *Manifest, result *Result, imagePath string, hashes imageindex.ImageHashes, options Options) (split ocr.Split, e *xerr.Error) {
+	runDirPath := result.RunDir
+	splitTools := map[string]string{
+		"ocr_pipeline":     ocr.PipelineVersion,
+		"min_receipt_area": fmt.Sprint(preprocess.Cfg.MinReceiptArea),
+	}
+	splitInputHash := hashInputs(splitTools, hashes.SHA256)
+
+	if manifest.IsCurrent(StageSplit, splitInputHash) {
+		split, found, e := ocr.LoadSplit(runDirPath)
+		if e == nil && found {
+			result.SkippedStages = append(result.SkippedStages, StageSplit)
+			return split, nil
+		}
+	}
+
+	options.startStage(StageSplit)
+	startedAt := time.Now()
+	split, e = ocr.SplitReceiptsInRunDir(imagePath, runDirPath, preprocess.Cfg)
+	manifest.recordStage(runDirPath, StageSplit, splitInputHash, splitTools, startedAt, e)
+	return split, e
+}
+
+/*
+processSplitReceipts runs the rest of the pipeline for every receipt of a
+split photo, each in its own run directory (ocr.SplitReceipt.Dir) inside the
+photo's, with receipt.Source pointing back at the photo. A failed receipt
+doesn't stop the others; the first failure is returned.
+*/
+func processSplitReceipts(result Result, split ocr.Split, imagePath string, hashes imageindex.ImageHashes, options Options) (Result, Stage, *xerr.Error) {
+	var failedStage Stage
+	var firstErr *xerr.Error
+	for index, splitReceipt := range split.Receipts {
+		receiptOptions := options
+		receiptOptions.ResumeRunDir = filepath.Join(result.RunDir, splitReceipt.Dir)
+		receiptOptions.segmentOf = &segmentOf{
+			ImagePath: imagePath,
+			Segment: receipt.Segment{
+				Index:  index + 1,
+				Count:  len(split.Receipts),
+				RunDir: result.RunDir,
+				Left:   splitReceipt.Region.Left,
+				Top:    splitReceipt.Region.Top,
+				Right:  splitReceipt.Region.Right,
+				Bottom: splitReceipt.Region.Bottom,
+			},
+		}
+
+		cropPath := filepath.Join(result.RunDir, splitReceipt.ImagePath)
+		receiptResult, receiptFailedStage, e := ProcessImages([]string{cropPath}, hashes, receiptOptions)
+		result.Receipts = append(result.Receipts, receiptResult)
+		if e != nil {
+			tl.Log(
+				tl.Warning, palette.PurpleBold, "Receipt '%s' of '%s' failed at stage '%s': %s: '%s'",
+				index+1, imagePath, string(receiptFailedStage), e.Msg, e.ErrStr,
+			)
+			if firstErr == nil {
+				failedStage, firstErr = receiptFailedStage, e
+			}
+		}
+	}
+
+	if firstErr == nil {
+		tl.Log(
+			tl.Notice, palette.GreenBold, "Processed '%s' receipts of '%s' in '%s'",
+			len(result.Receipts), imagePath, result.RunDir,
+		)
+	}
+	return result, failedStage, firstErr
+}
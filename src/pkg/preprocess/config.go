@@ -0,0 +1,151 @@
+package preprocess
+
+import (
+	"encoding/json"
+	"fmt"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+)
+
+// ThresholdMethod selects how the grayscale image is turned black/white.
+type ThresholdMethod string
+
+const (
+	ThresholdFixed   ThresholdMethod = "fixed"   // contrast boost + one global cutoff (the original behaviour)
+	ThresholdOtsu    ThresholdMethod = "otsu"    // global cutoff picked from the image histogram
+	ThresholdSauvola ThresholdMethod = "sauvola" // local cutoff per pixel; copes with shadows and uneven light
+)
+
+/*
+Config toggles the steps of the OCR preprocessing chain.
+
+Set it in cfg/config.json under the "preprocess" key. Without it the chain
+is the original one (grayscale, resize, sharpen, fixed threshold). For
+photos of crumpled receipts, shadows or angled shots try:
+
+	"preprocess": {
+	  "threshold": "sauvola",
+	  "perspective_warp": true,
+	  "deskew": true,
+	  "crop_borders": true,
+	  "save_intermediates": true
+	}
+
+When the OCR of the result scores below min_quality_score, the retry
+profiles and the other page segmentation modes are tried as well and the
+best result is kept.
+
+With split_receipts a photo of several receipts laid out side by side on a
+darker surface is cut into one image per receipt (see FindReceipts) before
+any of this, and every receipt is processed on its own.
+*/
+type Config struct {
+	Threshold         ThresholdMethod `json:"threshold,omitempty"`               // "fixed" | "otsu" | "sauvola"
+	FixedThreshold    int             `json:"fixed_threshold,omitempty"`         // cutoff for "fixed", 1-254
+	SauvolaWindow     int             `json:"sauvola_window,omitempty"`          // local window in pixels of the resized image
+	SauvolaK          float64         `json:"sauvola_k,omitempty"`               // higher = thinner text, less noise (0.2-0.5)
+	PerspectiveWarp   bool            `json:"perspective_warp" default:"skip"`   // find the receipt edges and warp it flat
+	Deskew            bool            `json:"deskew" default:"skip"`             // estimate the text angle and rotate it level
+	MaxSkewDegrees    float64         `json:"max_skew_degrees,omitempty"`        // largest angle deskew looks for
+	CropBorders       bool            `json:"crop_borders" default:"skip"`       // drop dark background touching the image edges
+	SaveIntermediates bool            `json:"save_intermediates" default:"skip"` // write every step to <run dir>/preprocess/<image>/
+
+	// Several receipts in one photo (see ocr.SplitReceiptsInRunDir).
+	SplitReceipts  bool    `json:"split_receipts" default:"skip"` // look for several receipts in every photo
+	MinReceiptArea float64 `json:"min_receipt_area,omitempty"`    // smallest receipt, as a share of the photo (0.03 = 3%)
+
+	// OCR quality retries (see ocr.ProcessImageInRunDir).
+	OCRAttempts     int       `json:"ocr_attempts,omitempty"`      // profile / page segmentation combinations tried at most; 1 = no retries
+	MinQualityScore float64   `json:"min_quality_score,omitempty"` // OCR quality (0-100) that stops further attempts
+	RetryProfiles   []Profile `json:"retry_profiles,omitempty"`    // tried in order after the settings above
+	PageSegModes    []string  `json:"page_seg_modes,omitempty"`    // tesseract modes; the first one is used for every profile
+}
+
+/*
+Profile is an alternative set of steps tried when the OCR quality of the
+configured steps is low. Threshold parameters come from Config.
+*/
+type Profile struct {
+	Name            string          `json:"name"`
+	Threshold       ThresholdMethod `json:"threshold"`
+	PerspectiveWarp bool            `json:"perspective_warp"`
+	Deskew          bool            `json:"deskew"`
+	CropBorders     bool            `json:"crop_borders"`
+}
+
+func DefaultValueConfig() Config {
+	return Config{
+		Threshold:      ThresholdFixed,
+		FixedThreshold: 200,
+		SauvolaWindow:  41,
+		SauvolaK:       0.2,
+		MaxSkewDegrees: 10,
+		MinReceiptArea: 0.03,
+
+		OCRAttempts:     6,
+		MinQualityScore: 70,
+		RetryProfiles: []Profile{
+			{Name: "sauvola", Threshold: ThresholdSauvola, Deskew: true, CropBorders: true},
+			{Name: "otsu", Threshold: ThresholdOtsu, Deskew: true, CropBorders: true},
+			{Name: "sauvola-warp", Threshold: ThresholdSauvola, PerspectiveWarp: true, Deskew: true, CropBorders: true},
+		},
+		PageSegModes: []string{"single_block", "single_column", "sparse_text"},
+	}
+}
+
+// create config with default values before config gets initialized
+var Cfg Config = DefaultValueConfig() // this one we use to access config values from anywhere
+
+/*
+If local Config is provided - use it. Replace all missing values with default ones.
+
+If not provided - just use defaultConfig.
+*/
+func InitializeConfig(localConfig *Config) {
+	// If not provided - just use defaultConfig
+	if localConfig == nil {
+		tl.Log(tl.Info, palette.Purple, "%s config is %s, keeping %s", "preprocess", "not provided", "default preprocess config")
+		return
+	}
+
+	defaultConfig := DefaultValueConfig() // Default values to replace some values with during config initialization
+
+	// If local Config is provided - use it
+	Cfg = *localConfig
+
+	tl.ApplyDefaults(&Cfg, defaultConfig, func(field string, defVal any) {
+		tl.Log(
+			tl.Info, palette.Purple,
+			"%s field is %s in %s configuration. Using default value: %v",
+			field, "missing", "preprocess", tl.PrettyForStderr(defVal),
+		)
+	})
+
+	tl.Log(tl.Info, palette.Green, "%s config was %s, using %s", "preprocess", "provided", "local preprocess config")
+	tl.LogJSON(tl.Verbose, palette.CyanDim, fmt.Sprintf("%s configuration", "preprocess"), Cfg)
+}
+
+/*
+WithProfile returns a copy of the config with the steps of profile.
+*/
+func (config Config) WithProfile(profile Profile) Config {
+	config.Threshold = profile.Threshold
+	config.PerspectiveWarp = profile.PerspectiveWarp
+	config.Deskew = profile.Deskew
+	config.CropBorders = profile.CropBorders
+	return config
+}
+
+/*
+Fingerprint returns the settings that change the processed image, as
+compact JSON, so run manifests redo OCR when they change. Saving
+intermediate images and receipt splitting (a stage of its own) don't count.
+*/
+func (config Config) Fingerprint() string {
+	config.SaveIntermediates = false
+	config.SplitReceipts = false
+	config.MinReceiptArea = 0
+	jsonBytes, _ := json.Marshal(config)
+	return string(jsonBytes)
+}
@@ -0,0 +1,67 @@
+package preprocess
+
+import (
+	"image"
+)
+
+/*
+cropBorders removes what the threshold left of the background: black
+regions connected to the image edges are painted white, then the image is
+cropped to the remaining print plus a small margin. An image with no print
+left is returned unchanged.
+*/
+func cropBorders(binaryImage *image.Gray) (croppedImage *image.Gray, cropped bool) {
+	width, height := binaryImage.Rect.Dx(), binaryImage.Rect.Dy()
+	cleanedImage := image.NewGray(binaryImage.Rect)
+	copy(cleanedImage.Pix, binaryImage.Pix)
+
+	var stack []int
+	push := func(x, y int) {
+		index := y*cleanedImage.Stride + x
+		if cleanedImage.Pix[index] == 0 {
+			cleanedImage.Pix[index] = 255
+			stack = append(stack, y*width+x)
+		}
+	}
+	for x := 0; x < width; x++ {
+		push(x, 0)
+		push(x, height-1)
+	}
+	for y := 0; y < height; y++ {
+		push(0, y)
+		push(width-1, y)
+	}
+	for len(stack) > 0 {
+		point := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := point%width, point/width
+		if x > 0 {
+			push(x-1, y)
+		}
+		if x < width-1 {
+			push(x+1, y)
+		}
+		if y > 0 {
+			push(x, y-1)
+		}
+		if y < height-1 {
+			push(x, y+1)
+		}
+	}
+
+	content := image.Rectangle{}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if cleanedImage.Pix[y*cleanedImage.Stride+x] == 0 {
+				content = content.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if content.Empty() {
+		return binaryImage, false
+	}
+
+	margin := max(width/50, 10)
+	content = content.Inset(-margin).Intersect(cleanedImage.Rect)
+	return cleanedImage.SubImage(content).(*image.Gray), true
+}
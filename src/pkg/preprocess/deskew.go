@@ -0,0 +1,87 @@
+package preprocess
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	deskewSampleSize = 1200 // longest side of the copy the angle is estimated on
+	skewEdgeContrast = 48   // brightness step between vertical neighbours that counts as an edge
+)
+
+/*
+estimateSkew returns the counter-clockwise rotation in degrees (within
+±maxDegrees) that makes the text lines horizontal.
+
+Horizontal edges (pixels much brighter or darker than the one above them:
+tops and bottoms of strokes, paper edges) are projected onto the vertical
+axis for each candidate angle; level text lines give the sharpest profile.
+Edges rather than dark pixels keep shadows and dark backgrounds out of it. A coarse pass in 0.5°
+steps is refined in 0.1° steps.
+*/
+func estimateSkew(grayImage *image.Gray, maxDegrees float64) float64 {
+	sampleImage := grayImage
+	if max(grayImage.Rect.Dx(), grayImage.Rect.Dy()) > deskewSampleSize {
+		sampleImage = toGray(imaging.Fit(grayImage, deskewSampleSize, deskewSampleSize, imaging.Box))
+	}
+	width, height := sampleImage.Rect.Dx(), sampleImage.Rect.Dy()
+	var edgeX, edgeY []float64
+	for y := 1; y < height; y++ {
+		for x := 0; x < width; x++ {
+			above := int(sampleImage.Pix[(y-1)*sampleImage.Stride+x])
+			current := int(sampleImage.Pix[y*sampleImage.Stride+x])
+			if above-current > skewEdgeContrast || current-above > skewEdgeContrast {
+				edgeX = append(edgeX, float64(x))
+				edgeY = append(edgeY, float64(y))
+			}
+		}
+	}
+	if len(edgeX) == 0 {
+		return 0
+	}
+
+	profileSize := width + height
+	profile := make([]float64, 2*profileSize+1)
+	score := func(degrees float64) float64 {
+		clear(profile)
+		radians := degrees * math.Pi / 180
+		sin, cos := math.Sin(radians), math.Cos(radians)
+		for index := range edgeX {
+			// Row of the pixel after rotating the image counter-clockwise.
+			row := int(math.Round(edgeY[index]*cos-edgeX[index]*sin)) + profileSize
+			profile[row]++
+		}
+		var sharpness float64
+		for row := 1; row < len(profile); row++ {
+			difference := profile[row] - profile[row-1]
+			sharpness += difference * difference
+		}
+		return sharpness
+	}
+
+	bestDegrees, bestScore := 0.0, score(0)
+	search := func(from, to, step float64) {
+		for degrees := from; degrees <= to+step/2; degrees += step {
+			degreesScore := score(degrees)
+			if degreesScore > bestScore {
+				bestDegrees, bestScore = degrees, degreesScore
+			}
+		}
+	}
+	search(-maxDegrees, maxDegrees, 0.5)
+	search(bestDegrees-0.5, bestDegrees+0.5, 0.1)
+
+	return math.Round(bestDegrees*10) / 10
+}
+
+/*
+deskew rotates the image counter-clockwise by degrees, filling the corners
+with white.
+*/
+func deskew(grayImage *image.Gray, degrees float64) *image.Gray {
+	return toGray(imaging.Rotate(grayImage, degrees, color.White))
+}
@@ -0,0 +1,72 @@
+package preprocess
+
+import (
+	"math"
+)
+
+/*
+Geometry records how the processed image relates to the source image, so
+positions found by OCR can be mapped back onto the original photo (after
+EXIF orientation, as imaging.Open with AutoOrientation loads it).
+
+Fields:
+  - SourceWidth, SourceHeight: the source image.
+  - Warp: projective transform from warped image to source coordinates
+    (perspective warp only).
+  - Rotation: deskew rotation (deskew only).
+  - ScaleX, ScaleY: resized size / size before resizing.
+  - CropLeft, CropTop: offset of the border crop in the thresholded image.
+  - Width, Height: the processed image.
+*/
+type Geometry struct {
+	SourceWidth  int         `json:"source_width"`
+	SourceHeight int         `json:"source_height"`
+	Warp         *[9]float64 `json:"warp,omitempty"`
+	Rotation     *Rotation   `json:"rotation,omitempty"`
+	ScaleX       float64     `json:"scale_x"`
+	ScaleY       float64     `json:"scale_y"`
+	CropLeft     int         `json:"crop_left"`
+	CropTop      int         `json:"crop_top"`
+	Width        int         `json:"width"`
+	Height       int         `json:"height"`
+}
+
+/*
+Rotation is a counter-clockwise rotation by Degrees of a FromWidth x
+FromHeight image onto a ToWidth x ToHeight canvas (centers aligned, as
+imaging.Rotate does it).
+*/
+type Rotation struct {
+	Degrees    float64 `json:"degrees"`
+	FromWidth  int     `json:"from_width"`
+	FromHeight int     `json:"from_height"`
+	ToWidth    int     `json:"to_width"`
+	ToHeight   int     `json:"to_height"`
+}
+
+/*
+ToSource maps a point of the processed image to the source image.
+*/
+func (geometry Geometry) ToSource(x, y float64) (sourceX, sourceY float64) {
+	x += float64(geometry.CropLeft)
+	y += float64(geometry.CropTop)
+
+	if geometry.ScaleX > 0 && geometry.ScaleY > 0 {
+		x /= geometry.ScaleX
+		y /= geometry.ScaleY
+	}
+
+	if rotation := geometry.Rotation; rotation != nil {
+		sin, cos := math.Sincos(math.Pi * rotation.Degrees / 180)
+		centeredX := x - float64(rotation.ToWidth)/2
+		centeredY := y - float64(rotation.ToHeight)/2
+		x = centeredX*cos - centeredY*sin + float64(rotation.FromWidth)/2
+		y = centeredX*sin + centeredY*cos + float64(rotation.FromHeight)/2
+	}
+
+	if geometry.Warp != nil {
+		x, y = projectiveTransform(*geometry.Warp).apply(x, y)
+	}
+
+	return x, y
+}
@@ -0,0 +1,268 @@
+package preprocess
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// edgeSampleSize is the longest side of the copy receipt edges are searched on.
+const edgeSampleSize = 800
+
+/*
+quad is the receipt outline in source image coordinates: top-left,
+top-right, bottom-right, bottom-left.
+*/
+type quad [4]pointF
+
+type pointF struct {
+	X, Y float64
+}
+
+/*
+findReceiptQuad looks for the receipt as the largest bright region of an
+Otsu-binarized copy and returns its four corners (the region points with
+extreme x+y and x-y). found is false when there is no such region, when it
+is too small or not roughly four-sided, or when it already fills the frame
+(the photo is cropped to the receipt, nothing to warp).
+*/
+func findReceiptQuad(grayImage *image.Gray) (corners quad, found bool) {
+	sampleImage := grayImage
+	if max(grayImage.Rect.Dx(), grayImage.Rect.Dy()) > edgeSampleSize {
+		sampleImage = toGray(imaging.Fit(grayImage, edgeSampleSize, edgeSampleSize, imaging.Box))
+	}
+	scale := float64(grayImage.Rect.Dx()) / float64(sampleImage.Rect.Dx())
+
+	// Smooth away print so the paper is one region rather than text-shaped holes.
+	blurredImage := toGray(imaging.Blur(sampleImage, 2))
+	threshold := otsuThreshold(blurredImage)
+	width, height := blurredImage.Rect.Dx(), blurredImage.Rect.Dy()
+
+	region := largestBrightRegion(blurredImage, threshold)
+	if len(region) < width*height/10 {
+		return corners, false
+	}
+
+	topLeft, bottomRight := region[0], region[0]
+	topRight, bottomLeft := region[0], region[0]
+	for _, point := range region {
+		x, y := point%width, point/width
+		if x+y < topLeft%width+topLeft/width {
+			topLeft = point
+		}
+		if x+y > bottomRight%width+bottomRight/width {
+			bottomRight = point
+		}
+		if x-y > topRight%width-topRight/width {
+			topRight = point
+		}
+		if y-x > bottomLeft/width-bottomLeft%width {
+			bottomLeft = point
+		}
+	}
+	for index, point := range []int{topLeft, topRight, bottomRight, bottomLeft} {
+		corners[index] = pointF{X: (float64(point%width) + 0.5) * scale, Y: (float64(point/width) + 0.5) * scale}
+	}
+
+	// Corner quads of blobs (or of a rotated frame-filling receipt) don't
+	// cover their region; a receipt outline covers nearly all of it.
+	sampleArea := float64(width*height) * scale * scale
+	quadArea := corners.area()
+	if quadArea < float64(len(region))*scale*scale*0.85 || quadArea > sampleArea*0.9 {
+		return corners, false
+	}
+
+	return corners, true
+}
+
+/*
+largestBrightRegion returns the pixel indexes (y*width+x) of the largest
+4-connected region brighter than threshold.
+*/
+func largestBrightRegion(grayImage *image.Gray, threshold uint8) (region []int) {
+	forEachBrightRegion(grayImage, threshold, func(current []int) {
+		if len(current) > len(region) {
+			region = append(region[:0], current...)
+		}
+	})
+	return region
+}
+
+/*
+forEachBrightRegion calls visit with the pixel indexes (y*width+x) of every
+4-connected region brighter than threshold. The slice is reused between
+calls.
+*/
+func forEachBrightRegion(grayImage *image.Gray, threshold uint8, visit func(region []int)) {
+	width, height := grayImage.Rect.Dx(), grayImage.Rect.Dy()
+	visited := make([]bool, width*height)
+	var stack, current []int
+
+	for start := range visited {
+		if visited[start] || grayImage.Pix[(start/width)*grayImage.Stride+start%width] <= threshold {
+			continue
+		}
+		current = current[:0]
+		stack = append(stack[:0], start)
+		visited[start] = true
+		for len(stack) > 0 {
+			point := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			current = append(current, point)
+			x, y := point%width, point/width
+			for _, neighbour := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				nx, ny := neighbour[0], neighbour[1]
+				if nx < 0 || ny < 0 || nx >= width || ny >= height {
+					continue
+				}
+				index := ny*width + nx
+				if visited[index] || grayImage.Pix[ny*grayImage.Stride+nx] <= threshold {
+					continue
+				}
+				visited[index] = true
+				stack = append(stack, index)
+			}
+		}
+		visit(current)
+	}
+}
+
+// area of the quad (shoelace formula).
+func (corners quad) area() float64 {
+	var doubled float64
+	for index, point := range corners {
+		next := corners[(index+1)%4]
+		doubled += point.X*next.Y - next.X*point.Y
+	}
+	return math.Abs(doubled) / 2
+}
+
+/*
+warpPerspective maps the quad onto an upright rectangle as wide as its
+longer horizontal edge and as tall as its longer vertical edge. transform
+maps the result back to grayImage; warped is false if the quad is
+degenerate and grayImage is returned as is.
+*/
+func warpPerspective(grayImage *image.Gray, corners quad) (warpedImage *image.Gray, transform projectiveTransform, warped bool) {
+	distance := func(a, b pointF) float64 { return math.Hypot(a.X-b.X, a.Y-b.Y) }
+	width := int(math.Round(max(distance(corners[0], corners[1]), distance(corners[3], corners[2]))))
+	height := int(math.Round(max(distance(corners[0], corners[3]), distance(corners[1], corners[2]))))
+
+	rectangle := quad{{0, 0}, {float64(width), 0}, {float64(width), float64(height)}, {0, float64(height)}}
+	transform, solved := homography(rectangle, corners)
+	if !solved || width < 1 || height < 1 {
+		return grayImage, transform, false
+	}
+
+	warpedImage = image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sourceX, sourceY := transform.apply(float64(x)+0.5, float64(y)+0.5)
+			warpedImage.Pix[y*warpedImage.Stride+x] = sampleBilinear(grayImage, sourceX-0.5, sourceY-0.5)
+		}
+	}
+
+	return warpedImage, transform, true
+}
+
+// projective transform, row-major with h[8] = 1.
+type projectiveTransform [9]float64
+
+func (transform projectiveTransform) apply(x, y float64) (float64, float64) {
+	w := transform[6]*x + transform[7]*y + transform[8]
+	return (transform[0]*x + transform[1]*y + transform[2]) / w, (transform[3]*x + transform[4]*y + transform[5]) / w
+}
+
+/*
+homography solves for the transform that maps each from corner onto the
+matching to corner (8 linear equations, Gaussian elimination).
+*/
+func homography(from, to quad) (transform projectiveTransform, solved bool) {
+	var system [8][9]float64
+	for index := range from {
+		x, y, u, v := from[index].X, from[index].Y, to[index].X, to[index].Y
+		system[2*index] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		system[2*index+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+
+	for column := 0; column < 8; column++ {
+		pivot := column
+		for row := column + 1; row < 8; row++ {
+			if math.Abs(system[row][column]) > math.Abs(system[pivot][column]) {
+				pivot = row
+			}
+		}
+		if math.Abs(system[pivot][column]) < 1e-12 {
+			return transform, false
+		}
+		system[column], system[pivot] = system[pivot], system[column]
+		for row := 0; row < 8; row++ {
+			if row == column {
+				continue
+			}
+			factor := system[row][column] / system[column][column]
+			for k := column; k < 9; k++ {
+				system[row][k] -= factor * system[column][k]
+			}
+		}
+	}
+
+	for row := 0; row < 8; row++ {
+		transform[row] = system[row][8] / system[row][row]
+	}
+	transform[8] = 1
+	return transform, true
+}
+
+/*
+sampleBilinear returns the interpolated value at (x, y), white outside the
+image.
+*/
+func sampleBilinear(grayImage *image.Gray, x, y float64) uint8 {
+	width, height := grayImage.Rect.Dx(), grayImage.Rect.Dy()
+	if x < -0.5 || y < -0.5 || x > float64(width)-0.5 || y > float64(height)-0.5 {
+		return 255
+	}
+	x = min(max(x, 0), float64(width-1))
+	y = min(max(y, 0), float64(height-1))
+
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, width-1), min(y0+1, height-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	pixel := func(px, py int) float64 { return float64(grayImage.Pix[py*grayImage.Stride+px]) }
+	top := pixel(x0, y0)*(1-fx) + pixel(x1, y0)*fx
+	bottom := pixel(x0, y1)*(1-fx) + pixel(x1, y1)*fx
+	return uint8(math.Round(top*(1-fy) + bottom*fy))
+}
+
+/*
+drawQuad returns a color copy of the image with the quad outlined in red,
+for the intermediate images.
+*/
+func drawQuad(grayImage *image.Gray, corners quad) *image.RGBA {
+	outlinedImage := image.NewRGBA(grayImage.Rect)
+	draw.Draw(outlinedImage, outlinedImage.Rect, grayImage, grayImage.Rect.Min, draw.Src)
+
+	red := color.RGBA{R: 255, A: 255}
+	thickness := max(grayImage.Rect.Dx()/300, 1)
+	for index, from := range corners {
+		to := corners[(index+1)%4]
+		steps := int(math.Hypot(to.X-from.X, to.Y-from.Y)) + 1
+		for step := 0; step <= steps; step++ {
+			fraction := float64(step) / float64(steps)
+			x := int(from.X + (to.X-from.X)*fraction)
+			y := int(from.Y + (to.Y-from.Y)*fraction)
+			for dy := -thickness; dy <= thickness; dy++ {
+				for dx := -thickness; dx <= thickness; dx++ {
+					outlinedImage.Set(x+dx, y+dy, red)
+				}
+			}
+		}
+	}
+
+	return outlinedImage
+}
@@ -0,0 +1,193 @@
+/*
+OCR image preprocessing.
+
+Turns a receipt photo into the black/white image tesseract reads. The chain
+is:
+
+ 1. Grayscale.
+ 2. Perspective warp (optional): find the receipt edges and warp the
+    four corners to an upright rectangle.
+ 3. Deskew (optional): rotate the text lines level.
+ 4. Resize to double height and sharpen.
+ 5. Threshold: fixed, Otsu or Sauvola.
+ 6. Border crop (optional): drop background left around the receipt.
+
+Steps are toggled in Config; with SaveIntermediates every step is also
+written next to the result for debugging.
+*/
+package preprocess
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+)
+
+/*
+IntermediateDirName is the directory, next to the result, that intermediate
+images go to: <dir>/preprocess/<result name>/01-gray.png, ...
+*/
+const IntermediateDirName = "preprocess"
+
+/*
+Report is what the optional steps found.
+
+Fields:
+  - Warped: the receipt edges were found and the image was warped.
+  - Corners: receipt corners in the source image (Warped only).
+  - SkewDegrees: counter-clockwise rotation applied by deskew.
+  - Cropped: border crop removed something.
+  - IntermediateDirPath: where intermediate images were saved, if enabled.
+  - Geometry: maps processed image positions back to the source image.
+*/
+type Report struct {
+	Warped              bool          `json:"warped"`
+	Corners             [4][2]float64 `json:"corners,omitempty"`
+	SkewDegrees         float64       `json:"skew_degrees"`
+	Cropped             bool          `json:"cropped"`
+	IntermediateDirPath string        `json:"intermediate_dir_path,omitempty"`
+	Geometry            Geometry      `json:"geometry"`
+}
+
+/*
+Process reads sourcePath, runs the preprocessing chain configured in config
+and saves the result to destinationPath (PNG).
+*/
+func Process(sourcePath string, destinationPath string, config Config) (report Report, e *xerr.Error) {
+	originalImage, openErr := imaging.Open(sourcePath, imaging.AutoOrientation(true))
+	if openErr != nil {
+		e = xerr.NewError(openErr, "open source image for processing", sourcePath)
+		return report, e
+	}
+
+	saveStep := func(string, image.Image) *xerr.Error { return nil }
+	if config.SaveIntermediates {
+		resultName := strings.TrimSuffix(filepath.Base(destinationPath), filepath.Ext(destinationPath))
+		report.IntermediateDirPath = filepath.Join(filepath.Dir(destinationPath), IntermediateDirName, resultName)
+		mkdirErr := os.MkdirAll(report.IntermediateDirPath, 0o755)
+		if mkdirErr != nil {
+			e = xerr.NewError(mkdirErr, "create directory for intermediate images", report.IntermediateDirPath)
+			return report, e
+		}
+		step := 0
+		saveStep = func(name string, stepImage image.Image) *xerr.Error {
+			step++
+			stepPath := filepath.Join(report.IntermediateDirPath, fmt.Sprintf("%02d-%s.png", step, name))
+			saveErr := imaging.Save(stepImage, stepPath)
+			if saveErr != nil {
+				return xerr.NewError(saveErr, "save intermediate image", stepPath)
+			}
+			return nil
+		}
+	}
+
+	// Convert to grayscale for more stable OCR.
+	grayImage := toGray(originalImage)
+	report.Geometry.SourceWidth, report.Geometry.SourceHeight = grayImage.Rect.Dx(), grayImage.Rect.Dy()
+	e = saveStep("gray", grayImage)
+	if e != nil {
+		return report, e
+	}
+
+	if config.PerspectiveWarp {
+		corners, found := findReceiptQuad(grayImage)
+		if found {
+			e = saveStep("edges", drawQuad(grayImage, corners))
+			if e != nil {
+				return report, e
+			}
+			var transform projectiveTransform
+			grayImage, transform, found = warpPerspective(grayImage, corners)
+			if found {
+				report.Geometry.Warp = (*[9]float64)(&transform)
+			}
+		}
+		if found {
+			report.Warped = true
+			for index, corner := range corners {
+				report.Corners[index] = [2]float64{corner.X, corner.Y}
+			}
+			e = saveStep("warped", grayImage)
+			if e != nil {
+				return report, e
+			}
+		} else {
+			tl.Log(tl.Info1, palette.Cyan, "No receipt edges found in '%s', skipping perspective warp", sourcePath)
+		}
+	}
+
+	if config.Deskew {
+		report.SkewDegrees = estimateSkew(grayImage, config.MaxSkewDegrees)
+		if report.SkewDegrees != 0 {
+			rotation := Rotation{Degrees: report.SkewDegrees, FromWidth: grayImage.Rect.Dx(), FromHeight: grayImage.Rect.Dy()}
+			grayImage = deskew(grayImage, report.SkewDegrees)
+			rotation.ToWidth, rotation.ToHeight = grayImage.Rect.Dx(), grayImage.Rect.Dy()
+			report.Geometry.Rotation = &rotation
+			e = saveStep("deskewed", grayImage)
+			if e != nil {
+				return report, e
+			}
+		}
+	}
+
+	// Resize (double height, preserve aspect ratio) to help OCR with small
+	// text, then apply a mild sharpening filter to make edges crisper.
+	resizedImage := imaging.Resize(grayImage, 0, grayImage.Rect.Dy()*2, imaging.Lanczos)
+	sharpenedImage := toGray(imaging.Sharpen(resizedImage, 1.0))
+	report.Geometry.ScaleX = float64(sharpenedImage.Rect.Dx()) / float64(grayImage.Rect.Dx())
+	report.Geometry.ScaleY = float64(sharpenedImage.Rect.Dy()) / float64(grayImage.Rect.Dy())
+	e = saveStep("sharpened", sharpenedImage)
+	if e != nil {
+		return report, e
+	}
+
+	binaryImage, e := binarize(sharpenedImage, config)
+	if e != nil {
+		return report, e
+	}
+	e = saveStep("threshold-"+string(config.Threshold), binaryImage)
+	if e != nil {
+		return report, e
+	}
+
+	if config.CropBorders {
+		binaryImage, report.Cropped = cropBorders(binaryImage)
+		if report.Cropped {
+			report.Geometry.CropLeft, report.Geometry.CropTop = binaryImage.Rect.Min.X, binaryImage.Rect.Min.Y
+			e = saveStep("cropped", binaryImage)
+			if e != nil {
+				return report, e
+			}
+		}
+	}
+
+	report.Geometry.Width, report.Geometry.Height = binaryImage.Rect.Dx(), binaryImage.Rect.Dy()
+	saveErr := imaging.Save(binaryImage, destinationPath)
+	if saveErr != nil {
+		e = xerr.NewError(saveErr, "save processed image", destinationPath)
+		return report, e
+	}
+
+	return report, nil
+}
+
+/*
+toGray converts any image to *image.Gray with its origin at (0, 0).
+*/
+func toGray(sourceImage image.Image) *image.Gray {
+	bounds := sourceImage.Bounds()
+	if grayImage, isGray := sourceImage.(*image.Gray); isGray && bounds.Min == (image.Point{}) {
+		return grayImage
+	}
+	grayImage := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(grayImage, grayImage.Rect, sourceImage, bounds.Min, draw.Src)
+	return grayImage
+}
@@ -0,0 +1,93 @@
+package preprocess
+
+import (
+	"image"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// minReceiptFill is the share of its bounding box a receipt region covers (paper is a filled quadrilateral, even tilted).
+const minReceiptFill = 0.45
+
+/*
+FindReceipts looks for several receipts photographed together on a darker
+background. Like findReceiptQuad it binarizes a blurred, downsized copy with
+Otsu; every bright region covering at least minArea of the photo (0.03 =
+3%) and most of its bounding box is a receipt. It returns their bounding
+rectangles in sourceImage pixels with a small margin, in reading order (rows
+top to bottom, left to right within a row).
+
+Fewer than two rectangles means the photo shouldn't be split: one receipt,
+or receipts that touch or lie on a light background.
+*/
+func FindReceipts(sourceImage image.Image, minArea float64) (regions []image.Rectangle) {
+	grayImage := toGray(sourceImage)
+	sampleImage := grayImage
+	if max(grayImage.Rect.Dx(), grayImage.Rect.Dy()) > edgeSampleSize {
+		sampleImage = toGray(imaging.Fit(grayImage, edgeSampleSize, edgeSampleSize, imaging.Box))
+	}
+	scale := float64(grayImage.Rect.Dx()) / float64(sampleImage.Rect.Dx())
+
+	blurredImage := toGray(imaging.Blur(sampleImage, 2))
+	threshold := otsuThreshold(blurredImage)
+	width, height := blurredImage.Rect.Dx(), blurredImage.Rect.Dy()
+
+	var sampleRegions []image.Rectangle
+	forEachBrightRegion(blurredImage, threshold, func(region []int) {
+		if float64(len(region)) < minArea*float64(width*height) {
+			return
+		}
+		bounds := image.Rectangle{}
+		for _, point := range region {
+			x, y := point%width, point/width
+			bounds = bounds.Union(image.Rect(x, y, x+1, y+1))
+		}
+		if float64(len(region)) < minReceiptFill*float64(bounds.Dx()*bounds.Dy()) {
+			return
+		}
+		sampleRegions = append(sampleRegions, bounds)
+	})
+	if len(sampleRegions) < 2 {
+		return nil
+	}
+
+	sourceBounds := sourceImage.Bounds()
+	margin := max(sourceBounds.Dx(), sourceBounds.Dy()) / 100
+	for _, sampleRegion := range readingOrder(sampleRegions) {
+		region := image.Rect(
+			int(float64(sampleRegion.Min.X)*scale), int(float64(sampleRegion.Min.Y)*scale),
+			int(float64(sampleRegion.Max.X)*scale), int(float64(sampleRegion.Max.Y)*scale),
+		)
+		region = region.Add(sourceBounds.Min).Inset(-margin).Intersect(sourceBounds)
+		regions = append(regions, region)
+	}
+	return regions
+}
+
+/*
+readingOrder sorts regions into rows (a region whose vertical middle is
+within the first region of a row belongs to that row) from top to bottom,
+and each row from left to right.
+*/
+func readingOrder(regions []image.Rectangle) (ordered []image.Rectangle) {
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Min.Y < regions[j].Min.Y })
+
+	var row []image.Rectangle
+	flushRow := func() {
+		sort.Slice(row, func(i, j int) bool { return row[i].Min.X < row[j].Min.X })
+		ordered = append(ordered, row...)
+		row = nil
+	}
+	for _, region := range regions {
+		if len(row) > 0 {
+			middle := (region.Min.Y + region.Max.Y) / 2
+			if middle < row[0].Min.Y || middle >= row[0].Max.Y {
+				flushRow()
+			}
+		}
+		row = append(row, region)
+	}
+	flushRow()
+	return ordered
+}
@@ -0,0 +1,157 @@
+package preprocess
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/tuumbleweed/xerr"
+)
+
+/*
+binarize turns a grayscale image black/white with the configured method.
+*/
+func binarize(grayImage *image.Gray, config Config) (binaryImage *image.Gray, e *xerr.Error) {
+	switch config.Threshold {
+	case ThresholdFixed, "":
+		// Strongly increase contrast first so text stands out from the paper;
+		// this mimics the aggressive binarization Tesseract tends to like.
+		highContrastImage := toGray(imaging.AdjustContrast(grayImage, 100.0))
+		return thresholdGlobal(highContrastImage, uint8(config.FixedThreshold)), nil
+	case ThresholdOtsu:
+		return thresholdGlobal(grayImage, otsuThreshold(grayImage)), nil
+	case ThresholdSauvola:
+		return thresholdSauvola(grayImage, config.SauvolaWindow, config.SauvolaK), nil
+	default:
+		err := fmt.Errorf("unknown threshold method '%s' (want fixed, otsu or sauvola)", config.Threshold)
+		e = xerr.NewError(err, "binarize image", string(config.Threshold))
+		return nil, e
+	}
+}
+
+/*
+thresholdGlobal makes every pixel brighter than threshold white and the rest
+black.
+*/
+func thresholdGlobal(grayImage *image.Gray, threshold uint8) *image.Gray {
+	binaryImage := image.NewGray(grayImage.Rect)
+	for index, value := range grayImage.Pix {
+		if value > threshold {
+			binaryImage.Pix[index] = 255
+		}
+	}
+	return binaryImage
+}
+
+/*
+otsuThreshold picks the cutoff that best separates the histogram into two
+classes (maximum between-class variance).
+*/
+func otsuThreshold(grayImage *image.Gray) uint8 {
+	var histogram [256]float64
+	for _, value := range grayImage.Pix {
+		histogram[value]++
+	}
+
+	var total, sum float64
+	for value, count := range histogram {
+		total += count
+		sum += float64(value) * count
+	}
+
+	var backgroundWeight, backgroundSum, bestVariance float64
+	var threshold int
+	for value, count := range histogram {
+		backgroundWeight += count
+		if backgroundWeight == 0 {
+			continue
+		}
+		foregroundWeight := total - backgroundWeight
+		if foregroundWeight == 0 {
+			break
+		}
+		backgroundSum += float64(value) * count
+		backgroundMean := backgroundSum / backgroundWeight
+		foregroundMean := (sum - backgroundSum) / foregroundWeight
+		variance := backgroundWeight * foregroundWeight * (backgroundMean - foregroundMean) * (backgroundMean - foregroundMean)
+		if variance > bestVariance {
+			bestVariance = variance
+			threshold = value
+		}
+	}
+
+	return uint8(threshold)
+}
+
+/*
+thresholdSauvola compares every pixel with a cutoff computed from the mean m
+and standard deviation s of the window around it:
+
+	T = m * (1 + k * (s/128 - 1))
+
+so paper in a shadow stays white while faint print on bright paper still
+turns black. Window sums are kept per column and slid down the image, which
+needs memory for one row only.
+*/
+func thresholdSauvola(grayImage *image.Gray, window int, k float64) *image.Gray {
+	bounds := grayImage.Rect
+	width, height := bounds.Dx(), bounds.Dy()
+	binaryImage := image.NewGray(bounds)
+	half := max(window/2, 1)
+
+	columnSums := make([]uint64, width)
+	columnSquares := make([]uint64, width)
+	addRow := func(y int, sign int) {
+		row := grayImage.Pix[y*grayImage.Stride : y*grayImage.Stride+width]
+		for x, value := range row {
+			if sign > 0 {
+				columnSums[x] += uint64(value)
+				columnSquares[x] += uint64(value) * uint64(value)
+			} else {
+				columnSums[x] -= uint64(value)
+				columnSquares[x] -= uint64(value) * uint64(value)
+			}
+		}
+	}
+
+	for y := 0; y < min(half, height); y++ {
+		addRow(y, 1)
+	}
+	for y := 0; y < height; y++ {
+		if y+half < height {
+			addRow(y+half, 1)
+		}
+		if y-half-1 >= 0 {
+			addRow(y-half-1, -1)
+		}
+		rows := min(y+half, height-1) - max(y-half, 0) + 1
+
+		var windowSum, windowSquares uint64
+		for x := 0; x < min(half, width); x++ {
+			windowSum += columnSums[x]
+			windowSquares += columnSquares[x]
+		}
+		for x := 0; x < width; x++ {
+			if x+half < width {
+				windowSum += columnSums[x+half]
+				windowSquares += columnSquares[x+half]
+			}
+			if x-half-1 >= 0 {
+				windowSum -= columnSums[x-half-1]
+				windowSquares -= columnSquares[x-half-1]
+			}
+			columns := min(x+half, width-1) - max(x-half, 0) + 1
+
+			count := float64(rows * columns)
+			mean := float64(windowSum) / count
+			deviation := math.Sqrt(max(float64(windowSquares)/count-mean*mean, 0))
+			threshold := mean * (1 + k*(deviation/128-1))
+			if float64(grayImage.Pix[y*grayImage.Stride+x]) > threshold {
+				binaryImage.Pix[y*binaryImage.Stride+x] = 255
+			}
+		}
+	}
+
+	return binaryImage
+}
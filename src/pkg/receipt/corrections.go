@@ -0,0 +1,177 @@
+package receipt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+)
+
+// CorrectionsFileName is the name of the corrections overlay inside a run directory.
+const CorrectionsFileName = "receipt-corrections.json"
+
+// ManualCategoryRule is Item.CategoryRule of items whose category was corrected by hand.
+const ManualCategoryRule = "manual"
+
+/*
+Corrections are fixes a person made to an analysis, kept in
+receipt-corrections.json next to receipt-analysis.json so the LLM output
+stays as it was and reprocessing doesn't lose them. Readers that show
+numbers (the report) use Apply on top of the analysis.
+
+Fields (nil means "not corrected"):
+  - CorrectedAt: when the corrections were last saved (RFC 3339).
+  - ConfirmedAt: when a person confirmed the receipt is right (RFC 3339,
+    "" if never); becomes Analysis.ConfirmedAt. Kept here rather than in
+    receipt-analysis.json, which reanalyzing rewrites.
+  - ReceiptDate, ReceiptDateTime, MerchantName, MerchantTaxID: replace
+    the analysis fields of the same name.
+  - ReceiptTotal: replaces totals.receipt_total.
+  - Items: replace all items ([] when all were removed);
+    computed_items_total and total_check_message are worked out again
+    from them.
+*/
+type Corrections struct {
+	CorrectedAt     string   `json:"corrected_at"`
+	ConfirmedAt     string   `json:"confirmed_at,omitempty"`
+	ReceiptDate     *string  `json:"receipt_date,omitempty"`
+	ReceiptDateTime *string  `json:"receipt_datetime,omitempty"`
+	MerchantName    *string  `json:"merchant_name,omitempty"`
+	MerchantTaxID   *string  `json:"merchant_tax_id,omitempty"`
+	ReceiptTotal    *float64 `json:"receipt_total,omitempty"`
+	Items           []Item   `json:"items"`
+}
+
+/*
+IsEmpty reports whether the corrections change nothing and confirm nothing.
+*/
+func (corrections Corrections) IsEmpty() bool {
+	return corrections.ConfirmedAt == "" && corrections.ReceiptDate == nil && corrections.ReceiptDateTime == nil &&
+		corrections.MerchantName == nil && corrections.MerchantTaxID == nil &&
+		corrections.ReceiptTotal == nil && corrections.Items == nil
+}
+
+/*
+Changes reports whether the corrections change any field (a confirmation
+alone changes none).
+*/
+func (corrections Corrections) Changes() bool {
+	corrections.ConfirmedAt = ""
+	return !corrections.IsEmpty()
+}
+
+/*
+Apply returns analysis with the corrections on top. When the items or the
+receipt total were corrected, the items are added up again and
+total_check_message says whether they still differ from the total.
+*/
+func (corrections Corrections) Apply(analysis Analysis) Analysis {
+	if corrections.ConfirmedAt != "" {
+		analysis.ConfirmedAt = corrections.ConfirmedAt
+	}
+	if corrections.ReceiptDate != nil {
+		analysis.ReceiptDate = *corrections.ReceiptDate
+	}
+	if corrections.ReceiptDateTime != nil {
+		analysis.ReceiptDateTime = *corrections.ReceiptDateTime
+	}
+	if corrections.MerchantName != nil {
+		analysis.MerchantName = *corrections.MerchantName
+	}
+	if corrections.MerchantTaxID != nil {
+		analysis.MerchantTaxID = *corrections.MerchantTaxID
+	}
+	if corrections.Items == nil && corrections.ReceiptTotal == nil {
+		return analysis
+	}
+
+	if corrections.Items != nil {
+		analysis.Items = append([]Item{}, corrections.Items...)
+	}
+	if corrections.ReceiptTotal != nil {
+		analysis.Totals.ReceiptTotal = *corrections.ReceiptTotal
+	}
+
+	itemsTotal := 0.0
+	for _, item := range analysis.Items {
+		itemsTotal += item.LineTotal
+	}
+	analysis.Totals.ComputedItemsTotal = math.Round(itemsTotal*100) / 100
+	analysis.Totals.TotalCheckMessage = ""
+	difference := analysis.Totals.ReceiptTotal - analysis.Totals.ComputedItemsTotal
+	if math.Abs(difference) > 1 {
+		analysis.Totals.TotalCheckMessage = fmt.Sprintf(
+			"Sum of corrected items is %.2f but receipt total is %.2f (difference: %.2f).",
+			analysis.Totals.ComputedItemsTotal, analysis.Totals.ReceiptTotal, difference,
+		)
+	}
+	return analysis
+}
+
+/*
+CorrectionsPath returns the path of receipt-corrections.json inside runDirPath.
+*/
+func CorrectionsPath(runDirPath string) string {
+	return filepath.Join(runDirPath, CorrectionsFileName)
+}
+
+/*
+LoadCorrections reads the corrections of a run directory. found is false
+when it has none.
+*/
+func LoadCorrections(runDirPath string) (corrections Corrections, found bool, e *xerr.Error) {
+	correctionsPath := CorrectionsPath(runDirPath)
+	bytesRead, readErr := os.ReadFile(correctionsPath)
+	if errors.Is(readErr, os.ErrNotExist) {
+		return corrections, false, nil
+	}
+	if readErr != nil {
+		e = xerr.NewError(readErr, "read receipt corrections file", correctionsPath)
+		return corrections, false, e
+	}
+
+	unmarshalErr := json.Unmarshal(bytesRead, &corrections)
+	if unmarshalErr != nil {
+		e = xerr.NewError(unmarshalErr, "unmarshal receipt corrections JSON", correctionsPath)
+		return corrections, false, e
+	}
+	return corrections, true, nil
+}
+
+/*
+SaveCorrections writes the corrections of a run directory, or removes the
+file when they are empty.
+*/
+func SaveCorrections(runDirPath string, corrections Corrections) (e *xerr.Error) {
+	correctionsPath := CorrectionsPath(runDirPath)
+	if corrections.IsEmpty() {
+		removeErr := os.Remove(correctionsPath)
+		if removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			e = xerr.NewError(removeErr, "remove receipt corrections file", correctionsPath)
+			return e
+		}
+		tl.Log(tl.Info1, palette.Green, "%s in '%s'", "No receipt corrections left", runDirPath)
+		return nil
+	}
+
+	jsonBytes, marshalErr := json.MarshalIndent(corrections, "", "  ")
+	if marshalErr != nil {
+		e = xerr.NewError(marshalErr, "marshal receipt corrections to JSON", correctionsPath)
+		return e
+	}
+
+	writeErr := os.WriteFile(correctionsPath, jsonBytes, 0o644)
+	if writeErr != nil {
+		e = xerr.NewError(writeErr, "write receipt corrections file", correctionsPath)
+		return e
+	}
+
+	tl.Log(tl.Info1, palette.Green, "%s to '%s'", "Saved receipt corrections", correctionsPath)
+	return nil
+}
@@ -0,0 +1,63 @@
+package receipt
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+)
+
+// AnalysisFileName is the name of the analysis file inside a run directory.
+const AnalysisFileName = "receipt-analysis.json"
+
+/*
+AnalysisPath returns the path of receipt-analysis.json inside runDirPath.
+*/
+func AnalysisPath(runDirPath string) string {
+	return filepath.Join(runDirPath, AnalysisFileName)
+}
+
+/*
+SaveAnalysis writes the analysis as pretty-printed JSON to analysisPath,
+overwriting any existing file.
+*/
+func SaveAnalysis(analysisPath string, analysis Analysis) (e *xerr.Error) {
+	jsonBytes, marshalErr := json.MarshalIndent(analysis, "", "  ")
+	if marshalErr != nil {
+		e = xerr.NewError(marshalErr, "marshal receipt analysis to JSON", analysisPath)
+		return e
+	}
+
+	writeErr := os.WriteFile(analysisPath, jsonBytes, 0o644)
+	if writeErr != nil {
+		e = xerr.NewError(writeErr, "write receipt analysis file", analysisPath)
+		return e
+	}
+
+	tl.Log(tl.Info1, palette.Green, "%s to '%s'", "Saved receipt analysis", analysisPath)
+	return e
+}
+
+/*
+LoadAnalysis reads and unmarshals an Analysis from analysisPath.
+
+Unknown fields are ignored, so older files keep loading as the model grows.
+*/
+func LoadAnalysis(analysisPath string) (analysis Analysis, e *xerr.Error) {
+	bytesRead, readErr := os.ReadFile(analysisPath)
+	if readErr != nil {
+		e = xerr.NewErrorEC(readErr, "read receipt analysis file", "path", analysisPath, false)
+		return analysis, e
+	}
+
+	unmarshalErr := json.Unmarshal(bytesRead, &analysis)
+	if unmarshalErr != nil {
+		e = xerr.NewErrorEC(unmarshalErr, "unmarshal receipt analysis JSON", "path", analysisPath, false)
+		return analysis, e
+	}
+
+	return analysis, e
+}
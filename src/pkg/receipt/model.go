@@ -0,0 +1,224 @@
+/*
+Shared receipt data model.
+
+The same types are used to build the LLM structured-output schema, to write
+receipt-analysis.json in the pipeline and to read it back in the report, so
+the three can never drift apart.
+*/
+package receipt
+
+import (
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/currency"
+	"expense-tracker/src/pkg/openai"
+)
+
+/*
+Item holds information about a single product line parsed from a receipt.
+
+Fields:
+  - LineIndex: zero-based index of the line in the OCR text that primarily
+    corresponds to this item. Use -1 if the line index is unclear.
+  - RawLine: raw OCR text for this item (or the main line used).
+  - OriginalProductName: cleaned product name as it is in receipt.
+  - ProductNameEnglish: short English translation of the product name.
+  - Quantity: quantity of the item (1.0 if not explicitly specified).
+  - UnitPrice: unit price in the receipt currency, if you can infer it (0 if unknown).
+  - LineTotal: total amount for this item in the receipt currency.
+  - CategoryKey: one of the allowed category keys (or "other" if nothing fits).
+  - Box: where LineIndex is on the original photo (filled in by the
+    pipeline from ocr-boxes.json, not by the model; nil if unknown).
+  - CategoryRule: name of the categorization rule that set CategoryKey
+    (filled in by the pipeline, see category.ApplyRules; "" when the
+    model's category was kept).
+  - LLMCategoryKey: the model's category, kept when a rule fired.
+  - SKU: the merchant's product code (filled in from e-invoices, "" for
+    photographed receipts).
+*/
+type Item struct {
+	LineIndex           int      `json:"line_index" desc:"Zero-based index of the main OCR line for this item, or -1 if unknown."`
+	RawLine             string   `json:"raw_line" desc:"Raw OCR text line(s) used to derive this item."`
+	OriginalProductName string   `json:"original_product_name" desc:"Cleaned product name as it is in the OCR text/image, without the price."`
+	ProductNameEnglish  string   `json:"product_name_english" desc:"Short English translation of the product name."`
+	Quantity            float64  `json:"quantity" desc:"Quantity of the item (1.0 if not explicitly given)."`
+	UnitPrice           float64  `json:"unit_price" desc:"Unit price in the receipt currency, or 0 if unknown."`
+	LineTotal           float64  `json:"line_total" desc:"Total amount for this item in the receipt currency."`
+	CategoryKey         string   `json:"category_key" desc:"One of the allowed category keys or 'other'."`
+	Box                 *ItemBox `json:"box,omitempty" schema:"-"`
+	CategoryRule        string   `json:"category_rule,omitempty" schema:"-"`
+	LLMCategoryKey      string   `json:"llm_category_key,omitempty" schema:"-"`
+	SKU                 string   `json:"sku,omitempty" schema:"-"`
+}
+
+/*
+ItemBox points an item at its OCR line box.
+
+Fields:
+  - Line: index into the lines of the run's ocr-boxes.json.
+  - Left, Top, Right, Bottom: that line's bounding rectangle in pixels of
+    the original image (after EXIF orientation).
+  - Image: the photo the line is on, relative to the run directory, for
+    receipts stitched from several photos ("" for a single photo).
+*/
+type ItemBox struct {
+	Image  string `json:"image,omitempty"`
+	Line   int    `json:"line"`
+	Left   int    `json:"left"`
+	Top    int    `json:"top"`
+	Right  int    `json:"right"`
+	Bottom int    `json:"bottom"`
+}
+
+/*
+Totals holds the summary totals for a parsed receipt.
+
+Fields:
+  - ReceiptTotal: the total amount as written on the receipt.
+  - ComputedItemsTotal: the sum of all item line totals.
+  - TotalCheckMessage: empty string if receipt total matches sum of items;
+    otherwise, a short English explanation of the difference.
+*/
+type Totals struct {
+	ReceiptTotal       float64 `json:"receipt_total" desc:"Total amount as written on the receipt, in the receipt currency."`
+	ComputedItemsTotal float64 `json:"computed_items_total" desc:"Sum of all item line_total values."`
+	TotalCheckMessage  string  `json:"total_check_message" desc:"Empty string if sums match; otherwise a short English explanation."`
+}
+
+/*
+Source identifies the original image a receipt was extracted from. It is
+filled in by the pipeline (never by the model) and lets the report detect the
+same receipt processed twice.
+
+Fields:
+  - ImagePath: path of the original image given to the pipeline.
+  - SHA256: hex SHA-256 of the original image bytes.
+  - PerceptualHash: 64-bit dHash (hex) of the original image.
+  - Segment: where on the image the receipt is, when the photo had several
+    receipts (nil otherwise). The other fields are then those of the whole
+    photo, shared by all its receipts.
+*/
+type Source struct {
+	ImagePath      string   `json:"image_path"`
+	SHA256         string   `json:"sha256"`
+	PerceptualHash string   `json:"perceptual_hash"`
+	Segment        *Segment `json:"segment,omitempty"`
+}
+
+/*
+Segment is one receipt of a photo with several receipts.
+
+Fields:
+  - Index: 1-based position of the receipt in the photo, in reading order.
+  - Count: receipts found in the photo.
+  - RunDir: run directory of the whole photo (split.json, orig.*); the
+    receipt's own run directory is inside it.
+  - Left, Top, Right, Bottom: the receipt's rectangle in pixels of the
+    photo (after EXIF orientation).
+
+The ledger keeps only Index.
+*/
+type Segment struct {
+	Index  int    `json:"index"`
+	Count  int    `json:"count,omitempty"`
+	RunDir string `json:"run_dir,omitempty"`
+	Left   int    `json:"left,omitempty"`
+	Top    int    `json:"top,omitempty"`
+	Right  int    `json:"right,omitempty"`
+	Bottom int    `json:"bottom,omitempty"`
+}
+
+/*
+Invoice holds what only electronic invoices carry. It is filled in by the
+e-invoice importer (never by the model); nil for photographed receipts.
+
+Fields:
+  - Number: invoice number with its prefix, e.g. "SETP990000002".
+  - CUFE: the invoice's unique code (cbc:UUID), which DIAN uses to look
+    it up.
+  - Subtotal: amount before taxes.
+  - TaxTotal: sum of Taxes.
+  - Taxes: taxes per scheme and rate (IVA 19%, INC 8%, ...).
+*/
+type Invoice struct {
+	Number   string  `json:"number"`
+	CUFE     string  `json:"cufe"`
+	Subtotal float64 `json:"subtotal"`
+	TaxTotal float64 `json:"tax_total"`
+	Taxes    []Tax   `json:"taxes"`
+}
+
+/*
+Tax is one tax of an invoice.
+
+Fields:
+  - Scheme: tax name, e.g. "IVA", "INC", "ICUI".
+  - Percent: rate in percent.
+  - TaxableAmount: amount the rate applies to.
+  - Amount: tax charged.
+*/
+type Tax struct {
+	Scheme        string  `json:"scheme"`
+	Percent       float64 `json:"percent"`
+	TaxableAmount float64 `json:"taxable_amount"`
+	Amount        float64 `json:"amount"`
+}
+
+/*
+Analysis is the full result of the AI-based receipt parsing, as stored in
+receipt-analysis.json.
+
+Fields:
+  - LLMRunMetadata: metadata returned by the LLM wrapper (not part of the
+    schema sent to the model).
+  - Source: original image identity (not part of the schema).
+  - Invoice: electronic invoice details (not part of the schema; nil
+    unless imported from a DIAN e-invoice).
+  - ConfirmedAt: when a person confirmed the items, categories and English
+    names are right (RFC 3339, not part of the schema; "" if never).
+    Confirmations are saved in Corrections.ConfirmedAt; analyses confirmed
+    before that carry it here, and reanalyzing keeps it.
+  - ReceiptDate: purchase date printed on the receipt as YYYY-MM-DD ("" if unknown).
+  - ReceiptDateTime: purchase date and time as YYYY-MM-DD HH:MM:SS ("" if the
+    time is not printed).
+  - MerchantName: store or business name as printed on the receipt.
+  - MerchantTaxID: merchant tax identifier (NIT in Colombia) as digits, a
+    dash and the check digit, e.g. "900123456-7" ("" if not printed). The
+    ledger keys merchants by it after NormalizeTaxID.
+  - StoreAddress: store address as printed on the receipt ("" if not printed).
+  - Currency: ISO 4217 code of all amounts on the receipt ("COP", "USD",
+    "EUR", ...). Empty in analyses made before it was recorded; use
+    EffectiveCurrency.
+  - Items: list of parsed receipt items.
+  - Totals: summary totals for the receipt (receipt total vs sum of items).
+*/
+type Analysis struct {
+	LLMRunMetadata  *openai.LLMRunMetadata `json:"llm_run_metadata,omitempty" schema:"-"`
+	Source          *Source                `json:"source,omitempty" schema:"-"`
+	Invoice         *Invoice               `json:"invoice,omitempty" schema:"-"`
+	ConfirmedAt     string                 `json:"confirmed_at,omitempty" schema:"-"`
+	ReceiptDate     string                 `json:"receipt_date" desc:"Purchase date as YYYY-MM-DD, or empty string if not printed."`
+	ReceiptDateTime string                 `json:"receipt_datetime" desc:"Purchase date and time as YYYY-MM-DD HH:MM:SS, or empty string if no time is printed."`
+	MerchantName    string                 `json:"merchant_name" desc:"Store or business name as printed on the receipt."`
+	MerchantTaxID   string                 `json:"merchant_tax_id" desc:"Merchant tax ID (NIT) such as 900123456-7, or empty string if not printed."`
+	StoreAddress    string                 `json:"store_address" desc:"Store address as printed on the receipt, or empty string if not printed."`
+	Currency        string                 `json:"currency" desc:"ISO 4217 currency code of the amounts, such as COP, USD or EUR."`
+	Items           []Item                 `json:"items" desc:"List of line items parsed from the receipt."`
+	Totals          Totals                 `json:"totals" desc:"Summary totals for the receipt."`
+}
+
+/*
+EffectiveCurrency returns the normalized currency of the receipt amounts,
+assuming currency.Default for analyses without one.
+*/
+func (analysis Analysis) EffectiveCurrency() string {
+	return currency.Normalize(analysis.Currency)
+}
+
+/*
+AnalysisSchemaProperties returns the JSON Schema properties for Analysis,
+to be used as the structured-output schema for the LLM call.
+*/
+func AnalysisSchemaProperties() (properties map[string]any, e *xerr.Error) {
+	return openai.SchemaPropertiesFromStruct(Analysis{})
+}
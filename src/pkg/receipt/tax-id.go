@@ -0,0 +1,32 @@
+package receipt
+
+import (
+	"regexp"
+	"strings"
+)
+
+// taxIDNoiseReplacer drops what receipts print around a NIT and unifies dashes.
+var taxIDNoiseReplacer = strings.NewReplacer(
+	"NIT", "", ".", "", ",", "", ":", "", " ", "",
+	"\u2010", "-", "\u2011", "-", "\u2012", "-", "\u2013", "-", "\u2014", "-",
+)
+
+// taxIDCheckDigitRegexp matches a NIT with a separate check digit: "900123456-7" or "900123456DV7".
+var taxIDCheckDigitRegexp = regexp.MustCompile(`^(\d+)(?:-|-?DV-?)(\d)$`)
+
+/*
+NormalizeTaxID returns a merchant tax ID in the form of MerchantTaxID:
+digits, a dash and the check digit, without the "NIT" label, dots or
+spaces. An ID printed without a separate check digit is kept as digits
+only, since its last digit may or may not be the check digit.
+
+Example:
+
+	NormalizeTaxID("NIT: 900.123.456-7") -> "900123456-7"
+	NormalizeTaxID("900123456 DV 7")     -> "900123456-7"
+	NormalizeTaxID("900,123,456")        -> "900123456"
+*/
+func NormalizeTaxID(taxID string) string {
+	cleaned := taxIDNoiseReplacer.Replace(strings.ToUpper(taxID))
+	return taxIDCheckDigitRegexp.ReplaceAllString(cleaned, "$1-$2")
+}
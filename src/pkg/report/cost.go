@@ -0,0 +1,187 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"expense-tracker/src/pkg/openai"
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+modelCostRow is the processing cost of one model in the reported month.
+
+Fields:
+  - Model: model name without snapshot date (e.g. "gpt-5-mini").
+  - Provider: backend that ran it ("openai", "local").
+  - Runs: number of LLM analyses.
+  - TokensIn: input tokens, including cached.
+  - TokensOut: output tokens, including reasoning.
+  - CostUSD: total estimated cost.
+  - MismatchRuns: analyses whose items didn't add up to the receipt total,
+    a rough quality signal to weigh against the price.
+*/
+type modelCostRow struct {
+	Model        string  `json:"model"`
+	Provider     string  `json:"provider"`
+	Runs         int     `json:"runs"`
+	TokensIn     int64   `json:"tokens_in"`
+	TokensOut    int64   `json:"tokens_out"`
+	CostUSD      float64 `json:"cost_usd"`
+	MismatchRuns int     `json:"mismatch_runs"`
+}
+
+/*
+costSummary is the "Processing cost" section of the report.
+
+Fields:
+  - TotalUSD: estimated cost of all LLM runs for the month's receipts,
+    including duplicates (they were paid for too).
+  - Runs: number of LLM runs.
+  - EstimatedRuns: runs without a stored cost_usd, priced from their tokens
+    with the current price table.
+  - UnpricedRuns: runs whose model has no price (counted as $0).
+  - Rows: per model, most expensive first.
+*/
+type costSummary struct {
+	TotalUSD      float64        `json:"total_usd"`
+	Runs          int            `json:"runs"`
+	EstimatedRuns int            `json:"estimated_runs"`
+	UnpricedRuns  int            `json:"unpriced_runs"`
+	Rows          []modelCostRow `json:"rows"`
+}
+
+/*
+buildCostSummary adds up LLM run costs of every analysis in the period.
+*/
+func buildCostSummary(periodRuns []periodRun) (summary costSummary) {
+	rowByModel := make(map[string]*modelCostRow)
+
+	for _, candidate := range periodRuns {
+		meta := candidate.Analysis.LLMRunMetadata
+		if meta == nil {
+			continue
+		}
+
+		costUSD, costKnown := runCostUSD(*meta)
+		if meta.CostUSD == 0 && costKnown && costUSD > 0 {
+			summary.EstimatedRuns += 1
+		}
+		if !costKnown {
+			summary.UnpricedRuns += 1
+		}
+
+		provider := meta.Provider
+		if provider == "" {
+			provider = "openai"
+		}
+		key := provider + "|" + meta.Model
+
+		row, exists := rowByModel[key]
+		if !exists {
+			row = &modelCostRow{Model: meta.Model, Provider: provider}
+			rowByModel[key] = row
+		}
+
+		row.Runs += 1
+		row.TokensIn += int64(meta.TokensIn)
+		row.TokensOut += int64(meta.TokensOut)
+		row.CostUSD += costUSD
+		if hasTotalsMismatch(candidate.Analysis) {
+			row.MismatchRuns += 1
+		}
+
+		summary.Runs += 1
+		summary.TotalUSD += costUSD
+	}
+
+	for _, row := range rowByModel {
+		summary.Rows = append(summary.Rows, *row)
+	}
+	sort.Slice(summary.Rows, func(firstIndex int, secondIndex int) bool {
+		return summary.Rows[firstIndex].CostUSD > summary.Rows[secondIndex].CostUSD
+	})
+
+	return summary
+}
+
+/*
+runCostUSD returns the stored cost_usd, or prices the tokens with the
+current price table for analyses made before costs were recorded.
+Local models cost nothing.
+*/
+func runCostUSD(meta openai.LLMRunMetadata) (costUSD float64, known bool) {
+	if meta.CostUSD > 0 {
+		return meta.CostUSD, true
+	}
+	if meta.Provider == "local" {
+		return 0, true
+	}
+	return openai.EstimateCostUSD(meta)
+}
+
+/*
+hasTotalsMismatch reports whether the model flagged a difference between the
+receipt total and the sum of its items.
+*/
+func hasTotalsMismatch(run receipt.Analysis) bool {
+	return strings.TrimSpace(run.Totals.TotalCheckMessage) != ""
+}
+
+/*
+renderCostSection writes the "Processing cost" card.
+*/
+func renderCostSection(buffer *bytes.Buffer, summary costSummary, receiptCount int) {
+	buffer.WriteString(`<div style="padding:0 0 18px 0;">`)
+	buffer.WriteString(cardOpen())
+	buffer.WriteString(`<div style="padding:16px 18px 16px 18px;">`)
+	buffer.WriteString(`<div style="font-size:13px;font-weight:900;color:#111827;">Processing cost</div>`)
+	buffer.WriteString(`<div style="margin-top:6px;font-size:12px;line-height:1.6;color:#6B7280;">`)
+	buffer.WriteString(`OCR runs locally (Tesseract) and is free. LLM analysis: <span style="font-weight:800;color:#111827;">` + html.EscapeString(openai.FormatUSD(summary.TotalUSD)) + `</span>`)
+	buffer.WriteString(` for ` + formatIntHuman(int64(summary.Runs)) + ` runs`)
+	if receiptCount > 0 {
+		buffer.WriteString(` (` + html.EscapeString(openai.FormatUSD(summary.TotalUSD/float64(receiptCount))) + ` per counted receipt)`)
+	}
+	buffer.WriteString(`.</div>`)
+
+	if len(summary.Rows) > 0 {
+		cellStyle := `padding:6px 8px;border-bottom:1px solid #E5E7EB;font-size:12px;color:#111827;`
+		headStyle := `padding:6px 8px;border-bottom:1px solid #E5E7EB;font-size:11px;color:#6B7280;text-transform:uppercase;letter-spacing:0.06em;`
+
+		buffer.WriteString(`<table role="presentation" cellpadding="0" cellspacing="0" border="0" width="100%" style="margin-top:10px;border-collapse:collapse;">`)
+		buffer.WriteString(`<tr>`)
+		buffer.WriteString(`<td style="` + headStyle + `">Model</td>`)
+		buffer.WriteString(`<td align="right" style="` + headStyle + `">Runs</td>`)
+		buffer.WriteString(`<td align="right" style="` + headStyle + `">Tokens in / out</td>`)
+		buffer.WriteString(`<td align="right" style="` + headStyle + `">Cost</td>`)
+		buffer.WriteString(`<td align="right" style="` + headStyle + `">Per run</td>`)
+		buffer.WriteString(`<td align="right" style="` + headStyle + `">Totals mismatch</td>`)
+		buffer.WriteString(`</tr>`)
+
+		for _, row := range summary.Rows {
+			perRun := 0.0
+			mismatchPercent := 0.0
+			if row.Runs > 0 {
+				perRun = row.CostUSD / float64(row.Runs)
+				mismatchPercent = float64(row.MismatchRuns) / float64(row.Runs) * 100.0
+			}
+
+			buffer.WriteString(`<tr>`)
+			buffer.WriteString(`<td style="` + cellStyle + `font-weight:800;">` + html.EscapeString(row.Model) + ` <span style="font-weight:400;color:#6B7280;">(` + html.EscapeString(row.Provider) + `)</span></td>`)
+			buffer.WriteString(`<td align="right" style="` + cellStyle + `">` + formatIntHuman(int64(row.Runs)) + `</td>`)
+			buffer.WriteString(`<td align="right" style="` + cellStyle + `">` + formatIntHuman(row.TokensIn) + ` / ` + formatIntHuman(row.TokensOut) + `</td>`)
+			buffer.WriteString(`<td align="right" style="` + cellStyle + `font-weight:800;">` + html.EscapeString(openai.FormatUSD(row.CostUSD)) + `</td>`)
+			buffer.WriteString(`<td align="right" style="` + cellStyle + `">` + html.EscapeString(openai.FormatUSD(perRun)) + `</td>`)
+			buffer.WriteString(`<td align="right" style="` + cellStyle + `">` + fmt.Sprintf("%.0f%%", mismatchPercent) + `</td>`)
+			buffer.WriteString(`</tr>`)
+		}
+		buffer.WriteString(`</table>`)
+	}
+
+	buffer.WriteString(`</div>`)
+	buffer.WriteString(cardClose())
+	buffer.WriteString(`</div>`)
+}
@@ -0,0 +1,280 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/config"
+	"expense-tracker/src/pkg/currency"
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+receiptConverter converts receipt amounts into the reporting currency and
+keeps counts for the report notes.
+
+Fields:
+  - Currency: reporting currency.
+  - RatesPath: exchange rates file; only read if it exists.
+  - rates: loaded rates, nil without a rates file.
+  - convertedCount: receipts in another currency that were converted.
+  - inexactCount: conversions that used rates from a different date.
+  - failedByCurrency: receipts left out because no rate was found, by currency.
+*/
+type receiptConverter struct {
+	Currency  string
+	RatesPath string
+
+	rates            *currency.Rates
+	convertedCount   int
+	inexactCount     int
+	failedByCurrency map[string]int
+}
+
+/*
+newReceiptConverter loads the rates file if it exists. A missing file is
+fine as long as every receipt is already in the reporting currency.
+*/
+func newReceiptConverter(reportingCurrency string, ratesPath string) (converter *receiptConverter, e *xerr.Error) {
+	converter = &receiptConverter{
+		Currency:         currency.Normalize(reportingCurrency),
+		RatesPath:        ratesPath,
+		failedByCurrency: make(map[string]int),
+	}
+
+	if ratesPath != "" && config.FileExists(ratesPath) {
+		converter.rates, e = currency.LoadRates(ratesPath)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	return converter, nil
+}
+
+/*
+convert returns the rate from the receipt currency to the reporting currency
+on the receipt date.
+*/
+func (converter *receiptConverter) convert(run receipt.Analysis, receiptTime time.Time) (conversion currency.Conversion, e *xerr.Error) {
+	conversion, e = converter.rates.Convert(run.EffectiveCurrency(), converter.Currency, receiptTime.Format(time.DateOnly))
+	if e != nil {
+		converter.failedByCurrency[conversion.From] += 1
+		return conversion, e
+	}
+
+	if conversion.From != conversion.To {
+		converter.convertedCount += 1
+		if !conversion.Exact {
+			converter.inexactCount += 1
+		}
+	}
+
+	return conversion, nil
+}
+
+/*
+toReporting converts an amount in the receipt currency into minor units of
+the reporting currency.
+*/
+func (converter *receiptConverter) toReporting(amount float64, conversion currency.Conversion) int64 {
+	return currency.ToMinor(amount*conversion.Rate, converter.Currency)
+}
+
+/*
+notes explains conversions (and receipts that couldn't be converted) in the
+report notes.
+*/
+func (converter *receiptConverter) notes() (notes []string) {
+	if converter.convertedCount > 0 {
+		notes = append(notes, fmt.Sprintf(
+			"%d receipts were converted to %s with the rates in %s; original amounts are listed under Receipts.",
+			converter.convertedCount, converter.Currency, converter.RatesPath,
+		))
+	}
+	if converter.inexactCount > 0 {
+		notes = append(notes, fmt.Sprintf(
+			"%d conversions used the closest available rate date instead of the receipt date.",
+			converter.inexactCount,
+		))
+	}
+
+	failedCurrencies := make([]string, 0, len(converter.failedByCurrency))
+	for code, count := range converter.failedByCurrency {
+		failedCurrencies = append(failedCurrencies, fmt.Sprintf("%d in %s", count, code))
+	}
+	sort.Strings(failedCurrencies)
+	if len(failedCurrencies) > 0 {
+		notes = append(notes, fmt.Sprintf(
+			"Receipts NOT included because there is no %s exchange rate for them in %s: %s.",
+			converter.Currency, converter.RatesPath, strings.Join(failedCurrencies, ", "),
+		))
+	}
+
+	return notes
+}
+
+/*
+receiptItemRow is an item line in the receipt drill-down.
+
+Fields:
+  - Name: English product name (original name if there is none).
+  - CategoryKey: normalized category key.
+  - OriginalAmount: line total as printed, in the receipt currency.
+  - Amount: line total in minor units of the reporting currency.
+*/
+type receiptItemRow struct {
+	Name           string  `json:"name"`
+	CategoryKey    string  `json:"category_key"`
+	OriginalAmount float64 `json:"original_amount"`
+	Amount         int64   `json:"amount"`
+}
+
+/*
+receiptRow is a counted receipt in the drill-down section.
+
+Fields:
+  - RunDir: pipeline run directory.
+  - Time: receipt time used for the period filter.
+  - Merchant: merchant name.
+  - OriginalTotal: receipt total in the receipt currency.
+  - Total: receipt total in minor units of the reporting currency.
+  - Conversion: rate applied (Rate 1 when no conversion was needed).
+  - Items: the receipt lines.
+  - PossibleDuplicateOf: run directory of a counted receipt whose photo
+    looks the same but whose content differs ("" if none).
+*/
+type receiptRow struct {
+	RunDir              string              `json:"run_dir"`
+	Time                time.Time           `json:"time"`
+	Merchant            string              `json:"merchant"`
+	OriginalTotal       float64             `json:"original_total"`
+	Total               int64               `json:"total"`
+	Conversion          currency.Conversion `json:"conversion"`
+	Items               []receiptItemRow    `json:"items"`
+	PossibleDuplicateOf string              `json:"possible_duplicate_of,omitempty"`
+}
+
+func buildReceiptRow(candidate periodRun, conversion currency.Conversion, converter *receiptConverter) receiptRow {
+	run := candidate.Analysis
+
+	merchant := strings.TrimSpace(run.MerchantName)
+	if merchant == "" {
+		merchant = "Unknown merchant"
+	}
+
+	originalTotal := chooseReceiptTotal(run)
+	row := receiptRow{
+		RunDir:        candidate.Path,
+		Time:          candidate.Time,
+		Merchant:      merchant,
+		OriginalTotal: originalTotal,
+		Total:         converter.toReporting(originalTotal, conversion),
+		Conversion:    conversion,
+		Items:         make([]receiptItemRow, 0, len(run.Items)),
+	}
+
+	for _, item := range run.Items {
+		name := strings.TrimSpace(item.ProductNameEnglish)
+		if name == "" {
+			name = strings.TrimSpace(item.OriginalProductName)
+		}
+		row.Items = append(row.Items, receiptItemRow{
+			Name:           name,
+			CategoryKey:    normalizeCategoryKey(item.CategoryKey),
+			OriginalAmount: item.LineTotal,
+			Amount:         converter.toReporting(item.LineTotal, conversion),
+		})
+	}
+
+	return row
+}
+
+/*
+renderReceiptsSection writes the per-receipt drill-down: one collapsible
+entry per counted receipt with its items, showing the original amounts and
+the rate for receipts in another currency.
+*/
+func renderReceiptsSection(buffer *bytes.Buffer, receiptRows []receiptRow, reportingCurrency string) {
+	if len(receiptRows) == 0 {
+		return
+	}
+
+	sortedRows := append([]receiptRow(nil), receiptRows...)
+	sort.SliceStable(sortedRows, func(firstIndex int, secondIndex int) bool {
+		return sortedRows[firstIndex].Time.Before(sortedRows[secondIndex].Time)
+	})
+
+	cellStyle := `padding:4px 6px;border-bottom:1px solid #F3F4F6;font-size:12px;color:#111827;`
+	mutedStyle := `font-size:11px;color:#6B7280;`
+
+	buffer.WriteString(`<div style="padding:0 0 18px 0;">`)
+	buffer.WriteString(cardOpen())
+	buffer.WriteString(`<div style="padding:16px 18px 16px 18px;">`)
+	buffer.WriteString(`<div style="font-size:13px;font-weight:900;color:#111827;">Receipts</div>`)
+	buffer.WriteString(`<div style="margin-top:4px;font-size:12px;line-height:1.5;color:#6B7280;">Amounts in ` + html.EscapeString(reportingCurrency) + `; receipts in other currencies also show the original amount.</div>`)
+
+	for _, row := range sortedRows {
+		converted := row.Conversion.From != row.Conversion.To
+
+		buffer.WriteString(`<details style="margin-top:8px;border-top:1px solid #E5E7EB;padding-top:8px;">`)
+		buffer.WriteString(`<summary style="cursor:pointer;font-size:13px;color:#111827;">`)
+		buffer.WriteString(`<span style="color:#6B7280;">` + row.Time.Format("2006-01-02") + `</span> &nbsp;`)
+		buffer.WriteString(`<span style="font-weight:800;">` + html.EscapeString(row.Merchant) + `</span> &nbsp;`)
+		buffer.WriteString(`<span style="font-weight:900;">` + html.EscapeString(currency.FormatMinor(row.Total, reportingCurrency)) + `</span>`)
+		if converted {
+			buffer.WriteString(` <span style="` + mutedStyle + `">(` + html.EscapeString(currency.Format(row.OriginalTotal, row.Conversion.From)) + `)</span>`)
+		}
+		if row.PossibleDuplicateOf != "" {
+			buffer.WriteString(` <span style="font-size:11px;font-weight:800;color:#B45309;">possible duplicate</span>`)
+		}
+		buffer.WriteString(`</summary>`)
+
+		if row.PossibleDuplicateOf != "" {
+			buffer.WriteString(`<div style="margin-top:4px;` + mutedStyle + `">Photo looks like ` + html.EscapeString(row.PossibleDuplicateOf) + `, but the total, date or merchant differ; counted.</div>`)
+		}
+
+		if converted {
+			buffer.WriteString(`<div style="margin-top:4px;` + mutedStyle + `">` + html.EscapeString(formatConversion(row.Conversion)) + `</div>`)
+		}
+
+		buffer.WriteString(`<table role="presentation" cellpadding="0" cellspacing="0" border="0" width="100%" style="margin-top:6px;border-collapse:collapse;">`)
+		for _, item := range row.Items {
+			buffer.WriteString(`<tr>`)
+			buffer.WriteString(`<td style="` + cellStyle + `">` + html.EscapeString(item.Name) + `</td>`)
+			buffer.WriteString(`<td style="` + cellStyle + mutedStyle + `">` + html.EscapeString(displayCategoryName(item.CategoryKey)) + `</td>`)
+			if converted {
+				buffer.WriteString(`<td align="right" style="` + cellStyle + mutedStyle + `">` + html.EscapeString(currency.Format(item.OriginalAmount, row.Conversion.From)) + `</td>`)
+			}
+			buffer.WriteString(`<td align="right" style="` + cellStyle + `">` + html.EscapeString(currency.FormatMinor(item.Amount, reportingCurrency)) + `</td>`)
+			buffer.WriteString(`</tr>`)
+		}
+		buffer.WriteString(`</table>`)
+		buffer.WriteString(`<div style="margin-top:4px;font-size:11px;color:#9CA3AF;">` + html.EscapeString(row.RunDir) + `</div>`)
+
+		buffer.WriteString(`</details>`)
+	}
+
+	buffer.WriteString(`</div>`)
+	buffer.WriteString(cardClose())
+	buffer.WriteString(`</div>`)
+}
+
+/*
+formatConversion describes the rate, quoting the stronger currency so the
+number stays readable (1 USD = 3925.5 COP rather than 1 COP = 0.000254 USD).
+*/
+func formatConversion(conversion currency.Conversion) string {
+	quote := fmt.Sprintf("1 %s = %.6g %s", conversion.From, conversion.Rate, conversion.To)
+	if conversion.Rate < 1 {
+		quote = fmt.Sprintf("1 %s = %.6g %s", conversion.To, 1/conversion.Rate, conversion.From)
+	}
+
+	return fmt.Sprintf("Converted at %s (rates of %s)", quote, conversion.RateDate)
+}
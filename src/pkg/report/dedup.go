@@ -0,0 +1,167 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"expense-tracker/src/pkg/currency"
+	"expense-tracker/src/pkg/imageindex"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+receiptDeduplicator remembers receipts already counted in the report and
+tells whether a new one is the same receipt seen again.
+
+A receipt is a duplicate when any of these match an already counted one:
+  - source.sha256 (the exact same image processed twice, e.g. with -force);
+  - source.perceptual_hash within imageindex.DefaultMaxDistance bits (a
+    second photo of the same receipt), only between photos (see
+    imageindex.IsPhoto) and only when the content agrees too: the same
+    total, or the same date and merchant. A near-identical photo whose
+    content differs is counted but flagged as a possible duplicate;
+  - merchant + receipt date and time + total (same receipt, different photo
+    that the hash didn't catch). Date-only receipts are not matched this way,
+    since two identical purchases on the same day are common.
+
+Receipts split from one photo share its hashes, so the hashes only match
+receipts at the same position (source.segment) of the photo.
+*/
+type receiptDeduplicator struct {
+	seenSHA256         map[sourceHash]string
+	seenPerceptualHash map[sourceHash]seenReceipt
+	seenContentKey     map[string]string
+}
+
+// sourceHash is an image hash and the receipt's position in the photo (0 for the whole photo).
+type sourceHash struct {
+	Hash    string
+	Segment int
+}
+
+// seenReceipt is a counted receipt and its run directory.
+type seenReceipt struct {
+	RunDir   string
+	Analysis receipt.Analysis
+}
+
+/*
+duplicateMatch is the counted receipt a new one matched.
+
+Fields:
+  - OfPath: run directory of the counted receipt.
+  - Reason: why they match, for the log.
+  - Possible: only the photos look alike; the new receipt is counted too.
+*/
+type duplicateMatch struct {
+	OfPath   string
+	Reason   string
+	Possible bool
+}
+
+func newReceiptDeduplicator() *receiptDeduplicator {
+	return &receiptDeduplicator{
+		seenSHA256:         make(map[sourceHash]string),
+		seenPerceptualHash: make(map[sourceHash]seenReceipt),
+		seenContentKey:     make(map[string]string),
+	}
+}
+
+/*
+checkAndAdd returns the counted receipt run duplicates and true, unless the
+match is only a possible one (see duplicateMatch). Otherwise, and for
+possible duplicates, it records run under runDir.
+*/
+func (dedup *receiptDeduplicator) checkAndAdd(runDir string, run receipt.Analysis) (match duplicateMatch, found bool) {
+	contentKey := receiptContentKey(run)
+	segment := 0
+	if run.Source != nil && run.Source.Segment != nil {
+		segment = run.Source.Segment.Index
+	}
+
+	if run.Source != nil && run.Source.SHA256 != "" {
+		previousPath, exists := dedup.seenSHA256[sourceHash{run.Source.SHA256, segment}]
+		if exists {
+			return duplicateMatch{OfPath: previousPath, Reason: "same image (sha256)"}, true
+		}
+	}
+
+	comparesPhotos := run.Source != nil && run.Source.PerceptualHash != "" && imageindex.IsPhoto(run.Source.ImagePath)
+	if comparesPhotos {
+		for perceptualHash, previous := range dedup.seenPerceptualHash {
+			if perceptualHash.Segment != segment {
+				continue
+			}
+			distance := imageindex.HammingDistance(perceptualHash.Hash, run.Source.PerceptualHash)
+			if distance < 0 || distance > imageindex.DefaultMaxDistance {
+				continue
+			}
+			if sameReceiptContent(previous.Analysis, run) {
+				return duplicateMatch{OfPath: previous.RunDir, Reason: fmt.Sprintf("near-identical photo (hash distance %d)", distance)}, true
+			}
+			if !found {
+				match = duplicateMatch{
+					OfPath:   previous.RunDir,
+					Reason:   fmt.Sprintf("near-identical photo (hash distance %d) with another total, date or merchant", distance),
+					Possible: true,
+				}
+				found = true
+			}
+		}
+	}
+
+	if contentKey != "" {
+		previousPath, exists := dedup.seenContentKey[contentKey]
+		if exists {
+			return duplicateMatch{OfPath: previousPath, Reason: "same merchant, date and total"}, true
+		}
+	}
+
+	if run.Source != nil && run.Source.SHA256 != "" {
+		dedup.seenSHA256[sourceHash{run.Source.SHA256, segment}] = runDir
+	}
+	if comparesPhotos {
+		dedup.seenPerceptualHash[sourceHash{run.Source.PerceptualHash, segment}] = seenReceipt{RunDir: runDir, Analysis: run}
+	}
+	if contentKey != "" {
+		dedup.seenContentKey[contentKey] = runDir
+	}
+
+	return match, found
+}
+
+/*
+sameReceiptContent reports whether two receipts whose photos look alike
+also agree on what they say: the same total, or the same date and
+merchant.
+*/
+func sameReceiptContent(first receipt.Analysis, second receipt.Analysis) bool {
+	firstTotal, secondTotal := chooseReceiptTotal(first), chooseReceiptTotal(second)
+	if firstTotal > 0 && first.EffectiveCurrency() == second.EffectiveCurrency() && math.Abs(firstTotal-secondTotal) < 0.005 {
+		return true
+	}
+
+	firstMerchant, secondMerchant := ledger.MerchantKey(first), ledger.MerchantKey(second)
+	return first.ReceiptDate != "" && first.ReceiptDate == second.ReceiptDate &&
+		firstMerchant != "" && firstMerchant == secondMerchant
+}
+
+/*
+receiptContentKey builds "merchant|datetime|currency total" for receipts that have
+enough header data (including the time) to be identified without the image;
+otherwise "".
+*/
+func receiptContentKey(run receipt.Analysis) string {
+	merchant := ledger.MerchantKey(run)
+
+	when := strings.TrimSpace(run.ReceiptDateTime)
+
+	total := chooseReceiptTotal(run)
+	if merchant == "" || when == "" || total <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s|%s|%s", merchant, when, currency.Format(total, run.EffectiveCurrency()))
+}
@@ -0,0 +1,746 @@
+/*
+Monthly expense report: receipts from the ledger filtered to one month,
+deduplicated, converted to one currency and rendered as email-safe HTML.
+Used by the report command and by receipt-server.
+*/
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tl "github.com/tuumbleweed/tintlog/logger"
+	"github.com/tuumbleweed/tintlog/palette"
+	"github.com/tuumbleweed/xerr"
+
+	"expense-tracker/src/pkg/category"
+	"expense-tracker/src/pkg/currency"
+	"expense-tracker/src/pkg/ledger"
+	"expense-tracker/src/pkg/receipt"
+)
+
+/*
+Options controls which receipts are included and how amounts are shown.
+
+CategoryLevel rolls item categories up the taxonomy (category.Current):
+1 shows top-level categories only, 2 their children, and so on; 0 shows
+the categories as the items have them.
+*/
+type Options struct {
+	Year          int        `json:"year"`
+	Month         time.Month `json:"month"`
+	Timezone      string     `json:"timezone"`
+	MaxRows       int        `json:"max_rows"`
+	ReportTitle   string     `json:"report_title"`
+	Currency      string     `json:"currency"`
+	RatesPath     string     `json:"rates_path"`
+	CategoryLevel int        `json:"category_level"`
+}
+
+/*
+categoryAgg accumulates spend for a category across many receipts.
+*/
+type categoryAgg struct {
+	Key             string `json:"key"`
+	DisplayName     string `json:"display_name"`
+	Amount          int64  `json:"amount"`
+	ItemLineCount   int64  `json:"item_line_count"`
+	ReceiptHitCount int64  `json:"receipt_hit_count"`
+}
+
+/*
+categoryRow is a rendered row in the final report.
+*/
+type categoryRow struct {
+	Key         string  `json:"key"`
+	DisplayName string  `json:"display_name"`
+	Amount      int64   `json:"amount"`
+	Percent     float64 `json:"percent"`
+	Color       string  `json:"color"`
+	BarPercent  int     `json:"bar_percent"`
+}
+
+/*
+MonthlyReport is the computed summary for the HTML report.
+Amounts are minor units of Currency (whole pesos for COP, cents for USD).
+*/
+type MonthlyReport struct {
+	Title                 string        `json:"title"`
+	Year                  int           `json:"year"`
+	Month                 time.Month    `json:"month"`
+	Timezone              string        `json:"timezone"`
+	Currency              string        `json:"currency"`
+	PeriodStart           time.Time     `json:"period_start"`
+	PeriodEnd             time.Time     `json:"period_end"`
+	GeneratedAt           time.Time     `json:"generated_at"`
+	ReceiptCount          int           `json:"receipt_count"`
+	TotalSpent            int64         `json:"total_spent"`
+	TotalSpentSourceLabel string        `json:"total_spent_source_label"`
+	Rows                  []categoryRow `json:"rows"`
+	Receipts              []receiptRow  `json:"receipts"`
+	Cost                  costSummary   `json:"cost"`
+	Notes                 []string      `json:"notes"`
+}
+
+/*
+NewOptions returns the options for the given month with the defaults used
+by the report command: America/Bogota, 100 category rows, COP and
+./cfg/exchange-rates.json.
+*/
+func NewOptions(year int, month time.Month) Options {
+	return Options{
+		Year:        year,
+		Month:       month,
+		Timezone:    "America/Bogota",
+		MaxRows:     100,
+		ReportTitle: fmt.Sprintf("Expense report — %s %d", month.String(), year),
+		Currency:    currency.Default,
+		RatesPath:   "./cfg/exchange-rates.json",
+	}
+}
+
+/*
+BuildMonthly filters ledger receipts by the selected month/year, aggregates
+totals by category_key, and returns a MonthlyReport. Receipts corrected by
+hand are counted with their corrections (receipt-corrections.json).
+Receipts rejected in the review queue are left out; pending ones are
+counted and mentioned in the notes.
+
+Filtering uses a "best available" date:
+- receipt_datetime (if present)
+- receipt_date (if present)
+- llm_run_metadata.started_at (Unix ms)
+*/
+func BuildMonthly(options Options, storedReceipts []ledger.StoredReceipt) (report MonthlyReport, e *xerr.Error) {
+	location, locationErr := time.LoadLocation(options.Timezone)
+	if locationErr != nil {
+		location = time.UTC
+	}
+
+	periodStart := time.Date(options.Year, options.Month, 1, 0, 0, 0, 0, location)
+	periodEnd := periodStart.AddDate(0, 1, 0).Add(-time.Nanosecond)
+
+	converter, e := newReceiptConverter(options.Currency, options.RatesPath)
+	if e != nil {
+		return report, e
+	}
+
+	categoryAggByKey := make(map[string]*categoryAgg)
+	receiptCount := 0
+	totalSpent := int64(0)
+	totalSpentFrom := "receipt_total when available, else sum(items.line_total)"
+	receiptRows := make([]receiptRow, 0)
+
+	dateFallbackCount := 0
+	explicitDateCount := 0
+	duplicateCount := 0
+	possibleDuplicateCount := 0
+	correctedCount := 0
+	rejectedCount := 0
+	pendingReviewCount := 0
+
+	periodRuns := make([]periodRun, 0)
+
+	for _, stored := range storedReceipts {
+		run, corrected := correctedAnalysis(stored)
+
+		runTime, runTimeSource, timeErr := determineReceiptTime(run, location)
+		if timeErr != nil {
+			tl.Log(tl.Warning, palette.PurpleBright, "Skipping receipt with no usable date '%s': %s", stored.RunDir, timeErr)
+			continue
+		}
+
+		if runTimeSource == "llm_run_metadata.started_at" {
+			dateFallbackCount += 1
+		} else {
+			explicitDateCount += 1
+		}
+
+		if runTime.Before(periodStart) || runTime.After(periodEnd) {
+			continue
+		}
+
+		if stored.ReviewState == ledger.ReviewRejected {
+			rejectedCount += 1
+			tl.Log(tl.Notice1, palette.Purple, "Not counting '%s': rejected in the review queue", stored.RunDir)
+			continue
+		}
+
+		periodRuns = append(periodRuns, periodRun{
+			Path: stored.RunDir, Time: runTime, Analysis: run, Corrected: corrected, ReviewState: stored.ReviewState,
+		})
+	}
+
+	// Newest analysis first, so a receipt reprocessed with -force replaces the older run.
+	sort.SliceStable(periodRuns, func(firstIndex int, secondIndex int) bool {
+		return analysisStartedAt(periodRuns[firstIndex].Analysis) > analysisStartedAt(periodRuns[secondIndex].Analysis)
+	})
+
+	dedup := newReceiptDeduplicator()
+
+	for _, candidate := range periodRuns {
+		runDir := candidate.Path
+		run := candidate.Analysis
+
+		duplicate, isDuplicate := dedup.checkAndAdd(runDir, run)
+		if isDuplicate && !duplicate.Possible {
+			duplicateCount += 1
+			tl.Log(tl.Notice1, palette.Purple, "Not counting '%s' twice: %s as '%s'", runDir, duplicate.Reason, duplicate.OfPath)
+			continue
+		}
+
+		conversion, convertErr := converter.convert(run, candidate.Time)
+		if convertErr != nil {
+			tl.Log(tl.Warning, palette.PurpleBright, "Not counting '%s': %s, %s", runDir, convertErr.Msg, convertErr.ErrStr)
+			continue
+		}
+
+		receiptCount += 1
+		if candidate.Corrected {
+			correctedCount += 1
+		}
+		if candidate.ReviewState == ledger.ReviewPending {
+			pendingReviewCount += 1
+		}
+
+		receiptTotal := converter.toReporting(chooseReceiptTotal(run), conversion)
+		totalSpent += receiptTotal
+
+		row := buildReceiptRow(candidate, conversion, converter)
+		if isDuplicate {
+			possibleDuplicateCount += 1
+			row.PossibleDuplicateOf = duplicate.OfPath
+			tl.Log(tl.Notice1, palette.Purple, "Counting '%s', a possible duplicate: %s as '%s'", runDir, duplicate.Reason, duplicate.OfPath)
+		}
+		receiptRows = append(receiptRows, row)
+
+		seenCategoriesInThisReceipt := make(map[string]bool)
+
+		for _, item := range run.Items {
+			categoryKey := normalizeCategoryKey(item.CategoryKey)
+			if categoryKey == "" {
+				categoryKey = "uncategorized"
+			}
+			categoryKey = category.Current.RollUp(categoryKey, options.CategoryLevel)
+
+			agg, exists := categoryAggByKey[categoryKey]
+			if !exists {
+				agg = &categoryAgg{
+					Key:             categoryKey,
+					DisplayName:     displayCategoryName(categoryKey),
+					Amount:          0,
+					ItemLineCount:   0,
+					ReceiptHitCount: 0,
+				}
+				categoryAggByKey[categoryKey] = agg
+			}
+
+			agg.Amount += converter.toReporting(item.LineTotal, conversion)
+			agg.ItemLineCount += 1
+
+			alreadyCounted := seenCategoriesInThisReceipt[categoryKey]
+			if !alreadyCounted {
+				agg.ReceiptHitCount += 1
+				seenCategoriesInThisReceipt[categoryKey] = true
+			}
+		}
+	}
+
+	rows := buildCategoryRows(categoryAggByKey, totalSpent, options.MaxRows)
+
+	notes := make([]string, 0)
+	notes = append(notes, fmt.Sprintf("Totals source: %s.", totalSpentFrom))
+	notes = append(notes, "Category percentages are computed from sum(items.line_total) divided by the displayed total.")
+	if options.CategoryLevel > 0 {
+		notes = append(notes, fmt.Sprintf("Categories are rolled up to level %d of the category taxonomy.", options.CategoryLevel))
+	}
+	if duplicateCount > 0 {
+		notes = append(notes, fmt.Sprintf("%d duplicate receipt analyses (same image, near-identical photo with the same total or date and merchant, or same merchant/time/total) were counted only once.", duplicateCount))
+	}
+	if possibleDuplicateCount > 0 {
+		notes = append(notes, fmt.Sprintf("%d receipts look like the photo of another receipt but differ in total, date or merchant; they are counted and marked as possible duplicates.", possibleDuplicateCount))
+	}
+	if correctedCount > 0 {
+		notes = append(notes, fmt.Sprintf("%d receipts were corrected by hand (%s); the corrected values are used.", correctedCount, receipt.CorrectionsFileName))
+	}
+	if pendingReviewCount > 0 {
+		notes = append(notes, fmt.Sprintf("%d receipts awaiting review (go run ./src/cmd/review list) are included.", pendingReviewCount))
+	}
+	if rejectedCount > 0 {
+		notes = append(notes, fmt.Sprintf("%d receipts rejected in the review queue were left out.", rejectedCount))
+	}
+	notes = append(notes, converter.notes()...)
+	if dateFallbackCount > 0 && explicitDateCount == 0 {
+		notes = append(notes, "Date filtering used llm_run_metadata.started_at for all receipts (no explicit receipt date fields were found).")
+	} else if dateFallbackCount > 0 {
+		notes = append(notes, "Some receipts used llm_run_metadata.started_at as the date because receipt_date/receipt_datetime were missing.")
+	}
+
+	cost := buildCostSummary(periodRuns)
+	if cost.EstimatedRuns > 0 {
+		notes = append(notes, fmt.Sprintf("%d LLM runs had no recorded cost; it was estimated from their tokens with the current price table.", cost.EstimatedRuns))
+	}
+	if cost.UnpricedRuns > 0 {
+		notes = append(notes, fmt.Sprintf("%d LLM runs used a model without a price (add it to openai.model_prices) and are counted as $0.", cost.UnpricedRuns))
+	}
+
+	report = MonthlyReport{
+		Title:                 options.ReportTitle,
+		Year:                  options.Year,
+		Month:                 options.Month,
+		Timezone:              options.Timezone,
+		Currency:              options.Currency,
+		PeriodStart:           periodStart,
+		PeriodEnd:             periodEnd,
+		GeneratedAt:           time.Now().In(location),
+		ReceiptCount:          receiptCount,
+		TotalSpent:            totalSpent,
+		TotalSpentSourceLabel: totalSpentFrom,
+		Rows:                  rows,
+		Receipts:              receiptRows,
+		Cost:                  cost,
+		Notes:                 notes,
+	}
+
+	tl.Log(tl.Info1, palette.Green, "Included %s receipts for %s-%s", formatIntHuman(int64(receiptCount)), options.Year, int(options.Month))
+
+	return report, e
+}
+
+/*
+periodRun is a receipt analysis that falls into the reported month.
+Path is its run directory, Time the receipt time used for filtering,
+Corrected whether Analysis has receipt-corrections.json applied,
+ReviewState where it is in the review queue.
+*/
+type periodRun struct {
+	Path        string
+	Time        time.Time
+	Analysis    receipt.Analysis
+	Corrected   bool
+	ReviewState ledger.ReviewState
+}
+
+/*
+correctedAnalysis returns the stored analysis with the corrections of its
+run directory on top (see receipt.Corrections), or as stored when it has
+none or they can't be read. corrected is false when they only confirm it.
+*/
+func correctedAnalysis(stored ledger.StoredReceipt) (run receipt.Analysis, corrected bool) {
+	corrections, found, e := receipt.LoadCorrections(stored.RunDir)
+	if e != nil {
+		tl.Log(tl.Warning, palette.PurpleBright, "Ignoring corrections of '%s': %s, %s", stored.RunDir, e.Msg, e.ErrStr)
+		return stored.Analysis, false
+	}
+	if !found {
+		return stored.Analysis, false
+	}
+	return corrections.Apply(stored.Analysis), corrections.Changes()
+}
+
+/*
+analysisStartedAt returns when the LLM analysis ran (Unix ms), or 0 if unknown.
+*/
+func analysisStartedAt(run receipt.Analysis) int64 {
+	if run.LLMRunMetadata == nil {
+		return 0
+	}
+	return run.LLMRunMetadata.StartedAt
+}
+
+/*
+determineReceiptTime finds the best available timestamp to use for filtering.
+
+It returns:
+- the chosen time
+- a short source label for diagnostics
+- a *xerr.Error if no usable time is found
+*/
+func determineReceiptTime(run receipt.Analysis, location *time.Location) (receiptTime time.Time, source string, e *xerr.Error) {
+	if run.ReceiptDateTime != "" {
+		parsed, ok := parseReceiptDateTime(run.ReceiptDateTime, location)
+		if ok {
+			return parsed, "receipt_datetime", e
+		}
+	}
+
+	if run.ReceiptDate != "" {
+		parsed, ok := parseReceiptDate(run.ReceiptDate, location)
+		if ok {
+			return parsed, "receipt_date", e
+		}
+	}
+
+	if run.LLMRunMetadata != nil && run.LLMRunMetadata.StartedAt > 0 {
+		receiptTime = time.UnixMilli(run.LLMRunMetadata.StartedAt).In(location)
+		return receiptTime, "llm_run_metadata.started_at", e
+	}
+
+	e = xerr.NewErrorECOL(fmt.Errorf("no usable date fields present"), "determine receipt time", "hint", "expected receipt_datetime, receipt_date, or llm_run_metadata.started_at")
+	return receiptTime, source, e
+}
+
+/*
+parseReceiptDateTime tries common datetime formats and returns (time, ok).
+*/
+func parseReceiptDateTime(raw string, location *time.Location) (parsed time.Time, ok bool) {
+	candidates := []string{
+		time.RFC3339,
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"02/01/2006 15:04:05",
+		"02/01/2006 15:04",
+	}
+
+	for _, layout := range candidates {
+		value, parseErr := time.ParseInLocation(layout, raw, location)
+		if parseErr == nil {
+			return value, true
+		}
+	}
+
+	return parsed, false
+}
+
+/*
+parseReceiptDate tries common date-only formats and returns (time, ok).
+
+The returned time is at 12:00 local time to avoid edge cases around DST boundaries.
+*/
+func parseReceiptDate(raw string, location *time.Location) (parsed time.Time, ok bool) {
+	candidates := []string{
+		"2006-01-02",
+		"02/01/2006",
+		"2006/01/02",
+	}
+
+	for _, layout := range candidates {
+		value, parseErr := time.ParseInLocation(layout, raw, location)
+		if parseErr == nil {
+			return time.Date(value.Year(), value.Month(), value.Day(), 12, 0, 0, 0, location), true
+		}
+	}
+
+	return parsed, false
+}
+
+/*
+chooseReceiptTotal selects the overall total for a receipt, in the receipt
+currency.
+
+Preference:
+1) totals.receipt_total if > 0
+2) totals.computed_items_total if > 0
+3) sum(items.line_total)
+*/
+func chooseReceiptTotal(run receipt.Analysis) float64 {
+	if run.Totals.ReceiptTotal > 0 {
+		return run.Totals.ReceiptTotal
+	}
+	if run.Totals.ComputedItemsTotal > 0 {
+		return run.Totals.ComputedItemsTotal
+	}
+
+	sum := 0.0
+	for _, item := range run.Items {
+		sum += item.LineTotal
+	}
+	return sum
+}
+
+/*
+buildCategoryRows converts aggregations into sorted rows, assigns colors (the taxonomy's, else from a palette), and optionally groups overflow into "Other".
+*/
+func buildCategoryRows(categoryAggByKey map[string]*categoryAgg, totalSpent int64, maxRows int) []categoryRow {
+	rows := make([]categoryRow, 0, len(categoryAggByKey))
+
+	for _, agg := range categoryAggByKey {
+		percent := 0.0
+		if totalSpent > 0 {
+			percent = (float64(agg.Amount) / float64(totalSpent)) * 100.0
+		}
+
+		barPercent := int(math.Round(percent))
+		if agg.Amount > 0 && barPercent == 0 {
+			barPercent = 1
+		}
+		if barPercent > 100 {
+			barPercent = 100
+		}
+
+		row := categoryRow{
+			Key:         agg.Key,
+			DisplayName: agg.DisplayName,
+			Amount:      agg.Amount,
+			Percent:     percent,
+			Color:       "",
+			BarPercent:  barPercent,
+		}
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(firstIndex int, secondIndex int) bool {
+		return rows[firstIndex].Amount > rows[secondIndex].Amount
+	})
+
+	if maxRows < 3 {
+		maxRows = 3
+	}
+
+	if len(rows) > maxRows {
+		keep := rows[:maxRows-1]
+		rest := rows[maxRows-1:]
+
+		otherAmount := int64(0)
+		for _, row := range rest {
+			otherAmount += row.Amount
+		}
+
+		otherPercent := 0.0
+		if totalSpent > 0 {
+			otherPercent = (float64(otherAmount) / float64(totalSpent)) * 100.0
+		}
+
+		otherBarPercent := int(math.Round(otherPercent))
+		if otherAmount > 0 && otherBarPercent == 0 {
+			otherBarPercent = 1
+		}
+		if otherBarPercent > 100 {
+			otherBarPercent = 100
+		}
+
+		other := categoryRow{
+			Key:         "other",
+			DisplayName: "Other",
+			Amount:      otherAmount,
+			Percent:     otherPercent,
+			Color:       "",
+			BarPercent:  otherBarPercent,
+		}
+
+		rows = append(keep, other)
+	}
+
+	paletteColors := []string{
+		"#2563EB", "#7C3AED", "#059669", "#DB2777", "#D97706",
+		"#0EA5E9", "#65A30D", "#9333EA", "#F43F5E", "#14B8A6",
+		"#4F46E5", "#B45309",
+	}
+
+	paletteIndex := 0
+	for index := 0; index < len(rows); index += 1 {
+		color := category.Current.Color(rows[index].Key)
+		if color == "" {
+			color = paletteColors[paletteIndex%len(paletteColors)]
+			paletteIndex += 1
+		}
+		rows[index].Color = color
+	}
+
+	return rows
+}
+
+/*
+normalizeCategoryKey trims and normalizes a category key for consistent grouping.
+*/
+func normalizeCategoryKey(categoryKey string) string {
+	trimmed := strings.TrimSpace(categoryKey)
+	trimmed = strings.ToLower(trimmed)
+	return trimmed
+}
+
+/*
+displayCategoryName returns the taxonomy name of a category key (see category.Taxonomy.Name).
+*/
+func displayCategoryName(categoryKey string) string {
+	if categoryKey == "uncategorized" {
+		return "Uncategorized"
+	}
+	return category.Current.Name(categoryKey)
+}
+
+/*
+RenderHTML converts a MonthlyReport into a single HTML string using inline CSS only.
+*/
+func RenderHTML(report MonthlyReport) (htmlText string, e *xerr.Error) {
+	var buffer bytes.Buffer
+
+	totalFormatted := currency.FormatMinor(report.TotalSpent, report.Currency)
+	monthName := report.Month.String()
+
+	buffer.WriteString("<!doctype html>")
+	buffer.WriteString("<html>")
+	buffer.WriteString("<head>")
+	buffer.WriteString(`<meta charset="utf-8">`)
+	buffer.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1">`)
+	buffer.WriteString("</head>")
+
+	bodyStyle := "margin:0;padding:0;background-color:#F3F4F6;font-family:-apple-system,BlinkMacSystemFont,'Segoe UI',Roboto,Inter,Arial,sans-serif;color:#111827;"
+	buffer.WriteString(`<body style="` + bodyStyle + `">`)
+
+	// Outer wrapper table (email-safe centering).
+	buffer.WriteString(`<table role="presentation" cellpadding="0" cellspacing="0" border="0" width="100%" style="border-collapse:collapse;background-color:#F3F4F6;">`)
+	buffer.WriteString(`<tr>`)
+	buffer.WriteString(`<td align="center" style="padding:24px;">`)
+
+	// Main container.
+	buffer.WriteString(`<table role="presentation" cellpadding="0" cellspacing="0" border="0" width="680" style="border-collapse:separate;background-color:#F3F4F6;width:680px;max-width:680px;">`)
+	buffer.WriteString(`<tr><td style="padding:0;">`)
+
+	// Header.
+	buffer.WriteString(`<div style="padding:8px 4px 18px 4px;">`)
+	buffer.WriteString(`<div style="font-size:24px;font-weight:800;line-height:1.2;color:#111827;">` + html.EscapeString(report.Title) + `</div>`)
+	buffer.WriteString(`<div style="margin-top:6px;font-size:13px;line-height:1.5;color:#6B7280;">`)
+	buffer.WriteString(`Period: <span style="font-weight:700;color:#111827;">` + html.EscapeString(monthName) + ` ` + strconv.Itoa(report.Year) + `</span>`)
+	buffer.WriteString(` &nbsp;•&nbsp; Receipts: <span style="font-weight:700;color:#111827;">` + formatIntHuman(int64(report.ReceiptCount)) + `</span>`)
+	buffer.WriteString(` &nbsp;•&nbsp; Timezone: <span style="font-weight:700;color:#111827;">` + html.EscapeString(report.Timezone) + `</span>`)
+	buffer.WriteString(`</div>`)
+	buffer.WriteString(`</div>`)
+
+	// Summary card.
+	buffer.WriteString(cardOpen())
+	buffer.WriteString(`<div style="padding:18px 18px 6px 18px;">`)
+	buffer.WriteString(`<div style="font-size:12px;letter-spacing:0.10em;text-transform:uppercase;color:#6B7280;">Total spent</div>`)
+	buffer.WriteString(`<div style="margin-top:6px;font-size:34px;font-weight:900;line-height:1.1;color:#111827;">` + html.EscapeString(totalFormatted) + `</div>`)
+	buffer.WriteString(`<div style="margin-top:8px;font-size:13px;line-height:1.5;color:#6B7280;">`)
+	buffer.WriteString(`From <span style="font-weight:700;color:#111827;">` + report.PeriodStart.Format("2006-01-02") + `</span> to <span style="font-weight:700;color:#111827;">` + report.PeriodEnd.Format("2006-01-02") + `</span>`)
+	buffer.WriteString(`</div>`)
+	buffer.WriteString(`</div>`)
+
+	buffer.WriteString(`<div style="padding:0 18px 18px 18px;">`)
+	buffer.WriteString(`<div style="height:1px;background-color:#E5E7EB;width:100%;"></div>`)
+	buffer.WriteString(`<div style="margin-top:14px;font-size:14px;font-weight:800;color:#111827;">Category breakdown</div>`)
+	buffer.WriteString(`<div style="margin-top:4px;font-size:12px;line-height:1.5;color:#6B7280;">Percent of total spend for the month.</div>`)
+	buffer.WriteString(`</div>`)
+
+	// Category table.
+	buffer.WriteString(`<div style="padding:0 18px 18px 18px;">`)
+	if report.ReceiptCount == 0 || len(report.Rows) == 0 {
+		buffer.WriteString(`<div style="padding:14px;border:1px dashed #D1D5DB;border-radius:12px;background-color:#FAFAFA;color:#6B7280;font-size:13px;line-height:1.6;">`)
+		buffer.WriteString(`No receipts found for this month in the ledger.`)
+		buffer.WriteString(`</div>`)
+	} else {
+		buffer.WriteString(`<table role="presentation" cellpadding="0" cellspacing="0" border="0" width="100%" style="border-collapse:separate;border-spacing:0 10px;">`)
+		for _, row := range report.Rows {
+			buffer.WriteString(`<tr>`)
+			buffer.WriteString(`<td style="padding:12px 12px 12px 12px;background-color:#FFFFFF;border:1px solid #E5E7EB;border-radius:12px;">`)
+
+			// Row header.
+			buffer.WriteString(`<table role="presentation" cellpadding="0" cellspacing="0" border="0" width="100%" style="border-collapse:collapse;">`)
+			buffer.WriteString(`<tr>`)
+
+			// Category name with dot.
+			buffer.WriteString(`<td style="vertical-align:top;padding-right:10px;">`)
+			buffer.WriteString(`<div style="display:inline-block;width:10px;height:10px;border-radius:999px;background-color:` + row.Color + `;margin-right:8px;position:relative;top:1px;"></div>`)
+			buffer.WriteString(`<span style="font-size:14px;font-weight:800;color:#111827;">` + html.EscapeString(row.DisplayName) + `</span>`)
+			buffer.WriteString(`</td>`)
+
+			// Amount.
+			buffer.WriteString(`<td align="right" style="vertical-align:top;">`)
+			buffer.WriteString(`<div style="font-size:14px;font-weight:900;color:#111827;">` + html.EscapeString(currency.FormatMinor(row.Amount, report.Currency)) + `</div>`)
+			buffer.WriteString(`<div style="margin-top:2px;font-size:12px;font-weight:800;color:#6B7280;">` + fmt.Sprintf("%.1f%%", row.Percent) + `</div>`)
+			buffer.WriteString(`</td>`)
+
+			buffer.WriteString(`</tr>`)
+
+			// Bar.
+			buffer.WriteString(`<tr><td colspan="2" style="padding-top:10px;">`)
+			buffer.WriteString(`<div style="width:100%;height:10px;border-radius:999px;background-color:#EEF2FF;overflow:hidden;border:1px solid #E5E7EB;">`)
+			buffer.WriteString(`<div style="height:10px;width:` + strconv.Itoa(row.BarPercent) + `%;background-color:` + row.Color + `;border-radius:999px;"></div>`)
+			buffer.WriteString(`</div>`)
+			buffer.WriteString(`</td></tr>`)
+
+			buffer.WriteString(`</table>`)
+
+			buffer.WriteString(`</td>`)
+			buffer.WriteString(`</tr>`)
+		}
+		buffer.WriteString(`</table>`)
+	}
+	buffer.WriteString(`</div>`)
+
+	renderReceiptsSection(&buffer, report.Receipts, report.Currency)
+
+	renderCostSection(&buffer, report.Cost, report.ReceiptCount)
+
+	// Notes card.
+	buffer.WriteString(`<div style="padding:0 0 18px 0;">`)
+	buffer.WriteString(cardOpen())
+	buffer.WriteString(`<div style="padding:16px 18px 16px 18px;">`)
+	buffer.WriteString(`<div style="font-size:13px;font-weight:900;color:#111827;">Notes</div>`)
+	buffer.WriteString(`<div style="margin-top:10px;font-size:12px;line-height:1.7;color:#6B7280;">`)
+	for _, note := range report.Notes {
+		buffer.WriteString(`• ` + html.EscapeString(note) + `<br>`)
+	}
+	buffer.WriteString(`</div>`)
+	buffer.WriteString(`<div style="margin-top:12px;font-size:11px;color:#9CA3AF;">Generated ` + html.EscapeString(report.GeneratedAt.Format("2006-01-02 15:04:05")) + `</div>`)
+	buffer.WriteString(`</div>`)
+	buffer.WriteString(cardClose())
+	buffer.WriteString(`</div>`)
+
+	// Close main container and wrappers.
+	buffer.WriteString(`</td></tr>`)
+	buffer.WriteString(`</table>`)
+
+	buffer.WriteString(`</td>`)
+	buffer.WriteString(`</tr>`)
+	buffer.WriteString(`</table>`)
+
+	buffer.WriteString(`</body>`)
+	buffer.WriteString(`</html>`)
+
+	htmlText = buffer.String()
+	return htmlText, e
+}
+
+/*
+cardOpen returns the opening HTML for a card-like container (email-safe).
+*/
+func cardOpen() string {
+	return `<div style="background-color:#FFFFFF;border:1px solid #E5E7EB;border-radius:16px;box-shadow:0 8px 24px rgba(17,24,39,0.06);overflow:hidden;">`
+}
+
+/*
+cardClose returns the closing HTML for a card-like container.
+*/
+func cardClose() string {
+	return `</div>`
+}
+
+/*
+groupThousands groups digits in a base-10 string using the provided separator.
+*/
+func groupThousands(raw string, sep string) string {
+	if len(raw) <= 3 {
+		return raw
+	}
+
+	var builder strings.Builder
+	firstGroupLen := len(raw) % 3
+	if firstGroupLen == 0 {
+		firstGroupLen = 3
+	}
+
+	builder.WriteString(raw[:firstGroupLen])
+
+	for index := firstGroupLen; index < len(raw); index += 3 {
+		builder.WriteString(sep)
+		builder.WriteString(raw[index : index+3])
+	}
+
+	return builder.String()
+}
+
+/*
+formatIntHuman formats a count with comma separators for readability.
+*/
+func formatIntHuman(value int64) string {
+	raw := strconv.FormatInt(value, 10)
+	return groupThousands(raw, ",")
+}